@@ -0,0 +1,186 @@
+// Package arq retransmits the media of a datagram conn within a latency window, like SRT. The
+// receiver delivers every packet at its send time plus the latency (TSBPD, timestamp based packet
+// delivery), so the stream keeps the pace of the sender with a fixed delay. A lost packet is asked
+// again with a NAK while it can still arrive in time; past its delivery time the receiver gives it
+// up, and past the latency the sender stops retransmitting it and tells the receiver to drop it.
+// Both sides count the drops, the acks of the receiver carry its count to the sender.
+//
+// The feedback, clock and session packets of transport are about the time they arrive, they go
+// once and skip the window.
+//
+// A datagram is
+//
+//	0: type
+//	1: flags, 1 for a retransmission
+//	2-3: latency of the sender, ms, the receiver uses the larger one
+//	4-7: sequence number
+//	8-11: send time, us of the sender clock, wraps
+//	12-13: round trip time of the sender, ms, spaces the NAKs
+//	14-17: id of the sending conn, random, a new one starts the window of the receiver again
+//	the transport record
+//
+// an ack has the next sequence number it waits for, the send time of the last packet and how long
+// it was held, and the drops of the receiver; a NAK has the ranges of the missing sequence
+// numbers; a drop request has a range the sender gave up.
+package arq
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	typeData   = 0
+	typeDirect = 1 // outside the window
+	typeAck    = 2
+	typeNak    = 3
+	typeDrop   = 4
+
+	flagRetransmit = 1
+
+	dataHeaderSize = 18
+	ackSize        = 20
+	dropSize       = 12
+	maxDatagram    = 1 << 16
+	maxNakRanges   = 128
+	maxWindow      = 1 << 13 // packets ahead of the delivery
+	recordQueue    = 1 << 8
+
+	ackInterval    = 10 * time.Millisecond
+	minNakInterval = 20 * time.Millisecond
+	initialRTT     = 100 * time.Millisecond
+)
+
+var (
+	ErrShortDatagram = errors.New("arq: datagram too short")
+	ErrUnknownType   = errors.New("arq: unknown datagram type")
+)
+
+// Config of a Conn
+type Config struct {
+	Latency time.Duration // from the send time to the delivery, a few round trips
+}
+
+// DefaultConfig has the default latency of SRT
+var DefaultConfig = Config{
+	Latency: 120 * time.Millisecond,
+}
+
+// Stats is the counters of a Conn, in packets of the window
+type Stats struct {
+	Sent          uint64
+	Retransmitted uint64
+	SendDropped   uint64 // not acked within the latency, the sender gave them up
+	Delivered     uint64
+	Recovered     uint64 // delivered from a retransmission
+	Dropped       uint64 // missing at their delivery time
+	Late          uint64 // arrived after their delivery or twice
+	PeerDropped   uint64 // Dropped of the peer, from its last ack
+	RTT           time.Duration
+}
+
+type dataHeader struct {
+	flags   uint8
+	latency time.Duration
+	seq     uint32
+	ts      uint32
+	rtt     time.Duration
+	id      uint32
+}
+
+func putDataHeader(b []byte, h dataHeader) {
+	b[0] = typeData
+	b[1] = h.flags
+	binary.BigEndian.PutUint16(b[2:], uint16(h.latency/time.Millisecond))
+	binary.BigEndian.PutUint32(b[4:], h.seq)
+	binary.BigEndian.PutUint32(b[8:], h.ts)
+	binary.BigEndian.PutUint16(b[12:], uint16(h.rtt/time.Millisecond))
+	binary.BigEndian.PutUint32(b[14:], h.id)
+}
+
+func parseDataHeader(b []byte) (dataHeader, error) {
+	if len(b) < dataHeaderSize {
+		return dataHeader{}, ErrShortDatagram
+	}
+	return dataHeader{
+		flags:   b[1],
+		latency: time.Duration(binary.BigEndian.Uint16(b[2:])) * time.Millisecond,
+		seq:     binary.BigEndian.Uint32(b[4:]),
+		ts:      binary.BigEndian.Uint32(b[8:]),
+		rtt:     time.Duration(binary.BigEndian.Uint16(b[12:])) * time.Millisecond,
+		id:      binary.BigEndian.Uint32(b[14:]),
+	}, nil
+}
+
+type ack struct {
+	next    uint32 // all the packets before are delivered or dropped
+	echo    uint32 // send time of the last packet received
+	held    uint32 // us since it arrived
+	dropped uint32
+}
+
+func (a ack) marshal() []byte {
+	b := make([]byte, ackSize)
+	b[0] = typeAck
+	binary.BigEndian.PutUint32(b[4:], a.next)
+	binary.BigEndian.PutUint32(b[8:], a.echo)
+	binary.BigEndian.PutUint32(b[12:], a.held)
+	binary.BigEndian.PutUint32(b[16:], a.dropped)
+	return b
+}
+
+func parseAck(b []byte) (ack, error) {
+	if len(b) < ackSize {
+		return ack{}, ErrShortDatagram
+	}
+	return ack{
+		next:    binary.BigEndian.Uint32(b[4:]),
+		echo:    binary.BigEndian.Uint32(b[8:]),
+		held:    binary.BigEndian.Uint32(b[12:]),
+		dropped: binary.BigEndian.Uint32(b[16:]),
+	}, nil
+}
+
+// seqRange is the sequence numbers from first to last, included
+type seqRange struct {
+	first, last uint32
+}
+
+func marshalNak(ranges []seqRange) []byte {
+	b := make([]byte, 4+8*len(ranges))
+	b[0] = typeNak
+	for i, r := range ranges {
+		binary.BigEndian.PutUint32(b[4+8*i:], r.first)
+		binary.BigEndian.PutUint32(b[8+8*i:], r.last)
+	}
+	return b
+}
+
+func parseNak(b []byte) []seqRange {
+	var ranges []seqRange
+	for i := 4; i+8 <= len(b); i += 8 {
+		ranges = append(ranges, seqRange{binary.BigEndian.Uint32(b[i:]), binary.BigEndian.Uint32(b[i+4:])})
+	}
+	return ranges
+}
+
+func marshalDrop(r seqRange) []byte {
+	b := make([]byte, dropSize)
+	b[0] = typeDrop
+	binary.BigEndian.PutUint32(b[4:], r.first)
+	binary.BigEndian.PutUint32(b[8:], r.last)
+	return b
+}
+
+func parseDrop(b []byte) (seqRange, error) {
+	if len(b) < dropSize {
+		return seqRange{}, ErrShortDatagram
+	}
+	return seqRange{binary.BigEndian.Uint32(b[4:]), binary.BigEndian.Uint32(b[8:])}, nil
+}
+
+// before compare the sequence numbers across the wrap
+func before(a, b uint32) bool {
+	return int32(a-b) < 0
+}
@@ -0,0 +1,201 @@
+package arq
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/netsim"
+	"github.com/l-f-h/video/transport"
+)
+
+// pair connect a sender to a receiver over loopback udp, the sending side through the profile
+func pair(t *testing.T, cfg Config, p netsim.Profile) (sender, receiver *Conn) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender = NewConn(netsim.NewConn(client, p, true), cfg)
+	receiver = NewConn(transport.NewPeerConn(server), cfg)
+	t.Cleanup(func() {
+		sender.Close()
+		receiver.Close()
+	})
+	return sender, receiver
+}
+
+type arrival struct {
+	seq  uint16
+	sent time.Time
+	at   time.Time
+}
+
+// stream send n media packets every interval, and read them until the last one is due
+func stream(t *testing.T, sender, receiver *Conn, n int, interval, wait time.Duration) []arrival {
+	arrivals := make(chan []arrival)
+	go func() {
+		var got []arrival
+		tconn := transport.NewConn(receiver)
+		receiver.SetReadDeadline(time.Now().Add(time.Duration(n)*interval + wait))
+		for len(got) < n {
+			p, err := tconn.ReadPacket()
+			if err != nil {
+				break
+			}
+			got = append(got, arrival{seq: p.Seq, sent: p.CaptureTime(), at: time.Now()})
+		}
+		arrivals <- got
+	}()
+
+	tconn := transport.NewConn(sender)
+	pz := transport.NewPacketizer()
+	for i := 0; i < n; i++ {
+		for _, p := range pz.Packetize(make([]byte, 100), false, time.Now()) {
+			if _, err := tconn.WritePacket(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(interval)
+	}
+	return <-arrivals
+}
+
+func checkOrder(t *testing.T, got []arrival) {
+	for i := 1; i < len(got); i++ {
+		if int16(got[i].seq-got[i-1].seq) <= 0 {
+			t.Fatalf("packet %d after %d", got[i].seq, got[i-1].seq)
+		}
+	}
+}
+
+func TestTSBPD(t *testing.T) {
+	cfg := Config{Latency: 80 * time.Millisecond}
+	sender, receiver := pair(t, cfg, netsim.Profile{Jitter: 5 * time.Millisecond, Delay: 10 * time.Millisecond, Seed: 1})
+	got := stream(t, sender, receiver, 50, 2*time.Millisecond, time.Second)
+	if len(got) != 50 {
+		t.Fatalf("delivered %d of 50", len(got))
+	}
+	checkOrder(t, got)
+	// the delivery is the send time plus the least delay and the latency, whatever the jitter
+	for _, a := range got {
+		if d := a.at.Sub(a.sent); d < cfg.Latency || d > cfg.Latency+30*time.Millisecond {
+			t.Fatalf("packet %d delivered after %v, latency %v", a.seq, d, cfg.Latency)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	cfg := Config{Latency: 250 * time.Millisecond}
+	sender, receiver := pair(t, cfg, netsim.Profile{Delay: 10 * time.Millisecond, Loss: 0.2, Seed: 3})
+	got := stream(t, sender, receiver, 200, 2*time.Millisecond, time.Second)
+	if len(got) != 200 {
+		t.Fatalf("delivered %d of 200, %+v", len(got), receiver.Stats())
+	}
+	checkOrder(t, got)
+	if s := receiver.Stats(); s.Recovered == 0 || s.Dropped != 0 {
+		t.Fatalf("receiver stats %+v", s)
+	}
+	if s := sender.Stats(); s.Retransmitted == 0 {
+		t.Fatalf("sender stats %+v", s)
+	}
+}
+
+func TestPacedRecovery(t *testing.T) {
+	cfg := Config{Latency: 250 * time.Millisecond}
+	sender, receiver := pair(t, cfg, netsim.Profile{Delay: 10 * time.Millisecond, Loss: 0.2, Seed: 3})
+	paced := make(chan func() error, 1024)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case send := <-paced:
+				send()
+			case <-done:
+				return
+			}
+		}
+	}()
+	sender.Pace(func(size int, send func() error) {
+		select {
+		case paced <- send:
+		case <-done:
+		}
+	})
+	got := stream(t, sender, receiver, 200, 2*time.Millisecond, time.Second)
+	if len(got) != 200 {
+		t.Fatalf("delivered %d of 200, %+v", len(got), receiver.Stats())
+	}
+	if s := receiver.Stats(); s.Recovered == 0 {
+		t.Fatalf("receiver stats %+v", s)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	// a round trip longer than the latency, a lost packet can't come back in time
+	cfg := Config{Latency: 40 * time.Millisecond}
+	sender, receiver := pair(t, cfg, netsim.Profile{Delay: 30 * time.Millisecond, Loss: 0.2, Seed: 4})
+	got := stream(t, sender, receiver, 200, 2*time.Millisecond, 300*time.Millisecond)
+	if len(got) == 0 || len(got) == 200 {
+		t.Fatalf("delivered %d of 200", len(got))
+	}
+	checkOrder(t, got)
+	for _, a := range got {
+		if d := a.at.Sub(a.sent); d > cfg.Latency+50*time.Millisecond {
+			t.Fatalf("packet %d delivered after %v", a.seq, d)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	rs, ss := receiver.Stats(), sender.Stats()
+	if rs.Dropped == 0 || rs.Delivered+rs.Dropped != 200 {
+		t.Fatalf("receiver stats %+v", rs)
+	}
+	if ss.PeerDropped != rs.Dropped || ss.SendDropped == 0 {
+		t.Fatalf("sender stats %+v, receiver dropped %d", ss, rs.Dropped)
+	}
+}
+
+func TestDirect(t *testing.T) {
+	cfg := Config{Latency: time.Second}
+	sender, receiver := pair(t, cfg, netsim.Profile{})
+	fb := &transport.Feedback{}
+	start := time.Now()
+	if _, err := transport.NewConn(sender).WritePacket(transport.NewFeedbackPacket(fb)); err != nil {
+		t.Fatal(err)
+	}
+	receiver.SetReadDeadline(time.Now().Add(cfg.Latency / 2))
+	p, err := transport.NewConn(receiver).ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != transport.TypeFeedback || time.Since(start) > cfg.Latency/2 {
+		t.Fatalf("%v packet after %v", p.Type, time.Since(start))
+	}
+}
+
+func TestRestart(t *testing.T) {
+	cfg := Config{Latency: 40 * time.Millisecond}
+	sender, receiver := pair(t, cfg, netsim.Profile{})
+	if got := stream(t, sender, receiver, 20, 2*time.Millisecond, time.Second); len(got) != 20 {
+		t.Fatalf("delivered %d of 20", len(got))
+	}
+	sender.Close()
+
+	// the sender reconnects from another socket, its sequence numbers and clock start again
+	client, err := net.DialUDP("udp", nil, receiver.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := NewConn(client, cfg)
+	defer again.Close()
+	got := stream(t, again, receiver, 20, 2*time.Millisecond, time.Second)
+	if len(got) != 20 {
+		t.Fatalf("delivered %d of 20 after the reconnection, %+v", len(got), receiver.Stats())
+	}
+	checkOrder(t, got)
+}
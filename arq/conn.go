@@ -0,0 +1,325 @@
+package arq
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/transport"
+)
+
+const lengthSize = 2 // of the records of transport.Conn
+
+// sentPacket is kept for the retransmissions until it is acked or too late
+type sentPacket struct {
+	seq      uint32
+	datagram []byte
+	sent     time.Time
+	last     time.Time // of the last retransmission
+}
+
+// Conn is the window over a datagram net.Conn as the net.Conn of a transport.Conn: a Write is a
+// record, a Read returns a record at its delivery time
+type Conn struct {
+	conn  net.Conn
+	cfg   Config
+	id    uint32 // of the data, a reconnected sender has a new one
+	epoch time.Time
+
+	mu    sync.Mutex // of the sender and the stats
+	seq   uint32
+	sent  []*sentPacket // in sequence order
+	srtt  time.Duration
+	stats Stats
+	pace  func(size int, send func() error) // of the retransmissions, nil sends them at once
+
+	in       chan []byte // data and drop requests for the receiver
+	records  chan []byte
+	rest     []byte
+	deadline chan time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// NewConn start the window over conn, a connected udp conn or any conn keeping the datagrams
+func NewConn(conn net.Conn, cfg Config) *Conn {
+	c := &Conn{
+		conn:     conn,
+		cfg:      cfg,
+		id:       rand.Uint32(),
+		epoch:    time.Now(),
+		srtt:     initialRTT,
+		in:       make(chan []byte, recordQueue),
+		records:  make(chan []byte, recordQueue),
+		deadline: make(chan time.Time, 1),
+		done:     make(chan struct{}),
+	}
+	c.deadline <- time.Time{}
+	go c.read()
+	go newReceiver(c).run()
+	return c
+}
+
+// now return the send time of the sender clock
+func (c *Conn) now() uint32 {
+	return uint32(time.Since(c.epoch) / time.Microsecond)
+}
+
+// Write send a record, a media record is kept for the retransmissions
+func (c *Conn) Write(b []byte) (int, error) {
+	if len(b) < lengthSize {
+		return 0, transport.ErrBadRecord
+	}
+	p, err := transport.Unmarshal(b[lengthSize:])
+	if err != nil {
+		return 0, err
+	}
+	if p.Type != transport.TypeMedia {
+		d := make([]byte, 1+len(b))
+		d[0] = typeDirect
+		copy(d[1:], b)
+		if _, err := c.conn.Write(d); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.expire(now)
+	d := make([]byte, dataHeaderSize+len(b))
+	putDataHeader(d, dataHeader{latency: c.cfg.Latency, seq: c.seq, ts: c.now(), rtt: c.srtt, id: c.id})
+	copy(d[dataHeaderSize:], b)
+	c.sent = append(c.sent, &sentPacket{seq: c.seq, datagram: d, sent: now, last: now})
+	c.seq++
+	c.stats.Sent++
+	if _, err := c.conn.Write(d); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// expire give up the packets past the latency and the round trip of their ack, and ask the
+// receiver to drop them, must hold c.mu
+func (c *Conn) expire(now time.Time) {
+	n := 0
+	for n < len(c.sent) && now.Sub(c.sent[n].sent) > c.cfg.Latency+c.srtt {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	c.stats.SendDropped += uint64(n)
+	c.conn.Write(marshalDrop(seqRange{c.sent[0].seq, c.sent[n-1].seq}))
+	c.sent = c.sent[n:]
+}
+
+func (c *Conn) onAck(a ack) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for n < len(c.sent) && before(c.sent[n].seq, a.next) {
+		n++
+	}
+	c.sent = c.sent[n:]
+	if rtt := time.Duration(c.now()-a.echo-a.held) * time.Microsecond; rtt > 0 && rtt < time.Minute {
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.stats.PeerDropped = uint64(a.dropped)
+}
+
+// onNak retransmit the missing packets that can still arrive in time
+func (c *Conn) onNak(ranges []seqRange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sent) == 0 {
+		return
+	}
+	now := time.Now()
+	first := c.sent[0].seq
+	for _, r := range ranges {
+		for seq := r.first; !before(r.last, seq); seq++ {
+			i := int(seq - first)
+			if before(seq, first) || i >= len(c.sent) {
+				continue
+			}
+			p := c.sent[i]
+			if now.Sub(p.sent) > c.cfg.Latency {
+				continue
+			}
+			c.retransmit(p, now)
+		}
+	}
+}
+
+// retransmitUnacked retransmit the packets still not acked a round trip after their last send,
+// the receiver can't NAK the lost tail of the stream, must hold c.mu
+func (c *Conn) retransmitUnacked(now time.Time) {
+	timeout := c.srtt + 2*ackInterval
+	for _, p := range c.sent {
+		if now.Sub(p.sent) > c.cfg.Latency {
+			continue
+		}
+		if now.Sub(p.last) > timeout {
+			c.retransmit(p, now)
+		}
+	}
+}
+
+func (c *Conn) retransmit(p *sentPacket, now time.Time) {
+	p.datagram[1] |= flagRetransmit
+	p.last = now
+	c.stats.Retransmitted++
+	if c.pace == nil {
+		c.conn.Write(p.datagram)
+		return
+	}
+	d := append([]byte(nil), p.datagram...)
+	c.pace(len(d), func() error {
+		c.conn.Write(d) // a lost retransmission is NAKed again
+		return nil
+	})
+}
+
+// Pace queue the retransmissions with pace, like a pacer in front of the socket, so they count
+// against the pacing rate; pace calls send in their turn
+func (c *Conn) Pace(pace func(size int, send func() error)) {
+	c.mu.Lock()
+	c.pace = pace
+	c.mu.Unlock()
+}
+
+// read the datagrams of the peer, the acks and NAKs go to the sender
+func (c *Conn) read() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		switch buf[0] {
+		case typeData, typeDrop:
+			d := append([]byte(nil), buf[:n]...)
+			select {
+			case c.in <- d:
+			case <-c.done:
+				return
+			}
+		case typeDirect:
+			c.push(append([]byte(nil), buf[1:n]...))
+		case typeAck:
+			if a, err := parseAck(buf[:n]); err == nil {
+				c.onAck(a)
+			}
+		case typeNak:
+			c.onNak(parseNak(buf[:n]))
+		}
+	}
+}
+
+// push a record for Read, false if the conn is closed
+func (c *Conn) push(record []byte) bool {
+	select {
+	case c.records <- record:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *Conn) count(f func(s *Stats)) {
+	c.mu.Lock()
+	f(&c.stats)
+	c.mu.Unlock()
+}
+
+// Stats return a snapshot of the counters
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.RTT = c.srtt
+	return s
+}
+
+func (c *Conn) fail(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+// Read the records at their delivery time, a short b gets the record in several reads
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.rest) == 0 {
+		record, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		c.rest = record
+	}
+	n := copy(b, c.rest)
+	c.rest = c.rest[n:]
+	return n, nil
+}
+
+func (c *Conn) next() ([]byte, error) {
+	select {
+	case record := <-c.records:
+		return record, nil
+	default:
+	}
+	deadline := <-c.deadline
+	c.deadline <- deadline
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case record := <-c.records:
+		return record, nil
+	case <-c.done:
+		return nil, c.err
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *Conn) Close() error {
+	c.fail(net.ErrClosed)
+	return c.conn.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline apply to the next reads
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	<-c.deadline
+	c.deadline <- t
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
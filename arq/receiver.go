@@ -0,0 +1,286 @@
+package arq
+
+import (
+	"time"
+)
+
+// received is a packet of the window waiting for its delivery time, nil record for a packet the
+// sender gave up
+type received struct {
+	record        []byte
+	ts            int64 // send time, us of the sender clock unwrapped
+	arrived       time.Time
+	retransmitted bool
+}
+
+// receiver is the receiving side of a Conn, run owns it
+type receiver struct {
+	c *Conn
+
+	started bool
+	id      uint32 // of the sender
+	next    uint32 // to deliver
+	highest uint32 // received
+	window  map[uint32]*received
+	naked   map[uint32]time.Time // missing, with the time of the last NAK
+	latency time.Duration        // the larger of both sides
+	rtt     time.Duration        // of the sender
+
+	base    time.Time // receive time of the send time 0, the earliest seen
+	lastTS  uint32
+	ts      int64
+	lastAck time.Time
+	last    *received // first transmission, for the echo of the acks
+	lastRaw uint32
+}
+
+func newReceiver(c *Conn) *receiver {
+	return &receiver{
+		c:       c,
+		window:  make(map[uint32]*received),
+		naked:   make(map[uint32]time.Time),
+		latency: c.cfg.Latency,
+		rtt:     initialRTT,
+	}
+}
+
+func (r *receiver) run() {
+	timer := time.NewTimer(ackInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case d := <-r.c.in:
+			r.onDatagram(d, time.Now())
+		case <-timer.C:
+		case <-r.c.done:
+			return
+		}
+		now := time.Now()
+		if !r.deliver(now) {
+			return
+		}
+		r.report(now)
+		r.c.mu.Lock()
+		r.c.expire(now)
+		r.c.retransmitUnacked(now)
+		r.c.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(r.wake(now))
+	}
+}
+
+func (r *receiver) onDatagram(d []byte, now time.Time) {
+	switch d[0] {
+	case typeData:
+		h, err := parseDataHeader(d)
+		if err != nil {
+			return
+		}
+		r.onData(h, d[dataHeaderSize:], now)
+	case typeDrop:
+		if dr, err := parseDrop(d); err == nil {
+			r.onDrop(dr)
+		}
+	}
+}
+
+func (r *receiver) onData(h dataHeader, record []byte, now time.Time) {
+	if r.started && h.id != r.id {
+		r.restart()
+	}
+	if h.latency > r.latency {
+		r.latency = h.latency
+	}
+	if h.rtt > 0 {
+		r.rtt = h.rtt
+	}
+	if !r.started {
+		r.started, r.id = true, h.id
+		r.next, r.highest = h.seq, h.seq-1
+		r.ts, r.lastTS = int64(h.ts), h.ts
+		r.base = now.Add(-time.Duration(h.ts) * time.Microsecond)
+	}
+	ts := r.unwrap(h.ts)
+	// a packet faster than the first one moves the base earlier, the delay is the least one
+	if at := r.base.Add(time.Duration(ts) * time.Microsecond); now.Before(at) {
+		r.base = r.base.Add(now.Sub(at))
+	}
+
+	if before(h.seq, r.next) || r.window[h.seq] != nil {
+		r.c.count(func(s *Stats) { s.Late++ })
+		return
+	}
+	if h.seq-r.next >= maxWindow {
+		return
+	}
+	p := &received{record: record, ts: ts, arrived: now, retransmitted: h.flags&flagRetransmit != 0}
+	r.window[h.seq] = p
+	if !p.retransmitted {
+		r.last, r.lastRaw = p, h.ts
+	}
+	delete(r.naked, h.seq)
+	if before(r.highest, h.seq) {
+		for seq := r.highest + 1; seq != h.seq; seq++ {
+			r.naked[seq] = time.Time{}
+		}
+		r.highest = h.seq
+	}
+}
+
+// restart the window for a new sender, the one before reconnected, its packets still waiting
+// are dropped
+func (r *receiver) restart() {
+	n := uint64(r.highest + 1 - r.next)
+	r.c.count(func(s *Stats) { s.Dropped += n })
+	*r = *newReceiver(r.c)
+}
+
+// onDrop stop waiting for the packets the sender gave up
+func (r *receiver) onDrop(dr seqRange) {
+	if !r.started {
+		return
+	}
+	if dr.last-r.next >= maxWindow {
+		return
+	}
+	// the lost tail of the stream is known from the request only
+	if before(r.highest, dr.last) {
+		for seq := r.highest + 1; !before(dr.last, seq); seq++ {
+			r.naked[seq] = time.Time{}
+		}
+		r.highest = dr.last
+	}
+	for seq := dr.first; !before(dr.last, seq); seq++ {
+		if before(seq, r.next) || r.window[seq] != nil {
+			continue
+		}
+		r.window[seq] = &received{}
+		delete(r.naked, seq)
+	}
+}
+
+// unwrap the send time to the us since the epoch of the sender
+func (r *receiver) unwrap(ts uint32) int64 {
+	t := r.ts + int64(int32(ts-r.lastTS))
+	if t > r.ts {
+		r.ts, r.lastTS = t, ts
+	}
+	return t
+}
+
+func (r *receiver) deliverAt(p *received) time.Time {
+	return r.base.Add(time.Duration(p.ts)*time.Microsecond + r.latency)
+}
+
+// deliver the packets of the head at their time, the missing ones before a packet due are
+// dropped; false if the conn is closed
+func (r *receiver) deliver(now time.Time) bool {
+	for r.started && before(r.next, r.highest+1) {
+		p := r.window[r.next]
+		if p == nil {
+			due := r.firstAfterNext()
+			if due == nil || now.Before(r.deliverAt(due)) {
+				return true
+			}
+			delete(r.naked, r.next)
+			r.next++
+			r.c.count(func(s *Stats) { s.Dropped++ })
+			continue
+		}
+		if p.record == nil {
+			delete(r.window, r.next)
+			r.next++
+			r.c.count(func(s *Stats) { s.Dropped++ })
+			continue
+		}
+		if now.Before(r.deliverAt(p)) {
+			return true
+		}
+		delete(r.window, r.next)
+		r.next++
+		r.c.count(func(s *Stats) {
+			s.Delivered++
+			if p.retransmitted {
+				s.Recovered++
+			}
+		})
+		if !r.c.push(p.record) {
+			return false
+		}
+	}
+	return true
+}
+
+// firstAfterNext return the first packet received after the missing head
+func (r *receiver) firstAfterNext() *received {
+	for seq := r.next + 1; !before(r.highest, seq); seq++ {
+		if p := r.window[seq]; p != nil && p.record != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// report ack the window every ackInterval and NAK the missing packets once per round trip
+func (r *receiver) report(now time.Time) {
+	if !r.started {
+		return
+	}
+	if now.Sub(r.lastAck) >= ackInterval {
+		r.lastAck = now
+		next := r.next
+		for r.window[next] != nil && before(next, r.highest+1) {
+			next++
+		}
+		a := ack{next: next, echo: r.lastRaw, dropped: uint32(r.c.Stats().Dropped)}
+		if r.last != nil {
+			a.held = uint32(now.Sub(r.last.arrived) / time.Microsecond)
+		}
+		r.c.conn.Write(a.marshal())
+	}
+
+	interval := r.rtt
+	if interval < minNakInterval {
+		interval = minNakInterval
+	}
+	var ranges []seqRange
+	for seq := r.next; before(seq, r.highest+1) && len(ranges) < maxNakRanges; seq++ {
+		last, missing := r.naked[seq]
+		if !missing || now.Sub(last) < interval {
+			continue
+		}
+		r.naked[seq] = now
+		if n := len(ranges); n > 0 && ranges[n-1].last == seq-1 {
+			ranges[n-1].last = seq
+		} else {
+			ranges = append(ranges, seqRange{seq, seq})
+		}
+	}
+	if len(ranges) > 0 {
+		r.c.conn.Write(marshalNak(ranges))
+	}
+}
+
+// wake return the time to the next delivery or report
+func (r *receiver) wake(now time.Time) time.Duration {
+	d := ackInterval
+	if p := r.window[r.next]; p != nil && p.record != nil {
+		if until := r.deliverAt(p).Sub(now); until < d {
+			d = until
+		}
+	} else if p := r.firstAfterNext(); p != nil {
+		if until := r.deliverAt(p).Sub(now); until < d {
+			d = until
+		}
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
@@ -1,10 +1,13 @@
 package cam
 
 import (
-	"fmt"
+	"errors"
 	"gocv.io/x/gocv"
-	"image"
 	"log"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/source"
 )
 
 const (
@@ -12,57 +15,76 @@ const (
 )
 
 
+var ErrCapture = errors.New("cam: the capture ended")
+
+// Frame is a picture of the camera with its capture time
+type Frame = source.Frame
+
+// WebCam is the source of a camera of OpenCV, local or at a url. A live camera drops its oldest
+// frames for a slow consumer by default, the driver would keep them instead.
 type WebCam struct {
 	cam      *gocv.VideoCapture
-	frameQue chan image.Image
-	stop     bool
+	frameQue *source.Queue
+	caps     Caps
+	done     chan struct{}
+	stopOnce sync.Once
+	err      error // set before frameQue is closed
 }
 
+var _ source.Source = (*WebCam)(nil)
+
 func NewWebCamWithURL(url string) (*WebCam, error) {
-	c := &WebCam{}
-	c.stop = false
-	c.frameQue = make(chan image.Image, queBuffer)
-	cam, err := gocv.OpenVideoCapture(url)
-	if err != nil {
-		return nil, fmt.Errorf("OpenVideoCapture error: %v", err)
-	}
-	c.cam = cam
-	return c, nil
+	return NewWebCam(CameraConfig{Path: url})
 }
 
 func NewWebCamWithLocalCam() (*WebCam, error) {
-	c := &WebCam{}
-	c.stop = false
-	c.frameQue = make(chan image.Image, queBuffer)
-	cam, err := gocv.OpenVideoCapture(0)
-	if err != nil {
-		return nil, fmt.Errorf("OpenVideoCapture error: %v", err)
-	}
-	c.cam = cam
-	return c, nil
+	return NewWebCam(CameraConfig{Width: 1280, Height: 720})
+}
+
+// Caps return the settings the camera granted
+func (c *WebCam) Caps() Caps {
+	return c.caps
+}
+
+func (c *WebCam) Frames() <-chan Frame {
+	return c.frameQue.Frames()
 }
 
-func (c *WebCam) FrameQueue() <-chan image.Image {
-	return c.frameQue
+// SetPolicy of the queue before Start
+func (c *WebCam) SetPolicy(policy source.Policy) {
+	c.frameQue = source.NewQueue(queBuffer, policy)
+}
+
+// Dropped return the frames dropped by the policy of the queue
+func (c *WebCam) Dropped() uint64 {
+	return c.frameQue.Dropped()
+}
+
+// Err return ErrCapture if the camera stopped giving pictures, once Frames is closed
+func (c *WebCam) Err() error {
+	return c.err
 }
 
 func (c *WebCam) Start() {
 	go func() {
-		defer close(c.frameQue)
-		cam, err := gocv.OpenVideoCapture(0)
-		if err != nil {
-			log.Fatalf("open cam error: %v", err)
-		}
-		cam.Set(gocv.VideoCaptureFrameWidth, 1280)
-		cam.Set(gocv.VideoCaptureFrameHeight, 720)
+		defer c.frameQue.Close()
+		// the capture of the constructor, already set up by its config
+		cam := c.cam
+		defer cam.Close()
 		img := gocv.NewMat()
 		// win := gocv.NewWindow("feihaoCam")
 		for {
-			if c.stop {
+			select {
+			case <-c.done:
 				log.Println("cam stop")
 				return
+			default:
+			}
+			if !cam.Read(&img) {
+				c.err = ErrCapture
+				return
 			}
-			cam.Read(&img)
+			captured := time.Now()
 			if img.Empty() {
 				continue
 			}
@@ -70,12 +92,15 @@ func (c *WebCam) Start() {
 				log.Printf("convert frame to rgbPic error: %v", err)
 				continue
 			} else {
-				c.frameQue <- rgbImg
+				if !c.frameQue.Push(Frame{Image: rgbImg, Timestamp: captured}, c.done) {
+					log.Println("cam stop")
+					return
+				}
 			}
 		}
 	}()
 }
 
 func (c *WebCam) Stop() {
-	c.stop = true
+	c.stopOnce.Do(func() { close(c.done) })
 }
@@ -0,0 +1,179 @@
+package cam
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/l-f-h/video/source"
+	"gocv.io/x/gocv"
+)
+
+const (
+	maxProbe = 8 // device indexes ListDevices tries without /dev/video nodes
+
+	// CAP_PROP_AUTO_WB of OpenCV, this gocv has no name for it
+	videoCaptureAutoWB gocv.VideoCaptureProperties = 44
+	// the manual mode of CAP_PROP_AUTO_EXPOSURE, V4L2_EXPOSURE_MANUAL on linux
+	manualExposure = 1
+)
+
+// CameraConfig of a camera, the zero values keep the settings of the driver. The rest are hints,
+// the camera may grant another size, rate or format: the Caps of the WebCam tell.
+type CameraConfig struct {
+	Device       int    // index of the local camera
+	Path         string // a device like /dev/video2 or a url of OpenCV, instead of Device
+	Width        int
+	Height       int
+	FPS          int
+	PixelFormat  string  // fourcc, like MJPG or YUYV
+	Exposure     float64 // manual exposure in the unit of the driver, 0 for auto
+	WhiteBalance int     // temperature in kelvin, 0 for auto
+
+	Policy source.Policy // of the queue of Open, empty for the default of the source
+}
+
+// Flags add the local camera, its hints and the policy of the queue to fs, the size and rate are
+// the ones of the encoding
+func Flags(fs *flag.FlagSet, cfg *CameraConfig) {
+	fs.IntVar(&cfg.Device, "cam-device", cfg.Device, "index of the local camera opened without -src, like 2 for /dev/video2")
+	fs.Func("queue", "policy of the queue of the source for a slow encoder: block, drop-oldest, drop-newest or latest-only, "+
+		"the default drops the oldest frames of a camera and blocks the others", func(name string) error {
+		p, err := source.ParsePolicy(name)
+		cfg.Policy = p
+		return err
+	})
+	fs.StringVar(&cfg.PixelFormat, "cam-format", cfg.PixelFormat, "pixel format of the camera, a fourcc like MJPG or YUYV")
+	fs.Float64Var(&cfg.Exposure, "cam-exposure", cfg.Exposure, "manual exposure of the camera in the unit of its driver, 0 for auto")
+	fs.IntVar(&cfg.WhiteBalance, "cam-wb", cfg.WhiteBalance, "white balance of the camera in kelvin, 0 for auto")
+}
+
+// Caps is what a camera granted
+type Caps struct {
+	Width, Height int
+	FPS           float64
+	PixelFormat   string
+}
+
+func (c Caps) String() string {
+	return fmt.Sprintf("%dx%d@%g %s", c.Width, c.Height, c.FPS, c.PixelFormat)
+}
+
+// NewWebCam open the camera of the config and ask it for the settings
+func NewWebCam(cfg CameraConfig) (*WebCam, error) {
+	cam, err := openCapture(cfg)
+	if err != nil {
+		return nil, err
+	}
+	configure(cam, cfg)
+	c := &WebCam{
+		cam:      cam,
+		frameQue: source.NewQueue(queBuffer, source.DropOldest),
+		caps:     readCaps(cam),
+		done:     make(chan struct{}),
+	}
+	if (cfg.Width > 0 && (c.caps.Width != cfg.Width || c.caps.Height != cfg.Height)) ||
+		(cfg.PixelFormat != "" && c.caps.PixelFormat != cfg.PixelFormat) {
+		log.Printf("cam: asked %dx%d %s, granted %v", cfg.Width, cfg.Height, cfg.PixelFormat, c.caps)
+	} else {
+		log.Printf("cam: %v", c.caps)
+	}
+	return c, nil
+}
+
+func openCapture(cfg CameraConfig) (*gocv.VideoCapture, error) {
+	var cam *gocv.VideoCapture
+	var err error
+	if cfg.Path != "" {
+		cam, err = gocv.OpenVideoCapture(cfg.Path)
+	} else {
+		cam, err = gocv.VideoCaptureDevice(cfg.Device)
+	}
+	if err != nil {
+		if cam != nil {
+			cam.Close()
+		}
+		return nil, fmt.Errorf("OpenVideoCapture error: %v", err)
+	}
+	return cam, nil
+}
+
+// configure ask the camera for the config, the format first: the sizes and rates of a camera
+// depend on it
+func configure(cam *gocv.VideoCapture, cfg CameraConfig) {
+	if cfg.PixelFormat != "" {
+		cam.Set(gocv.VideoCaptureFOURCC, cam.ToCodec(cfg.PixelFormat))
+	}
+	if cfg.Width > 0 && cfg.Height > 0 {
+		cam.Set(gocv.VideoCaptureFrameWidth, float64(cfg.Width))
+		cam.Set(gocv.VideoCaptureFrameHeight, float64(cfg.Height))
+	}
+	if cfg.FPS > 0 {
+		cam.Set(gocv.VideoCaptureFPS, float64(cfg.FPS))
+	}
+	if cfg.Exposure > 0 {
+		cam.Set(gocv.VideoCaptureAutoExposure, manualExposure)
+		cam.Set(gocv.VideoCaptureExposure, cfg.Exposure)
+	}
+	if cfg.WhiteBalance > 0 {
+		cam.Set(videoCaptureAutoWB, 0)
+		cam.Set(gocv.VideoCaptureTemperature, float64(cfg.WhiteBalance))
+	}
+}
+
+func readCaps(cam *gocv.VideoCapture) Caps {
+	return Caps{
+		Width:       int(cam.Get(gocv.VideoCaptureFrameWidth)),
+		Height:      int(cam.Get(gocv.VideoCaptureFrameHeight)),
+		FPS:         cam.Get(gocv.VideoCaptureFPS),
+		PixelFormat: strings.TrimRight(cam.CodecString(), "\x00"),
+	}
+}
+
+// Device is a camera found by ListDevices
+type Device struct {
+	Index int
+	Path  string // the /dev/video node, empty elsewhere
+	Caps  Caps   // the settings of the driver
+}
+
+// ListDevices open the local cameras one after the other, the /dev/video nodes on linux, the
+// first indexes elsewhere; the ones in use or without capture, like the metadata nodes, are left
+// out
+func ListDevices() []Device {
+	var devices []Device
+	for _, d := range candidates() {
+		cam, err := gocv.VideoCaptureDevice(d.Index)
+		if err != nil || !cam.IsOpened() {
+			if cam != nil {
+				cam.Close()
+			}
+			continue
+		}
+		d.Caps = readCaps(cam)
+		cam.Close()
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+func candidates() []Device {
+	var devices []Device
+	nodes, _ := filepath.Glob("/dev/video*")
+	for _, node := range nodes {
+		if i, err := strconv.Atoi(strings.TrimPrefix(node, "/dev/video")); err == nil {
+			devices = append(devices, Device{Index: i, Path: node})
+		}
+	}
+	if len(devices) == 0 {
+		for i := 0; i < maxProbe; i++ {
+			devices = append(devices, Device{Index: i})
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Index < devices[j].Index })
+	return devices
+}
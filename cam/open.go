@@ -0,0 +1,79 @@
+package cam
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/source"
+)
+
+// Open the source of the url with the config of a camera, its size and frame rate are the ones
+// of the sources without their own too:
+//
+//	""                     the local camera of cfg.Device, -cam-device of Flags
+//	2, /dev/video2         another local camera
+//	synthetic[:<spec>]     a test pattern, like synthetic:box,noise=16, see source.ParseSynthetic
+//	images:<glob>          image files in name order, in a loop, like images:frames/out*.png
+//	<file>.y4m             a YUV4MPEG2 file at its frame rate
+//	pipe:rgba, pipe:i420   raw frames on stdin, like the rawvideo of ffmpeg
+//	pipe:y4m               a YUV4MPEG2 stream on stdin
+//	else                   a camera or video url of OpenCV
+//
+// The queue of the source follows cfg.Policy, or the default of the source if empty.
+func Open(url string, cfg CameraConfig) (source.Source, error) {
+	src, err := open(url, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Policy != "" {
+		src.SetPolicy(cfg.Policy)
+	}
+	return src, nil
+}
+
+func open(url string, cfg CameraConfig) (source.Source, error) {
+	width, height, fps := cfg.Width, cfg.Height, cfg.FPS
+	switch {
+	case url == "":
+		return NewWebCam(cfg)
+	case url == "synthetic" || strings.HasPrefix(url, "synthetic:"):
+		return source.ParseSynthetic(strings.TrimPrefix(strings.TrimPrefix(url, "synthetic"), ":"), width, height, fps)
+	case strings.HasPrefix(url, "images:"):
+		return source.NewFiles(strings.TrimPrefix(url, "images:"), fps, true)
+	case strings.HasSuffix(url, ".y4m"):
+		return openY4M(url)
+	case url == "pipe:y4m":
+		r, err := codec.NewY4MReader(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return source.NewReader(r, 0), nil
+	case strings.HasPrefix(url, "pipe:"):
+		return source.NewPipe(os.Stdin, strings.TrimPrefix(url, "pipe:"), width, height)
+	}
+	cfg.Path = url
+	return NewWebCam(cfg)
+}
+
+// y4mFile is the reader of an open file, the source closes it
+type y4mFile struct {
+	*codec.Y4MReader
+	io.Closer
+}
+
+func openY4M(name string) (source.Source, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := codec.NewY4MReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	num, den := r.FrameRate()
+	return source.NewReader(y4mFile{r, f}, time.Duration(den)*time.Second/time.Duration(num)), nil
+}
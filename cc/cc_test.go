@@ -0,0 +1,148 @@
+package cc
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/transport"
+)
+
+// simulate a sender paced at the target bitrate over a bottleneck, in virtual time
+func simulate(c *Controller, capacity float64, loss float64, duration time.Duration) []Decision {
+	const (
+		packetSize  = 1200
+		propagation = 25 * time.Millisecond
+	)
+	var (
+		start     = time.Unix(1000, 0)
+		now       = start
+		busy      time.Duration // the bottleneck is busy until
+		seq       uint16
+		rnd       = rand.New(rand.NewSource(1))
+		recorder  = transport.NewFeedbackRecorder()
+		nextFb    = start.Add(transport.FeedbackInterval)
+		decisions []Decision
+		arrivals  []time.Time
+		pending   []uint16
+	)
+	for now.Sub(start) < duration {
+		c.OnPacketSent(seq, packetSize, now)
+		sendAt := now.Sub(start)
+		if busy < sendAt {
+			busy = sendAt
+		}
+		busy += time.Duration(packetSize * 8 / capacity * float64(time.Second))
+		if rnd.Float64() >= loss {
+			pending = append(pending, seq)
+			arrivals = append(arrivals, start.Add(busy+propagation))
+		}
+		seq++
+		now = now.Add(time.Duration(packetSize * 8 / float64(c.TargetBitrate()) * float64(time.Second)))
+
+		for len(arrivals) > 0 && !arrivals[0].After(now) {
+			recorder.OnPacket(pending[0], arrivals[0])
+			arrivals, pending = arrivals[1:], pending[1:]
+		}
+		if now.After(nextFb) {
+			nextFb = nextFb.Add(transport.FeedbackInterval)
+			if fb := recorder.Build(); fb != nil {
+				decisions = append(decisions, c.OnFeedback(fb, now.Add(propagation)))
+			}
+		}
+	}
+	return decisions
+}
+
+func TestControllerConvergesToCapacity(t *testing.T) {
+	const capacity = 1000 * 1000
+	c := NewController(Config{InitialBitrate: 3000 * 1000, MinBitrate: 100 * 1000, MaxBitrate: 8000 * 1000})
+	decisions := simulate(c, capacity, 0, 30*time.Second)
+
+	overused := false
+	for _, d := range decisions {
+		overused = overused || d.Usage == UsageOverusing
+	}
+	if !overused {
+		t.Fatal("overuse never detected")
+	}
+	target := c.TargetBitrate()
+	if target > capacity*1.3 || target < capacity*0.4 {
+		t.Fatalf("target %dkbps, capacity %dkbps", target/1000, capacity/1000)
+	}
+}
+
+func TestControllerBacksOffOnLoss(t *testing.T) {
+	c := NewController(Config{InitialBitrate: 2000 * 1000, MinBitrate: 100 * 1000, MaxBitrate: 8000 * 1000})
+	simulate(c, 100*1000*1000, 0.2, 5*time.Second)
+	if target := c.TargetBitrate(); target > 1000*1000 {
+		t.Fatalf("target %dkbps with 20%% loss", target/1000)
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	now := time.Unix(0, 0)
+	a := NewAdapter(DefaultLadder, 3000*1000)
+	if s := a.Setting(); s.Width != 1280 || s.FPS != 30 {
+		t.Fatalf("initial setting %+v", s)
+	}
+
+	// down at once
+	now = now.Add(2 * time.Second)
+	s, changed := a.Update(400*1000, now)
+	if !changed || s.Width != 640 || s.FPS != 15 {
+		t.Fatalf("setting %+v changed %v", s, changed)
+	}
+
+	// up only after the hold, one rung at a time
+	now = now.Add(time.Second)
+	if s, _ := a.Update(3000*1000, now); s.FPS != 15 {
+		t.Fatalf("went up too early %+v", s)
+	}
+	now = now.Add(upSwitchHold)
+	if s, _ := a.Update(3000*1000, now); s.Width != 640 || s.FPS != 30 {
+		t.Fatalf("setting %+v, want 640x360@30", s)
+	}
+
+	// small bitrate changes are not applied
+	if _, changed := a.Update(3050*1000, now); changed {
+		t.Fatal("small change applied")
+	}
+}
+
+func TestAdapterAnswers(t *testing.T) {
+	now := time.Unix(0, 0)
+	a := NewAdapter(DefaultLadder, 3000*1000)
+
+	// rejected, back on the rung of the encoder until the hold is over
+	now = now.Add(2 * time.Second)
+	if s, _ := a.Update(1200*1000, now); s.Width != 960 {
+		t.Fatalf("setting %+v", s)
+	}
+	a.Restore(EncoderSetting{Width: 1280, Height: 720, FPS: 30}, now)
+	if s, _ := a.Update(1200*1000, now.Add(time.Second/2)); s.Width != 1280 || s.FPS != 30 {
+		t.Fatalf("setting %+v after the reject", s)
+	}
+
+	// countered, the rungs above the counter are not offered again
+	now = now.Add(2 * time.Second)
+	a.Update(1200*1000, now)
+	a.Counter(EncoderSetting{Width: 800, Height: 450, FPS: 30}, now)
+	now = now.Add(upSwitchHold)
+	if s, _ := a.Update(3000*1000, now); s.Width != 800 || s.Height != 450 {
+		t.Fatalf("setting %+v after the counter", s)
+	}
+	now = now.Add(2 * time.Second)
+	if s, _ := a.Update(400*1000, now); s.Width != 640 || s.FPS != 15 {
+		t.Fatalf("setting %+v, want 640x360@15", s)
+	}
+}
+
+func TestFitLadder(t *testing.T) {
+	if l := FitLadder(DefaultLadder, 960, 540, 25); len(l) != 2 || l[0].Width != 640 || l[0].FPS != 15 {
+		t.Fatalf("ladder %+v", l)
+	}
+	if l := FitLadder(DefaultLadder, 100, 100, 5); len(l) != 1 || l[0].Width != 320 {
+		t.Fatalf("ladder %+v", l)
+	}
+}
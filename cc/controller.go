@@ -0,0 +1,189 @@
+package cc
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/transport"
+)
+
+const (
+	historyLen = 1 << 14 // sent packets kept for the feedback
+)
+
+// Config of the controller, bitrates are in bit/s
+type Config struct {
+	InitialBitrate int64
+	MinBitrate     int64
+	MaxBitrate     int64
+}
+
+var DefaultConfig = Config{
+	InitialBitrate: 2000 * 1000,
+	MinBitrate:     150 * 1000,
+	MaxBitrate:     8000 * 1000,
+}
+
+// Decision is the state of the controller after a feedback, it is logged for analysis
+type Decision struct {
+	Time              time.Time
+	Usage             BandwidthUsage
+	Trend             float64 // slope of the delay, ms/ms
+	Threshold         float64
+	RTT               time.Duration
+	LossRate          float64 // of the feedback
+	AckedBitrate      int64
+	DelayBasedBitrate int64
+	LossBasedBitrate  int64
+	TargetBitrate     int64
+}
+
+type sentPacket struct {
+	sendTime time.Time
+	size     int
+}
+
+// Controller estimates the bitrate the path can carry from the receiver feedback
+type Controller struct {
+	mu        sync.Mutex
+	cfg       Config
+	unwrapper transport.SeqUnwrapper
+	history   map[int64]sentPacket
+	delay     *delayBasedEstimator
+	aimd      *aimdRateControl
+	loss      *lossBasedControl
+	acked     ackedRate
+	rtt       time.Duration
+	last      Decision
+}
+
+func NewController(cfg Config) *Controller {
+	initial, min, max := float64(cfg.InitialBitrate), float64(cfg.MinBitrate), float64(cfg.MaxBitrate)
+	return &Controller{
+		cfg:     cfg,
+		history: make(map[int64]sentPacket),
+		delay:   newDelayBasedEstimator(),
+		aimd:    newAimdRateControl(initial, min, max),
+		loss:    newLossBasedControl(initial, min, max),
+		last:    Decision{TargetBitrate: cfg.InitialBitrate},
+	}
+}
+
+// OnPacketSent records a packet with its transport sequence number
+func (c *Controller) OnPacketSent(seq uint16, size int, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.unwrapper.Unwrap(seq)
+	c.history[s] = sentPacket{sendTime: at, size: size}
+	delete(c.history, s-historyLen)
+}
+
+// OnFeedback updates the estimation and return the decision
+func (c *Controller) OnFeedback(fb *transport.Feedback, now time.Time) Decision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	base := c.unwrapper.Peek(fb.BaseSeq)
+
+	var (
+		received, lost int
+		lastSend       time.Time
+	)
+	for i, p := range fb.Packets {
+		seq := base + int64(i)
+		sent, ok := c.history[seq]
+		if !ok {
+			continue
+		}
+		delete(c.history, seq)
+		if !p.Received {
+			lost++
+			continue
+		}
+		received++
+		arrival := time.Duration(fb.RefTime+int64(p.Delta)) * time.Microsecond
+		c.acked.add(arrival, sent.size)
+		c.delay.onPacket(sent.sendTime, arrival, sent.size)
+		if sent.sendTime.After(lastSend) {
+			lastSend = sent.sendTime
+		}
+	}
+	if !lastSend.IsZero() {
+		// the feedback waits up to one interval on the receiver
+		rtt := now.Sub(lastSend) - transport.FeedbackInterval/2
+		if rtt > 0 {
+			if c.rtt == 0 {
+				c.rtt = rtt
+			} else {
+				c.rtt = (7*c.rtt + rtt) / 8
+			}
+		}
+	}
+
+	acked := c.acked.bitrate()
+	delayRate := c.aimd.update(c.delay.usage, acked, c.rtt, now)
+	lossRate := c.loss.update(received, lost, now)
+	target := delayRate
+	if lossRate < target {
+		target = lossRate
+	}
+
+	d := Decision{
+		Time:              now,
+		Usage:             c.delay.usage,
+		Trend:             c.delay.trend,
+		Threshold:         c.delay.threshold,
+		RTT:               c.rtt,
+		AckedBitrate:      int64(acked),
+		DelayBasedBitrate: int64(delayRate),
+		LossBasedBitrate:  int64(lossRate),
+		TargetBitrate:     int64(target),
+	}
+	if received+lost > 0 {
+		d.LossRate = float64(lost) / float64(received+lost)
+	}
+	c.last = d
+	return d
+}
+
+// TargetBitrate return the bitrate of the last decision
+func (c *Controller) TargetBitrate() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last.TargetBitrate
+}
+
+func (d Decision) String() string {
+	return fmt.Sprintf("%s trend %.4f threshold %.2f rtt %v loss %.3f acked %dkbps delay %dkbps loss %dkbps target %dkbps",
+		d.Usage, d.Trend, d.Threshold, d.RTT, d.LossRate, d.AckedBitrate/1000,
+		d.DelayBasedBitrate/1000, d.LossBasedBitrate/1000, d.TargetBitrate/1000)
+}
+
+// DecisionLogger writes the decisions as csv for analysis
+type DecisionLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	header bool
+}
+
+func NewDecisionLogger(w io.Writer) *DecisionLogger {
+	return &DecisionLogger{w: w}
+}
+
+func (l *DecisionLogger) Log(d Decision, s EncoderSetting) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.header {
+		l.header = true
+		if _, err := fmt.Fprintln(l.w, "time_ms,usage,trend,threshold,rtt_ms,loss,acked_bps,delay_bps,loss_bps,target_bps,width,height,fps,encoder_bps"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(l.w, "%d,%s,%.5f,%.3f,%d,%.4f,%d,%d,%d,%d,%d,%d,%d,%d\n",
+		d.Time.UnixNano()/int64(time.Millisecond), d.Usage, d.Trend, d.Threshold,
+		d.RTT/time.Millisecond, d.LossRate, d.AckedBitrate, d.DelayBasedBitrate,
+		d.LossBasedBitrate, d.TargetBitrate, s.Width, s.Height, s.FPS, s.Bitrate)
+	return err
+}
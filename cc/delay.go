@@ -0,0 +1,201 @@
+// Package cc provides the congestion control of the sender, a delay and loss based controller like GCC of webrtc
+package cc
+
+import (
+	"math"
+	"time"
+)
+
+type BandwidthUsage int
+
+const (
+	UsageNormal BandwidthUsage = iota
+	UsageUnderusing
+	UsageOverusing
+)
+
+const (
+	burstInterval      = 5 * time.Millisecond // packets sent within it form a group
+	trendlineWindow    = 20
+	trendlineSmoothing = 0.9
+	trendlineGain      = 4.0
+	maxDeltas          = 60
+	overusingTimeMs    = 10.0
+	initialThresholdMs = 12.5
+	thresholdUp        = 0.0087
+	thresholdDown      = 0.039
+	maxAdaptOffsetMs   = 15.0
+	maxThresholdUpdate = 100.0 // ms
+	minThreshold       = 6.0
+	maxThreshold       = 600.0
+)
+
+func (u BandwidthUsage) String() string {
+	switch u {
+	case UsageUnderusing:
+		return "underusing"
+	case UsageOverusing:
+		return "overusing"
+	}
+	return "normal"
+}
+
+// packetGroup is a burst of packets sent together, they are compared as one
+type packetGroup struct {
+	firstSend   time.Time
+	lastSend    time.Time
+	lastArrival time.Duration // receiver clock
+	size        int
+}
+
+type point struct {
+	x, y float64
+}
+
+// delayBasedEstimator detects the growth of the queue on the path from the one-way delay variation,
+// the trendline filter and the overuse detector of GCC
+type delayBasedEstimator struct {
+	current *packetGroup
+	prev    *packetGroup
+
+	firstArrival time.Duration
+	started      bool
+	accumulated  float64
+	smoothed     float64
+	history      []point
+	numDeltas    int
+
+	trend     float64
+	prevTrend float64
+	threshold float64
+
+	lastUpdateMs float64
+	updated      bool
+	overuseMs    float64
+	overuseCnt   int
+	usage        BandwidthUsage
+}
+
+func newDelayBasedEstimator() *delayBasedEstimator {
+	return &delayBasedEstimator{
+		threshold: initialThresholdMs,
+	}
+}
+
+// onPacket feed a received packet in the order of sending
+func (e *delayBasedEstimator) onPacket(sendTime time.Time, arrival time.Duration, size int) {
+	if e.current == nil {
+		e.current = &packetGroup{firstSend: sendTime, lastSend: sendTime, lastArrival: arrival, size: size}
+		return
+	}
+	if sendTime.Before(e.current.lastSend) {
+		return // reordered
+	}
+	if sendTime.Sub(e.current.firstSend) <= burstInterval {
+		e.current.lastSend = sendTime
+		if arrival > e.current.lastArrival {
+			e.current.lastArrival = arrival
+		}
+		e.current.size += size
+		return
+	}
+	if e.prev != nil {
+		sendDelta := float64(e.current.lastSend.Sub(e.prev.lastSend)) / float64(time.Millisecond)
+		arrivalDelta := float64(e.current.lastArrival-e.prev.lastArrival) / float64(time.Millisecond)
+		e.update(arrivalDelta-sendDelta, float64(e.current.lastArrival)/float64(time.Millisecond))
+	}
+	e.prev = e.current
+	e.current = &packetGroup{firstSend: sendTime, lastSend: sendTime, lastArrival: arrival, size: size}
+}
+
+// update the trendline with the delay variation of a group, and detect the usage
+func (e *delayBasedEstimator) update(delayDeltaMs, arrivalMs float64) {
+	if !e.started {
+		e.started = true
+		e.firstArrival = time.Duration(arrivalMs * float64(time.Millisecond))
+	}
+	if e.numDeltas < maxDeltas {
+		e.numDeltas++
+	}
+	e.accumulated += delayDeltaMs
+	e.smoothed = trendlineSmoothing*e.smoothed + (1-trendlineSmoothing)*e.accumulated
+	e.history = append(e.history, point{x: arrivalMs - float64(e.firstArrival)/float64(time.Millisecond), y: e.smoothed})
+	if len(e.history) > trendlineWindow {
+		e.history = e.history[1:]
+	}
+	if len(e.history) == trendlineWindow {
+		if slope, ok := linearFitSlope(e.history); ok {
+			e.prevTrend = e.trend
+			e.trend = slope
+		}
+	}
+	e.detect(arrivalMs)
+}
+
+func (e *delayBasedEstimator) detect(nowMs float64) {
+	if e.numDeltas < 2 {
+		return
+	}
+	modified := math.Min(float64(e.numDeltas), maxDeltas) * e.trend * trendlineGain
+	dt := 0.0
+	if e.updated {
+		dt = nowMs - e.lastUpdateMs
+	}
+	switch {
+	case modified > e.threshold:
+		e.overuseMs += dt
+		e.overuseCnt++
+		if e.overuseMs > overusingTimeMs && e.overuseCnt > 1 && e.trend >= e.prevTrend {
+			e.overuseMs = 0
+			e.overuseCnt = 0
+			e.usage = UsageOverusing
+		}
+	case modified < -e.threshold:
+		e.overuseMs = 0
+		e.overuseCnt = 0
+		e.usage = UsageUnderusing
+	default:
+		e.overuseMs = 0
+		e.overuseCnt = 0
+		e.usage = UsageNormal
+	}
+	e.adaptThreshold(modified, nowMs)
+}
+
+// adaptThreshold keep the detector sensitive to the queue but not starved by concurrent tcp flows
+func (e *delayBasedEstimator) adaptThreshold(modified, nowMs float64) {
+	if !e.updated {
+		e.updated = true
+		e.lastUpdateMs = nowMs
+	}
+	if math.Abs(modified) > e.threshold+maxAdaptOffsetMs {
+		e.lastUpdateMs = nowMs // a spike, do not adapt
+		return
+	}
+	k := thresholdUp
+	if math.Abs(modified) < e.threshold {
+		k = thresholdDown
+	}
+	dt := math.Min(nowMs-e.lastUpdateMs, maxThresholdUpdate)
+	e.threshold += k * (math.Abs(modified) - e.threshold) * dt
+	e.threshold = math.Max(minThreshold, math.Min(maxThreshold, e.threshold))
+	e.lastUpdateMs = nowMs
+}
+
+func linearFitSlope(points []point) (float64, bool) {
+	var sumX, sumY float64
+	for _, p := range points {
+		sumX += p.x
+		sumY += p.y
+	}
+	avgX, avgY := sumX/float64(len(points)), sumY/float64(len(points))
+	var num, den float64
+	for _, p := range points {
+		num += (p.x - avgX) * (p.y - avgY)
+		den += (p.x - avgX) * (p.x - avgX)
+	}
+	if den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
@@ -0,0 +1,165 @@
+package cc
+
+import (
+	"time"
+)
+
+const (
+	encoderShare     = 0.9 // the rest of the target is left for the packet headers
+	upSwitchMargin   = 1.25
+	upSwitchHold     = 5 * time.Second // stay on a rung this long before going up
+	downSwitchHold   = time.Second
+	minBitrateChange = 0.05 // smaller changes are not passed to the encoder
+)
+
+// Rung is a resolution and frame rate of the ladder, used above MinBitrate
+type Rung struct {
+	Width      int
+	Height     int
+	FPS        int
+	MinBitrate int64
+}
+
+// EncoderSetting is what the adapter asks the encoder to do
+type EncoderSetting struct {
+	Width   int
+	Height  int
+	FPS     int
+	Bitrate int64
+}
+
+// DefaultLadder goes from the 1280x720 of the camera down to 320x180, highest first
+var DefaultLadder = []Rung{
+	{Width: 1280, Height: 720, FPS: 30, MinBitrate: 2500 * 1000},
+	{Width: 1280, Height: 720, FPS: 20, MinBitrate: 1600 * 1000},
+	{Width: 960, Height: 540, FPS: 30, MinBitrate: 1000 * 1000},
+	{Width: 640, Height: 360, FPS: 30, MinBitrate: 500 * 1000},
+	{Width: 640, Height: 360, FPS: 15, MinBitrate: 300 * 1000},
+	{Width: 320, Height: 180, FPS: 15, MinBitrate: 0},
+}
+
+// Adapter drives the encoder from the target bitrate: the bitrate follows the target,
+// the resolution and frame rate move along the ladder with hysteresis
+type Adapter struct {
+	ladder     []Rung
+	cur        int
+	bitrate    int64
+	lastSwitch time.Time
+}
+
+func NewAdapter(ladder []Rung, initialBitrate int64) *Adapter {
+	a := &Adapter{ladder: ladder}
+	a.cur = a.rungFor(initialBitrate)
+	a.bitrate = int64(float64(initialBitrate) * encoderShare)
+	return a
+}
+
+func (a *Adapter) rungFor(bitrate int64) int {
+	for i, r := range a.ladder {
+		if bitrate >= r.MinBitrate {
+			return i
+		}
+	}
+	return len(a.ladder) - 1
+}
+
+// Update with the target bitrate, changed reports whether the encoder should apply the setting
+func (a *Adapter) Update(target int64, now time.Time) (s EncoderSetting, changed bool) {
+	next := a.cur
+	switch want := a.rungFor(target); {
+	case want > a.cur && now.Sub(a.lastSwitch) >= downSwitchHold:
+		next = want
+	case want < a.cur && now.Sub(a.lastSwitch) >= upSwitchHold &&
+		float64(target) >= upSwitchMargin*float64(a.ladder[a.cur-1].MinBitrate):
+		next = a.cur - 1 // one rung at a time
+	}
+	if next != a.cur {
+		a.cur = next
+		a.lastSwitch = now
+		changed = true
+	}
+
+	bitrate := int64(float64(target) * encoderShare)
+	if diff := float64(bitrate-a.bitrate) / float64(a.bitrate+1); diff > minBitrateChange || diff < -minBitrateChange {
+		a.bitrate = bitrate
+		changed = true
+	}
+	return a.Setting(), changed
+}
+
+// Setting return the current setting
+func (a *Adapter) Setting() EncoderSetting {
+	r := a.ladder[a.cur]
+	return EncoderSetting{Width: r.Width, Height: r.Height, FPS: r.FPS, Bitrate: a.bitrate}
+}
+
+// Restore go back to the rung of the setting the encoder runs, after the receiver rejected the
+// switch of the last Update or did not answer; the switch holds start again
+func (a *Adapter) Restore(s EncoderSetting, now time.Time) {
+	for i, r := range a.ladder {
+		if r.Width == s.Width && r.Height == s.Height && r.FPS == s.FPS {
+			a.cur = i
+			break
+		}
+	}
+	a.lastSwitch = now
+}
+
+// Counter stand at the counter-offer of the receiver: it becomes the top of the ladder, the rungs
+// it does not fit are dropped, so they are not offered again
+func (a *Adapter) Counter(s EncoderSetting, now time.Time) {
+	top := Rung{Width: s.Width, Height: s.Height, FPS: s.FPS}
+	ladder := []Rung{top}
+	exact := false
+	for _, r := range a.ladder {
+		switch {
+		case r.Width == top.Width && r.Height == top.Height && r.FPS == top.FPS:
+			ladder[0].MinBitrate, exact = r.MinBitrate, true
+		case r.Width <= top.Width && r.Height <= top.Height && r.FPS <= top.FPS:
+			ladder = append(ladder, r)
+		case !exact && len(ladder) == 1:
+			// the top takes the place of the lowest rung dropped above the others
+			ladder[0].MinBitrate = r.MinBitrate
+		}
+	}
+	if len(ladder) > 1 && ladder[0].MinBitrate < ladder[1].MinBitrate {
+		ladder[0].MinBitrate = ladder[1].MinBitrate
+	}
+	a.ladder, a.cur, a.lastSwitch = ladder, 0, now
+}
+
+// FrameLimiter drops the frames of the source to reach the frame rate of the setting
+type FrameLimiter struct {
+	next time.Time
+}
+
+// Allow reports whether the frame captured at now should be encoded
+func (l *FrameLimiter) Allow(now time.Time, fps int) bool {
+	if fps <= 0 {
+		return true
+	}
+	interval := time.Second / time.Duration(fps)
+	if !l.next.IsZero() && now.Before(l.next.Add(-interval/4)) {
+		return false
+	}
+	l.next = l.next.Add(interval)
+	if l.next.Before(now) {
+		l.next = now.Add(interval)
+	}
+	return true
+}
+
+// FitLadder return the rungs within the size and frame rate agreed with the receiver,
+// the lowest rung is kept in any case
+func FitLadder(ladder []Rung, maxWidth, maxHeight, maxFPS int) []Rung {
+	var fitted []Rung
+	for _, r := range ladder {
+		if r.Width <= maxWidth && r.Height <= maxHeight && r.FPS <= maxFPS {
+			fitted = append(fitted, r)
+		}
+	}
+	if len(fitted) == 0 {
+		fitted = append(fitted, ladder[len(ladder)-1])
+	}
+	return fitted
+}
@@ -0,0 +1,196 @@
+package cc
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	beta                = 0.85 // decrease factor on overuse
+	multiplicativeGain  = 1.08 // per second, far from the last congested rate
+	minAdditiveIncrease = 4000 // bps per second
+	defaultRTT          = 100 * time.Millisecond
+	averagePacketBits   = 1000 * 8
+
+	lossLow            = 0.02
+	lossHigh           = 0.10
+	lossIncrease       = 1.05
+	lossUpdateInterval = 200 * time.Millisecond
+	minLossPackets     = 20
+
+	rateWindow = 500 * time.Millisecond
+)
+
+type rateState int
+
+const (
+	rateHold rateState = iota
+	rateIncrease
+	rateDecrease
+)
+
+// aimdRateControl turns the usage of the delay based estimator to a bitrate
+type aimdRateControl struct {
+	rate       float64
+	min, max   float64
+	state      rateState
+	lastUpdate time.Time
+
+	// the acked bitrate of the last overuse, the increase slows down near it
+	avgMaxKbps float64
+	varMaxKbps float64
+}
+
+func newAimdRateControl(initial, min, max float64) *aimdRateControl {
+	return &aimdRateControl{
+		rate:       initial,
+		min:        min,
+		max:        max,
+		avgMaxKbps: -1,
+		varMaxKbps: 0.4,
+	}
+}
+
+func (a *aimdRateControl) update(usage BandwidthUsage, acked float64, rtt time.Duration, now time.Time) float64 {
+	switch usage {
+	case UsageOverusing:
+		a.state = rateDecrease
+	case UsageUnderusing:
+		a.state = rateHold // the queue drains, wait for it
+	default:
+		if a.state == rateHold || a.state == rateDecrease {
+			a.state = rateIncrease
+		}
+	}
+
+	dt := 0.0
+	if !a.lastUpdate.IsZero() {
+		dt = math.Min(now.Sub(a.lastUpdate).Seconds(), 1)
+	}
+	a.lastUpdate = now
+
+	switch a.state {
+	case rateIncrease:
+		if a.nearConvergence(acked) {
+			if rtt <= 0 {
+				rtt = defaultRTT
+			}
+			responseTime := (rtt + 100*time.Millisecond).Seconds()
+			a.rate += math.Max(minAdditiveIncrease, averagePacketBits/responseTime) * dt
+		} else {
+			a.rate *= math.Pow(multiplicativeGain, dt)
+		}
+		// never run far ahead of what the path delivers
+		if acked > 0 && a.rate > 1.5*acked+10000 {
+			a.rate = 1.5*acked + 10000
+		}
+	case rateDecrease:
+		next := beta * a.rate
+		if acked > 0 {
+			next = beta * acked
+			a.updateMax(acked / 1000)
+		}
+		if next < a.rate {
+			a.rate = next
+		}
+		a.state = rateHold
+	}
+	a.rate = clamp(a.rate, a.min, a.max)
+	return a.rate
+}
+
+func (a *aimdRateControl) nearConvergence(acked float64) bool {
+	if a.avgMaxKbps < 0 || acked <= 0 {
+		return false
+	}
+	std := math.Sqrt(a.varMaxKbps * a.avgMaxKbps)
+	kbps := acked / 1000
+	if kbps > a.avgMaxKbps+3*std {
+		a.avgMaxKbps = -1 // the path got better, search again
+		return false
+	}
+	return kbps > a.avgMaxKbps-3*std
+}
+
+func (a *aimdRateControl) updateMax(kbps float64) {
+	const alpha = 0.05
+	if a.avgMaxKbps < 0 {
+		a.avgMaxKbps = kbps
+	} else {
+		a.avgMaxKbps = (1-alpha)*a.avgMaxKbps + alpha*kbps
+	}
+	norm := math.Max(a.avgMaxKbps, 1)
+	a.varMaxKbps = (1-alpha)*a.varMaxKbps + alpha*(a.avgMaxKbps-kbps)*(a.avgMaxKbps-kbps)/norm
+	a.varMaxKbps = clamp(a.varMaxKbps, 0.4, 2.5)
+}
+
+// lossBasedControl backs off when the loss is high, whatever the delay says
+type lossBasedControl struct {
+	rate       float64
+	min, max   float64
+	received   int
+	lost       int
+	lossRate   float64
+	lastUpdate time.Time
+}
+
+func newLossBasedControl(initial, min, max float64) *lossBasedControl {
+	return &lossBasedControl{rate: initial, min: min, max: max}
+}
+
+func (l *lossBasedControl) update(received, lost int, now time.Time) float64 {
+	l.received += received
+	l.lost += lost
+	total := l.received + l.lost
+	if total < minLossPackets || now.Sub(l.lastUpdate) < lossUpdateInterval {
+		return l.rate
+	}
+	l.lossRate = float64(l.lost) / float64(total)
+	l.received, l.lost = 0, 0
+	l.lastUpdate = now
+
+	switch {
+	case l.lossRate < lossLow:
+		l.rate *= lossIncrease
+	case l.lossRate > lossHigh:
+		l.rate *= 1 - 0.5*l.lossRate
+	}
+	l.rate = clamp(l.rate, l.min, l.max)
+	return l.rate
+}
+
+type rateSample struct {
+	at   time.Duration
+	size int
+}
+
+// ackedRate measures the receiving rate from the arrival times in the feedback
+type ackedRate struct {
+	samples []rateSample
+	bytes   int
+}
+
+func (r *ackedRate) add(at time.Duration, size int) {
+	r.samples = append(r.samples, rateSample{at: at, size: size})
+	r.bytes += size
+	for len(r.samples) > 0 && at-r.samples[0].at > rateWindow {
+		r.bytes -= r.samples[0].size
+		r.samples = r.samples[1:]
+	}
+}
+
+// bitrate return 0 before the window fills up
+func (r *ackedRate) bitrate() float64 {
+	if len(r.samples) < 2 {
+		return 0
+	}
+	span := r.samples[len(r.samples)-1].at - r.samples[0].at
+	if span < rateWindow/2 {
+		return 0
+	}
+	return float64(r.bytes*8) / span.Seconds()
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
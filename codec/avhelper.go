@@ -0,0 +1,39 @@
+package codec
+
+// #cgo pkg-config: libavcodec libavutil
+// #include <libavcodec/avcodec.h>
+// #include <libavutil/frame.h>
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/giorgisio/goav/avcodec"
+	"github.com/giorgisio/goav/avutil"
+)
+
+// setRateControl set the bitrate of the encoder, goav has no setter for it.
+// libx264 reconfigures itself on the next frame when the bitrate of an opened context changes
+func setRateControl(ctx *avcodec.Context, bitrate, fps int) {
+	c := (*C.struct_AVCodecContext)(unsafe.Pointer(ctx))
+	c.bit_rate = C.int64_t(bitrate)
+	c.rc_max_rate = C.int64_t(bitrate)
+	// half a second of buffer keeps the frame size close to the rate of the path
+	c.rc_buffer_size = C.int(bitrate / 2)
+	c.framerate = C.AVRational{num: C.int(fps), den: 1}
+}
+
+// setFramePts set the pts of the frame, goav has only the getter of best_effort_timestamp
+func setFramePts(frame *avutil.Frame, pts int64) {
+	f := (*C.struct_AVFrame)(unsafe.Pointer(frame))
+	f.pts = C.int64_t(pts)
+}
+
+// setFrameKey make the encoder code the frame as a key frame, or leave the frame type to it
+func setFrameKey(frame *avutil.Frame, key bool) {
+	f := (*C.struct_AVFrame)(unsafe.Pointer(frame))
+	f.pict_type = C.AV_PICTURE_TYPE_NONE
+	if key {
+		f.pict_type = C.AV_PICTURE_TYPE_I
+	}
+}
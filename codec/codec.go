@@ -7,6 +7,10 @@ import (
 	"fmt"
 	"image"
 	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/giorgisio/goav/avcodec"
@@ -20,26 +24,71 @@ const (
 	ImgQueBufferSize     = 1 << 5  // avoid to use large size for image queue
 	PacketQueBufferSize  = 1 << 5
 	RawDataQueBufferSize = 1 << 6
+
+	noPts            = math.MinInt64 // AV_NOPTS_VALUE
+	maxPendingFrames = 1 << 7        // capture times kept for the frames in the encoder
 )
 
+// YUVFrame is a decoded picture with the capture time the sender attached to it
+type YUVFrame struct {
+	*image.YCbCr
+	Timestamp time.Time // capture time, sender clock, zero if unknown
+	DecodedAt time.Time
+}
+
+// rawData is a chunk of the h264 stream, an access unit is flushed to the decoder at its end
+type rawData struct {
+	data  []byte
+	pts   int64
+	flush bool
+	reset bool // drop the data of the parser and the frames of the decoder
+}
+
+// EncoderParams is the setting of the h264 encoder
+type EncoderParams struct {
+	Width   int
+	Height  int
+	FPS     int
+	Bitrate int // bit/s, 0 leaves the rate control of the encoder as default
+	GOPSize int
+}
+
+var DefaultEncoderParams = EncoderParams{
+	Width:   1280,
+	Height:  720,
+	FPS:     30,
+	GOPSize: 10,
+}
+
 type codecHandler struct {
+	mu              sync.Mutex // guards the encoder, it can be reconfigured while encoding
+	params          EncoderParams
+	srcWidth        int // size of the input image of the encoder
+	srcHeight       int
 	formatContext   *avformat.Context
 	videoStreamNb   int              // number of the video stream
 	codecCtx        *avcodec.Context // ctx of decoder or encoder
 	frameYUV        *avutil.Frame    // yuv frame container
 	swsCtx          *swscale.Context
-	yuvImgQueue     chan *image.YCbCr
+	yuvImgQueue     chan *YUVFrame
 	h264PacketQueue chan *avcodec.Packet
-	rawDataQueue    chan []byte
+	rawDataQueue    chan rawData
 	stop            bool
+	decodeErrors    uint64 // packets rejected by the decoder
+	forceKey        bool   // the next frame to encode is a key frame
+
+	tsMu         sync.Mutex // guards the capture times, read by the consumer of the packets
+	frameCnt     int64      // pts of the next frame to encode
+	captureTimes map[int64]time.Time
 }
 
 func NewCodecHandler() *codecHandler {
 	return &codecHandler{
 		stop:            false,
-		yuvImgQueue:     make(chan *image.YCbCr, ImgQueBufferSize),
+		yuvImgQueue:     make(chan *YUVFrame, ImgQueBufferSize),
 		h264PacketQueue: make(chan *avcodec.Packet, PacketQueBufferSize),
-		rawDataQueue:    make(chan []byte, RawDataQueBufferSize),
+		rawDataQueue:    make(chan rawData, RawDataQueBufferSize),
+		captureTimes:    make(map[int64]time.Time),
 	}
 }
 
@@ -116,15 +165,38 @@ func (h *codecHandler) InitAndOpenH264Decoder() error {
 }
 
 func (h *codecHandler) PushRawData(data []byte) {
-	h.rawDataQueue <- data
+	h.rawDataQueue <- rawData{data: data, pts: noPts}
+}
+
+// PushH264AccessUnit push a complete frame captured at captured, it is decoded without waiting
+// for the next frame and the decoded picture carries the capture time
+func (h *codecHandler) PushH264AccessUnit(data []byte, captured time.Time) {
+	pts := int64(noPts)
+	if !captured.IsZero() {
+		pts = captured.UnixNano() / int64(time.Microsecond)
+	}
+	h.rawDataQueue <- rawData{data: data, pts: pts, flush: true}
+}
+
+// ResetH264Decoder drop the data and the reference frames of the stream so far, the next one
+// starts from its key frame, like a sender that started again
+func (h *codecHandler) ResetH264Decoder() {
+	h.rawDataQueue <- rawData{reset: true}
 }
 
 func (h *codecHandler) parserH264Packet() {
 	data := make([]byte, 0, 1<<10)
-	succZeroCnt := 0 // successive zero cnt
+	succZeroCnt := 0    // successive zero cnt
+	pts := int64(noPts) // pts of the nal in data
 	for raw := range h.rawDataQueue {
-		for i := 0; i < len(raw); i++ {
-			b := raw[i]
+		if raw.reset {
+			data = data[:0]
+			succZeroCnt, pts = 0, noPts
+			h.h264PacketQueue <- nil
+			continue
+		}
+		for i := 0; i < len(raw.data); i++ {
+			b := raw.data[i]
 			data = append(data, b)
 			if b != 0 {
 				if b == 1 && succZeroCnt >= 2 {
@@ -136,17 +208,24 @@ func (h *codecHandler) parserH264Packet() {
 						completePacket = data[:len(data)-3]
 						data = data[len(data)-3:]
 					}
-					h.productOnePacket(completePacket)
+					h.productOnePacket(completePacket, pts)
+					pts = raw.pts
 				}
 				succZeroCnt = 0
 			} else {
 				succZeroCnt++
 			}
 		}
+		if raw.flush {
+			h.productOnePacket(data, pts)
+			data = data[:0]
+			succZeroCnt = 0
+		}
 	}
+	close(h.h264PacketQueue)
 }
 
-func (h *codecHandler) productOnePacket(packetData []byte) {
+func (h *codecHandler) productOnePacket(packetData []byte, pts int64) {
 	if len(packetData) == 0 {
 		return
 	}
@@ -158,33 +237,56 @@ func (h *codecHandler) productOnePacket(packetData []byte) {
 	for i := 0; i < packet.Size(); i++ {
 		*(*uint8)(unsafe.Pointer(uintptr(unsafe.Pointer(pdata)) + uintptr(i))) = uint8(packetData[i])
 	}
+	packet.SetPts(pts)
 	h.h264PacketQueue <- packet
 }
 
 func (h *codecHandler) H264Decode() {
 	for packet := range h.h264PacketQueue {
+		if packet == nil {
+			// a reset, the frames held by the decoder are dropped
+			h.codecCtx.AvcodecFlushBuffers()
+			continue
+		}
 		if errno := h.codecCtx.AvcodecSendPacket(packet); errno < 0 {
 			// log.Printf("AvcodecSendPacket error: %v\n", avutil.ErrorFromCode(errno))
+			atomic.AddUint64(&h.decodeErrors, 1)
 			continue
 		}
 		packet.AvFreePacket()
 		packet.AvPacketUnref()
 		avutil.AvFree(unsafe.Pointer(packet.Data()))
-		for {
-			if errno := h.codecCtx.AvcodecReceiveFrame((*avcodec.Frame)(unsafe.Pointer(h.frameYUV))); errno == avutil.AvErrorEAGAIN || errno == avutil.AvErrorEOF {
-				break
-			} else if errno < 0 {
-				log.Fatalf("AvcodecReceiveFrame error: %v", avutil.ErrorFromCode(errno))
-			}
+		h.receiveFrames()
+	}
+	if h.stop {
+		return
+	}
+	// the stream ended, drain the frames held by the decoder
+	h.codecCtx.AvcodecSendPacket(nil)
+	h.receiveFrames()
+	close(h.yuvImgQueue)
+}
 
-			yuvImg, err := frameToYUVPic(h.frameYUV)
-			if err != nil {
-				log.Fatalf("avutil.GetPicture error: %v\n", err)
-				return
-			}
-			h.yuvImgQueue <- yuvImg
-			avutil.AvFrameUnref(h.frameYUV)
+// receiveFrames take the decoded frames out of the decoder to the yuv queue
+func (h *codecHandler) receiveFrames() {
+	for {
+		if errno := h.codecCtx.AvcodecReceiveFrame((*avcodec.Frame)(unsafe.Pointer(h.frameYUV))); errno == avutil.AvErrorEAGAIN || errno == avutil.AvErrorEOF {
+			return
+		} else if errno < 0 {
+			log.Fatalf("AvcodecReceiveFrame error: %v", avutil.ErrorFromCode(errno))
+		}
+
+		yuvImg, err := frameToYUVPic(h.frameYUV)
+		if err != nil {
+			log.Fatalf("avutil.GetPicture error: %v\n", err)
+			return
 		}
+		frame := &YUVFrame{YCbCr: yuvImg, DecodedAt: time.Now()}
+		if pts := avutil.GetBestEffortTimestamp(h.frameYUV); pts != noPts {
+			frame.Timestamp = time.Unix(0, pts*int64(time.Microsecond))
+		}
+		h.yuvImgQueue <- frame
+		avutil.AvFrameUnref(h.frameYUV)
 	}
 }
 
@@ -216,10 +318,15 @@ func (h *codecHandler) initSwsContextForDecoder() {
 	)
 }
 
-func (h *codecHandler) initSwsContextForEncoder() {
+// initSwsContextForEncoder scale the input image to the size of the encoder
+func (h *codecHandler) initSwsContextForEncoder(srcWidth, srcHeight int) {
+	if h.swsCtx != nil {
+		swscale.SwsFreecontext(h.swsCtx)
+	}
+	h.srcWidth, h.srcHeight = srcWidth, srcHeight
 	h.swsCtx = swscale.SwsGetcontext(
-		h.codecCtx.Width(),
-		h.codecCtx.Height(),
+		srcWidth,
+		srcHeight,
 		avcodec.AV_PIX_FMT_RGBA,
 		h.codecCtx.Width(),
 		h.codecCtx.Height(),
@@ -272,13 +379,23 @@ func (h *codecHandler) DecoderRun() {
 					log.Printf("avutil.GetPicture error: %v\n", err)
 					return
 				}
-				h.yuvImgQueue <- yuvImg
+				h.yuvImgQueue <- &YUVFrame{YCbCr: yuvImg, DecodedAt: time.Now()}
 			}
 		}
 	}()
 }
 
 func (h *codecHandler) InitH264Encoder() error {
+	return h.InitH264EncoderWithParams(DefaultEncoderParams)
+}
+
+func (h *codecHandler) InitH264EncoderWithParams(params EncoderParams) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.openH264Encoder(params)
+}
+
+func (h *codecHandler) openH264Encoder(params EncoderParams) error {
 	encoder := avcodec.AvcodecFindEncoder(avcodec.CodecId(avcodec.AV_CODEC_ID_H264))
 	if encoder == nil {
 		return errors.New("not found h264 encoder")
@@ -289,28 +406,85 @@ func (h *codecHandler) InitH264Encoder() error {
 		return errors.New("encoder.AvcodecAllocContext3 failed")
 	}
 
-	encoderCtx.SetEncodeParams2(1280, 720, avcodec.AV_PIX_FMT_YUV, false, 10)
-	encoderCtx.SetTimebase(1, 30)
+	encoderCtx.SetEncodeParams2(params.Width, params.Height, avcodec.AV_PIX_FMT_YUV, false, params.GOPSize)
+	encoderCtx.SetTimebase(1, params.FPS)
+	if params.Bitrate > 0 {
+		setRateControl(encoderCtx, params.Bitrate, params.FPS)
+	}
 
 	if errno := encoderCtx.AvcodecOpen2(encoder, nil); errno != 0 {
 		return fmt.Errorf("encoderCtx.AvcodecOpen2 error: %v", avutil.ErrorFromCode(errno))
 	}
 	h.codecCtx = encoderCtx
+	h.params = params
 
 	if err := h.initYUVFrameContainer(); err != nil {
 		return fmt.Errorf("InitH264Encoder initYUVFrameContainer error: %v", err)
 	}
 
-	h.initSwsContextForEncoder()
+	// the scaler is made for the size of the first input image
+	h.srcWidth, h.srcHeight = 0, 0
 
 	return nil
 }
 
+func (h *codecHandler) closeH264Encoder() {
+	h.codecCtx.AvcodecClose()
+	h.codecCtx.AvcodecFreeContext()
+	avutil.AvFrameFree(h.frameYUV)
+	if h.swsCtx != nil {
+		swscale.SwsFreecontext(h.swsCtx)
+		h.swsCtx = nil
+	}
+}
+
+// ReconfigureH264Encoder reopen the encoder with new params, e.g. a new resolution or frame rate,
+// the next frame will be a key frame
+func (h *codecHandler) ReconfigureH264Encoder(params EncoderParams) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closeH264Encoder()
+	return h.openH264Encoder(params)
+}
+
+// SetH264EncoderBitrate change the bitrate of the running encoder, libx264 applies it on the next frame
+func (h *codecHandler) SetH264EncoderBitrate(bitrate int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.params.Bitrate = bitrate
+	setRateControl(h.codecCtx, bitrate, h.params.FPS)
+}
+
+// ForceKeyFrame make the next frame to encode a key frame
+func (h *codecHandler) ForceKeyFrame() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.forceKey = true
+}
+
+func (h *codecHandler) H264EncoderParams() EncoderParams {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.params
+}
+
 func (h *codecHandler) H264EncoderInputRGBImage(img image.Image) error {
+	return h.H264EncoderInputRGBImageAt(img, time.Now())
+}
+
+// H264EncoderInputRGBImageAt encode the image captured at captured,
+// the capture time of the output packet is given by EncodedPacketTimestamp
+func (h *codecHandler) H264EncoderInputRGBImageAt(img image.Image, captured time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	if h.stop {
 		return nil
 	}
-	numbytes := avcodec.AvpictureGetSize(avcodec.AV_PIX_FMT_RGBA, h.codecCtx.Width(), h.codecCtx.Height())
+	srcWidth, srcHeight := img.Bounds().Dx(), img.Bounds().Dy()
+	if h.swsCtx == nil || srcWidth != h.srcWidth || srcHeight != h.srcHeight {
+		h.initSwsContextForEncoder(srcWidth, srcHeight)
+	}
+	numbytes := avcodec.AvpictureGetSize(avcodec.AV_PIX_FMT_RGBA, srcWidth, srcHeight)
 	buffer := avutil.AvMalloc(uintptr(numbytes))
 	var offset uintptr
 	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
@@ -329,7 +503,7 @@ func (h *codecHandler) H264EncoderInputRGBImage(img image.Image) error {
 	}
 
 	frameRGBA := avutil.AvFrameAlloc()
-	if err := avutil.AvSetFrame(frameRGBA, h.codecCtx.Width(), h.codecCtx.Height(), avcodec.AV_PIX_FMT_RGBA); err != nil {
+	if err := avutil.AvSetFrame(frameRGBA, srcWidth, srcHeight, avcodec.AV_PIX_FMT_RGBA); err != nil {
 		return fmt.Errorf("avutil.AvSetFrame error: %v", err)
 	}
 	defer func() {
@@ -340,15 +514,25 @@ func (h *codecHandler) H264EncoderInputRGBImage(img image.Image) error {
 
 	avpicture := (*avcodec.Picture)(unsafe.Pointer(frameRGBA))
 	if errno := avpicture.AvpictureFill((*uint8)(buffer), avcodec.AV_PIX_FMT_RGBA,
-		h.codecCtx.Width(), h.codecCtx.Height()); errno < 0 {
+		srcWidth, srcHeight); errno < 0 {
 		return fmt.Errorf("AvpictureFill error: %v", avutil.ErrorFromCode(errno))
 	}
 
 	if errno := swscale.SwsScale2(h.swsCtx, avutil.Data(frameRGBA), avutil.Linesize(frameRGBA),
-		0, h.codecCtx.Height(), avutil.Data(h.frameYUV), avutil.Linesize(h.frameYUV)); errno <= 0 {
+		0, srcHeight, avutil.Data(h.frameYUV), avutil.Linesize(h.frameYUV)); errno <= 0 {
 		return fmt.Errorf("SwsScale2 error: %v", avutil.ErrorFromCode(errno))
 	}
 
+	setFrameKey(h.frameYUV, h.forceKey)
+	h.forceKey = false
+
+	h.tsMu.Lock()
+	setFramePts(h.frameYUV, h.frameCnt)
+	h.captureTimes[h.frameCnt] = captured
+	delete(h.captureTimes, h.frameCnt-maxPendingFrames) // dropped by a reconfiguration
+	h.frameCnt++
+	h.tsMu.Unlock()
+
 	packet := avcodec.AvPacketAlloc()
 	gp := 0
 	if errno := h.codecCtx.AvcodecEncodeVideo2(packet, (*avcodec.Frame)(unsafe.Pointer(h.frameYUV)), &gp); errno < 0 {
@@ -362,20 +546,57 @@ func (h *codecHandler) H264EncoderInputRGBImage(img image.Image) error {
 	return nil
 }
 
+// FlushH264Encoder output the frames still delayed in the encoder, at the end of the input
+func (h *codecHandler) FlushH264Encoder() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for !h.stop {
+		packet := avcodec.AvPacketAlloc()
+		gp := 0
+		if errno := h.codecCtx.AvcodecEncodeVideo2(packet, nil, &gp); errno < 0 {
+			return fmt.Errorf("AvcodecEncodeVideo2 error: %v", avutil.ErrorFromCode(errno))
+		}
+		if gp != 1 {
+			return nil
+		}
+		h.h264PacketQueue <- packet
+	}
+	return nil
+}
+
 func (h *codecHandler) GetH264EncoderOutputPacketQueue() <-chan *avcodec.Packet {
 	return h.h264PacketQueue
 }
 
+// EncodedPacketTimestamp return the capture time of the frame of an output packet of the encoder
+func (h *codecHandler) EncodedPacketTimestamp(p *avcodec.Packet) time.Time {
+	h.tsMu.Lock()
+	defer h.tsMu.Unlock()
+	captured := h.captureTimes[p.Pts()]
+	delete(h.captureTimes, p.Pts())
+	return captured
+}
+
 // GetPerFrameDuration calculate the duration of one frame, ms
 func (h *codecHandler) GetPerFrameDuration() uint32 {
 	timeBase := float64(h.codecCtx.AvCodecGetPktTimebase2().Num()) / float64(h.codecCtx.AvCodecGetPktTimebase2().Den())
 	return uint32(timeBase * 1000000)
 }
 
-func (h *codecHandler) YUVImgRecQue() <-chan *image.YCbCr {
+func (h *codecHandler) YUVImgRecQue() <-chan *YUVFrame {
 	return h.yuvImgQueue
 }
 
+// DecodeErrors return the count of the packets rejected by the h264 decoder
+func (h *codecHandler) DecodeErrors() uint64 {
+	return atomic.LoadUint64(&h.decodeErrors)
+}
+
+// QueueDepths return the length of the yuv image, h264 packet and raw data queues
+func (h *codecHandler) QueueDepths() (yuvImg, h264Packet, rawData int) {
+	return len(h.yuvImgQueue), len(h.h264PacketQueue), len(h.rawDataQueue)
+}
+
 func (h *codecHandler) GetVideoWidth() int32 {
 	return int32(h.codecCtx.Width())
 }
@@ -388,6 +609,12 @@ func (h *codecHandler) GetYUVFrameLineSize() [8]int32 {
 	return avutil.Linesize(h.frameYUV)
 }
 
+// EndH264Stream tell the decoder that no data follows, YUVImgRecQue is closed
+// after the last frame. Stop is not needed then.
+func (h *codecHandler) EndH264Stream() {
+	close(h.rawDataQueue)
+}
+
 func (h *codecHandler) Stop() {
 	h.stop = true
 	close(h.h264PacketQueue)
@@ -0,0 +1,102 @@
+package codec
+
+import (
+	"image"
+	"sync"
+	"time"
+)
+
+// LadderFrame is an encoded frame of a rendition of the ladder
+type LadderFrame struct {
+	Rendition int // index of its EncoderParams
+	Data      []byte
+	Key       bool
+	Captured  time.Time
+}
+
+// Ladder encodes the same pictures with several encoders in parallel, e.g. 1080p, 720p and 360p
+// for DASH. The key frames are forced on the same pictures in every rendition so the players
+// can switch between them at the segment boundaries.
+type Ladder struct {
+	encoders    []*codecHandler
+	keyInterval time.Duration
+	lastKey     time.Time
+	frames      chan LadderFrame
+	drained     sync.WaitGroup
+}
+
+// NewLadder open an encoder per params, a key frame is forced every keyInterval of capture time
+func NewLadder(params []EncoderParams, keyInterval time.Duration) (*Ladder, error) {
+	l := &Ladder{
+		keyInterval: keyInterval,
+		frames:      make(chan LadderFrame, PacketQueBufferSize),
+	}
+	for i, p := range params {
+		h := NewCodecHandler()
+		if err := h.InitH264EncoderWithParams(p); err != nil {
+			return nil, err
+		}
+		l.encoders = append(l.encoders, h)
+		l.drained.Add(1)
+		go l.drain(i, h)
+	}
+	go func() {
+		l.drained.Wait()
+		close(l.frames)
+	}()
+	return l, nil
+}
+
+// drain copy the packets of an encoder to the frames of the ladder and free them
+func (l *Ladder) drain(rendition int, h *codecHandler) {
+	defer l.drained.Done()
+	for p := range h.GetH264EncoderOutputPacketQueue() {
+		f := LadderFrame{
+			Rendition: rendition,
+			Data:      append([]byte(nil), PacketData(p)...),
+			Key:       IsKeyPacket(p),
+			Captured:  h.EncodedPacketTimestamp(p),
+		}
+		p.AvPacketUnref()
+		p.AvFreePacket()
+		l.frames <- f
+	}
+}
+
+// Encode the picture with every encoder, it returns once all of them have it
+func (l *Ladder) Encode(img image.Image, captured time.Time) error {
+	if l.lastKey.IsZero() || captured.Sub(l.lastKey) >= l.keyInterval {
+		l.lastKey = captured
+		for _, h := range l.encoders {
+			h.ForceKeyFrame()
+		}
+	}
+	errs := make([]error, len(l.encoders))
+	var wg sync.WaitGroup
+	for i, h := range l.encoders {
+		wg.Add(1)
+		go func(i int, h *codecHandler) {
+			defer wg.Done()
+			errs[i] = h.H264EncoderInputRGBImageAt(img, captured)
+		}(i, h)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Frames is closed after Stop
+func (l *Ladder) Frames() <-chan LadderFrame {
+	return l.frames
+}
+
+// Stop the encoders, after the last Encode
+func (l *Ladder) Stop() {
+	for _, h := range l.encoders {
+		h.Stop()
+	}
+}
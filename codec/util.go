@@ -3,8 +3,10 @@ package codec
 import (
 	"errors"
 	"image"
+	"reflect"
 	"unsafe"
 
+	"github.com/giorgisio/goav/avcodec"
 	"github.com/giorgisio/goav/avutil"
 )
 
@@ -36,3 +38,18 @@ func frameToYUVPic(frame *avutil.Frame) (*image.YCbCr, error) {
 	}
 	return img, nil
 }
+
+// PacketData return the data of the packet without copy, it is valid until the packet is freed
+func PacketData(p *avcodec.Packet) []byte {
+	var data []byte
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	header.Data = uintptr(unsafe.Pointer(p.Data()))
+	header.Len = p.Size()
+	header.Cap = p.Size()
+	return data
+}
+
+// IsKeyPacket reports whether the packet starts a gop
+func IsKeyPacket(p *avcodec.Packet) bool {
+	return p.Flags()&avcodec.AV_PKT_FLAG_KEY != 0
+}
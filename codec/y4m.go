@@ -0,0 +1,218 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrY4MFrameSize = errors.New("y4m: frame size differs from the stream")
+	ErrY4MHeader    = errors.New("y4m: bad header")
+)
+
+// Y4MWriter writes decoded frames as a YUV4MPEG2 stream, the size is taken from the first frame
+type Y4MWriter struct {
+	w      *bufio.Writer
+	fps    int
+	width  int
+	height int
+}
+
+func NewY4MWriter(w io.Writer, fps int) *Y4MWriter {
+	return &Y4MWriter{w: bufio.NewWriter(w), fps: fps}
+}
+
+// WriteFrame write a 4:2:0 frame, frames of another size than the first are refused
+func (y *Y4MWriter) WriteFrame(img *image.YCbCr) error {
+	if img.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		return fmt.Errorf("y4m: subsample ratio %v, want 4:2:0", img.SubsampleRatio)
+	}
+	size := img.Rect.Size()
+	if y.width == 0 {
+		y.width, y.height = size.X, size.Y
+		if _, err := fmt.Fprintf(y.w, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C420mpeg2\n", y.width, y.height, y.fps); err != nil {
+			return err
+		}
+	}
+	if size.X != y.width || size.Y != y.height {
+		return ErrY4MFrameSize
+	}
+	if _, err := y.w.WriteString("FRAME\n"); err != nil {
+		return err
+	}
+	cw, ch := (size.X+1)/2, (size.Y+1)/2
+	planes := []struct {
+		pix          []byte
+		stride, w, h int
+		offset       int
+	}{
+		{img.Y, img.YStride, size.X, size.Y, img.YOffset(img.Rect.Min.X, img.Rect.Min.Y)},
+		{img.Cb, img.CStride, cw, ch, img.COffset(img.Rect.Min.X, img.Rect.Min.Y)},
+		{img.Cr, img.CStride, cw, ch, img.COffset(img.Rect.Min.X, img.Rect.Min.Y)},
+	}
+	for _, p := range planes {
+		for row := 0; row < p.h; row++ {
+			start := p.offset + row*p.stride
+			if _, err := y.w.Write(p.pix[start : start+p.w]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush write the buffered frames
+func (y *Y4MWriter) Flush() error {
+	return y.w.Flush()
+}
+
+// Y4MReader reads the frames of a YUV4MPEG2 stream, 8 bit 4:2:0, 4:2:2, 4:4:4 or mono
+type Y4MReader struct {
+	r       *bufio.Reader
+	width   int
+	height  int
+	rateNum int
+	rateDen int
+	ratio   image.YCbCrSubsampleRatio
+	mono    bool
+}
+
+// NewY4MReader read the stream header
+func NewY4MReader(r io.Reader) (*Y4MReader, error) {
+	y := &Y4MReader{r: bufio.NewReader(r), rateNum: 25, rateDen: 1, ratio: image.YCbCrSubsampleRatio420}
+	line, err := y.readLine()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "YUV4MPEG2" {
+		return nil, ErrY4MHeader
+	}
+	for _, f := range fields[1:] {
+		value := f[1:]
+		switch f[0] {
+		case 'W':
+			y.width, err = strconv.Atoi(value)
+		case 'H':
+			y.height, err = strconv.Atoi(value)
+		case 'F':
+			y.rateNum, y.rateDen, err = parseRatio(value)
+		case 'C':
+			err = y.setColorspace(value)
+		}
+		// the interlacing, the pixel aspect and the X extensions don't change the planes
+		if err != nil {
+			return nil, fmt.Errorf("y4m: header %s: %v", f, err)
+		}
+	}
+	if y.width <= 0 || y.height <= 0 || y.rateNum <= 0 || y.rateDen <= 0 {
+		return nil, ErrY4MHeader
+	}
+	return y, nil
+}
+
+func parseRatio(s string) (num, den int, err error) {
+	n, d, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, ErrY4MHeader
+	}
+	if num, err = strconv.Atoi(n); err != nil {
+		return 0, 0, err
+	}
+	den, err = strconv.Atoi(d)
+	return num, den, err
+}
+
+func (y *Y4MReader) setColorspace(c string) error {
+	switch c {
+	case "420", "420jpeg", "420paldv", "420mpeg2":
+		y.ratio = image.YCbCrSubsampleRatio420
+	case "422":
+		y.ratio = image.YCbCrSubsampleRatio422
+	case "444":
+		y.ratio = image.YCbCrSubsampleRatio444
+	case "mono":
+		y.mono = true
+	default:
+		return fmt.Errorf("colorspace %s not supported", c)
+	}
+	return nil
+}
+
+// readLine read a header line without its newline, longer than the buffer is no header
+func (y *Y4MReader) readLine() (string, error) {
+	line, err := y.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", ErrY4MHeader
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(line[:len(line)-1]), nil
+}
+
+// Size return the size of the frames
+func (y *Y4MReader) Size() image.Point {
+	return image.Pt(y.width, y.height)
+}
+
+// FrameRate return the frames per second as a ratio, like 30000:1001
+func (y *Y4MReader) FrameRate() (num, den int) {
+	return y.rateNum, y.rateDen
+}
+
+// ReadFrame read the next frame, io.EOF after the last one and io.ErrUnexpectedEOF for a cut
+// one; a mono frame gets neutral 4:2:0 chroma
+func (y *Y4MReader) ReadFrame() (*image.YCbCr, error) {
+	line, err := y.readLine()
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+	// the FRAME parameters are ignored like the X ones of the stream
+	if line != "FRAME" && !strings.HasPrefix(line, "FRAME ") {
+		return nil, fmt.Errorf("y4m: frame header %q", line)
+	}
+	ratio := y.ratio
+	if y.mono {
+		ratio = image.YCbCrSubsampleRatio420
+	}
+	img := image.NewYCbCr(image.Rect(0, 0, y.width, y.height), ratio)
+	if _, err := io.ReadFull(y.r, img.Y); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if y.mono {
+		fill := bytes.Repeat([]byte{128}, len(img.Cb))
+		copy(img.Cb, fill)
+		copy(img.Cr, fill)
+		return img, nil
+	}
+	if _, err := io.ReadFull(y.r, img.Cb); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if _, err := io.ReadFull(y.r, img.Cr); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return img, nil
+}
+
+// unexpectedEOF is io.ErrUnexpectedEOF for a frame cut at a plane
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package codec
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testFrame is a 4:2:0 picture with the bytes of its planes all different of the next frame
+func testFrame(width, height, n int) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	for i := range img.Y {
+		img.Y[i] = uint8(i + n)
+	}
+	for i := range img.Cb {
+		img.Cb[i], img.Cr[i] = uint8(2*i+n), uint8(3*i+n)
+	}
+	return img
+}
+
+func TestY4M(t *testing.T) {
+	// an odd size has the chroma rounded up
+	var stream bytes.Buffer
+	w := NewY4MWriter(&stream, 30)
+	var frames []*image.YCbCr
+	for n := 0; n < 3; n++ {
+		frames = append(frames, testFrame(7, 5, n))
+		if err := w.WriteFrame(frames[n]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	written := append([]byte(nil), stream.Bytes()...)
+
+	r, err := NewY4MReader(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num, den := r.FrameRate(); r.Size() != image.Pt(7, 5) || num != 30 || den != 1 {
+		t.Fatalf("size %v rate %d:%d", r.Size(), num, den)
+	}
+	var again bytes.Buffer
+	w = NewY4MWriter(&again, 30)
+	for n := 0; ; n++ {
+		img, err := r.ReadFrame()
+		if err == io.EOF {
+			if n != len(frames) {
+				t.Fatalf("%d frames of %d", n, len(frames))
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(img.Y, frames[n].Y) || !bytes.Equal(img.Cb, frames[n].Cb) || !bytes.Equal(img.Cr, frames[n].Cr) {
+			t.Fatalf("frame %d differs", n)
+		}
+		if err := w.WriteFrame(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	// read and written again, the stream is the same to the byte
+	if !bytes.Equal(again.Bytes(), written) {
+		t.Fatal("the stream written again differs")
+	}
+}
+
+func TestY4MHeader(t *testing.T) {
+	r, err := NewY4MReader(strings.NewReader("YUV4MPEG2 W4 H2 F30000:1001 It A1:1 C444 XYSCSS=444\nFRAME Ixyz\n" +
+		strings.Repeat("y", 8) + strings.Repeat("u", 8) + strings.Repeat("v", 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num, den := r.FrameRate(); num != 30000 || den != 1001 {
+		t.Fatalf("rate %d:%d", num, den)
+	}
+	img, err := r.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.SubsampleRatio != image.YCbCrSubsampleRatio444 || img.Cr[7] != 'v' {
+		t.Fatalf("%v frame, Cr %q", img.SubsampleRatio, img.Cr)
+	}
+
+	r, err = NewY4MReader(strings.NewReader("YUV4MPEG2 W2 H2 Cmono\nFRAME\nyyyy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img, err := r.ReadFrame(); err != nil || img.Y[3] != 'y' || img.Cb[0] != 128 {
+		t.Fatalf("mono frame %v, %v", img, err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"YUV4MPEG W2 H2\n",
+		"YUV4MPEG2 W2\n",
+		"YUV4MPEG2 W2 H2 F30\n",
+		"YUV4MPEG2 W2 H2 C420p10\n",
+		"YUV4MPEG2 W2 H2",
+	} {
+		if _, err := NewY4MReader(strings.NewReader(bad)); err == nil {
+			t.Fatalf("header %q read", bad)
+		}
+	}
+
+	// a frame cut in its planes
+	r, _ = NewY4MReader(strings.NewReader("YUV4MPEG2 W2 H2\nFRAME\nyyyyu"))
+	if _, err := r.ReadFrame(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("cut frame: %v", err)
+	}
+	r, _ = NewY4MReader(strings.NewReader("YUV4MPEG2 W2 H2\nFRAMES\n"))
+	if _, err := r.ReadFrame(); err == nil {
+		t.Fatal("bad frame header read")
+	}
+}
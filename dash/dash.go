@@ -0,0 +1,189 @@
+// Package dash packages a ladder of H.264 renditions for MPEG-DASH players: fragmented MP4
+// init and media segments cut at the same key frames in every rendition, and the live MPD
+package dash
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/mp4"
+)
+
+const (
+	defaultDuration = mp4.Timescale / 30
+	keepSegments    = 3 // behind the window, for the players that are late
+)
+
+var (
+	ErrClosed                = errors.New("dash: packager closed")
+	ErrUnknownRepresentation = errors.New("dash: unknown representation")
+)
+
+type Config struct {
+	SegmentDuration time.Duration // a segment ends at the first key frame after it
+	Window          int           // segments in the manifest
+}
+
+var DefaultConfig = Config{
+	SegmentDuration: 2 * time.Second,
+	Window:          6,
+}
+
+// Representation is a rendition of the ladder
+type Representation struct {
+	ID        string // the directory of its segments, like 720p
+	Width     int
+	Height    int
+	FPS       int
+	Bandwidth int // bit/s
+}
+
+type frame struct {
+	data []byte
+	key  bool
+	pts  int64 // mp4.Timescale since the start of the packager
+}
+
+type segment struct {
+	time     uint64
+	duration uint64
+	data     []byte
+}
+
+// representation is the state of the segmenter of a rendition
+type representation struct {
+	Representation
+	ps        h264.ParameterSets
+	init      []byte
+	started   bool
+	pending   *frame // waits for the next frame, which gives its duration
+	samples   []mp4.Sample
+	start     int64 // of the samples
+	segments  []segment
+	fragments uint32
+}
+
+// Packager segments the renditions and serves them over HTTP. The renditions must have their key
+// frames at the same capture times for the players to switch between them.
+type Packager struct {
+	mu      sync.Mutex
+	cfg     Config
+	reps    []*representation
+	started bool
+	start   time.Time // the availability start time, time 0 of the segments
+	closed  bool
+}
+
+func NewPackager(cfg Config, reps []Representation) *Packager {
+	if cfg.SegmentDuration <= 0 {
+		cfg.SegmentDuration = DefaultConfig.SegmentDuration
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultConfig.Window
+	}
+	p := &Packager{cfg: cfg}
+	for _, r := range reps {
+		p.reps = append(p.reps, &representation{Representation: r})
+	}
+	return p
+}
+
+// WriteFrame add an Annex B access unit of the rendition i captured at t, the rendition starts on
+// a key frame with SPS/PPS
+func (p *Packager) WriteFrame(i int, au []byte, t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if i < 0 || i >= len(p.reps) {
+		return ErrUnknownRepresentation
+	}
+	r := p.reps[i]
+	if r.ps.Update(au) && r.ps.Complete() {
+		init, err := mp4.InitSegment(r.ps)
+		if err != nil {
+			return err
+		}
+		r.init = init
+	}
+	key := h264.IsKeyFrame(au)
+	if !r.started {
+		if !key || r.init == nil {
+			return nil
+		}
+		r.started = true
+	}
+	if !p.started {
+		p.started, p.start = true, t
+	}
+
+	pts := int64(t.Sub(p.start)) * mp4.Timescale / int64(time.Second)
+	if r.pending != nil {
+		duration := pts - r.pending.pts
+		if duration <= 0 {
+			duration = 1
+		}
+		p.add(r, *r.pending, duration)
+	}
+	r.pending = &frame{data: append([]byte(nil), au...), key: key, pts: pts}
+	return nil
+}
+
+// add a frame with its duration, a key frame a segment duration after the start of the segment
+// begins the next one
+func (p *Packager) add(r *representation, f frame, duration int64) {
+	target := int64(p.cfg.SegmentDuration) * mp4.Timescale / int64(time.Second)
+	if f.key && len(r.samples) > 0 && f.pts-r.start >= target {
+		p.flush(r)
+	}
+	if len(r.samples) == 0 {
+		r.start = f.pts
+	}
+	r.samples = append(r.samples, mp4.Sample{Data: f.data, Duration: uint32(duration), Key: f.key})
+}
+
+// flush mux the samples to a segment, the old segments leave
+func (p *Packager) flush(r *representation) {
+	if len(r.samples) == 0 {
+		return
+	}
+	var duration uint64
+	for _, s := range r.samples {
+		duration += uint64(s.Duration)
+	}
+	r.fragments++
+	r.segments = append(r.segments, segment{
+		time:     uint64(r.start),
+		duration: duration,
+		data:     mp4.Fragment(r.fragments, uint64(r.start), r.samples),
+	})
+	r.samples = nil
+	if n := len(r.segments) - p.cfg.Window - keepSegments; n > 0 {
+		r.segments = append(r.segments[:0], r.segments[n:]...)
+	}
+}
+
+// Close end the segments, the manifest becomes static
+func (p *Packager) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	for _, r := range p.reps {
+		if r.pending != nil {
+			duration := int64(defaultDuration)
+			if n := len(r.samples); n > 0 {
+				duration = int64(r.samples[n-1].Duration)
+			}
+			p.add(r, *r.pending, duration)
+			r.pending = nil
+		}
+		p.flush(r)
+	}
+	return nil
+}
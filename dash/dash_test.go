@@ -0,0 +1,133 @@
+package dash
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+)
+
+var (
+	sps = []byte{0x67, 0x42, 0xc0, 0x0d, 0xf4, 0x0a, 0x0f, 0xc8} // baseline 320x240
+	pps = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+var ladder = []Representation{
+	{ID: "720p", Width: 1280, Height: 720, FPS: 30, Bandwidth: 2500000},
+	{ID: "540p", Width: 960, Height: 540, FPS: 30, Bandwidth: 1200000},
+	{ID: "360p", Width: 640, Height: 360, FPS: 30, Bandwidth: 600000},
+}
+
+func accessUnit(i, size int, key bool) []byte {
+	var au []byte
+	nalType := byte(0x41)
+	if key {
+		au = h264.AppendAnnexB(au, sps, pps)
+		nalType = 0x65
+	}
+	body := bytes.Repeat([]byte{byte(i) | 1}, size)
+	body[0] = nalType
+	return h264.AppendAnnexB(au, body)
+}
+
+// feed write n frames at 30fps to every rendition, with the key frames every 2 seconds and a scene cut
+// in the last rendition
+func feed(t *testing.T, p *Packager, start time.Time, n int) {
+	for i := 0; i < n; i++ {
+		for r := range p.reps {
+			key := i%60 == 0 || (r == len(p.reps)-1 && i%60 == 45)
+			if err := p.WriteFrame(r, accessUnit(i, 1000>>uint(r), key), start.Add(time.Duration(i)*time.Second/30)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func get(t *testing.T, url string) ([]byte, *http.Response) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, resp
+}
+
+func TestAlignedSegments(t *testing.T) {
+	p := NewPackager(Config{SegmentDuration: 2 * time.Second, Window: 4}, ladder)
+	feed(t, p, time.Now(), 60*10+2)
+	for _, r := range p.reps[1:] {
+		if len(r.segments) != len(p.reps[0].segments) {
+			t.Fatalf("%s has %d segments, %s %d", r.ID, len(r.segments), p.reps[0].ID, len(p.reps[0].segments))
+		}
+		for i, s := range r.segments {
+			if s0 := p.reps[0].segments[i]; s.time != s0.time || s.duration != s0.duration {
+				t.Fatalf("segment %d of %s at %d+%d, of %s at %d+%d", i, r.ID, s.time, s.duration, p.reps[0].ID, s0.time, s0.duration)
+			}
+		}
+	}
+
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	b, resp := get(t, srv.URL+"/manifest.mpd")
+	if resp.Header.Get("Content-Type") != "application/dash+xml" {
+		t.Fatalf("manifest of %s", resp.Header.Get("Content-Type"))
+	}
+	var m mpd
+	if err := xml.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	reps := m.Period.AdaptationSet.Representations
+	if m.Type != "dynamic" || len(reps) != len(ladder) || m.Period.AdaptationSet.MaxWidth != 1280 {
+		t.Fatalf("manifest\n%s", b)
+	}
+	for i, rep := range reps {
+		timeline := rep.SegmentTemplate.Timeline
+		// 10 segments of 2s, the last 4 listed in one S
+		if rep.ID != ladder[i].ID || rep.Codecs != "avc1.42c00d" || len(timeline) != 1 ||
+			*timeline[0].T != 6*2*90000 || timeline[0].D != 2*90000 || timeline[0].R != 3 {
+			t.Fatalf("representation %d in\n%s", i, b)
+		}
+	}
+
+	for _, rep := range ladder {
+		b, resp := get(t, srv.URL+"/"+rep.ID+"/init.mp4")
+		if resp.StatusCode != http.StatusOK || string(b[4:8]) != "ftyp" {
+			t.Fatalf("init of %s: %d", rep.ID, resp.StatusCode)
+		}
+		b, resp = get(t, fmt.Sprintf("%s/%s/%d.m4s", srv.URL, rep.ID, 9*2*90000))
+		if resp.StatusCode != http.StatusOK || string(b[4:8]) != "moof" {
+			t.Fatalf("segment of %s: %d", rep.ID, resp.StatusCode)
+		}
+	}
+	if _, resp := get(t, srv.URL+"/720p/0.m4s"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("removed segment: %d", resp.StatusCode)
+	}
+}
+
+func TestClose(t *testing.T) {
+	p := NewPackager(DefaultConfig, ladder[:1])
+	feed(t, p, time.Now(), 90)
+	p.Close()
+	if err := p.WriteFrame(0, accessUnit(0, 10, true), time.Now()); err != ErrClosed {
+		t.Fatalf("write after close: %v", err)
+	}
+	m := p.manifest(time.Now())
+	// a segment of 2s and one of the last 30 frames, the scene cut does not end a segment
+	if m.Type != "static" || m.MediaPresentationDuration != "PT3.000S" || m.AvailabilityStartTime != "" {
+		t.Fatalf("%+v", m)
+	}
+	timeline := m.Period.AdaptationSet.Representations[0].SegmentTemplate.Timeline
+	if len(timeline) != 2 || timeline[1].T == nil || *timeline[1].T != 2*90000 {
+		t.Fatalf("timeline %+v", timeline)
+	}
+}
@@ -0,0 +1,240 @@
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l-f-h/video/mp4"
+)
+
+const (
+	manifestName = "manifest.mpd"
+	mpdTime      = "2006-01-02T15:04:05.000Z"
+)
+
+type mpd struct {
+	XMLName                    xml.Name `xml:"MPD"`
+	Xmlns                      string   `xml:"xmlns,attr"`
+	Profiles                   string   `xml:"profiles,attr"`
+	Type                       string   `xml:"type,attr"`
+	AvailabilityStartTime      string   `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime                string   `xml:"publishTime,attr,omitempty"`
+	MediaPresentationDuration  string   `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinimumUpdatePeriod        string   `xml:"minimumUpdatePeriod,attr,omitempty"`
+	MinBufferTime              string   `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       string   `xml:"timeShiftBufferDepth,attr,omitempty"`
+	SuggestedPresentationDelay string   `xml:"suggestedPresentationDelay,attr,omitempty"`
+	Period                     period
+}
+
+type period struct {
+	ID            string        `xml:"id,attr"`
+	Start         string        `xml:"start,attr"`
+	AdaptationSet adaptationSet `xml:"AdaptationSet"`
+}
+
+type adaptationSet struct {
+	ContentType      string              `xml:"contentType,attr"`
+	MimeType         string              `xml:"mimeType,attr"`
+	SegmentAlignment bool                `xml:"segmentAlignment,attr"`
+	StartWithSAP     int                 `xml:"startWithSAP,attr"`
+	MaxWidth         int                 `xml:"maxWidth,attr"`
+	MaxHeight        int                 `xml:"maxHeight,attr"`
+	Representations  []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID              string          `xml:"id,attr"`
+	Codecs          string          `xml:"codecs,attr"`
+	Width           int             `xml:"width,attr"`
+	Height          int             `xml:"height,attr"`
+	FrameRate       int             `xml:"frameRate,attr,omitempty"`
+	Bandwidth       int             `xml:"bandwidth,attr"`
+	SegmentTemplate segmentTemplate `xml:"SegmentTemplate"`
+}
+
+type segmentTemplate struct {
+	Timescale      int                `xml:"timescale,attr"`
+	Initialization string             `xml:"initialization,attr"`
+	Media          string             `xml:"media,attr"`
+	Timeline       []timelineSegments `xml:"SegmentTimeline>S"`
+}
+
+// timelineSegments is an S of the timeline, R more segments of the same duration follow the first
+type timelineSegments struct {
+	T *uint64 `xml:"t,attr"`
+	D uint64  `xml:"d,attr"`
+	R int     `xml:"r,attr,omitempty"`
+}
+
+// duration of the xsd, like PT2.000S
+func duration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
+
+// manifest describe the segments of the window, the renditions without segments are left out
+func (p *Packager) manifest(now time.Time) *mpd {
+	m := &mpd{
+		Xmlns:         "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:      "urn:mpeg:dash:profile:isoff-live:2011",
+		Type:          "dynamic",
+		MinBufferTime: duration(p.cfg.SegmentDuration),
+		Period:        period{ID: "0", Start: duration(0)},
+	}
+	set := &m.Period.AdaptationSet
+	set.ContentType, set.MimeType = "video", "video/mp4"
+	set.SegmentAlignment, set.StartWithSAP = true, 1
+
+	var end uint64
+	for _, r := range p.reps {
+		segments := r.segments
+		if len(segments) == 0 {
+			continue
+		}
+		if n := len(segments) - p.cfg.Window; n > 0 {
+			segments = segments[n:]
+		}
+		rep := mpdRepresentation{
+			ID:        r.ID,
+			Codecs:    r.ps.Codec(),
+			Width:     r.Width,
+			Height:    r.Height,
+			FrameRate: r.FPS,
+			Bandwidth: r.Bandwidth,
+			SegmentTemplate: segmentTemplate{
+				Timescale:      mp4.Timescale,
+				Initialization: "$RepresentationID$/init.mp4",
+				Media:          "$RepresentationID$/$Time$.m4s",
+			},
+		}
+		timeline := &rep.SegmentTemplate.Timeline
+		for i, s := range segments {
+			n := len(*timeline)
+			if i > 0 && (*timeline)[n-1].D == s.duration && segments[i-1].time+segments[i-1].duration == s.time {
+				(*timeline)[n-1].R++
+				continue
+			}
+			t := s.time
+			*timeline = append(*timeline, timelineSegments{T: &t, D: s.duration})
+		}
+		last := segments[len(segments)-1]
+		if last.time+last.duration > end {
+			end = last.time + last.duration
+		}
+		if r.Width > set.MaxWidth {
+			set.MaxWidth = r.Width
+		}
+		if r.Height > set.MaxHeight {
+			set.MaxHeight = r.Height
+		}
+		set.Representations = append(set.Representations, rep)
+	}
+
+	if p.closed {
+		m.Type = "static"
+		m.MediaPresentationDuration = duration(time.Duration(end) * time.Second / mp4.Timescale)
+		return m
+	}
+	m.AvailabilityStartTime = p.start.UTC().Format(mpdTime)
+	m.PublishTime = now.UTC().Format(mpdTime)
+	m.MinimumUpdatePeriod = duration(p.cfg.SegmentDuration)
+	m.TimeShiftBufferDepth = duration(time.Duration(p.cfg.Window) * p.cfg.SegmentDuration)
+	m.SuggestedPresentationDelay = duration(2 * p.cfg.SegmentDuration)
+	return m
+}
+
+// ServeHTTP serve the viewer page, the manifest, and the init and media segments of each rendition:
+// manifest.mpd, 720p/init.mp4, 720p/180000.m4s
+func (p *Packager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	name := strings.Trim(r.URL.Path, "/")
+	switch {
+	case name == "" || name == "index.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(viewerPage))
+	case name == manifestName:
+		p.serveManifest(w)
+	default:
+		p.serveSegment(w, r, name)
+	}
+}
+
+func (p *Packager) serveManifest(w http.ResponseWriter) {
+	p.mu.Lock()
+	if !p.started {
+		p.mu.Unlock()
+		http.Error(w, "no stream yet", http.StatusNotFound)
+		return
+	}
+	m := p.manifest(time.Now())
+	p.mu.Unlock()
+	b, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dash+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
+
+func (p *Packager) serveSegment(w http.ResponseWriter, r *http.Request, name string) {
+	fields := strings.Split(name, "/")
+	if len(fields) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	p.mu.Lock()
+	var data []byte
+	for _, rep := range p.reps {
+		if rep.ID != fields[0] {
+			continue
+		}
+		if fields[1] == "init.mp4" {
+			data = rep.init
+			break
+		}
+		if !strings.HasSuffix(fields[1], ".m4s") {
+			break
+		}
+		t, err := strconv.ParseUint(strings.TrimSuffix(fields[1], ".m4s"), 10, 64)
+		if err != nil {
+			break
+		}
+		for _, s := range rep.segments {
+			if s.time == t {
+				data = s.data
+			}
+		}
+	}
+	p.mu.Unlock()
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Write(data)
+}
+
+// viewerPage plays the manifest next to it with dash.js
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>video</title>
+<script src="https://cdn.dashjs.org/latest/dash.all.min.js"></script>
+</head>
+<body style="margin:0;background:#000">
+<video id="video" controls autoplay muted playsinline style="width:100%;height:100vh"></video>
+<script>
+var player = dashjs.MediaPlayer().create();
+player.initialize(document.getElementById('video'), 'manifest.mpd', true);
+</script>
+</body>
+</html>
+`
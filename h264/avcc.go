@@ -0,0 +1,93 @@
+package h264
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBadLength        = errors.New("h264: bad nal unit length")
+	ErrBadDecoderConfig = errors.New("h264: bad decoder configuration record")
+)
+
+// AppendAVCC append the NAL units to b each with a 4 byte length, the format of mp4 and flv
+func AppendAVCC(b []byte, nalus ...[]byte) []byte {
+	for _, n := range nalus {
+		b = append(b, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], uint32(len(n)))
+		b = append(b, n...)
+	}
+	return b
+}
+
+// SplitAVCC return the NAL units of length prefixed data, lengthSize is 1 to 4 bytes
+func SplitAVCC(b []byte, lengthSize int) ([][]byte, error) {
+	var nalus [][]byte
+	for len(b) > 0 {
+		if len(b) < lengthSize {
+			return nil, ErrBadLength
+		}
+		n := 0
+		for _, c := range b[:lengthSize] {
+			n = n<<8 | int(c)
+		}
+		b = b[lengthSize:]
+		if n > len(b) {
+			return nil, ErrBadLength
+		}
+		if n > 0 {
+			nalus = append(nalus, b[:n])
+		}
+		b = b[n:]
+	}
+	return nalus, nil
+}
+
+// DecoderConfig return the AVCDecoderConfigurationRecord of the parameter sets, the avcC box of mp4
+// and the sequence header of flv, with NAL unit lengths of 4 bytes
+func (ps *ParameterSets) DecoderConfig() []byte {
+	b := []byte{1, ps.SPS[1], ps.SPS[2], ps.SPS[3], 0xff, 0xe1} // version, profile, compatibility, level, 4 byte lengths, 1 sps
+	b = append(b, byte(len(ps.SPS)>>8), byte(len(ps.SPS)))
+	b = append(b, ps.SPS...)
+	b = append(b, 1, byte(len(ps.PPS)>>8), byte(len(ps.PPS)))
+	return append(b, ps.PPS...)
+}
+
+// Codec return the RFC 6381 codecs parameter of the stream, like avc1.42c01f for HLS and DASH
+func (ps *ParameterSets) Codec() string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", ps.SPS[1], ps.SPS[2], ps.SPS[3])
+}
+
+// ParseDecoderConfig take the first SPS and PPS of an AVCDecoderConfigurationRecord, and the size of its NAL unit lengths
+func ParseDecoderConfig(b []byte) (ParameterSets, int, error) {
+	var ps ParameterSets
+	if len(b) < 6 || b[0] != 1 {
+		return ps, 0, ErrBadDecoderConfig
+	}
+	lengthSize := int(b[4]&3) + 1
+	sets := func(b []byte, count int) ([]byte, []byte, error) {
+		var first []byte
+		for i := 0; i < count; i++ {
+			if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+				return nil, nil, ErrBadDecoderConfig
+			}
+			n := int(binary.BigEndian.Uint16(b))
+			if first == nil {
+				first = append([]byte(nil), b[2:2+n]...)
+			}
+			b = b[2+n:]
+		}
+		return first, b, nil
+	}
+	sps, rest, err := sets(b[6:], int(b[5]&0x1f))
+	if err != nil || len(rest) < 1 {
+		return ps, 0, ErrBadDecoderConfig
+	}
+	pps, _, err := sets(rest[1:], int(rest[0]))
+	if err != nil || sps == nil || pps == nil {
+		return ps, 0, ErrBadDecoderConfig
+	}
+	ps.SPS, ps.PPS = sps, pps
+	return ps, lengthSize, nil
+}
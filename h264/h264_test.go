@@ -0,0 +1,145 @@
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	sps   = []byte{0x67, 0x42, 0xc0, 0x1f}
+	pps   = []byte{0x68, 0xce, 0x3c, 0x80}
+	idr   = []byte{0x65, 0x88, 0x84, 0x00, 0x00, 0x03, 0x00}
+	slice = []byte{0x41, 0x9a, 0x02}
+)
+
+func TestSplitAnnexB(t *testing.T) {
+	// mixed 3 and 4 byte start codes
+	b := append([]byte{0, 0, 0, 1}, sps...)
+	b = append(b, 0, 0, 1)
+	b = append(b, pps...)
+	b = AppendAnnexB(b, idr)
+	nalus := SplitAnnexB(b)
+	if len(nalus) != 3 {
+		t.Fatalf("got %d nalus, want 3", len(nalus))
+	}
+	for i, want := range [][]byte{sps, pps, idr[:6]} {
+		if !bytes.Equal(nalus[i], want) {
+			t.Fatalf("nalu %d: got %x, want %x", i, nalus[i], want)
+		}
+	}
+	if !IsKeyFrame(b) || IsKeyFrame(AppendAnnexB(nil, slice)) {
+		t.Fatal("wrong key frame")
+	}
+}
+
+func TestParameterSets(t *testing.T) {
+	var ps ParameterSets
+	key := AppendAnnexB(nil, sps, pps, idr)
+	if !ps.Update(key) || ps.Update(key) || !ps.Complete() {
+		t.Fatal("wrong update")
+	}
+	bare := AppendAnnexB(nil, idr)
+	if got := ps.Prepend(bare); !bytes.Equal(got, key) {
+		t.Fatalf("got %x, want %x", got, key)
+	}
+	if got := ps.Prepend(key); !bytes.Equal(got, key) {
+		t.Fatal("parameter sets prepended twice")
+	}
+}
+
+// bitWriter write the exp-Golomb coded fields of a test SPS
+type bitWriter struct {
+	b    []byte
+	bits int
+}
+
+func (w *bitWriter) bit(v int) {
+	if w.bits%8 == 0 {
+		w.b = append(w.b, 0)
+	}
+	w.b[len(w.b)-1] |= byte(v&1) << uint(7-w.bits%8)
+	w.bits++
+}
+
+func (w *bitWriter) ue(v int) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	for i := 0; i < n; i++ {
+		w.bit(0)
+	}
+	for i := n; i >= 0; i-- {
+		w.bit(v >> uint(i))
+	}
+}
+
+func TestParseSPS(t *testing.T) {
+	// high profile 1920x1080: 120x68 macroblocks cropped by 8 rows
+	w := &bitWriter{}
+	w.ue(0) // sps id
+	w.ue(1) // chroma_format_idc
+	w.ue(0)
+	w.ue(0)
+	w.bit(0)
+	w.bit(0) // no scaling matrix
+	w.ue(0)
+	w.ue(0) // poc type 0
+	w.ue(0)
+	w.ue(4)
+	w.bit(0)
+	w.ue(119)
+	w.ue(67)
+	w.bit(1) // frame_mbs_only
+	w.bit(1)
+	w.bit(1) // cropping
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)
+	w.bit(1) // vui_parameters_present_flag, ignored
+	nalu := append([]byte{0x67, 100, 0, 40}, w.b...)
+
+	s, err := ParseSPS(nalu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Width != 1920 || s.Height != 1080 || s.Profile != 100 || s.Level != 40 {
+		t.Fatalf("got %+v", s)
+	}
+	if err := Validate(AppendAnnexB(nil, nalu, pps, idr)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(AppendAnnexB(nil, nalu, pps)); err == nil {
+		t.Fatal("access unit without slice is valid")
+	}
+	if _, err := ParseSPS(nalu[:6]); err == nil {
+		t.Fatal("truncated sps parsed")
+	}
+}
+
+func TestAVCC(t *testing.T) {
+	ps := ParameterSets{SPS: sps, PPS: pps}
+	got, lengthSize, err := ParseDecoderConfig(ps.DecoderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lengthSize != 4 || !bytes.Equal(got.SPS, sps) || !bytes.Equal(got.PPS, pps) {
+		t.Fatalf("got %+v with %d byte lengths", got, lengthSize)
+	}
+	if _, _, err := ParseDecoderConfig(ps.DecoderConfig()[:10]); err != ErrBadDecoderConfig {
+		t.Fatalf("truncated record: %v", err)
+	}
+
+	nalus, err := SplitAVCC(AppendAVCC(nil, sps, idr), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nalus) != 2 || !bytes.Equal(nalus[0], sps) || !bytes.Equal(nalus[1], idr) {
+		t.Fatalf("got %x", nalus)
+	}
+	if _, err := SplitAVCC([]byte{0, 0, 0, 9, 1}, 4); err != ErrBadLength {
+		t.Fatalf("truncated nal unit: %v", err)
+	}
+}
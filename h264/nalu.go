@@ -0,0 +1,156 @@
+// Package h264 handles the NAL units of H.264 access units in Annex B byte stream format,
+// the output of the encoder, without decoding them
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// NAL unit types
+const (
+	NALSlice = 1
+	NALIDR   = 5
+	NALSEI   = 6
+	NALSPS   = 7
+	NALPPS   = 8
+	NALAUD   = 9
+)
+
+var (
+	ErrNoNALU = errors.New("h264: no nal unit")
+
+	startCode = []byte{0, 0, 0, 1}
+)
+
+// SplitAnnexB return the NAL units of b without their start codes, they refer to b
+func SplitAnnexB(b []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	for i := 0; i+2 < len(b); {
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			i++
+			continue
+		}
+		if start >= 0 {
+			nalus = appendNALU(nalus, b[start:i])
+		}
+		i += 3
+		start = i
+	}
+	if start >= 0 {
+		nalus = appendNALU(nalus, b[start:])
+	}
+	return nalus
+}
+
+// appendNALU drop the zero bytes before the next start code, they belong to a 4 byte start code
+func appendNALU(nalus [][]byte, nalu []byte) [][]byte {
+	nalu = bytes.TrimRight(nalu, "\x00")
+	if len(nalu) == 0 {
+		return nalus
+	}
+	return append(nalus, nalu)
+}
+
+// AppendAnnexB append the NAL units to dst, each after a 4 byte start code
+func AppendAnnexB(dst []byte, nalus ...[]byte) []byte {
+	for _, n := range nalus {
+		dst = append(dst, startCode...)
+		dst = append(dst, n...)
+	}
+	return dst
+}
+
+// NALType return the type of a NAL unit without start code
+func NALType(nalu []byte) int {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return int(nalu[0] & 0x1f)
+}
+
+// IsKeyFrame reports whether the access unit has an IDR slice
+func IsKeyFrame(au []byte) bool {
+	for _, n := range SplitAnnexB(au) {
+		if NALType(n) == NALIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate check the NAL unit headers of an access unit, it does not decode the slices
+func Validate(au []byte) error {
+	nalus := SplitAnnexB(au)
+	if len(nalus) == 0 {
+		return ErrNoNALU
+	}
+	slices := 0
+	for i, n := range nalus {
+		if n[0]&0x80 != 0 {
+			return fmt.Errorf("h264: nal unit %d: forbidden bit set", i)
+		}
+		switch t := NALType(n); {
+		case t == 0 || t > 23:
+			return fmt.Errorf("h264: nal unit %d: reserved type %d", i, t)
+		case t == NALSlice || t == NALIDR:
+			slices++
+		case t == NALSPS:
+			if _, err := ParseSPS(n); err != nil {
+				return err
+			}
+		}
+	}
+	if slices == 0 {
+		return fmt.Errorf("h264: no slice in %d nal units", len(nalus))
+	}
+	return nil
+}
+
+// ParameterSets keeps the last SPS and PPS of a stream, a decoder joining the stream needs them
+// before the first IDR
+type ParameterSets struct {
+	SPS []byte
+	PPS []byte
+}
+
+// Update take the SPS and PPS of an access unit, it reports whether they changed
+func (ps *ParameterSets) Update(au []byte) bool {
+	changed := false
+	for _, n := range SplitAnnexB(au) {
+		switch NALType(n) {
+		case NALSPS:
+			if !bytes.Equal(ps.SPS, n) {
+				ps.SPS = append([]byte(nil), n...)
+				changed = true
+			}
+		case NALPPS:
+			if !bytes.Equal(ps.PPS, n) {
+				ps.PPS = append([]byte(nil), n...)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// Complete reports whether both SPS and PPS are known
+func (ps *ParameterSets) Complete() bool {
+	return len(ps.SPS) > 0 && len(ps.PPS) > 0
+}
+
+// Prepend return the access unit with the SPS and PPS in front unless it has them already
+func (ps *ParameterSets) Prepend(au []byte) []byte {
+	hasSPS, hasPPS := false, false
+	for _, n := range SplitAnnexB(au) {
+		hasSPS = hasSPS || NALType(n) == NALSPS
+		hasPPS = hasPPS || NALType(n) == NALPPS
+	}
+	if (hasSPS && hasPPS) || !ps.Complete() {
+		return au
+	}
+	b := AppendAnnexB(make([]byte, 0, len(ps.SPS)+len(ps.PPS)+8+len(au)), ps.SPS, ps.PPS)
+	return append(b, au...)
+}
@@ -0,0 +1,161 @@
+package h264
+
+import (
+	"errors"
+)
+
+var ErrBadSPS = errors.New("h264: bad sps")
+
+// SPS is the part of a sequence parameter set the muxers need
+type SPS struct {
+	Profile       int
+	Compatibility int // constraint flags
+	Level         int
+	Width         int
+	Height        int
+}
+
+// ParseSPS parse a SPS NAL unit without start code
+func ParseSPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 4 || NALType(nalu) != NALSPS {
+		return nil, ErrBadSPS
+	}
+	s := &SPS{Profile: int(nalu[1]), Compatibility: int(nalu[2]), Level: int(nalu[3])}
+	r := &bitReader{b: unescape(nalu[4:])}
+	r.ue() // seq_parameter_set_id
+
+	chromaFormat := 1
+	switch s.Profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			r.bit() // separate_colour_plane_flag
+		}
+		r.ue()  // bit_depth_luma_minus8
+		r.ue()  // bit_depth_chroma_minus8
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+		if r.bit() == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size && next != 0; j++ {
+					next = (last + r.se() + 256) % 256
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	r.ue()          // log2_max_frame_num_minus4
+	switch r.ue() { // pic_order_cnt_type
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bit() // delta_pic_order_always_zero_flag
+		r.se()  // offset_for_non_ref_pic
+		r.se()  // offset_for_top_to_bottom_field
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+	widthMbs := r.ue() + 1
+	heightMapUnits := r.ue() + 1
+	frameMbsOnly := r.bit()
+	if frameMbsOnly == 0 {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+
+	s.Width = widthMbs * 16
+	s.Height = (2 - frameMbsOnly) * heightMapUnits * 16
+	if r.bit() == 1 { // frame_cropping_flag
+		cropX, cropY := 1, 2-frameMbsOnly
+		switch chromaFormat {
+		case 1:
+			cropX, cropY = 2, 2*(2-frameMbsOnly)
+		case 2:
+			cropX = 2
+		}
+		left, right, top, bottom := r.ue(), r.ue(), r.ue(), r.ue()
+		s.Width -= cropX * (left + right)
+		s.Height -= cropY * (top + bottom)
+	}
+	if r.err != nil || s.Width <= 0 || s.Height <= 0 {
+		return nil, ErrBadSPS
+	}
+	return s, nil
+}
+
+// unescape remove the emulation prevention bytes, 00 00 03 -> 00 00
+func unescape(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// bitReader read the exp-Golomb coded fields, err is set past the end
+type bitReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) bit() int {
+	if r.pos >= len(r.b)*8 {
+		r.err = ErrBadSPS
+		return 0
+	}
+	v := int(r.b[r.pos/8]>>uint(7-r.pos%8)) & 1
+	r.pos++
+	return v
+}
+
+func (r *bitReader) ue() int {
+	zeros := 0
+	for r.bit() == 0 && r.err == nil {
+		zeros++
+		if zeros > 31 {
+			r.err = ErrBadSPS
+			return 0
+		}
+	}
+	v := 1
+	for i := 0; i < zeros; i++ {
+		v = v<<1 | r.bit()
+	}
+	return v - 1
+}
+
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v%2 == 1 {
+		return (v + 1) / 2
+	}
+	return -v / 2
+}
@@ -0,0 +1,260 @@
+// Package hls packages the H.264 stream of the encoder for HLS players: MPEG-TS or fragmented MP4
+// segments cut at key frames, a sliding live playlist, and LL-HLS partial segments
+package hls
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/mp4"
+)
+
+const (
+	FormatTS   = "ts"
+	FormatFMP4 = "fmp4"
+
+	clockRate       = 90000 // of the pts of MPEG-TS, and the timescale of the fMP4 track
+	defaultDuration = clockRate / 30
+	keepSegments    = 3 // behind the window, for the players that are late
+)
+
+var ErrClosed = errors.New("hls: packager closed")
+
+type Config struct {
+	Format          string        // FormatTS or FormatFMP4
+	SegmentDuration time.Duration // a segment ends at the first key frame after it
+	Window          int           // segments in the playlist
+	PartDuration    time.Duration // of the LL-HLS partial segments, 0 for none
+}
+
+var DefaultConfig = Config{
+	Format:          FormatTS,
+	SegmentDuration: 2 * time.Second,
+	Window:          6,
+}
+
+type frame struct {
+	data     []byte
+	key      bool
+	init     int   // of its parameter sets
+	pts      int64 // clockRate since the first frame
+	duration int64
+}
+
+// part is a piece of a segment, a segment is the concatenation of its parts
+type part struct {
+	data        []byte
+	duration    int64
+	independent bool // starts with a key frame
+}
+
+type segment struct {
+	seq           int
+	init          int // of the fMP4 init segment
+	discontinuity bool
+	parts         []*part
+	duration      int64
+	complete      bool
+}
+
+func (s *segment) data() []byte {
+	var b []byte
+	for _, p := range s.parts {
+		b = append(b, p.data...)
+	}
+	return b
+}
+
+// Packager cuts an H.264 stream to segments, and serves them with the playlist over HTTP.
+// A change of the SPS/PPS starts a segment after a discontinuity, with a new init segment for fMP4.
+type Packager struct {
+	cfg Config
+
+	mu          sync.Mutex
+	ps          h264.ParameterSets
+	inits       map[int][]byte
+	initID      int
+	started     bool
+	start       time.Time
+	pending     *frame // waits for the next frame for its duration
+	partFrames  []frame
+	cur         *segment
+	segments    []*segment // complete, the window and the late ones
+	nextSeq     int
+	discRemoved int // discontinuities of the segments removed
+	ts          *tsMuxer
+	fragments   uint32
+	maxPart     int64
+	maxSegment  int64
+	changed     chan struct{} // closed when a part or a segment is added
+	closed      bool
+}
+
+func NewPackager(cfg Config) *Packager {
+	if cfg.Format == "" {
+		cfg.Format = DefaultConfig.Format
+	}
+	if cfg.SegmentDuration <= 0 {
+		cfg.SegmentDuration = DefaultConfig.SegmentDuration
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultConfig.Window
+	}
+	return &Packager{
+		cfg:     cfg,
+		inits:   make(map[int][]byte),
+		ts:      newTSMuxer(),
+		changed: make(chan struct{}),
+	}
+}
+
+// WriteFrame add an Annex B access unit captured at t, the stream starts on a key frame with SPS/PPS
+func (p *Packager) WriteFrame(au []byte, t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	changed := p.ps.Update(au)
+	key := h264.IsKeyFrame(au)
+	if !p.started {
+		if !key || !p.ps.Complete() {
+			return nil
+		}
+		p.started, p.start = true, t
+	}
+	if changed {
+		if p.cfg.Format == FormatFMP4 {
+			init, err := mp4.InitSegment(p.ps)
+			if err != nil {
+				return err
+			}
+			p.inits[p.initID+1] = init
+		}
+		p.initID++
+	}
+
+	pts := int64(t.Sub(p.start)) * clockRate / int64(time.Second)
+	if p.pending != nil {
+		p.pending.duration = pts - p.pending.pts
+		if p.pending.duration <= 0 {
+			p.pending.duration = 1
+		}
+		p.add(*p.pending)
+	}
+	p.pending = &frame{data: append([]byte(nil), au...), key: key, init: p.initID, pts: pts}
+	return nil
+}
+
+// add a frame with its duration, cutting the segment or the part before it
+func (p *Packager) add(f frame) {
+	switch {
+	case p.cur == nil:
+	case f.key && (p.cur.duration+p.partDuration() >= p.target() || f.init != p.cur.init):
+		p.flushPart()
+		p.endSegment()
+	case p.cfg.PartDuration > 0 && len(p.partFrames) > 0 &&
+		p.partDuration()+f.duration > int64(p.cfg.PartDuration)*clockRate/int64(time.Second):
+		p.flushPart()
+	}
+	if p.cur == nil {
+		p.cur = &segment{seq: p.nextSeq, init: f.init}
+		if n := len(p.segments); n > 0 {
+			p.cur.discontinuity = p.segments[n-1].init != f.init
+		}
+		p.nextSeq++
+	}
+	p.partFrames = append(p.partFrames, f)
+}
+
+func (p *Packager) target() int64 {
+	return int64(p.cfg.SegmentDuration) * clockRate / int64(time.Second)
+}
+
+func (p *Packager) partDuration() int64 {
+	var d int64
+	for _, f := range p.partFrames {
+		d += f.duration
+	}
+	return d
+}
+
+// flushPart mux the frames of the part
+func (p *Packager) flushPart() {
+	if len(p.partFrames) == 0 {
+		return
+	}
+	var data []byte
+	if p.cfg.Format == FormatFMP4 {
+		samples := make([]mp4.Sample, len(p.partFrames))
+		for i, f := range p.partFrames {
+			samples[i] = mp4.Sample{Data: f.data, Duration: uint32(f.duration), Key: f.key}
+		}
+		p.fragments++
+		data = mp4.Fragment(p.fragments, uint64(p.partFrames[0].pts), samples)
+	} else {
+		if len(p.cur.parts) == 0 {
+			data = p.ts.tables(data)
+		}
+		for _, f := range p.partFrames {
+			data = p.ts.frame(data, f.data, f.pts, f.key)
+		}
+	}
+	pt := &part{data: data, duration: p.partDuration(), independent: p.partFrames[0].key}
+	p.cur.parts = append(p.cur.parts, pt)
+	p.cur.duration += pt.duration
+	if pt.duration > p.maxPart {
+		p.maxPart = pt.duration
+	}
+	p.partFrames = nil
+	p.notify()
+}
+
+func (p *Packager) endSegment() {
+	p.cur.complete = true
+	if p.cur.duration > p.maxSegment {
+		p.maxSegment = p.cur.duration
+	}
+	p.segments = append(p.segments, p.cur)
+	p.cur = nil
+	for len(p.segments) > p.cfg.Window+keepSegments {
+		if p.segments[0].discontinuity {
+			p.discRemoved++
+		}
+		p.segments = p.segments[1:]
+	}
+	for id := range p.inits {
+		if id < p.segments[0].init {
+			delete(p.inits, id) // no segment refers to it
+		}
+	}
+	p.notify()
+}
+
+func (p *Packager) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Close end the last segment and the playlist
+func (p *Packager) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if p.pending != nil {
+		p.pending.duration = defaultDuration
+		p.add(*p.pending)
+		p.pending = nil
+	}
+	if p.cur != nil {
+		p.flushPart()
+		p.endSegment()
+	}
+	p.closed = true
+	p.notify()
+	return nil
+}
@@ -0,0 +1,183 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+)
+
+var (
+	sps = []byte{0x67, 0x42, 0xc0, 0x0d, 0xf4, 0x0a, 0x0f, 0xc8} // baseline 320x240
+	pps = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+func accessUnit(i, size int, key bool) []byte {
+	var au []byte
+	nalType := byte(0x41)
+	if key {
+		au = h264.AppendAnnexB(au, sps, pps)
+		nalType = 0x65
+	}
+	body := bytes.Repeat([]byte{byte(i) | 1}, size)
+	body[0] = nalType
+	return h264.AppendAnnexB(au, body)
+}
+
+// feed write n frames at 30fps with a key frame every second
+func feed(t *testing.T, p *Packager, start time.Time, from, n int) {
+	for i := from; i < from+n; i++ {
+		if err := p.WriteFrame(accessUnit(i, 500, i%30 == 0), start.Add(time.Duration(i)*time.Second/30)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTS(t *testing.T) {
+	m := newTSMuxer()
+	au := accessUnit(0, 1000, true)
+	b := m.frame(m.tables(nil), au, 90000, true)
+	if len(b)%tsPacketSize != 0 {
+		t.Fatalf("%d bytes", len(b))
+	}
+	var pes []byte
+	for i := 0; i < len(b); i += tsPacketSize {
+		p := b[i : i+tsPacketSize]
+		if p[0] != 0x47 {
+			t.Fatalf("packet %d without sync byte", i/tsPacketSize)
+		}
+		pid := uint16(p[1]&0x1f)<<8 | uint16(p[2])
+		payload := p[4:]
+		if p[3]&0x20 != 0 {
+			payload = payload[1+int(p[4]):]
+		}
+		switch pid {
+		case pidPAT, pidPMT:
+			section := payload[1:]
+			length := int(section[1]&0x0f)<<8 | int(section[2])
+			if crc32MPEG(section[:3+length]) != 0 {
+				t.Fatalf("bad crc of the section of pid %d", pid)
+			}
+		case pidVideo:
+			pes = append(pes, payload...)
+		}
+	}
+	if !bytes.HasPrefix(pes, []byte{0, 0, 1, 0xe0}) {
+		t.Fatalf("pes %x", pes[:8])
+	}
+	nalus := h264.SplitAnnexB(pes[14:])
+	if len(nalus) != 4 || h264.NALType(nalus[0]) != h264.NALAUD || !bytes.Equal(nalus[1], sps) {
+		t.Fatalf("%d nal units in the pes", len(nalus))
+	}
+}
+
+func TestSlidingPlaylist(t *testing.T) {
+	p := NewPackager(Config{Format: FormatTS, SegmentDuration: 2 * time.Second, Window: 3})
+	feed(t, p, time.Now(), 0, 30*20+2) // the key frame after the 10th segment is pending
+	p.mu.Lock()
+	playlist := p.playlist()
+	p.mu.Unlock()
+	// 10 segments of 2s, the last 3 listed
+	for _, want := range []string{"#EXT-X-TARGETDURATION:2\n", "#EXT-X-MEDIA-SEQUENCE:7\n", "#EXTINF:2.000,\nseg9.ts\n"} {
+		if !strings.Contains(playlist, want) {
+			t.Fatalf("no %q in\n%s", want, playlist)
+		}
+	}
+	if strings.Count(playlist, "#EXTINF") != 3 {
+		t.Fatalf("not 3 segments in\n%s", playlist)
+	}
+
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/seg9.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(b) == 0 || len(b)%tsPacketSize != 0 || resp.Header.Get("Content-Type") != "video/mp2t" {
+		t.Fatalf("segment of %d bytes, %s", len(b), resp.Header.Get("Content-Type"))
+	}
+	if resp, _ := http.Get(srv.URL + "/seg0.ts"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("removed segment: %d", resp.StatusCode)
+	}
+}
+
+func TestLowLatency(t *testing.T) {
+	p := NewPackager(Config{Format: FormatFMP4, SegmentDuration: time.Second, Window: 4, PartDuration: 200 * time.Millisecond})
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	start := time.Now()
+	feed(t, p, start, 0, 45)
+
+	// block on the part after the next one
+	p.mu.Lock()
+	msn, part := p.nextPart()
+	p.mu.Unlock()
+	got := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(fmt.Sprintf("%s/index.m3u8?_HLS_msn=%d&_HLS_part=%d", srv.URL, msn, part+1))
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		got <- string(b)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case playlist := <-got:
+		t.Fatalf("answered before the part:\n%s", playlist)
+	default:
+	}
+	feed(t, p, start, 45, 15)
+	playlist := <-got
+	want := fmt.Sprintf("URI=\"seg%d.%d.m4s\"", msn, part+1)
+	for _, s := range []string{"#EXT-X-PART-INF:PART-TARGET=0.200", "#EXT-X-MAP:URI=\"init1.mp4\"", want, "#EXT-X-PRELOAD-HINT"} {
+		if !strings.Contains(playlist, s) {
+			t.Fatalf("no %q in\n%s", s, playlist)
+		}
+	}
+
+	for _, name := range []string{"init1.mp4", fmt.Sprintf("seg%d.%d.m4s", msn, part+1), "seg0.m4s"} {
+		resp, err := http.Get(srv.URL + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		box := "moof"
+		if strings.HasPrefix(name, "init") {
+			box = "ftyp"
+		}
+		if resp.StatusCode != http.StatusOK || len(b) < 8 || string(b[4:8]) != box {
+			t.Fatalf("%s: %d, %d bytes", name, resp.StatusCode, len(b))
+		}
+	}
+}
+
+func TestDiscontinuity(t *testing.T) {
+	p := NewPackager(Config{Format: FormatFMP4, SegmentDuration: time.Second, Window: 6})
+	start := time.Now()
+	feed(t, p, start, 0, 60)
+	sps2 := append([]byte(nil), sps...)
+	sps2[3] = 0x1e // another level
+	au := bytes.Replace(accessUnit(60, 500, true), sps, sps2, 1)
+	if err := p.WriteFrame(au, start.Add(2*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	feed(t, p, start, 61, 60)
+	p.mu.Lock()
+	playlist := p.playlist()
+	p.mu.Unlock()
+	if !strings.Contains(playlist, "#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"init2.mp4\"\n#EXTINF:1.000,\nseg2.m4s") {
+		t.Fatalf("no discontinuity in\n%s", playlist)
+	}
+}
@@ -0,0 +1,283 @@
+package hls
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	playlistName  = "index.m3u8"
+	partSegments  = 3 // with their parts in the playlist of LL-HLS
+	blockingLimit = 3 // reload blocks up to this many target durations
+)
+
+func (p *Packager) ext() string {
+	if p.cfg.Format == FormatFMP4 {
+		return ".m4s"
+	}
+	return ".ts"
+}
+
+func seconds(d int64) float64 {
+	return float64(d) / clockRate
+}
+
+// playlist write the live playlist, p.mu is held
+func (p *Packager) playlist() string {
+	b := &strings.Builder{}
+	ll := p.cfg.PartDuration > 0
+	version := 3
+	if p.cfg.Format == FormatFMP4 || ll {
+		version = 6
+	}
+	start := 0
+	if n := len(p.segments); n > p.cfg.Window {
+		start = n - p.cfg.Window
+	}
+	window := p.segments[start:]
+	target := int(math.Ceil(math.Max(seconds(p.maxSegment), p.cfg.SegmentDuration.Seconds())))
+	discSeq := p.discRemoved
+	for _, s := range p.segments[:start] {
+		if s.discontinuity {
+			discSeq++
+		}
+	}
+	seq := p.nextSeq
+	if len(window) > 0 {
+		seq = window[0].seq
+	} else if p.cur != nil {
+		seq = p.cur.seq
+	}
+
+	fmt.Fprintf(b, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n", version, target)
+	if ll {
+		partTarget := math.Max(seconds(p.maxPart), p.cfg.PartDuration.Seconds())
+		fmt.Fprintf(b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget)
+		fmt.Fprintf(b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	}
+	fmt.Fprintf(b, "#EXT-X-MEDIA-SEQUENCE:%d\n", seq)
+	if discSeq > 0 {
+		fmt.Fprintf(b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discSeq)
+	}
+
+	all := window
+	if ll && p.cur != nil {
+		all = append(all[:len(all):len(all)], p.cur)
+	}
+	for i, s := range all {
+		if s.discontinuity && i > 0 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if p.cfg.Format == FormatFMP4 && (i == 0 || s.init != all[i-1].init) {
+			fmt.Fprintf(b, "#EXT-X-MAP:URI=\"init%d.mp4\"\n", s.init)
+		}
+		if ll && i >= len(all)-partSegments {
+			for j, pt := range s.parts {
+				fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"seg%d.%d%s\"", seconds(pt.duration), s.seq, j, p.ext())
+				if pt.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if s.complete {
+			fmt.Fprintf(b, "#EXTINF:%.3f,\nseg%d%s\n", seconds(s.duration), s.seq, p.ext())
+		}
+	}
+	switch {
+	case p.closed:
+		b.WriteString("#EXT-X-ENDLIST\n")
+	case ll:
+		next, nextPart := p.nextPart()
+		fmt.Fprintf(b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"seg%d.%d%s\"\n", next, nextPart, p.ext())
+	}
+	return b.String()
+}
+
+// nextPart return the segment and the index of the part being made
+func (p *Packager) nextPart() (int, int) {
+	if p.cur != nil {
+		return p.cur.seq, len(p.cur.parts)
+	}
+	return p.nextSeq, 0
+}
+
+// has reports whether the part of the segment is out, or the whole segment for part < 0
+func (p *Packager) has(msn, part int) bool {
+	if p.closed {
+		return true
+	}
+	next, nextPart := p.nextPart()
+	return msn < next || (part >= 0 && msn == next && part < nextPart)
+}
+
+// ServeHTTP serve the viewer page, the playlist, the init segments, the segments and their parts:
+// index.m3u8, init1.mp4, seg5.ts, seg5.2.ts
+func (p *Packager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	name := path.Base(r.URL.Path)
+	switch {
+	case name == "/" || name == "." || name == "index.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(viewerPage))
+	case name == playlistName:
+		p.servePlaylist(w, r)
+	case strings.HasPrefix(name, "init") && strings.HasSuffix(name, ".mp4"):
+		id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "init"), ".mp4"))
+		p.mu.Lock()
+		init, ok := p.inits[id]
+		p.mu.Unlock()
+		if err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write(init)
+	case strings.HasPrefix(name, "seg") && strings.HasSuffix(name, p.ext()):
+		p.serveSegment(w, r, strings.TrimSuffix(strings.TrimPrefix(name, "seg"), p.ext()))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// servePlaylist answer the blocking reload of LL-HLS once the asked part is out
+func (p *Packager) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	msn, part := -1, -1
+	if s := r.URL.Query().Get("_HLS_msn"); s != "" {
+		var err error
+		if msn, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "bad _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		if s := r.URL.Query().Get("_HLS_part"); s != "" {
+			if part, err = strconv.Atoi(s); err != nil {
+				http.Error(w, "bad _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	timeout := time.After(blockingLimit * p.cfg.SegmentDuration)
+	p.mu.Lock()
+	for msn >= 0 && !p.has(msn, part) {
+		if next, _ := p.nextPart(); msn > next+1 {
+			p.mu.Unlock()
+			http.Error(w, "_HLS_msn too far ahead", http.StatusBadRequest)
+			return
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
+			http.Error(w, "part not ready", http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			return
+		}
+		p.mu.Lock()
+	}
+	if !p.started {
+		p.mu.Unlock()
+		http.Error(w, "no stream yet", http.StatusNotFound)
+		return
+	}
+	playlist := p.playlist()
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(playlist))
+}
+
+// serveSegment serve "5" or the part "5.2", a part being made is waited for like a preload hint
+func (p *Packager) serveSegment(w http.ResponseWriter, r *http.Request, name string) {
+	fields := strings.SplitN(name, ".", 2)
+	msn, err := strconv.Atoi(fields[0])
+	part := -1
+	if err == nil && len(fields) == 2 {
+		part, err = strconv.Atoi(fields[1])
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	timeout := time.After(blockingLimit * p.cfg.SegmentDuration)
+	p.mu.Lock()
+	for !p.has(msn, part) {
+		next, nextPart := p.nextPart()
+		if msn > next || (part >= 0 && msn == next && part > nextPart) {
+			break // not hinted
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
+			http.Error(w, "part not ready", http.StatusServiceUnavailable)
+			return
+		case <-r.Context().Done():
+			return
+		}
+		p.mu.Lock()
+	}
+	data := p.find(msn, part)
+	p.mu.Unlock()
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if p.cfg.Format == FormatFMP4 {
+		w.Header().Set("Content-Type", "video/mp4")
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+	w.Write(data)
+}
+
+// find the data of a segment or of one of its parts, p.mu is held
+func (p *Packager) find(msn, part int) []byte {
+	segments := p.segments
+	if p.cur != nil {
+		segments = append(segments[:len(segments):len(segments)], p.cur)
+	}
+	for _, s := range segments {
+		if s.seq != msn {
+			continue
+		}
+		switch {
+		case part < 0 && s.complete:
+			return s.data()
+		case part >= 0 && part < len(s.parts):
+			return s.parts[part].data
+		}
+	}
+	return nil
+}
+
+// viewerPage plays the playlist next to it, natively in Safari and with hls.js elsewhere
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>video</title>
+<script src="https://cdn.jsdelivr.net/npm/hls.js@1"></script>
+</head>
+<body style="margin:0;background:#000">
+<video id="video" controls autoplay muted playsinline style="width:100%;height:100vh"></video>
+<script>
+var video = document.getElementById('video');
+if (window.Hls && Hls.isSupported()) {
+	var hls = new Hls({lowLatencyMode: true});
+	hls.loadSource('index.m3u8');
+	hls.attachMedia(video);
+} else {
+	video.src = 'index.m3u8';
+}
+</script>
+</body>
+</html>
+`
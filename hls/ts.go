@@ -0,0 +1,178 @@
+package hls
+
+import (
+	"encoding/binary"
+
+	"github.com/l-f-h/video/h264"
+)
+
+const (
+	tsPacketSize = 188
+	pidPAT       = 0x0000
+	pidPMT       = 0x1000
+	pidVideo     = 0x0100
+	streamH264   = 0x1b
+)
+
+var aud = []byte{0x09, 0xf0} // access unit delimiter, any slice type
+
+// tsMuxer writes the frames to an MPEG-TS stream, the continuity counters go on across segments
+type tsMuxer struct {
+	cc map[uint16]uint8
+}
+
+func newTSMuxer() *tsMuxer {
+	return &tsMuxer{cc: make(map[uint16]uint8)}
+}
+
+// tables append the PAT and PMT, at the start of each segment
+func (m *tsMuxer) tables(b []byte) []byte {
+	pat := []byte{
+		0x00,       // table id
+		0xb0, 0x0d, // section syntax, length
+		0x00, 0x01, // transport stream id
+		0xc1,       // version 0, current
+		0x00, 0x00, // section numbers
+		0x00, 0x01, // program 1
+		0xe0 | pidPMT>>8, pidPMT & 0xff,
+	}
+	pmt := []byte{
+		0x02,       // table id
+		0xb0, 0x12, // section syntax, length
+		0x00, 0x01, // program 1
+		0xc1,
+		0x00, 0x00,
+		0xe0 | pidVideo>>8, pidVideo & 0xff, // pcr pid
+		0xf0, 0x00, // no program info
+		streamH264,
+		0xe0 | pidVideo>>8, pidVideo & 0xff,
+		0xf0, 0x00, // no es info
+	}
+	b = m.section(b, pidPAT, pat)
+	return m.section(b, pidPMT, pmt)
+}
+
+// section append a psi section with its crc in one packet
+func (m *tsMuxer) section(b []byte, pid uint16, s []byte) []byte {
+	s = append(s, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(s[len(s)-4:], crc32MPEG(s[:len(s)-4]))
+	p := make([]byte, tsPacketSize)
+	m.header(p, pid, true, false)
+	p[4] = 0 // pointer field
+	n := copy(p[5:], s)
+	for i := 5 + n; i < tsPacketSize; i++ {
+		p[i] = 0xff
+	}
+	return append(b, p...)
+}
+
+func (m *tsMuxer) header(p []byte, pid uint16, start, adaptation bool) {
+	p[0] = 0x47
+	p[1] = byte(pid >> 8)
+	if start {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	p[3] = 0x10 | m.cc[pid]&0x0f // payload
+	if adaptation {
+		p[3] |= 0x20
+	}
+	m.cc[pid]++
+}
+
+// frame append the PES of an access unit at pts in 90kHz, the key frames carry the PCR
+func (m *tsMuxer) frame(b []byte, au []byte, pts int64, key bool) []byte {
+	pes := []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5} // unbounded length, pts only
+	pes = appendTimestamp(pes, 0x20, pts)
+	pes = h264.AppendAnnexB(pes, aud)
+	for _, n := range h264.SplitAnnexB(au) {
+		if h264.NALType(n) != h264.NALAUD {
+			pes = h264.AppendAnnexB(pes, n)
+		}
+	}
+
+	first := true
+	for len(pes) > 0 {
+		p := make([]byte, tsPacketSize)
+		var af []byte
+		if first {
+			af = []byte{0x10} // pcr
+			if key {
+				af[0] |= 0x40 // random access
+			}
+			af = appendPCR(af, pts)
+		}
+		room := tsPacketSize - 4
+		if af != nil {
+			room -= 1 + len(af)
+		}
+		if len(pes) < room {
+			// stuffing in the adaptation field
+			if af == nil {
+				af = []byte{}
+				room--
+			}
+			if stuffing := room - len(pes); stuffing > 0 {
+				if len(af) == 0 {
+					af = append(af, 0) // no flags
+					stuffing--
+				}
+				for i := 0; i < stuffing; i++ {
+					af = append(af, 0xff)
+				}
+			}
+		}
+		m.header(p, pidVideo, first, af != nil)
+		i := 4
+		if af != nil {
+			p[i] = byte(len(af))
+			copy(p[i+1:], af)
+			i += 1 + len(af)
+		}
+		n := copy(p[i:], pes)
+		pes = pes[n:]
+		b = append(b, p...)
+		first = false
+	}
+	return b
+}
+
+func appendTimestamp(b []byte, prefix byte, ts int64) []byte {
+	ts &= 1<<33 - 1
+	return append(b,
+		prefix|byte(ts>>29)&0x0e|1,
+		byte(ts>>22), byte(ts>>14)|1,
+		byte(ts>>7), byte(ts<<1)|1)
+}
+
+func appendPCR(b []byte, pts int64) []byte {
+	base := pts & (1<<33 - 1)
+	return append(b,
+		byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1),
+		byte(base<<7)|0x7e, 0) // no extension
+}
+
+var crcTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// crc32MPEG is the crc of the psi sections, not reflected
+func crc32MPEG(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, c := range b {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^c]
+	}
+	return crc
+}
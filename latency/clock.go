@@ -0,0 +1,127 @@
+// Package latency measures the glass-to-glass latency: the capture time of a frame travels with it
+// from the camera of the sender to the screen of the receiver
+package latency
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/transport"
+)
+
+const (
+	ClockProbeInterval = time.Second
+	clockProbeSize     = 24
+	clockWindow        = 16 // probes kept, the one with the smallest rtt is trusted
+)
+
+var ErrShortProbe = errors.New("latency: clock probe too short")
+
+// ClockProbe is the payload of the clock packets, like NTP:
+// the receiver sends Origin, the sender stamps Receive and Transmit and sends it back
+type ClockProbe struct {
+	Origin   int64 // unix us, receiver clock
+	Receive  int64 // unix us, sender clock
+	Transmit int64 // unix us, sender clock
+}
+
+func NewClockProbe(now time.Time) *ClockProbe {
+	return &ClockProbe{Origin: unixMicro(now)}
+}
+
+// Answer stamp the probe on the sender, received is the arrival time of the probe
+func (c *ClockProbe) Answer(received, now time.Time) {
+	c.Receive = unixMicro(received)
+	c.Transmit = unixMicro(now)
+}
+
+func (c *ClockProbe) Marshal() []byte {
+	b := make([]byte, clockProbeSize)
+	binary.BigEndian.PutUint64(b[0:], uint64(c.Origin))
+	binary.BigEndian.PutUint64(b[8:], uint64(c.Receive))
+	binary.BigEndian.PutUint64(b[16:], uint64(c.Transmit))
+	return b
+}
+
+func UnmarshalClockProbe(b []byte) (*ClockProbe, error) {
+	if len(b) < clockProbeSize {
+		return nil, ErrShortProbe
+	}
+	return &ClockProbe{
+		Origin:   int64(binary.BigEndian.Uint64(b[0:])),
+		Receive:  int64(binary.BigEndian.Uint64(b[8:])),
+		Transmit: int64(binary.BigEndian.Uint64(b[16:])),
+	}, nil
+}
+
+// Packet wrap the probe to a transport packet
+func (c *ClockProbe) Packet() *transport.Packet {
+	return &transport.Packet{
+		Header:  transport.Header{Type: transport.TypeClock},
+		Payload: c.Marshal(),
+	}
+}
+
+type clockSample struct {
+	offset time.Duration
+	rtt    time.Duration
+}
+
+// ClockEstimator estimates the offset of the sender clock to the receiver clock,
+// zero until the first probe comes back, which is right when both run on one host
+type ClockEstimator struct {
+	mu      sync.Mutex
+	samples []clockSample
+}
+
+func NewClockEstimator() *ClockEstimator {
+	return &ClockEstimator{}
+}
+
+// AddProbe with an answered probe, now is its arrival on the receiver
+func (e *ClockEstimator) AddProbe(c *ClockProbe, now time.Time) {
+	t4 := unixMicro(now)
+	rtt := (t4 - c.Origin) - (c.Transmit - c.Receive)
+	if rtt < 0 {
+		return
+	}
+	offset := ((c.Receive - c.Origin) + (c.Transmit - t4)) / 2
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.samples = append(e.samples, clockSample{
+		offset: time.Duration(offset) * time.Microsecond,
+		rtt:    time.Duration(rtt) * time.Microsecond,
+	})
+	if len(e.samples) > clockWindow {
+		e.samples = e.samples[1:]
+	}
+}
+
+// Offset return the sender clock minus the receiver clock, taken from the probe with the smallest
+// rtt as its path was the least queued and so the most symmetric
+func (e *ClockEstimator) Offset() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	best := -1
+	for i, s := range e.samples {
+		if best < 0 || s.rtt < e.samples[best].rtt {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0
+	}
+	return e.samples[best].offset
+}
+
+// ToLocal convert a time of the sender clock to the receiver clock
+func (e *ClockEstimator) ToLocal(t time.Time) time.Time {
+	return t.Add(-e.Offset())
+}
+
+func unixMicro(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
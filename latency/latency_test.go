@@ -0,0 +1,59 @@
+package latency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockEstimator(t *testing.T) {
+	const offset = 3 * time.Second // the sender clock is ahead
+	e := NewClockEstimator()
+	if e.Offset() != 0 {
+		t.Fatal("offset before any probe")
+	}
+
+	now := time.Unix(1000, 0)
+	for i, delay := range []time.Duration{40, 5, 80, 5} {
+		up := delay * time.Millisecond
+		down := 5 * time.Millisecond // the queue is only on the way up
+		c := NewClockProbe(now)
+		sender := now.Add(up).Add(offset)
+		c.Answer(sender, sender.Add(time.Millisecond))
+		now = now.Add(up + time.Millisecond + down)
+
+		b, _ := UnmarshalClockProbe(c.Marshal())
+		e.AddProbe(b, now)
+		if i == 1 {
+			if got := e.Offset(); got != offset {
+				t.Fatalf("offset %v, want %v", got, offset)
+			}
+		}
+		now = now.Add(time.Second)
+	}
+	if got := e.Offset(); got != offset {
+		t.Fatalf("offset %v, want %v from the probe with the smallest rtt", got, offset)
+	}
+	captured := time.Unix(2000, 0)
+	if got := e.ToLocal(captured.Add(offset)); !got.Equal(captured) {
+		t.Fatalf("ToLocal %v, want %v", got, captured)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder("render")
+	for i := 1; i <= 100; i++ {
+		r.Add(time.Duration(i) * time.Millisecond)
+	}
+	s := r.Period()
+	if s.Count != 100 || s.Min != time.Millisecond || s.Max != 100*time.Millisecond ||
+		s.P50 != 50*time.Millisecond || s.P90 != 90*time.Millisecond || s.P99 != 99*time.Millisecond {
+		t.Fatalf("summary %+v", s)
+	}
+	if s := r.Period(); s.Count != 0 {
+		t.Fatalf("period not reset: %+v", s)
+	}
+	r.Add(time.Second)
+	if s := r.Total(); s.Count != 101 || s.Max != time.Second {
+		t.Fatalf("total %+v", s)
+	}
+}
@@ -0,0 +1,140 @@
+package latency
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxSamples = 1 << 16 // samples kept for the total, a uniform reservoir beyond it
+)
+
+// Summary of the latency samples
+type Summary struct {
+	Count int
+	Min   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// Recorder keeps the latency samples of one stage, for the current period and the whole run
+type Recorder struct {
+	mu     sync.Mutex
+	name   string
+	period []time.Duration
+	total  []time.Duration
+	count  int
+	rnd    *rand.Rand
+}
+
+func NewRecorder(name string) *Recorder {
+	return &Recorder{name: name, rnd: rand.New(rand.NewSource(1))}
+}
+
+func (r *Recorder) Name() string {
+	return r.name
+}
+
+func (r *Recorder) Add(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	if len(r.period) < maxSamples {
+		r.period = append(r.period, d)
+	}
+	if len(r.total) < maxSamples {
+		r.total = append(r.total, d)
+	} else if i := r.rnd.Intn(r.count); i < maxSamples {
+		r.total[i] = d
+	}
+}
+
+// Period return the summary of the samples since the last call
+func (r *Recorder) Period() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := summarize(r.period)
+	r.period = r.period[:0]
+	return s
+}
+
+// Total return the summary of the whole run
+func (r *Recorder) Total() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := summarize(r.total)
+	s.Count = r.count
+	return s
+}
+
+func summarize(samples []time.Duration) Summary {
+	if len(samples) == 0 {
+		return Summary{}
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	return Summary{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Mean:  sum / time.Duration(len(sorted)),
+		P50:   Percentile(sorted, 50),
+		P90:   Percentile(sorted, 90),
+		P99:   Percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// Percentile return the p-th percentile of the sorted samples, nearest rank
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func (s Summary) String() string {
+	if s.Count == 0 {
+		return "no frame"
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return fmt.Sprintf("%d frames min %.1fms mean %.1fms p50 %.1fms p90 %.1fms p99 %.1fms max %.1fms",
+		s.Count, ms(s.Min), ms(s.Mean), ms(s.P50), ms(s.P90), ms(s.P99), ms(s.Max))
+}
+
+// Report log the period summary of the recorders each interval until stop is closed,
+// then the summary of the whole run
+func Report(logf func(format string, v ...interface{}), interval time.Duration, stop <-chan struct{}, recorders ...*Recorder) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, r := range recorders {
+				logf("latency %s: %v", r.Name(), r.Period())
+			}
+		case <-stop:
+			for _, r := range recorders {
+				logf("latency %s total: %v", r.Name(), r.Total())
+			}
+			return
+		}
+	}
+}
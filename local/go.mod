@@ -1,10 +0,0 @@
-module github.com/l-f-h/video/local
-
-go 1.12
-
-require (
-	github.com/l-f-h/video v0.0.0-20200503152941-73b58ff7ecf6
-	github.com/veandco/go-sdl2 v0.4.1
-)
-
-replace github.com/l-f-h/video => /Users/liufeihao/goWorkspace/src/github.com/l-f-h/video
@@ -0,0 +1,296 @@
+// Package metrics provides the stream metrics of client and server in the prometheus text format,
+// served on the http server of pprof
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// metric is one series, the series of a name share its help and kind
+type metric interface {
+	describe() *desc
+	write(w io.Writer)
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   kind
+	labels string // rendered {k="v",...}
+}
+
+func newDesc(name, help string, k kind, labels []string) *desc {
+	if len(labels)%2 != 0 {
+		panic("metrics: labels must be key value pairs")
+	}
+	var pairs []string
+	for i := 0; i < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	d := &desc{name: name, help: help, kind: k}
+	if len(pairs) > 0 {
+		d.labels = "{" + strings.Join(pairs, ",") + "}"
+	}
+	return d
+}
+
+func (d *desc) describe() *desc {
+	return d
+}
+
+// Registry holds the metrics of a binary
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, old := range r.metrics {
+		if old.describe().name == m.describe().name && old.describe().labels == m.describe().labels {
+			panic("metrics: duplicate " + m.describe().name + m.describe().labels)
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo write all the metrics in the prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.mu.Unlock()
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].describe().name < metrics[j].describe().name })
+
+	var buf bytes.Buffer
+	for i, m := range metrics {
+		d := m.describe()
+		if i == 0 || metrics[i-1].describe().name != d.name {
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+		}
+		m.write(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// Handler serves the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
+
+// Handle the default registry on /metrics of http.DefaultServeMux, beside pprof
+func Handle() {
+	http.Handle("/metrics", Default.Handler())
+}
+
+// Counter only goes up
+type Counter struct {
+	*desc
+	v uint64
+}
+
+// NewCounter register a counter to the default registry, labels are key value pairs
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: newDesc(name, help, kindCounter, labels)}
+	Default.register(c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+func (c *Counter) Add(n int) {
+	atomic.AddUint64(&c.v, uint64(n))
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "%s%s %d\n", c.name, c.labels, c.Value())
+}
+
+// Gauge goes up and down
+type Gauge struct {
+	*desc
+	bits uint64
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: newDesc(name, help, kindGauge, labels)}
+	Default.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "%s%s %g\n", g.name, g.labels, g.Value())
+}
+
+// funcMetric reads its value when scraped, for the values owned by other packages
+type funcMetric struct {
+	*desc
+	f func() float64
+}
+
+// NewGaugeFunc register a gauge read from f, e.g. the length of a queue
+func NewGaugeFunc(name, help string, f func() float64, labels ...string) {
+	Default.register(&funcMetric{desc: newDesc(name, help, kindGauge, labels), f: f})
+}
+
+// NewCounterFunc register a counter read from f, e.g. the stats of the reassembler
+func NewCounterFunc(name, help string, f func() float64, labels ...string) {
+	Default.register(&funcMetric{desc: newDesc(name, help, kindCounter, labels), f: f})
+}
+
+func (m *funcMetric) write(w io.Writer) {
+	fmt.Fprintf(w, "%s%s %g\n", m.name, m.labels, m.f())
+}
+
+// Histogram counts the observations in buckets
+type Histogram struct {
+	*desc
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// LatencyBuckets in seconds, from 5ms to 10s
+var LatencyBuckets = []float64{.005, .01, .025, .05, .075, .1, .15, .2, .3, .5, .75, 1, 2, 5, 10}
+
+func NewHistogram(name, help string, bounds []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    newDesc(name, help, kindHistogram, labels),
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)),
+	}
+	Default.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// ObserveDuration observe d in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// le joins the labels of the series
+	prefix := "{"
+	if h.labels != "" {
+		prefix = h.labels[:len(h.labels)-1] + ","
+	}
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket%sle=\"%g\"} %d\n", h.name, prefix, b, h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%sle=\"+Inf\"} %d\n", h.name, prefix, h.count)
+	fmt.Fprintf(w, "%s_sum%s %g\n", h.name, h.labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels, h.count)
+}
+
+// Meter measures a rate over the last window, e.g. the bitrate from the bytes
+type Meter struct {
+	*desc
+	mu     sync.Mutex
+	scale  float64
+	window time.Duration
+	events []meterEvent
+}
+
+type meterEvent struct {
+	at time.Time
+	n  int
+}
+
+const meterWindow = 2 * time.Second
+
+// NewMeter register a gauge of the rate per second of Mark over the last 2s, multiplied by scale,
+// e.g. 8 to mark bytes and report bit/s
+func NewMeter(name, help string, scale float64, labels ...string) *Meter {
+	m := &Meter{desc: newDesc(name, help, kindGauge, labels), scale: scale, window: meterWindow}
+	Default.register(m)
+	return m
+}
+
+func (m *Meter) Mark(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.events = append(m.events, meterEvent{at: now, n: n})
+	m.expire(now)
+}
+
+func (m *Meter) expire(now time.Time) {
+	i := 0
+	for i < len(m.events) && now.Sub(m.events[i].at) > m.window {
+		i++
+	}
+	m.events = m.events[i:]
+}
+
+// Rate return the rate per second over the window
+func (m *Meter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	total := 0
+	for _, e := range m.events {
+		total += e.n
+	}
+	return float64(total) * m.scale / m.window.Seconds()
+}
+
+func (m *Meter) write(w io.Writer) {
+	fmt.Fprintf(w, "%s%s %g\n", m.name, m.labels, m.Rate())
+}
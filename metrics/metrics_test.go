@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExposition(t *testing.T) {
+	Default = NewRegistry()
+	frames := NewCounter("video_frames_total", "Frames by stage.", "stage", "encoded")
+	NewCounter("video_frames_total", "Frames by stage.", "stage", "sent").Add(2)
+	frames.Inc()
+	NewGauge("video_target_bitrate_bps", "Target bitrate.").Set(1.5e6)
+	NewGaugeFunc("video_queue_depth", "Queue depth.", func() float64 { return 3 }, "queue", "yuv")
+	h := NewHistogram("video_frame_latency_seconds", "Latency.", []float64{.05, .1}, "stage", "render")
+	h.ObserveDuration(30 * time.Millisecond)
+	h.ObserveDuration(80 * time.Millisecond)
+	h.ObserveDuration(time.Second)
+	NewMeter("video_bitrate_bps", "Bitrate.", 8).Mark(1000)
+
+	rec := httptest.NewRecorder()
+	Default.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE video_frames_total counter\n" +
+			"video_frames_total{stage=\"encoded\"} 1\n" +
+			"video_frames_total{stage=\"sent\"} 2\n",
+		"video_target_bitrate_bps 1.5e+06\n",
+		"video_queue_depth{queue=\"yuv\"} 3\n",
+		"video_frame_latency_seconds_bucket{stage=\"render\",le=\"0.05\"} 1\n",
+		"video_frame_latency_seconds_bucket{stage=\"render\",le=\"0.1\"} 2\n",
+		"video_frame_latency_seconds_bucket{stage=\"render\",le=\"+Inf\"} 3\n",
+		"video_frame_latency_seconds_count{stage=\"render\"} 3\n",
+		"video_bitrate_bps 4000\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "# HELP video_frames_total"); n != 1 {
+		t.Errorf("%d HELP lines for one name", n)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	Default = NewRegistry()
+	NewCounter("c", "")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate registered")
+		}
+	}()
+	NewCounter("c", "")
+}
@@ -0,0 +1,165 @@
+// Package mp4 writes H.264 video to ISO base media files, a plain MP4 file for recording
+package mp4
+
+import (
+	"encoding/binary"
+
+	"github.com/l-f-h/video/h264"
+)
+
+const (
+	Timescale      = 90000 // of the video track, like the rtp clock
+	movieTimescale = 1000
+)
+
+var matrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// buffer builds nested boxes, the size of a box is filled in when it ends
+type buffer struct {
+	b []byte
+}
+
+func (w *buffer) u8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *buffer) u16(v uint16) {
+	w.b = append(w.b, byte(v>>8), byte(v))
+}
+
+func (w *buffer) u32(v uint32) {
+	w.b = append(w.b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(w.b[len(w.b)-4:], v)
+}
+
+func (w *buffer) u64(v uint64) {
+	w.b = append(w.b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(w.b[len(w.b)-8:], v)
+}
+
+func (w *buffer) bytes(b []byte) {
+	w.b = append(w.b, b...)
+}
+
+func (w *buffer) zeros(n int) {
+	for i := 0; i < n; i++ {
+		w.b = append(w.b, 0)
+	}
+}
+
+func (w *buffer) box(typ string, body func()) {
+	start := len(w.b)
+	w.u32(0)
+	w.bytes([]byte(typ))
+	body()
+	binary.BigEndian.PutUint32(w.b[start:], uint32(len(w.b)-start))
+}
+
+func (w *buffer) fullBox(typ string, version uint8, flags uint32, body func()) {
+	w.box(typ, func() {
+		w.u32(uint32(version)<<24 | flags)
+		body()
+	})
+}
+
+func (w *buffer) ftyp(major string, brands ...string) {
+	w.box("ftyp", func() {
+		w.bytes([]byte(major))
+		w.u32(0x200)
+		for _, b := range brands {
+			w.bytes([]byte(b))
+		}
+	})
+}
+
+func (w *buffer) mvhd(duration uint64, nextTrack uint32) {
+	w.fullBox("mvhd", 1, 0, func() {
+		w.u64(0) // creation time
+		w.u64(0) // modification time
+		w.u32(movieTimescale)
+		w.u64(duration)
+		w.u32(0x00010000) // rate 1.0
+		w.u16(0x0100)     // volume 1.0
+		w.zeros(10)
+		for _, m := range matrix {
+			w.u32(m)
+		}
+		w.zeros(24)
+		w.u32(nextTrack)
+	})
+}
+
+func (w *buffer) tkhd(trackID uint32, duration uint64, width, height int) {
+	w.fullBox("tkhd", 1, 3, func() { // enabled, in movie
+		w.u64(0)
+		w.u64(0)
+		w.u32(trackID)
+		w.u32(0)
+		w.u64(duration)
+		w.zeros(8)
+		w.u16(0) // layer
+		w.u16(0) // alternate group
+		w.u16(0) // volume, 0 for video
+		w.u16(0)
+		for _, m := range matrix {
+			w.u32(m)
+		}
+		w.u32(uint32(width) << 16)
+		w.u32(uint32(height) << 16)
+	})
+}
+
+func (w *buffer) mdhd(duration uint64) {
+	w.fullBox("mdhd", 1, 0, func() {
+		w.u64(0)
+		w.u64(0)
+		w.u32(Timescale)
+		w.u64(duration)
+		w.u16(0x55c4) // und
+		w.u16(0)
+	})
+}
+
+func (w *buffer) hdlr() {
+	w.fullBox("hdlr", 0, 0, func() {
+		w.u32(0)
+		w.bytes([]byte("vide"))
+		w.zeros(12)
+		w.bytes([]byte("VideoHandler\x00"))
+	})
+}
+
+// minfHeader write the boxes of minf before stbl
+func (w *buffer) minfHeader() {
+	w.fullBox("vmhd", 0, 1, func() {
+		w.zeros(8)
+	})
+	w.box("dinf", func() {
+		w.fullBox("dref", 0, 0, func() {
+			w.u32(1)
+			w.fullBox("url ", 0, 1, func() {}) // media in the same file
+		})
+	})
+}
+
+// avc1 write the sample entry of an H.264 stream with its SPS and PPS
+func (w *buffer) avc1(sps, pps []byte, width, height int) {
+	w.box("avc1", func() {
+		w.zeros(6)
+		w.u16(1) // data reference index
+		w.zeros(16)
+		w.u16(uint16(width))
+		w.u16(uint16(height))
+		w.u32(0x00480000) // 72 dpi
+		w.u32(0x00480000)
+		w.u32(0)
+		w.u16(1) // frame count
+		w.zeros(32)
+		w.u16(0x0018) // depth
+		w.u16(0xffff)
+		w.box("avcC", func() {
+			ps := h264.ParameterSets{SPS: sps, PPS: pps}
+			w.bytes(ps.DecoderConfig())
+		})
+	})
+}
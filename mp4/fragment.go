@@ -0,0 +1,136 @@
+package mp4
+
+import (
+	"encoding/binary"
+
+	"github.com/l-f-h/video/h264"
+)
+
+const (
+	trackID = 1
+
+	sampleFlagsKey   = 0x02000000 // depends on no other sample
+	sampleFlagsInter = 0x01010000 // depends on others, not a sync sample
+
+	trunDataOffset  = 0x000001
+	trunDuration    = 0x000100
+	trunSize        = 0x000200
+	trunFlags       = 0x000400
+	trunCTSOffset   = 0x000800
+	tfhdBaseIsMoof  = 0x020000
+	fragmentedBrand = "iso6"
+)
+
+// Sample is a frame of a fragment, the times are in the Timescale
+type Sample struct {
+	Data     []byte // Annex B access unit, the parameter sets are in the init segment
+	Duration uint32
+	Key      bool
+	Offset   int32 // of the presentation time to the decode time
+}
+
+// InitSegment return the init segment of a fragmented MP4 stream, for HLS and DASH
+func InitSegment(ps h264.ParameterSets) ([]byte, error) {
+	sps, err := h264.ParseSPS(ps.SPS)
+	if err != nil {
+		return nil, err
+	}
+	b := &buffer{}
+	b.ftyp(fragmentedBrand, fragmentedBrand, "iso5", "dash", "avc1", "mp41")
+	b.box("moov", func() {
+		b.mvhd(0, trackID+1)
+		b.box("trak", func() {
+			b.tkhd(trackID, 0, sps.Width, sps.Height)
+			b.box("mdia", func() {
+				b.mdhd(0)
+				b.hdlr()
+				b.box("minf", func() {
+					b.minfHeader()
+					b.box("stbl", func() {
+						b.fullBox("stsd", 0, 0, func() {
+							b.u32(1)
+							b.avc1(ps.SPS, ps.PPS, sps.Width, sps.Height)
+						})
+						// the samples are in the fragments
+						for _, typ := range []string{"stts", "stsc", "stco"} {
+							b.fullBox(typ, 0, 0, func() { b.u32(0) })
+						}
+						b.fullBox("stsz", 0, 0, func() { b.u64(0) })
+					})
+				})
+			})
+		})
+		b.box("mvex", func() {
+			b.fullBox("trex", 0, 0, func() {
+				b.u32(trackID)
+				b.u32(1) // sample description
+				b.u32(0) // duration
+				b.u32(0) // size
+				b.u32(0) // flags
+			})
+		})
+	})
+	return b.b, nil
+}
+
+// Fragment return a moof and mdat of the samples, decoded from baseTime. seq counts the fragments from 1.
+func Fragment(seq uint32, baseTime uint64, samples []Sample) []byte {
+	var mdat []byte
+	sizes := make([]uint32, len(samples))
+	reordered := false
+	for i, s := range samples {
+		n := len(mdat)
+		mdat = appendSample(mdat, s.Data)
+		sizes[i] = uint32(len(mdat) - n)
+		reordered = reordered || s.Offset != 0
+	}
+	flags := uint32(trunDataOffset | trunDuration | trunSize | trunFlags)
+	if reordered {
+		flags |= trunCTSOffset
+	}
+
+	b := &buffer{}
+	var dataOffset int
+	b.box("moof", func() {
+		b.fullBox("mfhd", 0, 0, func() { b.u32(seq) })
+		b.box("traf", func() {
+			b.fullBox("tfhd", 0, tfhdBaseIsMoof, func() { b.u32(trackID) })
+			b.fullBox("tfdt", 1, 0, func() { b.u64(baseTime) })
+			b.fullBox("trun", 1, flags, func() { // signed offsets
+				b.u32(uint32(len(samples)))
+				dataOffset = len(b.b)
+				b.u32(0)
+				for i, s := range samples {
+					b.u32(s.Duration)
+					b.u32(sizes[i])
+					if s.Key {
+						b.u32(sampleFlagsKey)
+					} else {
+						b.u32(sampleFlagsInter)
+					}
+					if reordered {
+						b.u32(uint32(s.Offset))
+					}
+				}
+			})
+		})
+	})
+	binary.BigEndian.PutUint32(b.b[dataOffset:], uint32(len(b.b)+8)) // from the moof to the samples
+	b.u32(uint32(8 + len(mdat)))
+	b.bytes([]byte("mdat"))
+	b.bytes(mdat)
+	return b.b
+}
+
+// appendSample append the length prefixed NAL units of an access unit, without the
+// parameter sets of the sample description
+func appendSample(b []byte, au []byte) []byte {
+	for _, n := range h264.SplitAnnexB(au) {
+		switch h264.NALType(n) {
+		case h264.NALSPS, h264.NALPPS, h264.NALAUD:
+			continue
+		}
+		b = h264.AppendAVCC(b, n)
+	}
+	return b
+}
@@ -0,0 +1,155 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+)
+
+var (
+	sps = []byte{0x67, 0x42, 0xc0, 0x0d, 0xf4, 0x0a, 0x0f, 0xc8} // baseline 320x240
+	pps = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+// boxes return the child boxes of b by type, the first of each
+func boxes(t *testing.T, b []byte) map[string][]byte {
+	m := make(map[string][]byte)
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := uint64(8)
+		if size == 1 {
+			size = binary.BigEndian.Uint64(b[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(b)) {
+			t.Fatalf("box %q of %d bytes in %d", typ, size, len(b))
+		}
+		if _, ok := m[typ]; !ok {
+			m[typ] = b[header:size]
+		}
+		b = b[size:]
+	}
+	return m
+}
+
+func TestWriter(t *testing.T) {
+	f, err := ioutil.TempFile("", "mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w, err := NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	slice := []byte{0x41, 0x9a, 0x02}
+	idr := []byte{0x65, 0x88, 0x84}
+	w.WriteFrame(h264.AppendAnnexB(nil, slice), start) // before the key frame
+	for i := 0; i < 60; i++ {
+		au := h264.AppendAnnexB(nil, slice)
+		if i%30 == 0 {
+			au = h264.AppendAnnexB(nil, sps, pps, idr)
+		}
+		if err := w.WriteFrame(au, start.Add(time.Duration(i)*time.Second/30)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Frames() != 60 {
+		t.Fatalf("%d frames, want 60", w.Frames())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := boxes(t, b)
+	mdat, moov := top["mdat"], top["moov"]
+	if top["ftyp"] == nil || mdat == nil || moov == nil {
+		t.Fatal("missing top level box")
+	}
+	// the key frames carry only the idr, the parameter sets are in avcC
+	if want := append([]byte{0, 0, 0, 3}, idr...); !bytes.HasPrefix(mdat, want) {
+		t.Fatalf("mdat starts with %x, want %x", mdat[:7], want)
+	}
+	if len(mdat) != 60*7 {
+		t.Fatalf("mdat of %d bytes, want %d", len(mdat), 60*7)
+	}
+
+	stbl := boxes(t, boxes(t, boxes(t, boxes(t, moov)["trak"])["mdia"])["minf"])["stbl"]
+	tables := boxes(t, stbl)
+	if n := binary.BigEndian.Uint32(tables["stsz"][8:]); n != 60 {
+		t.Fatalf("stsz of %d samples", n)
+	}
+	stss := tables["stss"]
+	if n := binary.BigEndian.Uint32(stss[4:]); n != 2 || binary.BigEndian.Uint32(stss[12:]) != 31 {
+		t.Fatalf("stss %x", stss)
+	}
+	stts := tables["stts"]
+	if n := binary.BigEndian.Uint32(stts[4:]); n != 1 || binary.BigEndian.Uint32(stts[12:]) != Timescale/30 {
+		t.Fatalf("stts %x", stts)
+	}
+	if tables["ctts"] != nil {
+		t.Fatal("ctts without reordering")
+	}
+	if !bytes.Contains(tables["stsd"], sps) || !bytes.Contains(tables["stsd"], pps) {
+		t.Fatal("stsd without sps and pps")
+	}
+	co64 := tables["co64"]
+	if off := binary.BigEndian.Uint64(co64[8:]); off != uint64(len(top["ftyp"])+8+mdatHeaderSize) {
+		t.Fatalf("first chunk at %d", off)
+	}
+}
+
+func TestFragment(t *testing.T) {
+	init, err := InitSegment(h264.ParameterSets{SPS: sps, PPS: pps})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moov := boxes(t, init)["moov"]
+	if moov == nil || boxes(t, moov)["mvex"] == nil {
+		t.Fatal("init segment without mvex")
+	}
+
+	idr := []byte{0x65, 0x88, 0x84}
+	slice := []byte{0x41, 0x9a, 0x02, 0x03}
+	samples := []Sample{
+		{Data: h264.AppendAnnexB(nil, sps, pps, idr), Duration: 3000, Key: true},
+		{Data: h264.AppendAnnexB(nil, slice), Duration: 3000},
+	}
+	b := Fragment(7, 90000, samples)
+	top := boxes(t, b)
+	moof, mdat := top["moof"], top["mdat"]
+	if moof == nil || mdat == nil {
+		t.Fatal("missing moof or mdat")
+	}
+	if want := h264.AppendAVCC(h264.AppendAVCC(nil, idr), slice); !bytes.Equal(mdat, want) {
+		t.Fatalf("mdat %x, want %x", mdat, want)
+	}
+	if seq := binary.BigEndian.Uint32(boxes(t, moof)["mfhd"][4:]); seq != 7 {
+		t.Fatalf("sequence number %d", seq)
+	}
+	traf := boxes(t, boxes(t, moof)["traf"])
+	if base := binary.BigEndian.Uint64(traf["tfdt"][4:]); base != 90000 {
+		t.Fatalf("base decode time %d", base)
+	}
+	trun := traf["trun"]
+	if n := binary.BigEndian.Uint32(trun[4:]); n != 2 {
+		t.Fatalf("trun of %d samples", n)
+	}
+	// the data offset from the moof points to the first sample
+	if off := int(binary.BigEndian.Uint32(trun[8:])); !bytes.Equal(b[off:], mdat) {
+		t.Fatalf("data offset %d", off)
+	}
+}
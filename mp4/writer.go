@@ -0,0 +1,274 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+)
+
+const (
+	mdatHeaderSize  = 16 // with the 64 bit size
+	defaultDuration = Timescale / 30
+)
+
+var ErrClosed = errors.New("mp4: writer closed")
+
+type sample struct {
+	offset uint64
+	size   uint32
+	pts    int64
+	key    bool
+	desc   int // index of the sample description, from 0
+}
+
+// description is the SPS and PPS of the samples, a new one starts when the encoder changes them
+type description struct {
+	sps, pps      []byte
+	width, height int
+}
+
+// Writer records an H.264 stream to a plain MP4 file, the index is written by Close.
+// The resolution may change, every new SPS/PPS gets its own sample description.
+type Writer struct {
+	w       io.WriteSeeker
+	offset  uint64 // of the next sample in the file
+	samples []sample
+	descs   []description
+	ps      h264.ParameterSets
+	start   time.Time
+	buf     []byte
+	closed  bool
+}
+
+func NewWriter(w io.WriteSeeker) (*Writer, error) {
+	b := &buffer{b: ftypBox()}
+	b.u32(1) // the 64 bit size follows the type
+	b.bytes([]byte("mdat"))
+	b.u64(0)
+	if _, err := w.Write(b.b); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, offset: uint64(len(b.b))}, nil
+}
+
+// WriteFrame write an access unit in Annex B format captured at t. The frames before the
+// first key frame with SPS and PPS are skipped as they do not decode.
+func (w *Writer) WriteFrame(au []byte, t time.Time) error {
+	if w.closed {
+		return ErrClosed
+	}
+	if w.ps.Update(au) && w.ps.Complete() {
+		sps, err := h264.ParseSPS(w.ps.SPS)
+		if err != nil {
+			return err
+		}
+		w.descs = append(w.descs, description{
+			sps:    w.ps.SPS,
+			pps:    w.ps.PPS,
+			width:  sps.Width,
+			height: sps.Height,
+		})
+	}
+	key := h264.IsKeyFrame(au)
+	if len(w.descs) == 0 || (len(w.samples) == 0 && !key) {
+		return nil
+	}
+	if len(w.samples) == 0 {
+		w.start = t
+	}
+
+	w.buf = appendSample(w.buf[:0], au)
+	if _, err := w.w.Write(w.buf); err != nil {
+		return err
+	}
+	w.samples = append(w.samples, sample{
+		offset: w.offset,
+		size:   uint32(len(w.buf)),
+		pts:    ticks(t.Sub(w.start)),
+		key:    key,
+		desc:   len(w.descs) - 1,
+	})
+	w.offset += uint64(len(w.buf))
+	return nil
+}
+
+// Frames return the number of frames written
+func (w *Writer) Frames() int {
+	return len(w.samples)
+}
+
+// Close write the size of the media data and the index, it does not close the file
+func (w *Writer) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	mdatStart := int64(len(ftypBox()))
+	if _, err := w.w.Seek(mdatStart+8, io.SeekStart); err != nil {
+		return err
+	}
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], w.offset-uint64(mdatStart))
+	if _, err := w.w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := w.w.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	_, err := w.w.Write(w.moov())
+	return err
+}
+
+// ticks convert d to the timescale of the track, rounded
+func ticks(d time.Duration) int64 {
+	us := int64(d / time.Microsecond)
+	return (us*(Timescale/1000) + 500) / 1000
+}
+
+func ftypBox() []byte {
+	b := &buffer{}
+	b.ftyp("isom", "isom", "iso2", "avc1", "mp41")
+	return b.b
+}
+
+// decodeTimes return the decode time of each sample, the presentation times in order
+// and strictly increasing, and the duration of the last sample
+func (w *Writer) decodeTimes() ([]int64, int64) {
+	dts := make([]int64, len(w.samples))
+	for i, s := range w.samples {
+		dts[i] = s.pts
+	}
+	sort.Slice(dts, func(i, j int) bool { return dts[i] < dts[j] })
+	for i := 1; i < len(dts); i++ {
+		if dts[i] <= dts[i-1] {
+			dts[i] = dts[i-1] + 1
+		}
+	}
+	last := int64(defaultDuration)
+	if n := len(dts); n > 1 {
+		last = dts[n-1] - dts[n-2]
+	}
+	return dts, last
+}
+
+func (w *Writer) moov() []byte {
+	dts, last := w.decodeTimes()
+	var duration int64
+	if len(dts) > 0 {
+		duration = dts[len(dts)-1] + last
+	}
+	width, height := 0, 0
+	if len(w.descs) > 0 {
+		width, height = w.descs[0].width, w.descs[0].height
+	}
+
+	b := &buffer{}
+	b.box("moov", func() {
+		b.mvhd(uint64(duration*movieTimescale/Timescale), 2)
+		b.box("trak", func() {
+			b.tkhd(1, uint64(duration*movieTimescale/Timescale), width, height)
+			b.box("mdia", func() {
+				b.mdhd(uint64(duration))
+				b.hdlr()
+				b.box("minf", func() {
+					b.minfHeader()
+					b.box("stbl", func() {
+						w.sampleTable(b, dts, last)
+					})
+				})
+			})
+		})
+	})
+	return b.b
+}
+
+func (w *Writer) sampleTable(b *buffer, dts []int64, last int64) {
+	b.fullBox("stsd", 0, 0, func() {
+		b.u32(uint32(len(w.descs)))
+		for _, d := range w.descs {
+			b.avc1(d.sps, d.pps, d.width, d.height)
+		}
+	})
+
+	// run length coded durations
+	type run struct{ count, delta uint32 }
+	var runs []run
+	for i := range dts {
+		delta := last
+		if i+1 < len(dts) {
+			delta = dts[i+1] - dts[i]
+		}
+		if n := len(runs); n > 0 && runs[n-1].delta == uint32(delta) {
+			runs[n-1].count++
+		} else {
+			runs = append(runs, run{1, uint32(delta)})
+		}
+	}
+	b.fullBox("stts", 0, 0, func() {
+		b.u32(uint32(len(runs)))
+		for _, r := range runs {
+			b.u32(r.count)
+			b.u32(r.delta)
+		}
+	})
+
+	reordered := false
+	for i, s := range w.samples {
+		reordered = reordered || s.pts != dts[i]
+	}
+	if reordered {
+		b.fullBox("ctts", 1, 0, func() { // signed offsets
+			b.u32(uint32(len(w.samples)))
+			for i, s := range w.samples {
+				b.u32(1)
+				b.u32(uint32(int32(s.pts - dts[i])))
+			}
+		})
+	}
+
+	var keys []uint32
+	for i, s := range w.samples {
+		if s.key {
+			keys = append(keys, uint32(i+1))
+		}
+	}
+	b.fullBox("stss", 0, 0, func() {
+		b.u32(uint32(len(keys)))
+		for _, k := range keys {
+			b.u32(k)
+		}
+	})
+
+	// one sample per chunk, a new entry when the description changes
+	b.fullBox("stsc", 0, 0, func() {
+		var entries [][3]uint32
+		for i, s := range w.samples {
+			if i == 0 || s.desc != w.samples[i-1].desc {
+				entries = append(entries, [3]uint32{uint32(i + 1), 1, uint32(s.desc + 1)})
+			}
+		}
+		b.u32(uint32(len(entries)))
+		for _, e := range entries {
+			b.u32(e[0])
+			b.u32(e[1])
+			b.u32(e[2])
+		}
+	})
+	b.fullBox("stsz", 0, 0, func() {
+		b.u32(0)
+		b.u32(uint32(len(w.samples)))
+		for _, s := range w.samples {
+			b.u32(s.size)
+		}
+	})
+	b.fullBox("co64", 0, 0, func() {
+		b.u32(uint32(len(w.samples)))
+		for _, s := range w.samples {
+			b.u64(s.offset)
+		}
+	})
+}
@@ -1,13 +1,10 @@
 #!/usr/bin/env bash
 
-ROOT="/Users/liufeihao/goWorkspace/src/github.com/l-f-h/video/net"
-
-mkdir -p output
-
-cd ${ROOT}/client
-go build -o ${ROOT}/output/client
-
-cd ${ROOT}/server
-go build -o ${ROOT}/output/server
+ROOT="$(cd "$(dirname "$0")" && pwd)"
 
+mkdir -p ${ROOT}/output
 
+cd ${ROOT}
+go build -o ${ROOT}/output/video ./video
+go build -o ${ROOT}/output/netsim ./netsim
+go build -o ${ROOT}/output/keygen ./keygen
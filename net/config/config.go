@@ -0,0 +1,90 @@
+// Package config reads the flags of a command from a file, the flags of the command line have
+// precedence. A file has a flag per line, the name and the value, with # comments:
+//
+//	# video send -config send.conf
+//	p = udp
+//	r = 192.168.1.20:8888
+//	src = rtsp://192.168.1.64/stream1
+//	bitrate = 2000000
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Flag is a line of a file
+type Flag struct {
+	Name  string
+	Value string
+	Line  int
+}
+
+// Parse parse the args into fs, then the file of its -config flag for the flags the args don't set
+func Parse(fs *flag.FlagSet, args []string) error {
+	file := fs.String("config", "", "read the flags from the file, a name = value per line")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return nil
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	flags, err := Read(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *file, err)
+	}
+	return Apply(fs, flags)
+}
+
+// Apply set the flags of fs the command line didn't set
+func Apply(fs *flag.FlagSet, flags []Flag) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, f := range flags {
+		if fs.Lookup(f.Name) == nil {
+			return fmt.Errorf("line %d: unknown flag %q", f.Line, f.Name)
+		}
+		if set[f.Name] {
+			continue
+		}
+		if err := fs.Set(f.Name, f.Value); err != nil {
+			return fmt.Errorf("line %d: %v", f.Line, err)
+		}
+	}
+	return nil
+}
+
+// Read the flags of a file, a value may be quoted to keep its spaces
+func Read(r io.Reader) ([]Flag, error) {
+	var flags []Flag
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: no = in %q", n, line)
+		}
+		f := Flag{
+			Name:  strings.TrimSpace(line[:i]),
+			Value: strings.TrimSpace(line[i+1:]),
+			Line:  n,
+		}
+		if len(f.Value) >= 2 && f.Value[0] == '"' && f.Value[len(f.Value)-1] == '"' {
+			f.Value = f.Value[1 : len(f.Value)-1]
+		}
+		flags = append(flags, f)
+	}
+	return flags, s.Err()
+}
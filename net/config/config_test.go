@@ -0,0 +1,57 @@
+package config
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	flags, err := Read(strings.NewReader(`
+# a comment
+p = udp
+r=10.0.0.2:8888
+name = "two words"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Flag{{"p", "udp", 3}, {"r", "10.0.0.2:8888", 4}, {"name", "two words", 5}}
+	if len(flags) != len(want) {
+		t.Fatalf("flags %v", flags)
+	}
+	for i := range want {
+		if flags[i] != want[i] {
+			t.Fatalf("flag %d is %+v, want %+v", i, flags[i], want[i])
+		}
+	}
+	if _, err := Read(strings.NewReader("p udp")); err == nil {
+		t.Fatal("line without =")
+	}
+}
+
+func TestApply(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	p := fs.String("p", "tcp", "")
+	r := fs.String("r", "127.0.0.1:8888", "")
+	d := fs.Duration("d", 0, "")
+	if err := fs.Parse([]string{"-r", ":9000"}); err != nil {
+		t.Fatal(err)
+	}
+	// the command line has precedence
+	if err := Apply(fs, []Flag{{"p", "udp", 1}, {"r", ":7000", 2}, {"d", "2s", 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if *p != "udp" || *r != ":9000" || *d != 2*time.Second {
+		t.Fatalf("p %s, r %s, d %v", *p, *r, *d)
+	}
+	if err := Apply(fs, []Flag{{"x", "1", 4}}); err == nil {
+		t.Fatal("unknown flag applied")
+	}
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Duration("d", 0, "")
+	if err := Apply(fs, []Flag{{"d", "soon", 5}}); err == nil {
+		t.Fatal("bad duration applied")
+	}
+}
@@ -1,14 +1,51 @@
 module github.com/l-f-h/video/net
 
-go 1.12
+go 1.22
 
 require (
 	github.com/l-f-h/rudp v0.0.0-20200606033844-4bcf02f70785
 	github.com/l-f-h/video v0.0.0-20200503152941-73b58ff7ecf6
-	github.com/stretchr/testify v1.5.1 // indirect
 	github.com/veandco/go-sdl2 v0.4.1
 )
 
-replace github.com/l-f-h/rudp => /Users/liufeihao/goWorkspace/src/github.com/l-f-h/rudp
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/giorgisio/goav v0.1.1-0.20191111001116-902d1f3890c2 // indirect
+	github.com/go-task/slim-sprig v0.0.0-20230315185526-52ccab3ef572 // indirect
+	github.com/google/pprof v0.0.0-20210720184732-4bb14d4b1be1 // indirect
+	github.com/google/uuid v1.3.1 // indirect
+	github.com/gorilla/websocket v1.2.0 // indirect
+	github.com/onsi/ginkgo/v2 v2.9.5 // indirect
+	github.com/pion/datachannel v1.5.5 // indirect
+	github.com/pion/dtls/v2 v2.2.7 // indirect
+	github.com/pion/ice/v2 v2.3.11 // indirect
+	github.com/pion/interceptor v0.1.25 // indirect
+	github.com/pion/logging v0.2.2 // indirect
+	github.com/pion/mdns v0.0.8 // indirect
+	github.com/pion/randutil v0.1.0 // indirect
+	github.com/pion/rtcp v1.2.12 // indirect
+	github.com/pion/rtp v1.8.3 // indirect
+	github.com/pion/sctp v1.8.8 // indirect
+	github.com/pion/sdp/v3 v3.0.6 // indirect
+	github.com/pion/srtp/v2 v2.0.18 // indirect
+	github.com/pion/stun v0.6.1 // indirect
+	github.com/pion/transport/v2 v2.2.3 // indirect
+	github.com/pion/turn/v2 v2.1.3 // indirect
+	github.com/pion/webrtc/v3 v3.2.24 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/quic-go/quic-go v0.48.2 // indirect
+	github.com/stretchr/testify v1.9.0 // indirect
+	go.uber.org/mock v0.4.0 // indirect
+	gocv.io/x/gocv v0.23.0 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
+	golang.org/x/mod v0.17.0 // indirect
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/l-f-h/rudp => ../../rudp
 
-replace github.com/l-f-h/video => /Users/liufeihao/goWorkspace/src/github.com/l-f-h/video
+replace github.com/l-f-h/video => ../
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/l-f-h/video/secure"
+)
+
+// keygen write a new X25519 private key for -key and print its public key for -peer/-peers
+func main() {
+	var out string
+	flag.StringVar(&out, "o", "video.key", "file of the private key")
+	flag.Parse()
+
+	private, public, err := secure.GenerateKey()
+	if err != nil {
+		log.Fatalf("secure.GenerateKey error: %v", err)
+	}
+	if err := ioutil.WriteFile(out, []byte(secure.EncodeKey(private)+"\n"), 0600); err != nil {
+		log.Fatalf("write key error: %v", err)
+	}
+	fmt.Println(secure.EncodeKey(public))
+}
@@ -0,0 +1,69 @@
+package main
+
+// impairment proxy, the pure go replacement of netsimul.sh + dnctl
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/l-f-h/video/netsim"
+)
+
+func main() {
+	var (
+		protocol string
+		listen   string
+		upstream string
+		profile  string
+		file     string
+	)
+	flag.StringVar(&protocol, "p", "udp", "tcp/udp, use udp for rudp")
+	flag.StringVar(&listen, "l", "127.0.0.1:7777", "listen address, the client dials it")
+	flag.StringVar(&upstream, "u", "127.0.0.1:8888", "upstream address of the server")
+	flag.StringVar(&profile, "profile", "pipe1", strings.Join(netsim.ProfileNames(), "/")+" or a dnctl config like \"bw 10Mbit/s delay 50 plr 0.1\"")
+	flag.StringVar(&file, "f", "", "load the pipes from a dnctl script like netsimul.sh")
+	flag.Parse()
+
+	p, err := netsim.LookupProfile(profile)
+	if file != "" {
+		profiles, lerr := netsim.LoadProfiles(file)
+		if lerr != nil {
+			log.Fatalf("netsim.LoadProfiles error: %v", lerr)
+		}
+		if fp, ok := profiles[profile]; ok {
+			p, err = fp, nil
+		}
+	}
+	if err != nil {
+		log.Fatalf("profile error: %v", err)
+	}
+
+	proxy, err := netsim.NewProxy(protocol, upstream, p)
+	if err != nil {
+		log.Fatalf("netsim.NewProxy error: %v", err)
+	}
+	log.Printf("%s proxy %s -> %s, %v", protocol, listen, upstream, p)
+
+	go func() {
+		for range time.Tick(5 * time.Second) {
+			up, down := proxy.Stats()
+			log.Printf("up %+v", up)
+			log.Printf("down %+v", down)
+		}
+	}()
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	go func() {
+		<-ch
+		proxy.Close()
+	}()
+
+	if err := proxy.ListenAndServe(listen); err != nil {
+		log.Fatalf("proxy.ListenAndServe error: %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package recv
+
+import (
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/mp4"
+	"github.com/l-f-h/video/transport"
+)
+
+var streams int32 // count of the headless streams, each writes its own file
+
+// sink takes the frames of a headless stream
+type sink interface {
+	WriteFrame(f *transport.Frame) error
+	Close() error
+}
+
+// restarter is a sink that holds state of the sender, it drops it when the sender starts again
+type restarter interface {
+	Restart()
+}
+
+// receiveHeadless receive a stream without window: record it, write the decoded frames,
+// or only count and check them
+func receiveHeadless(s *stream) {
+	n := int(atomic.AddInt32(&streams, 1)) - 1
+	out, err := newSink(outputName(output, n), s.params.FPS)
+	if err != nil {
+		log.Fatalf("open output error: %v", err)
+	}
+
+	// the stamps are read from the pictures, decoded once more for a recording
+	var stamps *stampChecker
+	if checkStamps {
+		stamps = newStampChecker(s.clock)
+		decoded, err := newDecodeSink(func(frame *codec.YUVFrame) error {
+			stamps.onPicture(frame)
+			return nil
+		}, nil)
+		if err != nil {
+			log.Fatalf("open decoder error: %v", err)
+		}
+		out = teeSink{out, decoded}
+	}
+
+	if r, ok := out.(restarter); ok {
+		s.onRestart = r.Restart
+	}
+	check := &frameChecker{start: time.Now()}
+	stop := make(chan struct{})
+	reporters.Add(1)
+	go func() {
+		defer reporters.Done()
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-exiting:
+				log.Printf("exit: %v", check)
+				if stamps != nil {
+					log.Printf("exit: %v, latency %v", stamps, stamps.latency.Total())
+				}
+				return
+			case <-ticker.C:
+				log.Printf("%v", check)
+				if stamps != nil {
+					log.Printf("%v, latency %v", stamps, stamps.latency.Period())
+				}
+			}
+		}
+	}()
+
+	s.read(func(f *transport.Frame) {
+		check.onFrame(f)
+		if err := out.WriteFrame(f); err != nil {
+			log.Fatalf("write frame error: %v", err)
+		}
+	})
+	close(stop)
+	if err := out.Close(); err != nil {
+		log.Printf("close output error: %v", err)
+	}
+	s.conn.Close()
+	log.Printf("stream over: %v", check)
+	if stamps != nil {
+		log.Printf("stream over: %v, latency %v", stamps, stamps.latency.Total())
+	}
+}
+
+// frameChecker counts the frames and checks their NAL units, a frame after a loss does not
+// decode until the next key frame
+type frameChecker struct {
+	start    time.Time
+	frames   int
+	keys     int
+	invalid  int
+	broken   int // not decodable, the reference frames are missing
+	bytes    int
+	lastID   uint32
+	needsKey bool
+}
+
+func (c *frameChecker) onFrame(f *transport.Frame) {
+	if c.frames == 0 || f.ID != c.lastID+1 {
+		c.needsKey = true // the first frame, or frames were lost
+	}
+	c.lastID = f.ID
+	c.frames++
+	c.bytes += len(f.Data)
+	if err := h264.Validate(f.Data); err != nil {
+		c.invalid++
+		c.needsKey = true
+		log.Printf("frame %d: %v", f.ID, err)
+		return
+	}
+	if f.KeyFrame {
+		c.keys++
+		c.needsKey = false
+	}
+	if c.needsKey {
+		c.broken++
+	}
+}
+
+func (c *frameChecker) String() string {
+	elapsed := time.Since(c.start).Seconds()
+	return fmt.Sprintf("frames %d (%.1f fps, %.0f kbps) key %d invalid %d undecodable %d",
+		c.frames, float64(c.frames)/elapsed, float64(c.bytes)*8/elapsed/1000, c.keys, c.invalid, c.broken)
+}
+
+// outputName give the n-th stream its own file, out.mp4 becomes out-1.mp4
+func outputName(path string, n int) string {
+	if n == 0 || path == "" || path == "-" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// newSink choose the sink by the extension of the output
+func newSink(path string, fps int) (sink, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case path == "":
+		return nopSink{}, nil
+	case path == "-":
+		return &rawSink{w: os.Stdout}, nil
+	case ext == ".h264" || ext == ".264":
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return &rawSink{w: f, c: f}, nil
+	case ext == ".mp4":
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w, err := mp4.NewWriter(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &mp4Sink{f: f, w: w}, nil
+	case ext == ".y4m":
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		y := codec.NewY4MWriter(f, fps)
+		skipped := 0
+		return newDecodeSink(func(frame *codec.YUVFrame) error {
+			err := y.WriteFrame(frame.YCbCr)
+			if err == codec.ErrY4MFrameSize {
+				// y4m has one size, the sender changed the resolution
+				if skipped++; skipped == 1 {
+					log.Printf("y4m: skip the frames of %v", frame.Rect.Size())
+				}
+				return nil
+			}
+			return err
+		}, func() error {
+			if err := y.Flush(); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		})
+	case ext == ".png":
+		n := 0
+		return newDecodeSink(func(frame *codec.YUVFrame) error {
+			f, err := os.Create(pngName(path, n))
+			if err != nil {
+				return err
+			}
+			n++
+			if err := png.Encode(f, frame.YCbCr); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		}, nil)
+	}
+	return nil, fmt.Errorf("unknown output format %q", path)
+}
+
+// pngName number the pictures, a path with a verb like out%05d.png is formatted with the number
+func pngName(path string, n int) string {
+	if strings.Contains(path, "%") {
+		return fmt.Sprintf(path, n)
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%06d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+type nopSink struct{}
+
+func (nopSink) WriteFrame(*transport.Frame) error { return nil }
+func (nopSink) Close() error                      { return nil }
+
+// rawSink writes the Annex B stream as received, ffplay and ffmpeg read it
+type rawSink struct {
+	w io.Writer
+	c io.Closer
+}
+
+func (s *rawSink) WriteFrame(f *transport.Frame) error {
+	_, err := s.w.Write(f.Data)
+	return err
+}
+
+func (s *rawSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
+
+type mp4Sink struct {
+	f *os.File
+	w *mp4.Writer
+}
+
+func (s *mp4Sink) WriteFrame(f *transport.Frame) error {
+	t := f.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return s.w.WriteFrame(f.Data, t)
+}
+
+func (s *mp4Sink) Close() error {
+	if err := s.w.Close(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// decodeSink decodes the frames like the window does and passes the pictures to write
+type decodeSink struct {
+	codecHandler interface {
+		PushH264AccessUnit(data []byte, captured time.Time)
+		ResetH264Decoder()
+		EndH264Stream()
+	}
+	done     chan error
+	closeOut func() error
+}
+
+func newDecodeSink(write func(*codec.YUVFrame) error, closeOut func() error) (*decodeSink, error) {
+	codecHandler := codec.NewCodecHandler()
+	if err := codecHandler.InitAndOpenH264Decoder(); err != nil {
+		return nil, err
+	}
+	go codecHandler.H264Decode()
+	s := &decodeSink{codecHandler: codecHandler, done: make(chan error, 1), closeOut: closeOut}
+	go func() {
+		var err error
+		for frame := range codecHandler.YUVImgRecQue() {
+			framesDecoded.Inc()
+			if err == nil {
+				err = write(frame)
+			}
+		}
+		s.done <- err
+	}()
+	return s, nil
+}
+
+func (s *decodeSink) WriteFrame(f *transport.Frame) error {
+	s.codecHandler.PushH264AccessUnit(f.Data, f.Timestamp)
+	return nil
+}
+
+func (s *decodeSink) Restart() {
+	s.codecHandler.ResetH264Decoder()
+}
+
+// Close wait for the last pictures of the decoder
+func (s *decodeSink) Close() error {
+	s.codecHandler.EndH264Stream()
+	err := <-s.done
+	if s.closeOut != nil {
+		if cerr := s.closeOut(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package recv
+
+import (
+	"log"
+	"time"
+
+	"github.com/l-f-h/video/arq"
+	"github.com/l-f-h/video/metrics"
+)
+
+var (
+	framesReceived       = metrics.NewCounter("video_frames_total", "Frames by stage of the pipeline.", "stage", "received")
+	framesLost           = metrics.NewCounter("video_frames_total", "Frames by stage of the pipeline.", "stage", "lost")
+	framesDecoded        = metrics.NewCounter("video_frames_total", "Frames by stage of the pipeline.", "stage", "decoded")
+	framesRendered       = metrics.NewCounter("video_frames_total", "Frames by stage of the pipeline.", "stage", "rendered")
+	decodeErrors         = metrics.NewCounter("video_decode_errors_total", "Packets rejected by the h264 decoder.")
+	packetsReceived      = metrics.NewCounter("video_packets_received_total", "Packets read from the connection.")
+	packetsLost          = metrics.NewCounter("video_packets_lost_total", "Packets missing when the feedback was built.")
+	bytesReceived        = metrics.NewCounter("video_bytes_received_total", "Bytes read from the connection, with the packet headers.")
+	retransmits          = metrics.NewCounter("video_retransmits_total", "Packets sent again by the arq window, recovered on the receiver; 0 with the other protocols.", "side", "receiver")
+	arqDropped           = metrics.NewCounter("video_arq_dropped_total", "Packets given up by the latency window of arq.", "side", "receiver", "reason", "lost")
+	arqLate              = metrics.NewCounter("video_arq_dropped_total", "Packets given up by the latency window of arq.", "side", "receiver", "reason", "late")
+	recvBitrate          = metrics.NewMeter("video_receive_bitrate_bps", "Bitrate read from the connection over the last 2s.", 8)
+	decodeLatencySeconds = metrics.NewHistogram("video_frame_latency_seconds", "Latency from the capture of the frame.",
+		metrics.LatencyBuckets, "stage", "decoded")
+	renderLatencySeconds = metrics.NewHistogram("video_frame_latency_seconds", "Latency from the capture of the frame.",
+		metrics.LatencyBuckets, "stage", "rendered")
+	stampLatencySeconds = metrics.NewHistogram("video_frame_latency_seconds", "Latency from the capture of the frame.",
+		metrics.LatencyBuckets, "stage", "stamped")
+
+	stampsMissing    = metrics.NewCounter("video_stamped_frames_total", "Frames of the burned-in counter of a synthetic sender.", "result", "missing")
+	stampsRepeated   = metrics.NewCounter("video_stamped_frames_total", "Frames of the burned-in counter of a synthetic sender.", "result", "repeated")
+	stampsReordered  = metrics.NewCounter("video_stamped_frames_total", "Frames of the burned-in counter of a synthetic sender.", "result", "reordered")
+	stampsUnreadable = metrics.NewCounter("video_stamped_frames_total", "Frames of the burned-in counter of a synthetic sender.", "result", "unreadable")
+
+	yuvImgQueueDepth     = metrics.NewGauge("video_queue_depth", "Length of the codec queues.", "queue", "yuv_img", "side", "receiver")
+	h264PacketQueueDepth = metrics.NewGauge("video_queue_depth", "Length of the codec queues.", "queue", "h264_packet", "side", "receiver")
+	rawDataQueueDepth    = metrics.NewGauge("video_queue_depth", "Length of the codec queues.", "queue", "raw_data", "side", "receiver")
+)
+
+// sampleCodec update the depth of the codec queues and the decode errors every second until stop
+func sampleCodec(codecHandler interface {
+	QueueDepths() (yuvImg, h264Packet, rawData int)
+	DecodeErrors() uint64
+}, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var errors uint64
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		yuvImg, h264Packet, rawData := codecHandler.QueueDepths()
+		yuvImgQueueDepth.Set(float64(yuvImg))
+		h264PacketQueueDepth.Set(float64(h264Packet))
+		rawDataQueueDepth.Set(float64(rawData))
+		if n := codecHandler.DecodeErrors(); n > errors {
+			decodeErrors.Add(int(n - errors))
+			errors = n
+		}
+	}
+}
+
+// sampleARQ count the recovered and the dropped packets of the arq window every second, and log
+// the new drops
+func sampleARQ(conn *arq.Conn) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last arq.Stats
+	for range ticker.C {
+		s := conn.Stats()
+		retransmits.Add(int(s.Recovered - last.Recovered))
+		arqDropped.Add(int(s.Dropped - last.Dropped))
+		arqLate.Add(int(s.Late - last.Late))
+		if s.Dropped != last.Dropped {
+			log.Printf("arq: %d packets dropped at their delivery time, %d recovered", s.Dropped, s.Recovered)
+		}
+		last = s
+	}
+}
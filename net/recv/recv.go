@@ -0,0 +1,687 @@
+// Package recv is the receiver of the video command: it accepts the sessions of the senders and
+// plays their streams in a window, or writes them to a file.
+package recv
+
+import (
+	"flag"
+	"fmt"
+	"github.com/l-f-h/rudp"
+	"github.com/l-f-h/video/arq"
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/latency"
+	"github.com/l-f-h/video/metrics"
+	"github.com/l-f-h/video/net/config"
+	"github.com/l-f-h/video/quic"
+	"github.com/l-f-h/video/secure"
+	"github.com/l-f-h/video/session"
+	"github.com/l-f-h/video/transport"
+	"github.com/veandco/go-sdl2/sdl"
+	"image"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	listenAddr    string
+	httpAddr      string
+	psk           string
+	keyFile       string
+	peerKeys      string
+	subAddr       string
+	headless      bool
+	checkStamps   bool
+	output        string
+	arqLatency    time.Duration
+	resumeTimeout time.Duration
+
+	// receive a stream, in the window or headless
+	receive = decodeH264Stream
+	runMain = sdl.Main
+
+	exiting   = make(chan struct{}) // closed on a signal or when the window is closed
+	exitOnce  sync.Once
+	reporters sync.WaitGroup // of the streams, they log their totals on exiting
+)
+
+// Run the receiver with the args of the command line
+func Run(args []string) {
+	var protocol string
+	fs := flag.NewFlagSet("recv", flag.ExitOnError)
+	fs.StringVar(&protocol, "p", "unknown", "udp/rudp/tcp/quic/arq, or rtmp to take the streams of RTMP encoders")
+	fs.StringVar(&listenAddr, "l", "", "listen address, :8888 or :1935 for rtmp")
+	fs.StringVar(&httpAddr, "http", "localhost:9999", "address of the HTTP server of the metrics and pprof")
+	fs.StringVar(&psk, "psk", "", "accept only clients with the pre-shared key")
+	fs.StringVar(&keyFile, "key", "", "X25519 private key file of keygen, needs -peers")
+	fs.StringVar(&peerKeys, "peers", "", "pinned public keys of the clients, comma separated base64")
+	fs.StringVar(&subAddr, "sub", "", "play the stream of the relay at the tcp address instead of listening")
+	fs.DurationVar(&arqLatency, "latency", arq.DefaultConfig.Latency, "latency window of -p arq, the client may ask a larger one")
+	fs.DurationVar(&resumeTimeout, "resume", 30*time.Second, "keep the stream of a sender that left for it to reconnect, 0 ends it at once")
+	fs.BoolVar(&headless, "headless", false, "receive without window, only count and check the frames")
+	fs.BoolVar(&checkStamps, "stamps", false, "read the counter burned in by -src synthetic: the missing and repeated frames and the latency to the picture")
+	fs.StringVar(&output, "o", "", "receive without window to the file: .h264 (- for stdout), .mp4, .y4m or .png sequence")
+	if err := config.Parse(fs, args); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	if listenAddr == "" {
+		listenAddr = ":8888"
+		if protocol == "rtmp" {
+			listenAddr = ":1935"
+		}
+	}
+	if headless || output != "" {
+		receive = receiveHeadless
+		runMain = func(f func()) { f() }
+	}
+	metrics.Handle()
+	go func() {
+		log.Println(http.ListenAndServe(httpAddr, nil))
+	}()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		exit(1)
+	}()
+	if subAddr != "" {
+		runMain(subscribe)
+		return
+	}
+	switch protocol {
+	case "tcp":
+		runMain(tcp)
+	case "udp":
+		runMain(udp)
+	case "rudp":
+		runMain(rUDP)
+	case "quic":
+		runMain(listenQUIC)
+	case "arq":
+		runMain(arqUDP)
+	case "rtmp":
+		runMain(rtmpIngest)
+	default:
+		log.Fatalf("protocol error")
+	}
+}
+
+func tcp() {
+	listen, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		log.Fatalf("net.Listen tcp error: %v", err)
+	}
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			log.Fatalf("listen.Accept error: %v", err)
+		}
+		go func(c net.Conn) {
+			if c = decrypt(c, false); c != nil {
+				serve(c, false)
+			}
+		}(conn)
+
+	}
+}
+
+func udp() {
+	conn, err := listenUDP()
+	if err != nil {
+		log.Fatalf("net.Listen udp error: %v", err)
+	}
+	// answer the feedback to the last client, or to the client of the handshake
+	peer := transport.NewPeerConn(conn)
+	for {
+		if c := decrypt(peer, true); c != nil {
+			serve(c, true)
+			return
+		}
+	}
+}
+
+// arqUDP deliver the stream of the client after the latency window, with the lost packets
+// retransmitted in time or dropped
+func arqUDP() {
+	conn, err := listenUDP()
+	if err != nil {
+		log.Fatalf("net.Listen udp error: %v", err)
+	}
+	peer := transport.NewPeerConn(conn)
+	for {
+		if c := decrypt(peer, true); c != nil {
+			aconn := arq.NewConn(c, arq.Config{Latency: arqLatency})
+			go sampleARQ(aconn)
+			serve(aconn, true)
+			return
+		}
+	}
+}
+
+func listenUDP() (*net.UDPConn, error) {
+	laddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", laddr)
+}
+
+func rUDP() {
+	rudp.Debug()
+	laddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		log.Fatalf("net.ResolveUDPAddr error: %v", err)
+	}
+	listener, err := rudp.ListenRUDP(laddr)
+	if err != nil {
+		log.Fatalf("rudp.ListenRUDP error: %v", err)
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("listener.Accept error: %v", err)
+		}
+		go func(c net.Conn) {
+			if c = decrypt(c, false); c != nil {
+				serve(c, false)
+			}
+		}(conn)
+	}
+}
+
+// listenQUIC accept the senders of both quic modes, QUIC has the encryption
+func listenQUIC() {
+	if psk != "" || keyFile != "" {
+		log.Fatalf("-psk and -key are not supported with quic, it has TLS")
+	}
+	listener, err := quic.Listen(listenAddr, quic.DefaultConfig)
+	if err != nil {
+		log.Fatalf("quic.Listen error: %v", err)
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("listener.Accept error: %v", err)
+		}
+		go serve(conn, false)
+	}
+}
+
+// subscribe play the stream of a relay, the relay offers the session like a client
+func subscribe() {
+	conn, err := net.Dial("tcp", subAddr)
+	if err != nil {
+		log.Fatalf("net.Dial tcp error: %v", err)
+	}
+	serve(conn, false)
+}
+
+// decrypt run the handshake of the security layer if -psk or -key is set, nil if it failed
+func decrypt(conn net.Conn, datagram bool) net.Conn {
+	cfg, err := secure.NewConfig(psk, keyFile, peerKeys, datagram)
+	if err != nil {
+		log.Fatalf("secure.NewConfig error: %v", err)
+	}
+	if cfg == nil {
+		return conn
+	}
+	sconn, err := secure.Server(conn, *cfg)
+	if err != nil {
+		log.Printf("secure.Server error: %v", err)
+		if !datagram {
+			conn.Close()
+		}
+		return nil
+	}
+	log.Printf("encrypted from %v", conn.RemoteAddr())
+	return sconn
+}
+
+// stream is the receiving side of a session, the window and the headless modes read it the same way
+type stream struct {
+	mu       sync.Mutex // of conn, a resumption closes it
+	conn     io.Closer
+	params   session.Params                       // agreed at the start
+	read     func(onFrame func(*transport.Frame)) // the frames until the sender leaves
+	tconn    *transport.Conn
+	sess     *session.Session
+	clock    *latency.ClockEstimator
+	recorder *transport.FeedbackRecorder
+	resumed  chan *stream // the new conn of the sender
+
+	datagram  bool   // a sender that starts again on the conn is a new session of the stream
+	onRestart func() // of the reader, its decoder drops the frames of the old sender
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[uint64]*stream) // the streams the senders can resume, by session id
+)
+
+// serve receive the stream of a sender on conn, or hand conn to the stream of the session the
+// sender resumes; a datagram conn keeps the boundaries of the records
+func serve(conn net.Conn, datagram bool) {
+	s, err := acceptStream(conn, datagram)
+	if err != nil {
+		log.Printf("session.Accept error: %v", err)
+		conn.Close()
+		return
+	}
+	if s.sess.Resumed && resume(s) {
+		return
+	}
+	if resumeTimeout > 0 {
+		sessionsMu.Lock()
+		sessions[s.sess.ID] = s
+		sessionsMu.Unlock()
+	}
+	s.start()
+	receive(s)
+}
+
+// resume hand the conn of n to the stream of its session, false if the stream is over
+func resume(n *stream) bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s := sessions[n.sess.ID]
+	if s == nil {
+		return false
+	}
+	select {
+	case pending := <-s.resumed:
+		pending.conn.Close() // replaced by the newer one
+	default:
+	}
+	s.resumed <- n
+	// the read of a conn that didn't fail on this side stops
+	s.mu.Lock()
+	s.conn.Close()
+	s.mu.Unlock()
+	return true
+}
+
+// waitResume wait for the sender to resume the session after the read error, false if it
+// doesn't within -resume and the stream is over
+func (s *stream) waitResume(err error) bool {
+	if err != io.EOF {
+		log.Printf("ReadPacket error: %v", err)
+	}
+	s.mu.Lock()
+	s.conn.Close()
+	s.mu.Unlock()
+	if resumeTimeout > 0 {
+		log.Printf("sender left, waiting %v for it to resume", resumeTimeout)
+		select {
+		case n := <-s.resumed:
+			s.mu.Lock()
+			s.conn = n.conn
+			s.mu.Unlock()
+			s.tconn, s.sess = n.tconn, n.sess
+			s.start()
+			log.Printf("resumed %v", s.sess)
+			return true
+		case <-time.After(resumeTimeout):
+			log.Printf("sender did not resume within %v", resumeTimeout)
+		}
+	}
+	sessionsMu.Lock()
+	if sessions[s.sess.ID] == s {
+		delete(sessions, s.sess.ID)
+	}
+	sessionsMu.Unlock()
+	select {
+	case n := <-s.resumed:
+		n.conn.Close() // too late, the sender starts a new stream
+	default:
+	}
+	return false
+}
+
+// exit the receiver once the streams logged the totals of their latency
+func exit(code int) {
+	exitOnce.Do(func() { close(exiting) })
+	reporters.Wait()
+	os.Exit(code)
+}
+
+// untilExit return a channel closed with stop, or on exiting
+func untilExit(stop <-chan struct{}) <-chan struct{} {
+	c := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+		case <-exiting:
+		}
+		close(c)
+	}()
+	return c
+}
+
+// acceptStream accept the session of a sender on conn
+func acceptStream(conn net.Conn, datagram bool) (*stream, error) {
+	tconn := transport.NewConn(conn)
+	if datagram {
+		tconn = transport.NewDatagramConn(conn)
+	}
+	sess, err := session.Accept(tconn, session.DefaultCapabilities, 0)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%v", sess)
+
+	s := &stream{
+		conn:     conn,
+		params:   sess.Params,
+		tconn:    tconn,
+		sess:     sess,
+		clock:    latency.NewClockEstimator(),
+		recorder: transport.NewFeedbackRecorder(),
+		resumed:  make(chan *stream, 1),
+		datagram: datagram,
+	}
+	s.read = s.readPackets
+	return s, nil
+}
+
+// start report the feedback and probe the clock of the sender on the conn
+func (s *stream) start() {
+	go sendFeedback(s.tconn, s.recorder)
+	go probeClock(s.tconn)
+}
+
+// readPackets read the packets until the sender leaves, the complete frames go to onFrame
+func (s *stream) readPackets(onFrame func(*transport.Frame)) {
+	reassembler := transport.NewReassembler()
+	for {
+		p, err := s.tconn.ReadPacket()
+		if err != nil {
+			if s.waitResume(err) {
+				continue
+			}
+			return
+		}
+		// every packet has a transport-wide seq, the feedback reports them all
+		arrived := time.Now()
+		s.recorder.OnPacket(p.Seq, arrived)
+		packetsReceived.Inc()
+		bytesReceived.Add(p.Size())
+		recvBitrate.Mark(p.Size())
+		if p.Type == transport.TypeSession {
+			m, err := session.Unmarshal(p.Payload)
+			if err != nil {
+				continue
+			}
+			if s.datagram && s.restart(m) {
+				reassembler = transport.NewReassembler()
+				s.recorder.Restart()
+				s.recorder.OnPacket(p.Seq, arrived)
+				if s.onRestart != nil {
+					s.onRestart()
+				}
+				continue
+			}
+			renegotiate(s.tconn, s.sess, m)
+			continue
+		}
+		if p.Type == transport.TypeClock {
+			if probe, err := latency.UnmarshalClockProbe(p.Payload); err == nil {
+				s.clock.AddProbe(probe, time.Now())
+			}
+			continue
+		}
+		if p.Type != transport.TypeMedia {
+			continue
+		}
+		lost := reassembler.Stats().LostFrames
+		if frame := reassembler.Push(p); frame != nil {
+			framesReceived.Inc()
+			framesLost.Add(int(reassembler.Stats().LostFrames - lost))
+			onFrame(frame)
+		}
+	}
+}
+
+func decodeH264Stream(s *stream) {
+	params, clock := s.params, s.clock
+
+	over := make(chan struct{})
+	codecHandler := codec.NewCodecHandler()
+	if err := codecHandler.InitAndOpenH264Decoder(); err != nil {
+		log.Fatalf("InitAndOpenH264Decoder error: %v", err)
+	}
+
+	go codecHandler.H264Decode()
+
+	decodeStats := latency.NewRecorder("capture-to-decode")
+	renderStats := latency.NewRecorder("capture-to-render")
+	recorders := []*latency.Recorder{decodeStats, renderStats}
+	var stamps *stampChecker
+	if checkStamps {
+		stamps = newStampChecker(clock)
+		recorders = append(recorders, stamps.latency)
+	}
+	stopReport := make(chan struct{})
+	reportDone := make(chan struct{})
+	reporters.Add(1)
+	go func() {
+		defer reporters.Done()
+		// the totals at the end of the stream, or before the receiver exits
+		latency.Report(log.Printf, 5*time.Second, untilExit(stopReport), recorders...)
+		if stamps != nil {
+			log.Printf("stream over: %v", stamps)
+		}
+		close(reportDone)
+	}()
+	go sampleCodec(codecHandler, stopReport)
+	defer func() {
+		close(stopReport)
+		<-reportDone
+	}()
+
+	s.onRestart = codecHandler.ResetH264Decoder
+	go func() {
+		defer func() {
+			over <- struct{}{}
+		}()
+		s.read(func(frame *transport.Frame) {
+			codecHandler.PushH264AccessUnit(frame.Data, frame.Timestamp)
+		})
+	}()
+
+	var (
+		window     = &sdl.Window{}
+		renderCtx  = &sdl.Renderer{}
+		textureCtx = &sdl.Texture{}
+	)
+
+	sdl.Do(func() {
+		err := sdl.Init(sdl.INIT_AUDIO | sdl.INIT_VIDEO | sdl.INIT_TIMER)
+		if err != nil {
+			log.Fatalf("sdl.Init error: %v", err)
+		}
+		window, renderCtx, err = sdl.CreateWindowAndRenderer(
+			int32(params.Width),
+			int32(params.Height),
+			sdl.WINDOW_SHOWN)
+		if err != nil {
+			log.Fatalf("sdl.CreateWindow error: %v", err)
+		}
+		window.SetTitle("Video From LFH")
+		textureCtx, err = renderCtx.CreateTexture(sdl.PIXELFORMAT_IYUV, sdl.TEXTUREACCESS_TARGET,
+			int32(params.Width), int32(params.Height))
+		if err != nil {
+			log.Fatalf("renderCtx.CreateTexture error: %v", err)
+		}
+		fmt.Println("sdl init successful")
+	})
+
+	go func() {
+		textureSize := image.Pt(params.Width, params.Height)
+		yuvImageQue := codecHandler.YUVImgRecQue()
+		for yuvImg := range yuvImageQue {
+			framesDecoded.Inc()
+			// the sender changes the resolution with the bandwidth, the window keeps its size
+			if size := yuvImg.Rect.Size(); size != textureSize {
+				textureSize = size
+				sdl.Do(func() {
+					textureCtx.Destroy()
+					var err error
+					textureCtx, err = renderCtx.CreateTexture(sdl.PIXELFORMAT_IYUV, sdl.TEXTUREACCESS_TARGET,
+						int32(size.X), int32(size.Y))
+					if err != nil {
+						log.Fatalf("renderCtx.CreateTexture error: %v", err)
+					}
+				})
+			}
+			if err := textureCtx.UpdateYUV(nil,
+				yuvImg.Y,
+				yuvImg.YStride,
+				yuvImg.Cb,
+				yuvImg.CStride,
+				yuvImg.Cr,
+				yuvImg.CStride,
+			); err != nil {
+				fmt.Printf("textureCtx.UpdateYUV error: %v\n", err)
+				return
+			}
+			if err := renderCtx.Copy(textureCtx, nil, nil); err != nil {
+				fmt.Printf("renderCtx.Copy error: %v\n", err)
+				continue
+			}
+			renderCtx.Present()
+			framesRendered.Inc()
+			if stamps != nil {
+				stamps.onPicture(yuvImg)
+			}
+			if !yuvImg.Timestamp.IsZero() {
+				captured := clock.ToLocal(yuvImg.Timestamp)
+				decodeStats.Add(yuvImg.DecodedAt.Sub(captured))
+				renderStats.Add(time.Since(captured))
+				decodeLatencySeconds.ObserveDuration(yuvImg.DecodedAt.Sub(captured))
+				renderLatencySeconds.ObserveDuration(time.Since(captured))
+			}
+			sdl.Delay(uint32(math.Floor(codec.PerFrameDelayOf30FPS)))
+		}
+	}()
+
+	closed := false
+	sdl.Do(func() {
+		defer func() {
+			window.Destroy()
+			textureCtx.Destroy()
+			renderCtx.Destroy()
+			sdl.Quit()
+		}()
+		poll := time.NewTicker(10 * time.Millisecond)
+		defer poll.Stop()
+		for {
+			for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+				switch event.(type) {
+				case *sdl.QuitEvent:
+					fmt.Println("Quit")
+					closed = true
+					return
+				}
+			}
+			select {
+			case <-over:
+				fmt.Println("over Quit")
+				return
+			case <-exiting:
+				return
+			case <-poll.C:
+			}
+		}
+	})
+	if closed {
+		exit(0)
+	}
+}
+
+// sendFeedback report the arrival of the packets to the sender for its congestion control
+func sendFeedback(tconn *transport.Conn, recorder *transport.FeedbackRecorder) {
+	ticker := time.NewTicker(transport.FeedbackInterval)
+	defer ticker.Stop()
+	var lost uint64
+	for range ticker.C {
+		fb := recorder.Build()
+		if fb == nil {
+			continue
+		}
+		if n := recorder.Stats().Lost; n > lost {
+			packetsLost.Add(int(n - lost))
+			lost = n
+		}
+		if _, err := tconn.WritePacket(transport.NewFeedbackPacket(fb)); err != nil {
+			log.Printf("write feedback error: %v", err)
+			return
+		}
+	}
+}
+
+// probeClock measure the offset to the clock of the client, for the latency across hosts
+func probeClock(tconn *transport.Conn) {
+	ticker := time.NewTicker(latency.ClockProbeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := tconn.WritePacket(latency.NewClockProbe(time.Now()).Packet()); err == transport.ErrNoPeer {
+			continue // the udp client has sent nothing yet
+		} else if err != nil {
+			log.Printf("write clock probe error: %v", err)
+			return
+		}
+	}
+}
+
+// restart answer the offer of a sender that started again on the conn of the stream, false
+// if it is not one or it was rejected
+func (s *stream) restart(offer *session.Message) bool {
+	old := s.sess.ID
+	answer := s.sess.Restart(offer, session.DefaultCapabilities)
+	if answer == nil || !writeAnswer(s.tconn, answer) || answer.Type != session.TypeAnswer {
+		return false
+	}
+	sessionsMu.Lock()
+	if sessions[old] == s {
+		delete(sessions, old)
+		sessions[s.sess.ID] = s
+	}
+	sessionsMu.Unlock()
+	log.Printf("sender restarted: %v", s.sess)
+	return true
+}
+
+// renegotiate answer an offer of the client during the session
+func renegotiate(tconn *transport.Conn, sess *session.Session, offer *session.Message) {
+	answer := sess.HandleOffer(offer, session.DefaultCapabilities)
+	if answer == nil || !writeAnswer(tconn, answer) {
+		return
+	}
+	switch {
+	case answer.Type != session.TypeAnswer:
+	case offer.Resume:
+		log.Printf("resumed %v", sess)
+	case offer.Round > 0:
+		log.Printf("renegotiated %v", sess.CurrentParams())
+	}
+}
+
+func writeAnswer(tconn *transport.Conn, answer *session.Message) bool {
+	pkt, err := answer.Packet()
+	if err != nil {
+		log.Printf("session answer error: %v", err)
+		return false
+	}
+	if _, err := tconn.WritePacket(pkt); err != nil {
+		log.Printf("write session answer error: %v", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package recv
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/latency"
+	"github.com/l-f-h/video/rtmp"
+	"github.com/l-f-h/video/session"
+	"github.com/l-f-h/video/transport"
+)
+
+// rtmpIngest take the streams published by RTMP encoders like OBS and ffmpeg, each is received
+// like the stream of a client
+func rtmpIngest() {
+	server := rtmp.NewServer(func(st *rtmp.Stream) {
+		s, err := rtmpStream(st)
+		if err != nil {
+			log.Printf("rtmp stream error: %v", err)
+			return
+		}
+		receive(s)
+	})
+	log.Fatalf("rtmp error: %v", server.ListenAndServe(listenAddr))
+}
+
+// rtmpStream wait the first key frame of a publish for the size of the stream, the capture
+// times are unknown
+func rtmpStream(st *rtmp.Stream) (*stream, error) {
+	var first *rtmp.Frame
+	params := session.Params{Codec: session.CodecH264, FPS: codec.DefaultEncoderParams.FPS}
+	for params.Width == 0 {
+		f, err := st.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		if !f.Key {
+			continue
+		}
+		for _, n := range h264.SplitAnnexB(f.Data) {
+			if h264.NALType(n) != h264.NALSPS {
+				continue
+			}
+			sps, err := h264.ParseSPS(n)
+			if err != nil {
+				return nil, fmt.Errorf("ParseSPS error: %v", err)
+			}
+			params.Width, params.Height = sps.Width, sps.Height
+		}
+		first = f
+	}
+	log.Printf("rtmp %s/%s %dx%d", st.App, st.Key, params.Width, params.Height)
+
+	s := &stream{
+		conn:   st,
+		params: params,
+		clock:  latency.NewClockEstimator(),
+	}
+	s.read = func(onFrame func(*transport.Frame)) {
+		f := first
+		for id := uint32(0); ; id++ {
+			framesReceived.Inc()
+			bytesReceived.Add(len(f.Data))
+			recvBitrate.Mark(len(f.Data))
+			onFrame(&transport.Frame{ID: id, KeyFrame: f.Key, Data: f.Data})
+			var err error
+			if f, err = st.ReadFrame(); err != nil {
+				return
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,80 @@
+package recv
+
+import (
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/latency"
+	"github.com/l-f-h/video/source"
+	"github.com/l-f-h/video/transport"
+)
+
+// stampChecker reads the number and the capture time burned in the pictures of a synthetic
+// sender: the frames the whole pipeline lost or repeated, and its latency to the picture
+type stampChecker struct {
+	clock   *latency.ClockEstimator
+	latency *latency.Recorder
+
+	mu      sync.Mutex
+	check   source.StampCheck
+	counted source.StampCheck // in the metrics
+}
+
+func newStampChecker(clock *latency.ClockEstimator) *stampChecker {
+	return &stampChecker{clock: clock, latency: latency.NewRecorder("capture-to-picture")}
+}
+
+func (c *stampChecker) onPicture(frame *codec.YUVFrame) {
+	stamp, ok := source.Detect(frame.YCbCr)
+	if ok {
+		d := time.Since(c.clock.ToLocal(stamp.Time))
+		c.latency.Add(d)
+		stampLatencySeconds.ObserveDuration(d)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.check.Add(stamp, ok)
+	stampsMissing.Add(int(c.check.Missing - c.counted.Missing))
+	stampsRepeated.Add(int(c.check.Repeated - c.counted.Repeated))
+	stampsReordered.Add(int(c.check.Reordered - c.counted.Reordered))
+	stampsUnreadable.Add(int(c.check.Unreadable - c.counted.Unreadable))
+	c.counted = c.check
+}
+
+func (c *stampChecker) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.check.String()
+}
+
+// teeSink writes the frames to every sink
+type teeSink []sink
+
+func (t teeSink) WriteFrame(f *transport.Frame) error {
+	for _, s := range t {
+		if err := s.WriteFrame(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t teeSink) Restart() {
+	for _, s := range t {
+		if r, ok := s.(restarter); ok {
+			r.Restart()
+		}
+	}
+}
+
+func (t teeSink) Close() error {
+	var err error
+	for _, s := range t {
+		if cerr := s.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
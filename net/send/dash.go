@@ -0,0 +1,120 @@
+package send
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l-f-h/video/cam"
+	"github.com/l-f-h/video/cc"
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/dash"
+)
+
+const (
+	dashQueue        = 1 << 3
+	dashBitsPerPixel = 0.09 // 2.5Mbit/s at 720p30
+)
+
+// dashOut encodes the camera pictures to the renditions of -dash-ladder and serves them with DASH,
+// a picture the encoders have no time for is lost for every rendition alike
+type dashOut struct {
+	addr     string
+	fps      int
+	heights  []int
+	pictures chan cam.Frame
+}
+
+// newDASHOut serve the renditions on addr at fps
+func newDASHOut(ladder, addr string, fps int) *dashOut {
+	o := &dashOut{addr: addr, fps: fps, pictures: make(chan cam.Frame, dashQueue)}
+	for _, s := range strings.Split(ladder, ",") {
+		height, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(s), "p"))
+		if err != nil || height <= 0 {
+			log.Fatalf("bad -dash-ladder %q", ladder)
+		}
+		o.heights = append(o.heights, height)
+	}
+	go o.run()
+	return o
+}
+
+func (o *dashOut) push(f cam.Frame) {
+	select {
+	case o.pictures <- f:
+	default:
+	}
+}
+
+// renditions fit the ladder to the size of the camera, the renditions larger than it are left out
+func (o *dashOut) renditions(width, height int) ([]codec.EncoderParams, []dash.Representation) {
+	var params []codec.EncoderParams
+	var reps []dash.Representation
+	var heights []int
+	for _, h := range o.heights {
+		if h <= height {
+			heights = append(heights, h)
+		}
+	}
+	if len(heights) == 0 {
+		heights = []int{height} // the camera is smaller than every rendition
+	}
+	fps := o.fps
+	for _, h := range heights {
+		w := (width*h/height + 1) &^ 1
+		p := codec.EncoderParams{
+			Width:   w,
+			Height:  h,
+			FPS:     fps,
+			Bitrate: int(float64(w*h*fps) * dashBitsPerPixel),
+			GOPSize: 2 * fps * int(dash.DefaultConfig.SegmentDuration/time.Second), // the forced key frames come first
+		}
+		params = append(params, p)
+		reps = append(reps, dash.Representation{
+			ID:        fmt.Sprintf("%dp", h),
+			Width:     p.Width,
+			Height:    p.Height,
+			FPS:       p.FPS,
+			Bandwidth: p.Bitrate,
+		})
+	}
+	return params, reps
+}
+
+// run start the ladder on the first picture, once its size is known
+func (o *dashOut) run() {
+	first := <-o.pictures
+	bounds := first.Image.Bounds()
+	params, reps := o.renditions(bounds.Dx(), bounds.Dy())
+	ladder, err := codec.NewLadder(params, dash.DefaultConfig.SegmentDuration)
+	if err != nil {
+		log.Fatalf("codec.NewLadder error: %v", err)
+	}
+	packager := dash.NewPackager(dash.DefaultConfig, reps)
+	mux := http.NewServeMux()
+	mux.Handle("/dash/", http.StripPrefix("/dash", packager))
+	go func() {
+		log.Fatalf("dash error: %v", http.ListenAndServe(o.addr, mux))
+	}()
+	log.Printf("dash ladder %v", reps)
+
+	go func() {
+		for f := range ladder.Frames() {
+			if err := packager.WriteFrame(f.Rendition, f.Data, f.Captured); err != nil {
+				log.Printf("dash error: %v", err)
+			}
+		}
+	}()
+	var limiter cc.FrameLimiter
+	for f := first; ; f = <-o.pictures {
+		if !limiter.Allow(f.Timestamp, o.fps) {
+			continue
+		}
+		if err := ladder.Encode(f.Image, f.Timestamp); err != nil {
+			log.Fatalf("ladder.Encode error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package send
+
+import (
+	"log"
+	"time"
+
+	"github.com/l-f-h/video/arq"
+	"github.com/l-f-h/video/metrics"
+)
+
+var (
+	framesCaptured = metrics.NewCounter("video_frames_total", "Frames by stage of the pipeline.", "stage", "captured")
+	framesSkipped  = metrics.NewCounter("video_frames_total", "Frames by stage of the pipeline.", "stage", "skipped")
+	framesDropped  = metrics.NewCounter("video_frames_total", "Frames by stage of the pipeline.", "stage", "dropped")
+	framesEncoded  = metrics.NewCounter("video_frames_total", "Frames by stage of the pipeline.", "stage", "encoded")
+	framesSent     = metrics.NewCounter("video_frames_total", "Frames by stage of the pipeline.", "stage", "sent")
+	packetsSent    = metrics.NewCounter("video_packets_sent_total", "Packets written to the connection.")
+	bytesSent      = metrics.NewCounter("video_bytes_sent_total", "Bytes written to the connection, with the packet headers.")
+	retransmits    = metrics.NewCounter("video_retransmits_total", "Packets sent again by the arq window, recovered on the receiver; 0 with the other protocols.", "side", "sender")
+	sendBitrate    = metrics.NewMeter("video_send_bitrate_bps", "Bitrate written to the connection over the last 2s.", 8)
+	targetBitrate  = metrics.NewGauge("video_target_bitrate_bps", "Target bitrate of the congestion control.")
+	encoderBitrate = metrics.NewGauge("video_encoder_bitrate_bps", "Bitrate asked to the encoder.")
+	packetLoss     = metrics.NewGauge("video_packet_loss_ratio", "Packet loss in the feedback of the server.")
+	rtt            = metrics.NewGauge("video_rtt_seconds", "Round trip time measured by the feedback.")
+	reconnects     = metrics.NewCounter("video_reconnects_total", "Connections to the server resumed after a loss.")
+	arqDropped     = metrics.NewCounter("video_arq_dropped_total", "Packets given up by the latency window of arq.", "side", "sender", "reason", "expired")
+	arqPeerDropped = metrics.NewCounter("video_arq_dropped_total", "Packets given up by the latency window of arq.", "side", "sender", "reason", "receiver")
+	encodeLatency  = metrics.NewHistogram("video_frame_latency_seconds", "Latency from the capture of the frame.",
+		metrics.LatencyBuckets, "stage", "encoded")
+
+	yuvImgQueueDepth     = metrics.NewGauge("video_queue_depth", "Length of the codec queues.", "queue", "yuv_img", "side", "sender")
+	h264PacketQueueDepth = metrics.NewGauge("video_queue_depth", "Length of the codec queues.", "queue", "h264_packet", "side", "sender")
+	rawDataQueueDepth    = metrics.NewGauge("video_queue_depth", "Length of the codec queues.", "queue", "raw_data", "side", "sender")
+)
+
+// sampleQueues update the depth of the codec queues every second until stop
+func sampleQueues(codecHandler interface {
+	QueueDepths() (yuvImg, h264Packet, rawData int)
+}, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		yuvImg, h264Packet, rawData := codecHandler.QueueDepths()
+		yuvImgQueueDepth.Set(float64(yuvImg))
+		h264PacketQueueDepth.Set(float64(h264Packet))
+		rawDataQueueDepth.Set(float64(rawData))
+	}
+}
+
+// sampleSource count the frames dropped by the queue of the source every second until stop, and
+// log the new drops
+func sampleSource(src interface{ Dropped() uint64 }, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last uint64
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		if n := src.Dropped(); n != last {
+			framesDropped.Add(int(n - last))
+			log.Printf("source: %d frames dropped for the encoder", n)
+			last = n
+		}
+	}
+}
+
+// sampleARQ count the retransmissions and the drops of the arq window every second, and log the
+// new drops
+func sampleARQ(conn *arq.Conn) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var last arq.Stats
+	for range ticker.C {
+		s := conn.Stats()
+		retransmits.Add(int(s.Retransmitted - last.Retransmitted))
+		arqDropped.Add(int(s.SendDropped - last.SendDropped))
+		arqPeerDropped.Add(int(s.PeerDropped - last.PeerDropped))
+		if s.SendDropped != last.SendDropped || s.PeerDropped != last.PeerDropped {
+			log.Printf("arq: %d packets given up by the sender, %d dropped by the server, rtt %v",
+				s.SendDropped, s.PeerDropped, s.RTT)
+		}
+		last = s
+	}
+}
@@ -0,0 +1,123 @@
+package send
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/arq"
+	"github.com/l-f-h/video/pacer"
+	"github.com/l-f-h/video/session"
+	"github.com/l-f-h/video/transport"
+)
+
+var (
+	reconnectMin = 100 * time.Millisecond
+	reconnectMax = 10 * time.Second
+)
+
+func newSender(dial func() (net.Conn, error), tconn *transport.Conn, sess *session.Session) *sender {
+	s := &sender{dial: dial, tconn: tconn, sess: sess}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// conn wait for the conn to the server
+func (s *sender) conn() *transport.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.tconn == nil {
+		s.cond.Wait()
+	}
+	return s.tconn
+}
+
+// send write the packet and count it for the congestion control, a conn that fails the write goes
+// to the reconnection and its error is returned; the packets are dropped while reconnecting
+func (s *sender) send(pkt *transport.Packet) error {
+	s.mu.Lock()
+	tconn := s.tconn
+	s.mu.Unlock()
+	if tconn == nil {
+		return nil
+	}
+	seq, err := tconn.WritePacket(pkt)
+	if err != nil {
+		err = fmt.Errorf("write error: %v", err)
+		s.lost(tconn, err)
+		return err
+	}
+	s.controller.OnPacketSent(seq, pkt.Size(), time.Now())
+	packetsSent.Inc()
+	bytesSent.Add(pkt.Size())
+	sendBitrate.Mark(pkt.Size())
+	if pkt.FragIdx == pkt.FragCnt-1 {
+		framesSent.Inc()
+	}
+	return nil
+}
+
+// lost close the conn after an error and reconnect, once per conn
+func (s *sender) lost(tconn *transport.Conn, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tconn != tconn {
+		return
+	}
+	log.Printf("connection lost: %v", err)
+	s.tconn = nil
+	tconn.Close()
+	go s.reconnect(tconn)
+}
+
+// reconnect dial again with an exponential backoff and resume the session, the server keeps
+// its window and decoder; the encoder starts again from a key frame
+func (s *sender) reconnect(prev *transport.Conn) {
+	backoff := reconnectMin
+	for attempt := 1; ; attempt++ {
+		tconn, err := s.resume(prev)
+		if err == nil {
+			s.paceRetransmits(tconn)
+			s.mu.Lock()
+			s.tconn = tconn
+			s.cond.Broadcast()
+			s.mu.Unlock()
+			s.codecHandler.ForceKeyFrame()
+			reconnects.Inc()
+			log.Printf("reconnected after %d attempts: %v", attempt, s.sess)
+			return
+		}
+		log.Printf("reconnect error: %v, again in %v", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > reconnectMax {
+			backoff = reconnectMax
+		}
+	}
+}
+
+func (s *sender) resume(prev *transport.Conn) (*transport.Conn, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	tconn := prev.Reconnect(conn)
+	if err := s.sess.Resume(tconn, session.DefaultTimeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tconn, nil
+}
+
+// paceRetransmits queue the retransmissions of an arq conn in the pacer ahead of the video, they
+// count against the pacing rate
+func (s *sender) paceRetransmits(tconn *transport.Conn) {
+	a, ok := tconn.NetConn().(*arq.Conn)
+	if !ok || s.paced == nil {
+		return
+	}
+	a.Pace(func(size int, send func() error) {
+		s.paced.EnqueueFunc(size, pacer.PriorityRetransmission, send)
+	})
+}
@@ -0,0 +1,49 @@
+package send
+
+import (
+	"log"
+	"time"
+
+	"github.com/l-f-h/video/rtmp"
+)
+
+const rtmpQueue = 1 << 6
+
+// rtmpOut publish the frames to the RTMP server of -rtmp, a slow server loses frames up to the
+// next key frame instead of holding back the transmission
+type rtmpOut struct {
+	frames  chan encodedFrame
+	waitKey bool
+}
+
+func newRTMPOut(url string) *rtmpOut {
+	p, err := rtmp.Publish(url)
+	if err != nil {
+		log.Fatalf("rtmp.Publish error: %v", err)
+	}
+	log.Printf("publishing to %s", url)
+	o := &rtmpOut{frames: make(chan encodedFrame, rtmpQueue), waitKey: true}
+	go func() {
+		for f := range o.frames {
+			if f.captured.IsZero() {
+				f.captured = time.Now()
+			}
+			if err := p.WriteFrame(f.data, f.captured); err != nil && err != rtmp.ErrNotStarted {
+				log.Fatalf("rtmp error: %v", err)
+			}
+		}
+	}()
+	return o
+}
+
+func (o *rtmpOut) push(f encodedFrame) {
+	if o.waitKey && !f.key {
+		return
+	}
+	select {
+	case o.frames <- f:
+		o.waitKey = false
+	default:
+		o.waitKey = true
+	}
+}
@@ -0,0 +1,735 @@
+// Package send is the sender of the video command: it encodes the camera, or forwards an rtsp://
+// source, and sends it to a receiver with the congestion control of the feedback.
+package send
+
+import (
+	"flag"
+	"fmt"
+	"github.com/l-f-h/rudp"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/l-f-h/video/arq"
+	"github.com/l-f-h/video/cam"
+	"github.com/l-f-h/video/cc"
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/hls"
+	"github.com/l-f-h/video/latency"
+	"github.com/l-f-h/video/metrics"
+	"github.com/l-f-h/video/net/config"
+	"github.com/l-f-h/video/netsim"
+	"github.com/l-f-h/video/pacer"
+	"github.com/l-f-h/video/quic"
+	"github.com/l-f-h/video/rtc"
+	"github.com/l-f-h/video/rtsp"
+	"github.com/l-f-h/video/secure"
+	"github.com/l-f-h/video/session"
+	"github.com/l-f-h/video/transport"
+	"github.com/l-f-h/video/ws"
+	"github.com/veandco/go-sdl2/sdl"
+	_ "net/http/pprof"
+)
+
+// options is the command line of the sender
+type options struct {
+	protocol   string
+	remoteAddr string
+	localAddr  string
+	encoding   codec.EncoderParams
+	maxBitrate int64
+	simProfile string
+	ccLog      string
+	pacing     float64
+	psk        string
+	keyFile    string
+	peerKey    string
+	rtspAddr   string
+	sourceURL  string
+	camera     cam.CameraConfig
+	rtspTCP    bool
+	rtmpURL    string
+	hlsAddr    string
+	hlsFMP4    bool
+	hlsPart    time.Duration
+	dashAddr   string
+	dashLadder string
+	httpAddr   string
+	ws         bool
+	webrtc     bool
+	webrtcIP   string
+	quicDgram  bool
+	arqLatency time.Duration
+}
+
+// Run the sender with the args of the command line
+func Run(args []string) {
+	o := &options{encoding: codec.DefaultEncoderParams}
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	fs.StringVar(&o.protocol, "p", "unknown", "udp/rudp/tcp/quic/arq")
+	fs.StringVar(&o.remoteAddr, "r", "127.0.0.1:8888", "address of the server, or of the netsim proxy")
+	fs.StringVar(&o.localAddr, "l", ":8899", "local address, the port is the one of the dnctl configs of netsimul.sh")
+	fs.IntVar(&o.encoding.Width, "width", o.encoding.Width, "width of the stream offered to the server, the congestion control scales it down")
+	fs.IntVar(&o.encoding.Height, "height", o.encoding.Height, "height of the stream offered to the server")
+	fs.IntVar(&o.encoding.FPS, "fps", o.encoding.FPS, "frame rate of the stream offered to the server")
+	fs.IntVar(&o.encoding.GOPSize, "gop", o.encoding.GOPSize, "frames between the key frames")
+	fs.Int64Var(&o.maxBitrate, "bitrate", cc.DefaultConfig.MaxBitrate, "largest bitrate of the encoder, bit/s")
+	fs.StringVar(&o.simProfile, "sim", "", "simulate a bad network in process for tcp/udp/quic/arq, pipe1/pipe2 or a dnctl config")
+	fs.StringVar(&o.ccLog, "cclog", "", "write the decisions of the congestion control to the csv file")
+	fs.Float64Var(&o.pacing, "pace", pacer.DefaultConfig.Multiplier, "pace the packets at this multiple of the target bitrate, 0 sends at once")
+	fs.StringVar(&o.psk, "psk", "", "encrypt with the pre-shared key")
+	fs.StringVar(&o.keyFile, "key", "", "encrypt with the X25519 private key file of keygen, needs -peer")
+	fs.StringVar(&o.peerKey, "peer", "", "pinned public key of the server, base64")
+	fs.StringVar(&o.sourceURL, "src", "", "send the camera at the url instead of the local one, an rtsp:// stream is sent without re-encoding; or synthetic, images:<glob>, a .y4m file, pipe:rgba, pipe:i420, pipe:y4m")
+	cam.Flags(fs, &o.camera)
+	fs.BoolVar(&o.rtspTCP, "rtsp-tcp", false, "pull the rtsp:// source with the RTP in its tcp connection")
+	fs.StringVar(&o.rtspAddr, "rtsp", "", "also serve the stream to RTSP players on the address, like :8554")
+	fs.StringVar(&o.rtmpURL, "rtmp", "", "also publish the stream to the RTMP server, rtmp://host/app/key")
+	fs.StringVar(&o.hlsAddr, "hls", "", "also serve the stream to browsers with HLS on the address, like :8080")
+	fs.BoolVar(&o.hlsFMP4, "hls-fmp4", false, "fragmented MP4 segments instead of MPEG-TS")
+	fs.DurationVar(&o.hlsPart, "hls-part", 0, "low latency HLS with parts of the duration, like 200ms")
+	fs.StringVar(&o.dashAddr, "dash", "", "also encode the camera to a ladder of renditions served with DASH on the address, like :8081")
+	fs.StringVar(&o.dashLadder, "dash-ladder", "1080,720,360", "heights of the DASH renditions, the ones above the camera are left out")
+	fs.StringVar(&o.httpAddr, "http", "localhost:10000", "address of the HTTP server of the metrics, pprof and the -ws and -webrtc viewers")
+	fs.BoolVar(&o.ws, "ws", false, "also stream to the websocket viewers at /ws of -http")
+	fs.BoolVar(&o.webrtc, "webrtc", false, "also answer the WebRTC offers at /webrtc of -http")
+	fs.StringVar(&o.webrtcIP, "webrtc-ip", "", "public ip of the WebRTC host candidates, behind a 1:1 NAT")
+	fs.DurationVar(&o.arqLatency, "latency", arq.DefaultConfig.Latency, "latency window of -p arq, the server uses the larger of both")
+	fs.BoolVar(&o.quicDgram, "quic-datagram", false, "send the media of -p quic as QUIC datagrams instead of a stream per frame")
+	if err := config.Parse(fs, args); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	dial, datagram := o.dialer()
+	metrics.Handle()
+	go func() {
+		log.Println(http.ListenAndServe(o.httpAddr, nil))
+	}()
+	sdl.Main(func() { transmit(o, dial, datagram) })
+}
+
+// dialer return the dial function of -p, the conns of a datagram dial keep the boundaries of the
+// records
+func (o *options) dialer() (dial func() (net.Conn, error), datagram bool) {
+	switch o.protocol {
+	case "tcp":
+		return o.tcp, false
+	case "udp":
+		return o.udp, true
+	case "rudp":
+		rudp.Debug()
+		return o.rUDP, false
+	case "quic":
+		if o.psk != "" || o.keyFile != "" {
+			log.Fatalf("-psk and -key are not supported with quic, it has TLS")
+		}
+		return o.dialQUIC, false
+	case "arq":
+		return o.arqUDP, true
+	}
+	log.Fatalf("protocol error")
+	return nil, false
+}
+
+// the dial functions of the protocols connect to the server, again on a reconnection
+
+func (o *options) tcp() (net.Conn, error) {
+	laddr, err := net.ResolveTCPAddr("tcp", o.localAddr)
+	if err != nil {
+		return nil, err
+	}
+	raddr, err := net.ResolveTCPAddr("tcp", o.remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTCP("tcp", laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return o.encrypt(o.simulate(conn, false), false)
+}
+
+func (o *options) udp() (net.Conn, error) {
+	conn, err := o.dialUDP()
+	if err != nil {
+		return nil, err
+	}
+	return o.encrypt(o.simulate(conn, true), true)
+}
+
+// arqUDP retransmit the lost packets over udp while they can arrive within the -latency window
+func (o *options) arqUDP() (net.Conn, error) {
+	conn, err := o.udp()
+	if err != nil {
+		return nil, err
+	}
+	aconn := arq.NewConn(conn, arq.Config{Latency: o.arqLatency})
+	go sampleARQ(aconn)
+	return aconn, nil
+}
+
+func (o *options) dialUDP() (*net.UDPConn, error) {
+	laddr, raddr, err := o.udpAddrs()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetWriteBuffer(65536); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (o *options) udpAddrs() (laddr, raddr *net.UDPAddr, err error) {
+	if laddr, err = net.ResolveUDPAddr("udp", o.localAddr); err != nil {
+		return nil, nil, err
+	}
+	raddr, err = net.ResolveUDPAddr("udp", o.remoteAddr)
+	return laddr, raddr, err
+}
+
+// rudp owns its socket, use the netsim proxy for it
+func (o *options) rUDP() (net.Conn, error) {
+	laddr, raddr, err := o.udpAddrs()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := rudp.DialRUDP(laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return o.encrypt(conn, false)
+}
+
+// dialQUIC send each frame on its own QUIC stream, or as datagrams with -quic-datagram
+func (o *options) dialQUIC() (net.Conn, error) {
+	laddr, raddr, err := o.udpAddrs()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	var pc net.PacketConn = conn
+	if o.simProfile != "" {
+		p, err := netsim.LookupProfile(o.simProfile)
+		if err != nil {
+			log.Fatalf("netsim.LookupProfile error: %v", err)
+		}
+		log.Printf("simulate network %v", p)
+		pc = netsim.NewPacketConn(conn, p)
+	}
+	cfg := quic.DefaultConfig
+	if o.quicDgram {
+		cfg.Mode = quic.ModeDatagram
+	}
+	qconn, err := quic.DialConn(pc, raddr, cfg)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return quicConn{qconn, pc}, nil
+}
+
+// quicConn close its socket with it, a reconnection binds the port again
+type quicConn struct {
+	*quic.Conn
+	pc net.PacketConn
+}
+
+func (c quicConn) Close() error {
+	err := c.Conn.Close()
+	c.pc.Close()
+	return err
+}
+
+// simulate wrap the conn with the impairment of -sim
+func (o *options) simulate(conn net.Conn, datagram bool) net.Conn {
+	if o.simProfile == "" {
+		return conn
+	}
+	p, err := netsim.LookupProfile(o.simProfile)
+	if err != nil {
+		log.Fatalf("netsim.LookupProfile error: %v", err)
+	}
+	log.Printf("simulate network %v", p)
+	return netsim.NewConn(conn, p, datagram)
+}
+
+// encrypt run the handshake of the security layer if -psk or -key is set, conn is closed if it
+// fails
+func (o *options) encrypt(conn net.Conn, datagram bool) (net.Conn, error) {
+	cfg, err := secure.NewConfig(o.psk, o.keyFile, o.peerKey, datagram)
+	if err != nil {
+		log.Fatalf("secure.NewConfig error: %v", err)
+	}
+	if cfg == nil {
+		return conn, nil
+	}
+	sconn, err := secure.Client(conn, *cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	log.Printf("encrypted to %v", conn.RemoteAddr())
+	return sconn, nil
+}
+
+const videoStreamID = 1
+
+// transmit the stream over the conns of dial, the first one must connect; the conns of a
+// datagram dial keep the boundaries of the records
+func transmit(o *options, dial func() (net.Conn, error), datagram bool) {
+	tconn := connect(dial, datagram)
+	offer := o.encoding
+	var pulled *rtsp.Client
+	var first rtsp.Frame
+	if strings.HasPrefix(o.sourceURL, "rtsp://") {
+		if o.dashAddr != "" {
+			log.Fatalf("-dash needs the pictures of a camera, not an rtsp source")
+		}
+		pulled, first, offer = o.pull()
+	}
+	sess := o.initiate(tconn, offer)
+
+	s := newSender(dial, tconn, sess)
+	s.encoding = o.encoding
+	ladder := cc.FitLadder(cc.DefaultLadder, sess.Params.Width, sess.Params.Height, sess.Params.FPS)
+	if pulled != nil {
+		// the camera encodes, its size stays
+		ladder = []cc.Rung{{Width: offer.Width, Height: offer.Height, FPS: offer.FPS}}
+	}
+	o.control(s, ladder)
+
+	var (
+		frames <-chan encodedFrame
+		stop   func()
+	)
+	if pulled != nil {
+		s.codecHandler = &passthrough{params: offer}
+		frames = pulledFrames(pulled, first)
+		stop = func() { pulled.Close() }
+	} else {
+		s.codecHandler, frames, stop = o.encode(s.encoderParams(s.adapter.Setting()))
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	o.pace(s, tconn)
+	go s.adapt()
+	go s.stream(frames, o.openOutputs(s.codecHandler))
+	<-ch
+	stop()
+	os.Exit(-1)
+}
+
+// connect dial the first conn, the sender can't start without it
+func connect(dial func() (net.Conn, error), datagram bool) *transport.Conn {
+	conn, err := dial()
+	if err != nil {
+		log.Fatalf("dial error: %v", err)
+	}
+	if datagram {
+		return transport.NewDatagramConn(conn)
+	}
+	return transport.NewConn(conn)
+}
+
+// initiate offer the stream to the server, up to the bitrate of -bitrate
+func (o *options) initiate(tconn *transport.Conn, offer codec.EncoderParams) *session.Session {
+	sess, err := session.Initiate(tconn, session.Params{
+		Codec:   session.CodecH264,
+		Width:   offer.Width,
+		Height:  offer.Height,
+		FPS:     offer.FPS,
+		Bitrate: o.maxBitrate,
+		Streams: []session.Stream{{ID: videoStreamID, Kind: "video"}},
+	}, session.DefaultTimeout)
+	if err != nil {
+		log.Fatalf("session.Initiate error: %v", err)
+	}
+	log.Printf("%v", sess)
+	return sess
+}
+
+// control give s the congestion control of its session, the adapter moves along the ladder
+func (o *options) control(s *sender, ladder []cc.Rung) {
+	// the congestion control stays within what the server agreed to
+	ccConfig := cc.DefaultConfig
+	if ccConfig.MaxBitrate > s.sess.Params.Bitrate {
+		ccConfig.MaxBitrate = s.sess.Params.Bitrate
+	}
+	if ccConfig.InitialBitrate > ccConfig.MaxBitrate {
+		ccConfig.InitialBitrate = ccConfig.MaxBitrate
+	}
+	s.controller = cc.NewController(ccConfig)
+	s.adapter = cc.NewAdapter(ladder, ccConfig.InitialBitrate)
+	if o.ccLog != "" {
+		f, err := os.Create(o.ccLog)
+		if err != nil {
+			log.Fatalf("os.Create error: %v", err)
+		}
+		s.logger = cc.NewDecisionLogger(f)
+	}
+}
+
+// encode the source of -src with the params, stop ends the source and the encoder
+func (o *options) encode(params codec.EncoderParams) (enc encoder, frames <-chan encodedFrame, stop func()) {
+	codecHandler := codec.NewCodecHandler()
+	if err := codecHandler.InitH264EncoderWithParams(params); err != nil {
+		log.Fatalf("InitH264Encoder err: %v", err)
+	}
+	src, err := o.openSource()
+	if err != nil {
+		log.Fatalf("openSource error: %v", err)
+	}
+	sdl.Do(src.Start)
+	sampling := make(chan struct{})
+	go sampleSource(src, sampling)
+	var dashOutput *dashOut
+	if o.dashAddr != "" {
+		dashOutput = newDASHOut(o.dashLadder, o.dashAddr, o.encoding.FPS)
+	}
+	go func() {
+		var limiter cc.FrameLimiter
+		for frame := range src.Frames() {
+			framesCaptured.Inc()
+			if dashOutput != nil {
+				dashOutput.push(frame)
+			}
+			if !limiter.Allow(time.Now(), codecHandler.H264EncoderParams().FPS) {
+				framesSkipped.Inc()
+				continue
+			}
+			if err := codecHandler.H264EncoderInputRGBImageAt(frame.Image, frame.Timestamp); err != nil {
+				log.Fatalf("H264EncoderInputRGBImage error: %v", err)
+			}
+		}
+		if err := src.Err(); err != nil {
+			log.Fatalf("source error: %v", err)
+		}
+		log.Printf("source over")
+	}()
+	go sampleQueues(codecHandler, sampling)
+
+	// the packets of the encoder are freed once copied
+	encoded := make(chan encodedFrame)
+	go func() {
+		defer close(encoded)
+		for p := range codecHandler.GetH264EncoderOutputPacketQueue() {
+			captured := codecHandler.EncodedPacketTimestamp(p)
+			framesEncoded.Inc()
+			if !captured.IsZero() {
+				encodeLatency.ObserveDuration(time.Since(captured))
+			}
+			f := encodedFrame{
+				data:     append([]byte(nil), codec.PacketData(p)...),
+				key:      codec.IsKeyPacket(p),
+				captured: captured,
+			}
+			p.AvPacketUnref()
+			p.AvFreePacket()
+			encoded <- f
+		}
+	}()
+	return codecHandler, encoded, func() {
+		close(sampling)
+		src.Stop()
+		codecHandler.Stop()
+	}
+}
+
+// pace put the pacer of -pace in front of the conns of s
+func (o *options) pace(s *sender, tconn *transport.Conn) {
+	if o.pacing <= 0 {
+		return
+	}
+	cfg := pacer.DefaultConfig
+	cfg.Multiplier = o.pacing
+	s.paced = pacer.New(cfg, s.controller.TargetBitrate(), s.send)
+	go func() {
+		// Run returns on a write error, its conn is already given to the reconnection
+		for {
+			if err := s.paced.Run(); err == pacer.ErrClosed {
+				return
+			}
+		}
+	}()
+	s.paceRetransmits(tconn)
+}
+
+// stream packetize the frames to the server and write them to the outputs, the rest of a frame
+// is dropped when its conn is lost, the encoder starts again from a key frame on the reconnection
+func (s *sender) stream(frames <-chan encodedFrame, out *outputs) {
+	packetizer := transport.NewPacketizer()
+	for f := range frames {
+		for _, pkt := range packetizer.Packetize(f.data, f.key, f.captured) {
+			if s.paced != nil {
+				s.paced.Enqueue(pkt, pacer.PriorityVideo)
+				continue
+			}
+			if err := s.send(pkt); err != nil {
+				break
+			}
+		}
+		out.write(f)
+	}
+}
+
+// outputs are the viewers served beside the server, the ones of the flags set
+type outputs struct {
+	rtsp *rtsp.Server
+	rtmp *rtmpOut
+	ws   *ws.Server
+	rtc  *rtc.Sender
+	hls  *hls.Packager
+}
+
+// openOutputs start the outputs of the flags, the key frame requests of the webrtc viewers go
+// to enc
+func (o *options) openOutputs(enc encoder) *outputs {
+	out := &outputs{}
+	if o.rtspAddr != "" {
+		out.rtsp = rtsp.NewServer()
+		go func() {
+			log.Fatalf("rtsp error: %v", out.rtsp.ListenAndServe(o.rtspAddr))
+		}()
+	}
+	if o.rtmpURL != "" {
+		out.rtmp = newRTMPOut(o.rtmpURL)
+	}
+	if o.ws {
+		out.ws = ws.NewServer()
+		http.Handle("/ws", out.ws)
+	}
+	if o.webrtc {
+		// the webrtc viewers post their offers next to the websocket viewers
+		cfg := rtc.Config{}
+		if o.webrtcIP != "" {
+			cfg.PublicIP = []string{o.webrtcIP}
+		}
+		rtcSender, err := rtc.NewSender(cfg)
+		if err != nil {
+			log.Fatalf("rtc.NewSender error: %v", err)
+		}
+		rtcSender.OnKeyFrameRequest = enc.ForceKeyFrame
+		http.Handle("/webrtc", rtcSender)
+		out.rtc = rtcSender
+	}
+	if o.hlsAddr != "" {
+		out.hls = o.newPackager()
+	}
+	return out
+}
+
+// write the frame to the outputs
+func (out *outputs) write(f encodedFrame) {
+	if out.rtsp != nil {
+		out.rtsp.WriteFrame(f.data, f.captured)
+	}
+	if out.rtmp != nil {
+		out.rtmp.push(f)
+	}
+	if out.ws != nil {
+		out.ws.WriteFrame(f.data, f.captured)
+	}
+	if out.rtc != nil {
+		out.rtc.WriteFrame(f.data, f.captured)
+	}
+	if out.hls != nil {
+		captured := f.captured
+		if captured.IsZero() {
+			captured = time.Now()
+		}
+		if err := out.hls.WriteFrame(f.data, captured); err != nil {
+			log.Printf("hls error: %v", err)
+		}
+	}
+}
+
+// newPackager serve the HLS of the stream at /hls/ of -hls
+func (o *options) newPackager() *hls.Packager {
+	cfg := hls.DefaultConfig
+	if o.hlsFMP4 {
+		cfg.Format = hls.FormatFMP4
+	}
+	cfg.PartDuration = o.hlsPart
+	packager := hls.NewPackager(cfg)
+	mux := http.NewServeMux()
+	mux.Handle("/hls/", http.StripPrefix("/hls", packager))
+	go func() {
+		log.Fatalf("hls error: %v", http.ListenAndServe(o.hlsAddr, mux))
+	}()
+	return packager
+}
+
+// encoder is the part of the codec handler driven by the congestion control and the viewers
+type encoder interface {
+	H264EncoderParams() codec.EncoderParams
+	ReconfigureH264Encoder(params codec.EncoderParams) error
+	SetH264EncoderBitrate(bitrate int)
+	ForceKeyFrame()
+}
+
+// sender is the state of the control loop of the client
+type sender struct {
+	dial  func() (net.Conn, error)
+	mu    sync.Mutex
+	cond  *sync.Cond      // of tconn
+	tconn *transport.Conn // nil while reconnecting
+
+	sess         *session.Session
+	controller   *cc.Controller
+	adapter      *cc.Adapter
+	paced        *pacer.Pacer
+	codecHandler encoder
+	logger       *cc.DecisionLogger
+	encoding     codec.EncoderParams // of the flags, the adapter sets the size, rate and bitrate
+
+	answer  <-chan session.Message // of the pending renegotiation
+	offered codec.EncoderParams
+}
+
+// adapt the encoder to the feedback of the server, answer its clock probes
+// and take the answers of the renegotiations
+func (s *sender) adapt() {
+	for {
+		tconn := s.conn()
+		p, err := tconn.ReadPacket()
+		if err != nil {
+			s.lost(tconn, fmt.Errorf("read feedback error: %v", err))
+			continue
+		}
+		if p.Type == transport.TypeClock {
+			received := time.Now()
+			probe, err := latency.UnmarshalClockProbe(p.Payload)
+			if err != nil {
+				log.Printf("UnmarshalClockProbe error: %v", err)
+				continue
+			}
+			probe.Answer(received, time.Now())
+			if _, err := tconn.WritePacket(probe.Packet()); err != nil {
+				s.lost(tconn, fmt.Errorf("write clock probe error: %v", err))
+			}
+			continue
+		}
+		if p.Type == transport.TypeSession {
+			if m, err := session.Unmarshal(p.Payload); err == nil {
+				s.sess.HandleAnswer(m)
+			}
+			s.takeAnswer()
+			continue
+		}
+		if p.Type != transport.TypeFeedback {
+			continue
+		}
+		fb, err := transport.UnmarshalFeedback(p.Payload)
+		if err != nil {
+			log.Printf("UnmarshalFeedback error: %v", err)
+			continue
+		}
+
+		now := time.Now()
+		d := s.controller.OnFeedback(fb, now)
+		targetBitrate.Set(float64(d.TargetBitrate))
+		packetLoss.Set(d.LossRate)
+		rtt.Set(d.RTT.Seconds())
+		if s.paced != nil {
+			s.paced.SetTargetBitrate(d.TargetBitrate)
+		}
+		setting, changed := s.adapter.Update(d.TargetBitrate, now)
+		encoderBitrate.Set(float64(setting.Bitrate))
+		if s.logger != nil {
+			if err := s.logger.Log(d, setting); err != nil {
+				log.Printf("cc log error: %v", err)
+			}
+		}
+		s.takeAnswer()
+		if !changed {
+			continue
+		}
+
+		cur := s.codecHandler.H264EncoderParams()
+		params := s.encoderParams(setting)
+		log.Printf("cc: %v, encoder %dx%d@%d %dkbps", d, params.Width, params.Height, params.FPS, params.Bitrate/1000)
+		if (params.Width != cur.Width || params.Height != cur.Height || params.FPS != cur.FPS) && s.answer == nil {
+			s.renegotiate(tconn, params)
+		}
+		// the size and rate wait for the answer, the bitrate not
+		s.codecHandler.SetH264EncoderBitrate(params.Bitrate)
+	}
+}
+
+// renegotiate offer the server a new resolution or frame rate, the encoder switches on its
+// answer
+func (s *sender) renegotiate(tconn *transport.Conn, params codec.EncoderParams) {
+	offer := s.sess.CurrentParams()
+	offer.Width, offer.Height, offer.FPS, offer.Bitrate = params.Width, params.Height, params.FPS, int64(params.Bitrate)
+	done, err := s.sess.Renegotiate(tconn, offer)
+	if err != nil {
+		log.Printf("Renegotiate error: %v", err)
+		s.rollback()
+		return
+	}
+	s.answer, s.offered = done, params
+}
+
+// takeAnswer reconfigure the encoder with the params the server agreed to, the adapter follows
+// a counter-offer and goes back to the encoder on a reject or a timeout
+func (s *sender) takeAnswer() {
+	var answer session.Message
+	var ok bool
+	select {
+	case answer, ok = <-s.answer:
+	default:
+		return // none pending, or no answer yet
+	}
+	s.answer = nil
+	params := s.offered
+	params.Bitrate = s.codecHandler.H264EncoderParams().Bitrate
+	switch {
+	case !ok:
+		log.Printf("renegotiation timeout")
+		s.rollback()
+		return
+	case answer.Type == session.TypeReject:
+		log.Printf("renegotiation rejected: %s", answer.Reason)
+		s.rollback()
+		return
+	case answer.Countered:
+		log.Printf("renegotiation countered: %v", answer.Params)
+		params.Width, params.Height, params.FPS = answer.Params.Width, answer.Params.Height, answer.Params.FPS
+		if b := int(answer.Params.Bitrate); b > 0 && b < params.Bitrate {
+			params.Bitrate = b
+		}
+		s.adapter.Counter(encoderSetting(params), time.Now())
+	}
+	if err := s.codecHandler.ReconfigureH264Encoder(params); err != nil {
+		log.Fatalf("ReconfigureH264Encoder error: %v", err)
+	}
+}
+
+// rollback put the adapter back on the setting of the encoder
+func (s *sender) rollback() {
+	s.adapter.Restore(encoderSetting(s.codecHandler.H264EncoderParams()), time.Now())
+}
+
+func (s *sender) encoderParams(set cc.EncoderSetting) codec.EncoderParams {
+	params := s.encoding
+	params.Width, params.Height, params.FPS, params.Bitrate = set.Width, set.Height, set.FPS, int(set.Bitrate)
+	return params
+}
+
+func encoderSetting(p codec.EncoderParams) cc.EncoderSetting {
+	return cc.EncoderSetting{Width: p.Width, Height: p.Height, FPS: p.FPS, Bitrate: int64(p.Bitrate)}
+}
@@ -0,0 +1,120 @@
+package send
+
+import (
+	"log"
+	"time"
+
+	"github.com/l-f-h/video/cam"
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/rtsp"
+	"github.com/l-f-h/video/source"
+)
+
+// encodedFrame is an access unit to transmit
+type encodedFrame struct {
+	data     []byte
+	key      bool
+	captured time.Time
+}
+
+// openSource open the source of -src, the local camera by default, at the size and frame rate
+// of the encoding
+func (o *options) openSource() (source.Source, error) {
+	cfg := o.camera
+	cfg.Width, cfg.Height, cfg.FPS = o.encoding.Width, o.encoding.Height, o.encoding.FPS
+	return cam.Open(o.sourceURL, cfg)
+}
+
+// pullTimeout is the time the rtsp source has to send a key frame with its SPS
+const pullTimeout = 10 * time.Second
+
+// pull play the rtsp source of -src, the offer of the session is the size of the SPS of its first
+// key frame; the frames before it are skipped
+func (o *options) pull() (*rtsp.Client, rtsp.Frame, codec.EncoderParams) {
+	client, err := rtsp.Dial(o.sourceURL, o.rtspTCP)
+	if err != nil {
+		log.Fatalf("rtsp.Dial error: %v", err)
+	}
+	timeout := time.After(pullTimeout)
+	for skipped := 0; ; skipped++ {
+		var first rtsp.Frame
+		var ok bool
+		select {
+		case first, ok = <-client.Frames():
+			if !ok {
+				log.Fatalf("rtsp source error: %v", client.Err())
+			}
+		case <-timeout:
+			log.Fatalf("rtsp source error: no key frame with an SPS in %v", pullTimeout)
+		}
+		sps, err := keySPS(first.Data)
+		if err != nil {
+			log.Fatalf("ParseSPS error: %v", err)
+		}
+		if sps == nil {
+			continue
+		}
+		params := o.encoding
+		params.Width, params.Height = sps.Width, sps.Height
+		log.Printf("rtsp source %s %dx%d, %d frames skipped", o.sourceURL, params.Width, params.Height, skipped)
+		return client, first, params
+	}
+}
+
+// keySPS return the SPS of an IDR access unit, nil for the other ones and an IDR without SPS
+func keySPS(au []byte) (*h264.SPS, error) {
+	var sps []byte
+	idr := false
+	for _, n := range h264.SplitAnnexB(au) {
+		switch h264.NALType(n) {
+		case h264.NALSPS:
+			sps = n
+		case h264.NALIDR:
+			idr = true
+		}
+	}
+	if !idr || sps == nil {
+		return nil, nil
+	}
+	return h264.ParseSPS(sps)
+}
+
+// pulledFrames take the access units of the rtsp source as they come, without decoding
+func pulledFrames(client *rtsp.Client, first rtsp.Frame) <-chan encodedFrame {
+	frames := make(chan encodedFrame)
+	go func() {
+		defer close(frames)
+		f := first
+		for {
+			framesCaptured.Inc()
+			framesEncoded.Inc()
+			frames <- encodedFrame{data: f.Data, key: f.Key, captured: f.Timestamp}
+			var ok bool
+			if f, ok = <-client.Frames(); !ok {
+				if err := client.Err(); err != nil {
+					log.Fatalf("rtsp source error: %v", err)
+				}
+				return
+			}
+		}
+	}()
+	return frames
+}
+
+// passthrough is the encoder of a source sent as it comes, the congestion control can't change it
+type passthrough struct {
+	params codec.EncoderParams
+}
+
+func (p *passthrough) H264EncoderParams() codec.EncoderParams {
+	return p.params
+}
+
+func (p *passthrough) ReconfigureH264Encoder(params codec.EncoderParams) error {
+	return nil
+}
+
+func (p *passthrough) SetH264EncoderBitrate(bitrate int) {}
+
+func (p *passthrough) ForceKeyFrame() {}
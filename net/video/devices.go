@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/l-f-h/video/cam"
+	"github.com/l-f-h/video/net/config"
+)
+
+// runDevices print the local cameras with the settings of their drivers
+func runDevices(args []string) {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	if err := config.Parse(fs, args); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	devices := cam.ListDevices()
+	if len(devices) == 0 {
+		log.Fatalf("no camera")
+	}
+	for _, d := range devices {
+		name := d.Path
+		if name == "" {
+			name = fmt.Sprint(d.Index)
+		}
+		fmt.Printf("%-12s %v\n", name, d.Caps)
+	}
+}
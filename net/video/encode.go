@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/l-f-h/video/cam"
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/net/config"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// encoderFlags add the settings of the encoder to fs
+func encoderFlags(fs *flag.FlagSet, params *codec.EncoderParams) {
+	fs.IntVar(&params.Width, "width", params.Width, "width of the encoding, the pictures are scaled to it")
+	fs.IntVar(&params.Height, "height", params.Height, "height of the encoding")
+	fs.IntVar(&params.FPS, "fps", params.FPS, "frame rate of the encoding")
+	fs.IntVar(&params.Bitrate, "bitrate", params.Bitrate, "bitrate of the encoding, bit/s, 0 for the default rate control")
+	fs.IntVar(&params.GOPSize, "gop", params.GOPSize, "frames between the key frames")
+}
+
+// runEncode encode the source to a file until interrupted, -t or its end
+func runEncode(args []string) {
+	var source, output string
+	var duration time.Duration
+	params := codec.DefaultEncoderParams
+	var camera cam.CameraConfig
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	fs.StringVar(&source, "src", "", "encode the camera at the url instead of the local one, or synthetic, images:<glob>, a .y4m file, pipe:rgba, pipe:i420, pipe:y4m")
+	fs.StringVar(&output, "o", "demo.h264", "output file, .h264 or .mp4")
+	fs.DurationVar(&duration, "t", 0, "stop after the duration, 0 encodes until interrupted")
+	encoderFlags(fs, &params)
+	cam.Flags(fs, &camera)
+	if err := config.Parse(fs, args); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	camera.Width, camera.Height, camera.FPS = params.Width, params.Height, params.FPS
+	sdl.Main(func() { encode(source, camera, output, duration, params) })
+}
+
+// open the source and encoding the video to h264
+func encode(source string, camera cam.CameraConfig, output string, duration time.Duration, params codec.EncoderParams) {
+	codecHandler := codec.NewCodecHandler()
+	if err := codecHandler.InitH264EncoderWithParams(params); err != nil {
+		log.Fatalf("InitH264Encoder err: %v", err)
+	}
+
+	src, err := cam.Open(source, camera)
+	if err != nil {
+		log.Fatalf("open source error: %v", err)
+	}
+
+	out, err := createOutput(output)
+	if err != nil {
+		log.Fatalf("create output error: %v", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	if duration > 0 {
+		time.AfterFunc(duration, func() { stop <- os.Interrupt })
+	}
+
+	sdl.Do(src.Start)
+	captured := make(chan struct{})
+	go func() {
+		defer close(captured)
+		for frame := range src.Frames() {
+			if err := codecHandler.H264EncoderInputRGBImageAt(frame.Image, frame.Timestamp); err != nil {
+				log.Fatalf("H264EncoderInputRGBImage error: %v", err)
+			}
+		}
+		if err := src.Err(); err != nil {
+			log.Printf("source error: %v", err)
+		}
+		if n := src.Dropped(); n > 0 {
+			log.Printf("source: %d frames dropped for the encoder", n)
+		}
+		// the end of a file or a pipe stops like an interrupt
+		select {
+		case stop <- os.Interrupt:
+		default:
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		frames := 0
+		for p := range codecHandler.GetH264EncoderOutputPacketQueue() {
+			err := out.WriteFrame(codec.PacketData(p), codecHandler.EncodedPacketTimestamp(p))
+			p.AvPacketUnref()
+			p.AvFreePacket()
+			if err != nil {
+				log.Fatalf("write file error: %v", err)
+			}
+			frames++
+		}
+		log.Printf("%d frames to %s", frames, output)
+	}()
+
+	<-stop
+	src.Stop()
+	<-captured
+	if err := codecHandler.FlushH264Encoder(); err != nil {
+		log.Printf("FlushH264Encoder error: %v", err)
+	}
+	codecHandler.Stop()
+	<-done
+	if err := out.Close(); err != nil {
+		log.Fatalf("close output error: %v", err)
+	}
+}
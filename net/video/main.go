@@ -0,0 +1,53 @@
+// Command video sends, receives and processes the streams, a subcommand per task:
+//
+//	video send -p udp -r 192.168.1.20:8888
+//	video recv -p udp
+//	video play -i demo.h264
+//	video encode -o demo.mp4 -t 10s
+//	video probe demo.h264
+//	video transcode -i demo.mp4 -o small.h264 -width 640 -height 360
+//	video relay -p udp -s :8890
+//	video devices
+//
+// Each takes its flags from the command line and from the file of -config, see the package
+// config.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/l-f-h/video/net/recv"
+	"github.com/l-f-h/video/net/send"
+)
+
+var commands = []struct {
+	name  string
+	usage string
+	run   func(args []string)
+}{
+	{"send", "encode the camera and send it to a receiver", send.Run},
+	{"recv", "receive a stream to a window or a file", recv.Run},
+	{"play", "play a video file in a window", runPlay},
+	{"encode", "encode the camera to an h264 or mp4 file", runEncode},
+	{"probe", "print the size and the frames of a video file or rtsp:// stream", runProbe},
+	{"transcode", "decode a video file and encode it again with other settings", runTranscode},
+	{"relay", "forward the stream of a sender to the receivers started with recv -sub", runRelay},
+	{"devices", "list the local cameras", runDevices},
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		for _, c := range commands {
+			if c.name == os.Args[1] {
+				c.run(os.Args[2:])
+				return
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "usage: video <command> [flags], video <command> -h for its flags\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+	os.Exit(2)
+}
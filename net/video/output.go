@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/l-f-h/video/mp4"
+)
+
+// frameWriter is the file of the access units of encode and transcode
+type frameWriter interface {
+	WriteFrame(au []byte, t time.Time) error
+	Close() error
+}
+
+// createOutput create the file, .mp4 or else raw h264
+func createOutput(name string) (frameWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(name) != ".mp4" {
+		return rawH264{f}, nil
+	}
+	w, err := mp4.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &mp4File{w, f}, nil
+}
+
+type rawH264 struct {
+	f *os.File
+}
+
+func (r rawH264) WriteFrame(au []byte, t time.Time) error {
+	_, err := r.f.Write(au)
+	return err
+}
+
+func (r rawH264) Close() error {
+	return r.f.Close()
+}
+
+type mp4File struct {
+	*mp4.Writer
+	f *os.File
+}
+
+func (m *mp4File) Close() error {
+	if err := m.Writer.Close(); err != nil {
+		m.f.Close()
+		return err
+	}
+	return m.f.Close()
+}
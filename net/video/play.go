@@ -1,92 +1,31 @@
 package main
 
-// test codec
-
 import (
+	"flag"
 	"fmt"
 	"log"
-	"math"
 	"os"
-	"os/signal"
-	"reflect"
-	"unsafe"
-
-	"github.com/l-f-h/video/cam"
 
 	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/net/config"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-const (
-	stand30FPS = (float64(1) / float64(30)) * 1000
-)
-
-func main() {
-	sdl.Main(videoDecode)
-}
-
-// open cam and encoding the video to h264
-func videoEncode() {
-	codecHandler := codec.NewCodecHandler()
-	if err := codecHandler.InitH264Encoder(); err != nil {
-		log.Fatalf("InitH264Encoder err: %v", err)
+// runPlay play a video file in a window, any format libav opens
+func runPlay(args []string) {
+	var input string
+	var fps int
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	fs.StringVar(&input, "i", "demo.h264", "video file")
+	fs.IntVar(&fps, "fps", 30, "frame rate of the playback")
+	if err := config.Parse(fs, args); err != nil {
+		log.Fatalf("config error: %v", err)
 	}
-
-	webcam, err := cam.NewWebCamWithLocalCam()
-	if err != nil {
-		log.Fatalf("NewWebCamWithLocalCam error: %v", err)
-	}
-
-	ch := make(chan os.Signal)
-	signal.Notify(ch, os.Interrupt, os.Kill)
-	go func() {
-		<-ch
-		webcam.Stop()
-		codecHandler.Stop()
-		os.Exit(-1)
-	}()
-
-	sdl.Do(webcam.Start)
-	go func() {
-		for frame := range webcam.FrameQueue() {
-			if err := codecHandler.H264EncoderInputRGBImage(frame); err != nil {
-				log.Fatalf("H264EncoderInputRGBImage error: %v", err)
-			}
-		}
-	}()
-
-	// output h264 file for testing
-	go func() {
-		f, err := os.Create("demo.h264")
-		if err != nil {
-			log.Fatalf("Create output file error: %v", err)
-		}
-		defer func() {
-			f.Sync()
-			f.Close()
-		}()
-		for p := range codecHandler.GetH264EncoderOutputPacketQueue() {
-			shd := reflect.SliceHeader{}
-			shd.Data = uintptr(unsafe.Pointer(p.Data()))
-			shd.Len = p.Size()
-			shd.Cap = p.Size()
-			data := *(*[]byte)(unsafe.Pointer(&shd))
-			_, err := f.Write(data)
-			//fmt.Println(len(data))
-			//encodedStr := hex.EncodeToString(data)
-			//fmt.Println(encodedStr)
-			if err != nil {
-				log.Fatalf("write file error: %v", err)
-			}
-		}
-	}()
-
-	<-ch
+	sdl.Main(func() { play(input, fps) })
 }
 
 // decode the video and play
-func videoDecode() {
-	fileName := "./demo.h264"
+func play(fileName string, fps int) {
 	codecHandler := codec.NewCodecHandler()
 	if err := codecHandler.InitFormatContextWithVideoURI(fileName); err != nil {
 		log.Fatalf("codecHandler.InitFormatContextWithVideoURI error: %v", err)
@@ -151,7 +90,7 @@ func videoDecode() {
 				continue
 			}
 			renderCtx.Present()
-			sdl.Delay(uint32(math.Floor(stand30FPS)))
+			sdl.Delay(uint32(1000 / fps))
 		}
 	}()
 
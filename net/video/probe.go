@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/net/config"
+	"github.com/l-f-h/video/rtsp"
+)
+
+// runProbe print what a file or an rtsp:// stream has
+func runProbe(args []string) {
+	var duration time.Duration
+	var rtspTCP bool
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	fs.DurationVar(&duration, "t", 5*time.Second, "time to measure an rtsp:// stream")
+	fs.BoolVar(&rtspTCP, "rtsp-tcp", false, "pull the rtsp:// stream with the RTP in its tcp connection")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: video probe [flags] <file or rtsp:// url>\n")
+		fs.PrintDefaults()
+	}
+	if err := config.Parse(fs, args); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return
+	}
+	input := fs.Arg(0)
+	switch ext := filepath.Ext(input); {
+	case strings.HasPrefix(input, "rtsp://"):
+		probeRTSP(input, rtspTCP, duration)
+	case ext == ".h264" || ext == ".264":
+		probeH264(input)
+	default:
+		probeFile(input)
+	}
+}
+
+// h264Stats count the NAL units of access units
+type h264Stats struct {
+	sps       *h264.SPS
+	frames    int
+	keyFrames int
+	bytes     int
+}
+
+func (s *h264Stats) add(data []byte) {
+	s.bytes += len(data)
+	for _, n := range h264.SplitAnnexB(data) {
+		switch h264.NALType(n) {
+		case h264.NALSPS:
+			if sps, err := h264.ParseSPS(n); err == nil && s.sps == nil {
+				s.sps = sps
+			}
+		case h264.NALIDR, h264.NALSlice:
+			// first_mb_in_slice 0 is the first slice of a frame
+			if len(n) > 1 && n[1]&0x80 != 0 {
+				s.frames++
+				if h264.NALType(n) == h264.NALIDR {
+					s.keyFrames++
+				}
+			}
+		}
+	}
+}
+
+func (s *h264Stats) String() string {
+	size := "no SPS"
+	if s.sps != nil {
+		size = fmt.Sprintf("%dx%d profile %d level %d", s.sps.Width, s.sps.Height, s.sps.Profile, s.sps.Level)
+	}
+	return fmt.Sprintf("h264 %s, %d frames, %d key frames, %d bytes", size, s.frames, s.keyFrames, s.bytes)
+}
+
+// probeH264 count the frames of an Annex B file without decoding it
+func probeH264(name string) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		log.Fatalf("read file error: %v", err)
+	}
+	s := &h264Stats{}
+	s.add(data)
+	fmt.Printf("%s: %v\n", name, s)
+}
+
+// probeFile open the file with libav for the size of its video
+func probeFile(name string) {
+	codecHandler := codec.NewCodecHandler()
+	if err := codecHandler.InitFormatContextWithVideoURI(name); err != nil {
+		log.Fatalf("InitFormatContextWithVideoURI error: %v", err)
+	}
+	if err := codecHandler.FindVideoStream(); err != nil {
+		log.Fatalf("FindVideoStream error: %v", err)
+	}
+	if err := codecHandler.InitAndOpenVideoDecoder(); err != nil {
+		log.Fatalf("InitAndOpenVideoDecoder error: %v", err)
+	}
+	fmt.Printf("%s: video %dx%d\n", name, codecHandler.GetVideoWidth(), codecHandler.GetVideoHeight())
+}
+
+// probeRTSP play the stream for the duration, then print its frame rate and bitrate
+func probeRTSP(url string, tcp bool, duration time.Duration) {
+	client, err := rtsp.Dial(url, tcp)
+	if err != nil {
+		log.Fatalf("rtsp.Dial error: %v", err)
+	}
+	defer client.Close()
+	s := &h264Stats{}
+	timeout := time.After(duration)
+	start := time.Now()
+loop:
+	for {
+		select {
+		case f, ok := <-client.Frames():
+			if !ok {
+				if err := client.Err(); err != nil {
+					log.Fatalf("rtsp source error: %v", err)
+				}
+				break loop
+			}
+			s.add(f.Data)
+		case <-timeout:
+			break loop
+		}
+	}
+	elapsed := time.Since(start).Seconds()
+	fmt.Printf("%s: %v, %.1f fps, %.0f kbit/s, %d dropped\n", url, s,
+		float64(s.frames)/elapsed, float64(s.bytes)*8/1000/elapsed, client.Dropped())
+}
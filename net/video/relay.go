@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+
+	"github.com/l-f-h/rudp"
+	"github.com/l-f-h/video/metrics"
+	"github.com/l-f-h/video/net/config"
+	"github.com/l-f-h/video/relay"
+	"github.com/l-f-h/video/session"
+	"github.com/l-f-h/video/transport"
+)
+
+// runRelay forward the stream of one sender to many receivers started with -sub, without decoding it
+func runRelay(args []string) {
+	var protocol, listenAddr, subAddr, httpAddr string
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	fs.StringVar(&protocol, "p", "unknown", "udp/rudp/tcp of the publisher")
+	fs.StringVar(&listenAddr, "l", ":8888", "listen address of the publisher")
+	fs.StringVar(&subAddr, "s", ":8890", "tcp address of the subscribers")
+	fs.StringVar(&httpAddr, "http", "localhost:9998", "address of the HTTP server of the metrics and pprof")
+	if err := config.Parse(fs, args); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+
+	r := relay.New(session.DefaultCapabilities)
+	metrics.NewGaugeFunc("video_relay_subscribers", "Subscribers of the relay.", func() float64 {
+		return float64(len(r.Stats()))
+	})
+	metrics.NewCounterFunc("video_relay_frames_total", "Frames to the subscribers.", func() float64 {
+		return float64(r.Totals().Sent)
+	}, "state", "sent")
+	metrics.NewCounterFunc("video_relay_frames_total", "Frames to the subscribers.", func() float64 {
+		return float64(r.Totals().Dropped)
+	}, "state", "dropped")
+	metrics.Handle()
+	go func() {
+		log.Println(http.ListenAndServe(httpAddr, nil))
+	}()
+
+	go subscribers(r, subAddr)
+	switch protocol {
+	case "tcp":
+		relayTCP(r, listenAddr)
+	case "udp":
+		relayUDP(r, listenAddr)
+	case "rudp":
+		relayRUDP(r, listenAddr)
+	default:
+		log.Fatalf("protocol error")
+	}
+}
+
+func subscribers(r *relay.Relay, addr string) {
+	listen, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("net.Listen tcp error: %v", err)
+	}
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			log.Fatalf("listen.Accept error: %v", err)
+		}
+		go func(c net.Conn) {
+			if err := r.Subscribe(transport.NewConn(c)); err != nil {
+				log.Printf("subscriber %v: %v", c.RemoteAddr(), err)
+			}
+		}(conn)
+	}
+}
+
+func relayTCP(r *relay.Relay, addr string) {
+	listen, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("net.Listen tcp error: %v", err)
+	}
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			log.Fatalf("listen.Accept error: %v", err)
+		}
+		go func(c net.Conn) {
+			publish(r, transport.NewConn(c))
+			c.Close()
+		}(conn)
+	}
+}
+
+// udp has one socket, the publishers take turns on it
+func relayUDP(r *relay.Relay, addr string) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Fatalf("net.ResolveUDPAddr error: %v", err)
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		log.Fatalf("net.Listen udp error: %v", err)
+	}
+	for {
+		// a bad or lost datagram is skipped, it does not end the publish
+		publish(r, transport.NewDatagramConn(transport.NewPeerConn(conn)))
+	}
+}
+
+func relayRUDP(r *relay.Relay, addr string) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		log.Fatalf("net.ResolveUDPAddr error: %v", err)
+	}
+	listener, err := rudp.ListenRUDP(laddr)
+	if err != nil {
+		log.Fatalf("rudp.ListenRUDP error: %v", err)
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("listener.Accept error: %v", err)
+		}
+		go func(c net.Conn) {
+			publish(r, transport.NewConn(c))
+			c.Close()
+		}(conn)
+	}
+}
+
+func publish(r *relay.Relay, tconn *transport.Conn) {
+	if err := r.Publish(tconn); err != nil {
+		log.Printf("publisher %v: %v", tconn.NetConn().RemoteAddr(), err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/l-f-h/video/codec"
+	"github.com/l-f-h/video/net/config"
+)
+
+// runTranscode decode a file and encode it again with the settings of the flags, or only decode
+// it to a .y4m
+func runTranscode(args []string) {
+	var input, output string
+	params := codec.DefaultEncoderParams
+	params.Width, params.Height = 0, 0
+	fs := flag.NewFlagSet("transcode", flag.ExitOnError)
+	fs.StringVar(&input, "i", "", "input file, any format libav opens")
+	fs.StringVar(&output, "o", "out.h264", "output file, .h264 or .mp4, or .y4m for the decoded frames as they are")
+	encoderFlags(fs, &params)
+	if err := config.Parse(fs, args); err != nil {
+		log.Fatalf("config error: %v", err)
+	}
+	if input == "" {
+		log.Fatalf("transcode needs an input file, -i")
+	}
+	transcode(input, output, params)
+}
+
+// transcode the frames in order, their times are the frame rate of params from now
+func transcode(input, output string, params codec.EncoderParams) {
+	decoder := codec.NewCodecHandler()
+	if err := decoder.InitFormatContextWithVideoURI(input); err != nil {
+		log.Fatalf("InitFormatContextWithVideoURI error: %v", err)
+	}
+	if err := decoder.FindVideoStream(); err != nil {
+		log.Fatalf("FindVideoStream error: %v", err)
+	}
+	if err := decoder.InitAndOpenVideoDecoder(); err != nil {
+		log.Fatalf("InitAndOpenVideoDecoder error: %v", err)
+	}
+	if strings.HasSuffix(output, ".y4m") {
+		decodeY4M(decoder, output, params.FPS)
+		return
+	}
+	// the size of the input unless set
+	if params.Width == 0 || params.Height == 0 {
+		params.Width, params.Height = int(decoder.GetVideoWidth()), int(decoder.GetVideoHeight())
+	}
+
+	encoder := codec.NewCodecHandler()
+	if err := encoder.InitH264EncoderWithParams(params); err != nil {
+		log.Fatalf("InitH264Encoder error: %v", err)
+	}
+	out, err := createOutput(output)
+	if err != nil {
+		log.Fatalf("create output error: %v", err)
+	}
+	log.Printf("transcode %s to %s %dx%d@%d", input, output, params.Width, params.Height, params.FPS)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for p := range encoder.GetH264EncoderOutputPacketQueue() {
+			err := out.WriteFrame(codec.PacketData(p), encoder.EncodedPacketTimestamp(p))
+			p.AvPacketUnref()
+			p.AvFreePacket()
+			if err != nil {
+				log.Fatalf("write file error: %v", err)
+			}
+		}
+	}()
+
+	decoder.DecoderRun()
+	start, n := time.Now(), 0
+	for img := range decoder.YUVImgRecQue() {
+		t := start.Add(time.Duration(n) * time.Second / time.Duration(params.FPS))
+		if err := encoder.H264EncoderInputRGBImageAt(img.YCbCr, t); err != nil {
+			log.Fatalf("H264EncoderInputRGBImage error: %v", err)
+		}
+		n++
+	}
+	if err := encoder.FlushH264Encoder(); err != nil {
+		log.Printf("FlushH264Encoder error: %v", err)
+	}
+	encoder.Stop()
+	<-done
+	if err := out.Close(); err != nil {
+		log.Fatalf("close output error: %v", err)
+	}
+	log.Printf("%d frames in %v", n, time.Since(start))
+}
+
+// decodeY4M write the decoded frames without scaling, to diff the decoder to a reference
+func decodeY4M(decoder interface {
+	DecoderRun()
+	YUVImgRecQue() <-chan *codec.YUVFrame
+}, output string, fps int) {
+	f, err := os.Create(output)
+	if err != nil {
+		log.Fatalf("create output error: %v", err)
+	}
+	w := codec.NewY4MWriter(f, fps)
+	decoder.DecoderRun()
+	n := 0
+	for img := range decoder.YUVImgRecQue() {
+		if err := w.WriteFrame(img.YCbCr); err != nil {
+			log.Fatalf("write file error: %v", err)
+		}
+		n++
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("write file error: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("close output error: %v", err)
+	}
+	log.Printf("%d frames to %s", n, output)
+}
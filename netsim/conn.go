@@ -0,0 +1,103 @@
+package netsim
+
+import (
+	"net"
+	"sync"
+)
+
+const (
+	tcpMSS = 1448 // segment size of the stream mode
+)
+
+// Conn impairs the writes of a net.Conn.
+// In datagram mode (udp, or any conn that keeps the message boundary) each Write is a packet
+// that can be lost, reordered or duplicated. In stream mode (tcp) the bytes are cut to segments
+// which are delayed by the bandwidth and the retransmission of lost segments, but never lost.
+type Conn struct {
+	net.Conn
+	link     *Link
+	datagram bool
+
+	mu       sync.Mutex
+	writeErr error
+}
+
+func NewConn(c net.Conn, p Profile, datagram bool) *Conn {
+	return &Conn{
+		Conn:     c,
+		link:     NewLink(p),
+		datagram: datagram,
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.err(); err != nil {
+		return 0, err
+	}
+	if c.datagram {
+		c.link.Send(b, c.deliver)
+		return len(b), nil
+	}
+	for off := 0; off < len(b); off += tcpMSS {
+		end := off + tcpMSS
+		if end > len(b) {
+			end = len(b)
+		}
+		c.link.SendReliable(b[off:end], c.deliver)
+	}
+	return len(b), c.err()
+}
+
+func (c *Conn) deliver(b []byte) {
+	if _, err := c.Conn.Write(b); err != nil {
+		c.mu.Lock()
+		if c.writeErr == nil {
+			c.writeErr = err
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *Conn) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeErr
+}
+
+func (c *Conn) Link() *Link {
+	return c.link
+}
+
+func (c *Conn) Close() error {
+	c.link.Close()
+	return c.Conn.Close()
+}
+
+// PacketConn impairs the WriteTo of a net.PacketConn
+type PacketConn struct {
+	net.PacketConn
+	link *Link
+}
+
+func NewPacketConn(pc net.PacketConn, p Profile) *PacketConn {
+	return &PacketConn{
+		PacketConn: pc,
+		link:       NewLink(p),
+	}
+}
+
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.link.Send(b, func(data []byte) {
+		c.PacketConn.WriteTo(data, addr)
+	})
+	return len(b), nil
+}
+
+func (c *PacketConn) Link() *Link {
+	return c.link
+}
+
+func (c *PacketConn) Close() error {
+	c.link.Close()
+	return c.PacketConn.Close()
+}
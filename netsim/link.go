@@ -0,0 +1,284 @@
+package netsim
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	minRTO        = 200 * time.Millisecond // linux TCP_RTO_MIN
+	reorderExtra  = 5 * time.Millisecond   // a reordered packet arrives this late after its successor
+	backoffPeriod = time.Millisecond
+)
+
+// Stats is the counter of a link
+type Stats struct {
+	Packets    uint64 // packets entered the link
+	Bytes      uint64
+	Lost       uint64 // dropped by random or bursty loss
+	QueueDrops uint64 // dropped by the full bottleneck queue
+	Retrans    uint64 // lost packets of reliable sends, delivered after a RTO
+	Reordered  uint64
+	Duplicated uint64
+}
+
+type delivery struct {
+	at      time.Time
+	seq     uint64
+	data    []byte
+	deliver func([]byte)
+}
+
+type deliveryHeap []*delivery
+
+func (h deliveryHeap) Len() int { return len(h) }
+func (h deliveryHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h deliveryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *deliveryHeap) Push(x interface{}) { *h = append(*h, x.(*delivery)) }
+func (h *deliveryHeap) Pop() interface{} {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
+
+// Link is one direction of a simulated pipe: a bottleneck queue with the bandwidth of the profile,
+// followed by the propagation delay, loss, reordering and duplication.
+type Link struct {
+	profile Profile
+
+	mu          sync.Mutex
+	rnd         *rand.Rand
+	bad         bool        // state of the gilbert elliott model
+	departures  []time.Time // departure time of the packets in the bottleneck queue
+	busy        time.Time   // time the bottleneck finishes the queued packets
+	lastArrival time.Time
+	seq         uint64
+	pending     deliveryHeap
+	wakeup      chan struct{}
+	closed      chan struct{}
+	closeOnce   sync.Once
+
+	stats Stats
+}
+
+func NewLink(p Profile) *Link {
+	if p.QueueSize <= 0 {
+		p.QueueSize = DefaultQueueSize
+	}
+	seed := p.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	l := &Link{
+		profile: p,
+		rnd:     rand.New(rand.NewSource(seed)),
+		wakeup:  make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *Link) Profile() Profile {
+	return l.profile
+}
+
+// Send push a datagram into the link, deliver is called with a copy of b when it arrives.
+// return false if the packet is lost or dropped by the queue
+func (l *Link) Send(b []byte, deliver func([]byte)) bool {
+	return l.send(b, deliver, false)
+}
+
+// SendReliable models a segment of a stream protocol like TCP: a lost segment arrives after a
+// retransmission timeout and blocks the segments behind it, a full queue blocks the sender.
+func (l *Link) SendReliable(b []byte, deliver func([]byte)) {
+	for {
+		l.mu.Lock()
+		full := l.queueLen(time.Now()) >= l.profile.QueueSize
+		l.mu.Unlock()
+		if !full {
+			break
+		}
+		select {
+		case <-l.closed:
+			return
+		case <-time.After(backoffPeriod):
+		}
+	}
+	l.send(b, deliver, true)
+}
+
+func (l *Link) send(b []byte, deliver func([]byte), reliable bool) bool {
+	data := make([]byte, len(b))
+	copy(data, b)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.Packets++
+	l.stats.Bytes += uint64(len(b))
+
+	if !reliable && l.queueLen(now) >= l.profile.QueueSize {
+		l.stats.QueueDrops++
+		return false
+	}
+
+	// serialization at the bottleneck
+	start := now
+	if l.busy.After(start) {
+		start = l.busy
+	}
+	departure := start
+	if l.profile.Bandwidth > 0 {
+		departure = start.Add(time.Duration(int64(len(b)) * 8 * int64(time.Second) / l.profile.Bandwidth))
+	}
+	l.busy = departure
+	l.departures = append(l.departures, departure)
+
+	arrival := departure.Add(l.profile.Delay)
+	if j := l.profile.Jitter; j > 0 {
+		arrival = arrival.Add(time.Duration(l.rnd.Int63n(int64(2*j)+1)) - j)
+		if arrival.Before(departure) {
+			arrival = departure
+		}
+	}
+
+	if l.lost() {
+		if !reliable {
+			l.stats.Lost++
+			return false
+		}
+		// the sender finds the loss after a RTO and retransmits
+		rto := 2 * 2 * l.profile.Delay
+		if rto < minRTO {
+			rto = minRTO
+		}
+		arrival = arrival.Add(rto)
+		l.stats.Retrans++
+	}
+
+	if !reliable && l.profile.Reorder > 0 && l.rnd.Float64() < l.profile.Reorder {
+		// hold it back, the next packet overtakes it
+		arrival = l.maxArrival(arrival).Add(reorderExtra + l.profile.Jitter)
+		l.stats.Reordered++
+	} else {
+		// packets of a pipe keep their order
+		arrival = l.maxArrival(arrival)
+		l.lastArrival = arrival
+	}
+	l.schedule(arrival, data, deliver)
+
+	if !reliable && l.profile.Duplicate > 0 && l.rnd.Float64() < l.profile.Duplicate {
+		dup := make([]byte, len(data))
+		copy(dup, data)
+		l.schedule(arrival, dup, deliver)
+		l.stats.Duplicated++
+	}
+	return true
+}
+
+func (l *Link) maxArrival(t time.Time) time.Time {
+	if l.lastArrival.After(t) {
+		return l.lastArrival
+	}
+	return t
+}
+
+// lost decide the fate of a packet, must hold l.mu
+func (l *Link) lost() bool {
+	if ge := l.profile.Burst; ge != nil {
+		if l.bad {
+			if l.rnd.Float64() < ge.R {
+				l.bad = false
+			}
+		} else if l.rnd.Float64() < ge.P {
+			l.bad = true
+		}
+		rate := ge.GoodLoss
+		if l.bad {
+			rate = ge.BadLoss
+		}
+		return l.rnd.Float64() < rate
+	}
+	return l.profile.Loss > 0 && l.rnd.Float64() < l.profile.Loss
+}
+
+// queueLen count the packets still waiting for the bottleneck, must hold l.mu
+func (l *Link) queueLen(now time.Time) int {
+	i := 0
+	for i < len(l.departures) && !l.departures[i].After(now) {
+		i++
+	}
+	l.departures = l.departures[i:]
+	return len(l.departures)
+}
+
+func (l *Link) schedule(at time.Time, data []byte, deliver func([]byte)) {
+	l.seq++
+	heap.Push(&l.pending, &delivery{at: at, seq: l.seq, data: data, deliver: deliver})
+	select {
+	case l.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (l *Link) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		l.mu.Lock()
+		var ready []*delivery
+		now := time.Now()
+		for l.pending.Len() > 0 && !l.pending[0].at.After(now) {
+			ready = append(ready, heap.Pop(&l.pending).(*delivery))
+		}
+		wait := time.Hour
+		if l.pending.Len() > 0 {
+			wait = l.pending[0].at.Sub(now)
+		}
+		l.mu.Unlock()
+
+		for _, d := range ready {
+			d.deliver(d.data)
+		}
+		if len(ready) > 0 {
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-l.closed:
+			return
+		case <-l.wakeup:
+		case <-timer.C:
+		}
+	}
+}
+
+// Stats return a snapshot of the counters
+func (l *Link) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// Close drop the packets in flight
+func (l *Link) Close() {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+}
@@ -0,0 +1,188 @@
+package netsim
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseProfile(t *testing.T) {
+	p, err := ParseProfile("bw 20Mbit/s delay 50 plr 0.05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Bandwidth != Pipe1.Bandwidth || p.Delay != Pipe1.Delay || p.Loss != Pipe1.Loss {
+		t.Fatalf("parsed %v, want %v", p, Pipe1)
+	}
+
+	p, err = ParseProfile("bw 512Kbit/s jitter 10 gilbert 0.01 0.3 reorder 0.02 dup 0.01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Bandwidth != 512000 || p.Jitter != 10*time.Millisecond || p.Reorder != 0.02 || p.Duplicate != 0.01 {
+		t.Fatalf("parsed %v", p)
+	}
+	if p.Burst == nil || p.Burst.P != 0.01 || p.Burst.R != 0.3 || p.Burst.BadLoss != 1 {
+		t.Fatalf("parsed gilbert %+v", p.Burst)
+	}
+
+	if _, err := ParseProfile("bw 10Mbit/s plr 2"); err == nil {
+		t.Fatal("expect error of plr out of range")
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	script := `#!/usr/bin/env bash
+sudo dnctl pipe 1 config bw 20Mbit/s delay 50 plr 0.05 # bad
+sudo dnctl pipe 2 config bw 10Mbit/s delay 50 plr 0.10 # very bad
+`
+	file := filepath.Join(t.TempDir(), "netsimul.sh")
+	if err := os.WriteFile(file, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := LoadProfiles(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []Profile{Pipe1, Pipe2} {
+		got, ok := profiles[want.Name]
+		if !ok {
+			t.Fatalf("%s not loaded", want.Name)
+		}
+		if got.Bandwidth != want.Bandwidth || got.Delay != want.Delay || got.Loss != want.Loss {
+			t.Fatalf("loaded %v, want %v", got, want)
+		}
+	}
+}
+
+func sendPackets(t *testing.T, l *Link, n, size int, interval time.Duration) (received int, elapsed time.Duration) {
+	var (
+		mu   sync.Mutex
+		last time.Time
+	)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		l.Send(make([]byte, size), func([]byte) {
+			mu.Lock()
+			received++
+			last = time.Now()
+			mu.Unlock()
+		})
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+	time.Sleep(l.Profile().Delay + 200*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	return received, last.Sub(start)
+}
+
+func TestLinkLoss(t *testing.T) {
+	l := NewLink(Profile{Loss: 0.1, QueueSize: 10000, Seed: 42})
+	defer l.Close()
+	received, _ := sendPackets(t, l, 5000, 100, 0)
+	loss := 1 - float64(received)/5000
+	if loss < 0.08 || loss > 0.12 {
+		t.Fatalf("loss rate %.3f, want about 0.1", loss)
+	}
+}
+
+func TestLinkBurstLoss(t *testing.T) {
+	l := NewLink(Profile{Burst: &GilbertElliott{P: 0.02, R: 0.25, BadLoss: 1}, QueueSize: 10000, Seed: 7})
+	defer l.Close()
+	lost := make([]bool, 0, 5000)
+	for i := 0; i < 5000; i++ {
+		lost = append(lost, !l.Send([]byte{0}, func([]byte) {}))
+	}
+	var losses, bursts int
+	for i, x := range lost {
+		if x {
+			losses++
+			if i == 0 || !lost[i-1] {
+				bursts++
+			}
+		}
+	}
+	// mean burst length is 1/R = 4
+	if mean := float64(losses) / float64(bursts); mean < 2.5 {
+		t.Fatalf("mean burst length %.2f, losses are not bursty", mean)
+	}
+}
+
+func TestLinkBandwidthAndDelay(t *testing.T) {
+	p := Profile{Bandwidth: 8 * 1000 * 1000, Delay: 20 * time.Millisecond, QueueSize: 1000}
+	l := NewLink(p)
+	defer l.Close()
+	// 100 packets of 1000 bytes need 100ms at 8Mbit/s
+	received, elapsed := sendPackets(t, l, 100, 1000, 0)
+	if received != 100 {
+		t.Fatalf("received %d of 100", received)
+	}
+	if elapsed < 110*time.Millisecond || elapsed > 250*time.Millisecond {
+		t.Fatalf("elapsed %v, want about 120ms", elapsed)
+	}
+}
+
+func TestLinkQueueDrop(t *testing.T) {
+	l := NewLink(Profile{Bandwidth: 1000 * 1000, QueueSize: 10})
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		l.Send(make([]byte, 1000), func([]byte) {})
+	}
+	if drops := l.Stats().QueueDrops; drops < 80 {
+		t.Fatalf("queue drops %d, want about 90", drops)
+	}
+}
+
+func TestUDPProxy(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			server.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	proxy, err := NewProxy("udp", server.LocalAddr().String(), Profile{Delay: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.ServeUDP(pc)
+
+	client, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	start := time.Now()
+	client.Write([]byte("ping"))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("echo %q", buf[:n])
+	}
+	if rtt := time.Since(start); rtt < 20*time.Millisecond {
+		t.Fatalf("rtt %v, want at least 20ms", rtt)
+	}
+}
@@ -0,0 +1,249 @@
+package netsim
+
+//Package netsim simulates a bad network in process, it replaces the dnctl/dummynet pipes of netsimul.sh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultQueueSize = 50 // slots, same as the dummynet default
+)
+
+// GilbertElliott is a two state markov model for bursty loss
+type GilbertElliott struct {
+	P        float64 // probability of good -> bad
+	R        float64 // probability of bad -> good
+	BadLoss  float64 // loss rate in the bad state, 1 - h
+	GoodLoss float64 // loss rate in the good state, 1 - k
+}
+
+// Profile describes the impairment of one direction of a link
+type Profile struct {
+	Name      string
+	Bandwidth int64         // bit/s, 0 means unlimited
+	Delay     time.Duration // one-way propagation delay
+	Jitter    time.Duration // delay varies uniformly in [-Jitter, +Jitter]
+	Loss      float64       // random packet loss rate
+	Burst     *GilbertElliott
+	Reorder   float64 // rate of packets delivered after their successor
+	Duplicate float64 // rate of packets delivered twice
+	QueueSize int     // packets waiting for the bottleneck, the tail is dropped
+	Seed      int64   // random seed, the same seed replays the same impairment
+}
+
+// Profiles equivalent to the pipes of netsimul.sh
+var (
+	Pipe1 = Profile{Name: "pipe1", Bandwidth: 20 * 1000 * 1000, Delay: 50 * time.Millisecond, Loss: 0.05, QueueSize: DefaultQueueSize, Seed: 1}
+	Pipe2 = Profile{Name: "pipe2", Bandwidth: 10 * 1000 * 1000, Delay: 50 * time.Millisecond, Loss: 0.10, QueueSize: DefaultQueueSize, Seed: 2}
+)
+
+var builtinProfiles = map[string]Profile{
+	"pipe1":   Pipe1,
+	"pipe2":   Pipe2,
+	"bad":     Pipe1,
+	"verybad": Pipe2,
+}
+
+// ProfileNames return the names of the builtin profiles
+func ProfileNames() []string {
+	names := make([]string, 0, len(builtinProfiles))
+	for name := range builtinProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupProfile find a builtin profile by name, or parse the spec as a dnctl config
+// e.g. "pipe1" or "bw 20Mbit/s delay 50 plr 0.05"
+func LookupProfile(spec string) (Profile, error) {
+	if p, ok := builtinProfiles[strings.TrimSpace(spec)]; ok {
+		return p, nil
+	}
+	return ParseProfile(spec)
+}
+
+// ParseProfile parse the dnctl pipe config syntax, with a few extensions:
+//
+//	bw 20Mbit/s delay 50 plr 0.05 queue 50
+//	jitter 10 reorder 0.01 dup 0.01 seed 7
+//	gilbert <p> <r> [bad-loss] [good-loss]
+//
+// delay and jitter are in ms, like dnctl
+func ParseProfile(spec string) (Profile, error) {
+	p := Profile{QueueSize: DefaultQueueSize}
+	fields := strings.Fields(spec)
+	for i := 0; i < len(fields); i++ {
+		key := fields[i]
+		if key == "config" {
+			continue
+		}
+		if i+1 >= len(fields) {
+			return p, fmt.Errorf("netsim: missing value of %q", key)
+		}
+		val := fields[i+1]
+		i++
+		var err error
+		switch key {
+		case "bw":
+			p.Bandwidth, err = parseBandwidth(val)
+		case "delay":
+			p.Delay, err = parseMillisecond(val)
+		case "jitter":
+			p.Jitter, err = parseMillisecond(val)
+		case "plr":
+			p.Loss, err = parseRate(val)
+		case "reorder":
+			p.Reorder, err = parseRate(val)
+		case "dup":
+			p.Duplicate, err = parseRate(val)
+		case "queue":
+			p.QueueSize, err = strconv.Atoi(val)
+		case "seed":
+			p.Seed, err = strconv.ParseInt(val, 10, 64)
+		case "gilbert":
+			ge := &GilbertElliott{BadLoss: 1}
+			rates := []*float64{&ge.P, &ge.R, &ge.BadLoss, &ge.GoodLoss}
+			j := 0
+			for ; j < len(rates) && i+j < len(fields); j++ {
+				v, perr := parseRate(fields[i+j])
+				if perr != nil {
+					break
+				}
+				*rates[j] = v
+			}
+			if j < 2 {
+				return p, fmt.Errorf("netsim: gilbert needs at least <p> <r>")
+			}
+			i += j - 1
+			p.Burst = ge
+		default:
+			return p, fmt.Errorf("netsim: unknown option %q", key)
+		}
+		if err != nil {
+			return p, fmt.Errorf("netsim: bad value of %s: %v", key, err)
+		}
+	}
+	return p, nil
+}
+
+// LoadProfiles read the "dnctl pipe N config ..." lines of a script like netsimul.sh,
+// the pipe N is named as "pipeN"
+func LoadProfiles(file string) (map[string]Profile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	profiles := make(map[string]Profile)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		for i := 0; i+2 < len(fields); i++ {
+			if fields[i] != "pipe" || fields[i+2] != "config" {
+				continue
+			}
+			p, err := ParseProfile(strings.Join(fields[i+3:], " "))
+			if err != nil {
+				return nil, err
+			}
+			p.Name = "pipe" + fields[i+1]
+			if p.Seed == 0 {
+				p.Seed, _ = strconv.ParseInt(fields[i+1], 10, 64)
+			}
+			profiles[p.Name] = p
+			break
+		}
+	}
+	return profiles, scanner.Err()
+}
+
+func (p Profile) String() string {
+	s := fmt.Sprintf("%s: bw %s delay %v plr %.3f", p.Name, formatBandwidth(p.Bandwidth), p.Delay, p.Loss)
+	if p.Jitter > 0 {
+		s += fmt.Sprintf(" jitter %v", p.Jitter)
+	}
+	if p.Burst != nil {
+		s += fmt.Sprintf(" gilbert %.3f %.3f %.3f %.3f", p.Burst.P, p.Burst.R, p.Burst.BadLoss, p.Burst.GoodLoss)
+	}
+	if p.Reorder > 0 {
+		s += fmt.Sprintf(" reorder %.3f", p.Reorder)
+	}
+	if p.Duplicate > 0 {
+		s += fmt.Sprintf(" dup %.3f", p.Duplicate)
+	}
+	return s
+}
+
+func parseBandwidth(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/s"), "ps")
+	unit := int64(1)
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasSuffix(lower, "kbit"):
+		unit, s = 1000, s[:len(s)-4]
+	case strings.HasSuffix(lower, "mbit"):
+		unit, s = 1000*1000, s[:len(s)-4]
+	case strings.HasSuffix(lower, "gbit"):
+		unit, s = 1000*1000*1000, s[:len(s)-4]
+	case strings.HasSuffix(lower, "bit"):
+		s = s[:len(s)-3]
+	case strings.HasSuffix(s, "KByte"), strings.HasSuffix(s, "MByte"):
+		unit = 8 * 1000
+		if s[len(s)-5] == 'M' {
+			unit *= 1000
+		}
+		s = s[:len(s)-5]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v * float64(unit)), nil
+}
+
+func formatBandwidth(bw int64) string {
+	switch {
+	case bw == 0:
+		return "unlimited"
+	case bw%(1000*1000) == 0:
+		return fmt.Sprintf("%dMbit/s", bw/(1000*1000))
+	case bw%1000 == 0:
+		return fmt.Sprintf("%dKbit/s", bw/1000)
+	}
+	return fmt.Sprintf("%dbit/s", bw)
+}
+
+func parseMillisecond(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	ms, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+func parseRate(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > 1 {
+		return 0, fmt.Errorf("rate %v out of [0, 1]", v)
+	}
+	return v, nil
+}
@@ -0,0 +1,201 @@
+package netsim
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+const (
+	maxDatagramSize = 1 << 16
+)
+
+// Proxy forwards tcp or udp traffic to the upstream through a simulated pipe, like the dummynet
+// rules of badnetwork.conf both directions pass the same profile, each direction has its own queue.
+type Proxy struct {
+	network  string
+	upstream string
+	up       *Link // client -> upstream
+	down     *Link // upstream -> client
+
+	mu       sync.Mutex
+	listener net.Listener
+	pc       *net.UDPConn
+	sessions map[string]*net.UDPConn
+	closed   bool
+}
+
+// NewProxy create a proxy of "tcp" or "udp" (rudp goes over udp)
+func NewProxy(network, upstream string, p Profile) (*Proxy, error) {
+	if network != "tcp" && network != "udp" {
+		return nil, errors.New("netsim: proxy supports tcp and udp only")
+	}
+	down := p
+	down.Seed = p.Seed + 1
+	return &Proxy{
+		network:  network,
+		upstream: upstream,
+		up:       NewLink(p),
+		down:     NewLink(down),
+		sessions: make(map[string]*net.UDPConn),
+	}, nil
+}
+
+// ListenAndServe blocks until the proxy is closed
+func (p *Proxy) ListenAndServe(addr string) error {
+	if p.network == "tcp" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		return p.ServeTCP(l)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	pc, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	return p.ServeUDP(pc)
+}
+
+func (p *Proxy) ServeTCP(l net.Listener) error {
+	p.mu.Lock()
+	p.listener = l
+	p.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if p.isClosed() {
+				return nil
+			}
+			return err
+		}
+		go p.handleTCP(conn)
+	}
+}
+
+func (p *Proxy) handleTCP(client net.Conn) {
+	defer client.Close()
+	server, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		log.Printf("netsim: dial upstream %s error: %v", p.upstream, err)
+		return
+	}
+	defer server.Close()
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn, link *Link) {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, 1<<15)
+		for {
+			n, err := src.Read(buf)
+			for off := 0; off < n; off += tcpMSS {
+				end := off + tcpMSS
+				if end > n {
+					end = n
+				}
+				link.SendReliable(buf[off:end], func(b []byte) {
+					dst.Write(b)
+				})
+			}
+			if err != nil {
+				if err != io.EOF && !p.isClosed() {
+					log.Printf("netsim: tcp read error: %v", err)
+				}
+				return
+			}
+		}
+	}
+	go pipe(server, client, p.up)
+	go pipe(client, server, p.down)
+	<-done
+}
+
+func (p *Proxy) ServeUDP(pc *net.UDPConn) error {
+	p.mu.Lock()
+	p.pc = pc
+	p.mu.Unlock()
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFromUDP(buf)
+		if err != nil {
+			if p.isClosed() {
+				return nil
+			}
+			return err
+		}
+		server, err := p.udpSession(addr)
+		if err != nil {
+			log.Printf("netsim: dial upstream %s error: %v", p.upstream, err)
+			continue
+		}
+		p.up.Send(buf[:n], func(b []byte) {
+			server.Write(b)
+		})
+	}
+}
+
+// udpSession find the upstream socket of the client, each client gets its own port
+func (p *Proxy) udpSession(client *net.UDPAddr) (*net.UDPConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.sessions[client.String()]; ok {
+		return s, nil
+	}
+	raddr, err := net.ResolveUDPAddr("udp", p.upstream)
+	if err != nil {
+		return nil, err
+	}
+	server, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	p.sessions[client.String()] = server
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			p.down.Send(buf[:n], func(b []byte) {
+				p.pc.WriteToUDP(b, client)
+			})
+		}
+	}()
+	return server, nil
+}
+
+func (p *Proxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Stats return the counters of the upstream and downstream direction
+func (p *Proxy) Stats() (up, down Stats) {
+	return p.up.Stats(), p.down.Stats()
+}
+
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.up.Close()
+	p.down.Close()
+	for _, s := range p.sessions {
+		s.Close()
+	}
+	if p.listener != nil {
+		return p.listener.Close()
+	}
+	if p.pc != nil {
+		return p.pc.Close()
+	}
+	return nil
+}
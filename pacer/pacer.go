@@ -0,0 +1,196 @@
+// Package pacer spreads the packets of the sender out at a multiple of the target bitrate,
+// so a large key frame does not go out in one burst and overflow the queue of the router
+package pacer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/transport"
+)
+
+// Priority of a packet, a lower value is sent first
+type Priority int
+
+const (
+	PriorityRetransmission Priority = iota // a late packet is no use, they go first
+	PriorityVideo
+	numPriorities
+)
+
+var ErrClosed = errors.New("pacer closed")
+
+// Config of the pacer
+type Config struct {
+	Multiplier    float64       // the pacing rate is Multiplier times the target bitrate
+	BurstInterval time.Duration // the bucket holds this long of the pacing rate
+	MaxQueueDelay time.Duration // the rate goes up when the queue is longer than it
+	MinBitrate    int64         // bit/s, keeps the queue moving when the target is very low
+}
+
+var DefaultConfig = Config{
+	Multiplier:    2.5,
+	BurstInterval: 5 * time.Millisecond,
+	MaxQueueDelay: 2 * time.Second,
+	MinBitrate:    100 * 1000,
+}
+
+// Stats of the pacer
+type Stats struct {
+	Packets     int64
+	Bytes       int64
+	Queued      int // packets waiting
+	QueuedBytes int
+}
+
+// entry is a queued write, a packet of the transport or a write below it
+type entry struct {
+	size int
+	send func() error
+}
+
+// Pacer is a token bucket in front of the socket
+type Pacer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	cfg    Config
+	send   func(p *transport.Packet) error
+	queues [numPriorities][]entry
+	stats  Stats
+	rate   float64 // byte/s
+	tokens float64 // bytes, negative is the debt of the last packet
+	last   time.Time
+	closed bool
+}
+
+// New return a pacer sending with send at cfg.Multiplier times bitrate
+func New(cfg Config, bitrate int64, send func(p *transport.Packet) error) *Pacer {
+	p := &Pacer{cfg: cfg, send: send}
+	p.cond = sync.NewCond(&p.mu)
+	p.setRate(bitrate)
+	return p
+}
+
+// SetTargetBitrate follows the bitrate of the congestion control
+func (p *Pacer) SetTargetBitrate(bitrate int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setRate(bitrate)
+}
+
+func (p *Pacer) setRate(bitrate int64) {
+	if bitrate < p.cfg.MinBitrate {
+		bitrate = p.cfg.MinBitrate
+	}
+	p.rate = float64(bitrate) * p.cfg.Multiplier / 8
+}
+
+// Enqueue a packet, it is sent by Run
+func (p *Pacer) Enqueue(pkt *transport.Packet, prio Priority) {
+	p.EnqueueFunc(pkt.Size(), prio, func() error { return p.send(pkt) })
+}
+
+// EnqueueFunc queue a write of size bytes that is not a packet of the transport, like a
+// retransmission of arq below it, Run calls send in its turn
+func (p *Pacer) EnqueueFunc(size int, prio Priority, send func() error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.queues[prio] = append(p.queues[prio], entry{size: size, send: send})
+	p.stats.Queued++
+	p.stats.QueuedBytes += size
+	p.cond.Signal()
+}
+
+// Run sends the queued packets until Close, it returns the error of send
+func (p *Pacer) Run() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for !p.closed && p.stats.Queued == 0 {
+			p.cond.Wait()
+		}
+		if p.closed {
+			return ErrClosed
+		}
+
+		p.refill(time.Now())
+		if p.tokens < 0 {
+			wait := time.Duration(-p.tokens / p.currentRate() * float64(time.Second))
+			p.mu.Unlock()
+			time.Sleep(wait)
+			p.mu.Lock()
+			continue
+		}
+
+		e := p.pop()
+		p.tokens -= float64(e.size)
+		p.mu.Unlock()
+		err := e.send()
+		p.mu.Lock()
+		if err != nil {
+			return err
+		}
+		p.stats.Packets++
+		p.stats.Bytes += int64(e.size)
+	}
+}
+
+// currentRate is the pacing rate, raised to drain a queue longer than MaxQueueDelay
+func (p *Pacer) currentRate() float64 {
+	rate := p.rate
+	if p.cfg.MaxQueueDelay > 0 {
+		if drain := float64(p.stats.QueuedBytes) / p.cfg.MaxQueueDelay.Seconds(); drain > rate {
+			rate = drain
+		}
+	}
+	return rate
+}
+
+func (p *Pacer) refill(now time.Time) {
+	rate := p.currentRate()
+	if !p.last.IsZero() {
+		p.tokens += rate * now.Sub(p.last).Seconds()
+	}
+	p.last = now
+	burst := rate * p.cfg.BurstInterval.Seconds()
+	if burst < transport.MaxPacketSize {
+		burst = transport.MaxPacketSize
+	}
+	if p.tokens > burst {
+		p.tokens = burst
+	}
+}
+
+func (p *Pacer) pop() entry {
+	for i := range p.queues {
+		if len(p.queues[i]) == 0 {
+			continue
+		}
+		e := p.queues[i][0]
+		p.queues[i][0] = entry{}
+		p.queues[i] = p.queues[i][1:]
+		p.stats.Queued--
+		p.stats.QueuedBytes -= e.size
+		return e
+	}
+	return entry{}
+}
+
+// Stats return the counters of the pacer
+func (p *Pacer) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Close stops Run, the queued packets are dropped
+func (p *Pacer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+}
@@ -0,0 +1,112 @@
+package pacer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/transport"
+)
+
+func packet(id uint32, flags uint8) *transport.Packet {
+	return &transport.Packet{
+		Header:  transport.Header{Type: transport.TypeMedia, Flags: flags, FrameID: id},
+		Payload: make([]byte, transport.MaxPayloadSize),
+	}
+}
+
+func TestPacerRate(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent int
+	)
+	// 8Mbit/s is 1000 packets of 1200 bytes per second
+	p := New(Config{Multiplier: 1, BurstInterval: 5 * time.Millisecond}, 8000*1000,
+		func(*transport.Packet) error {
+			mu.Lock()
+			sent++
+			mu.Unlock()
+			return nil
+		})
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		p.Enqueue(packet(uint32(i), 0), PriorityVideo)
+	}
+	go p.Run()
+	defer p.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	n := sent
+	mu.Unlock()
+	if n < 30 || n > 70 {
+		t.Fatalf("sent %d packets in 50ms, want about 50", n)
+	}
+	for p.Stats().Queued > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("100 packets sent in %v, want about 100ms", elapsed)
+	}
+	if s := p.Stats(); s.Packets != 100 || s.Bytes != 100*transport.MaxPacketSize {
+		t.Fatalf("stats %+v", s)
+	}
+}
+
+func TestPacerPriority(t *testing.T) {
+	var order []Priority
+	done := make(chan struct{})
+	p := New(Config{Multiplier: 1, BurstInterval: 5 * time.Millisecond}, 1000*1000, func(pkt *transport.Packet) error {
+		order = append(order, PriorityVideo)
+		if len(order) == 5 {
+			close(done)
+		}
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		p.Enqueue(packet(uint32(i), 0), PriorityVideo)
+	}
+	// the retransmissions of arq are datagrams below the transport, they pass the video
+	for i := 0; i < 2; i++ {
+		p.EnqueueFunc(transport.MaxPacketSize, PriorityRetransmission, func() error {
+			order = append(order, PriorityRetransmission)
+			return nil
+		})
+	}
+	go p.Run()
+	defer p.Close()
+	<-done
+
+	want := []Priority{PriorityRetransmission, PriorityRetransmission, PriorityVideo, PriorityVideo, PriorityVideo}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order %v, want %v", order, want)
+		}
+	}
+	// the retransmissions count against the rate like the packets
+	deadline := time.Now().Add(time.Second)
+	for p.Stats().Packets < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if s := p.Stats(); s.Packets != 5 || s.Bytes != 5*transport.MaxPacketSize {
+		t.Fatalf("stats %+v", s)
+	}
+}
+
+func TestPacerDrainsLongQueue(t *testing.T) {
+	// 100kbit/s would take about 10s, the queue limit drains it in 100ms
+	p := New(Config{Multiplier: 1, BurstInterval: 5 * time.Millisecond, MaxQueueDelay: 100 * time.Millisecond},
+		100*1000, func(*transport.Packet) error { return nil })
+	for i := 0; i < 100; i++ {
+		p.Enqueue(packet(uint32(i), 0), PriorityVideo)
+	}
+	go p.Run()
+	defer p.Close()
+	deadline := time.Now().Add(time.Second)
+	for p.Stats().Queued > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue not drained: %+v", p.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,319 @@
+package quic
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/transport"
+	quicgo "github.com/quic-go/quic-go"
+)
+
+const (
+	lengthSize  = 2 // of the records of transport.Conn
+	recordQueue = 1 << 8
+
+	codeClose   quicgo.ApplicationErrorCode = 0
+	codeExpired quicgo.StreamErrorCode      = 1 // the frame passed its deadline
+)
+
+// sentFrame is the stream of a frame still in flight
+type sentFrame struct {
+	stream quicgo.SendStream
+	opened time.Time
+}
+
+// Conn is a QUIC connection as the net.Conn of a transport.Conn: a Write is a record, a Read
+// returns the records of the streams and the datagrams as they arrive
+type Conn struct {
+	conn  quicgo.Connection
+	cfg   Config
+	owned net.PacketConn // closed with the conn, of Dial
+
+	wmu      sync.Mutex
+	control  quicgo.SendStream
+	frame    uint32
+	stream   quicgo.SendStream // of frame, nil while it is dropped
+	opened   time.Time
+	inFlight []sentFrame
+
+	records  chan []byte
+	rest     []byte // of the record of the last Read
+	deadline chan time.Time
+
+	done     chan struct{}
+	failOnce sync.Once
+	err      error
+}
+
+func newConn(conn quicgo.Connection, cfg Config) *Conn {
+	c := &Conn{
+		conn:     conn,
+		cfg:      cfg,
+		records:  make(chan []byte, recordQueue),
+		deadline: make(chan time.Time, 1),
+		done:     make(chan struct{}),
+	}
+	c.deadline <- time.Time{}
+	go c.acceptStreams()
+	go c.readDatagrams()
+	return c
+}
+
+// Write send a record, the media go on the stream of their frame or as a datagram
+func (c *Conn) Write(b []byte) (int, error) {
+	if len(b) < lengthSize {
+		return 0, transport.ErrBadRecord
+	}
+	p, err := transport.Unmarshal(b[lengthSize:])
+	if err != nil {
+		return 0, err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if p.Type != transport.TypeMedia {
+		return c.writeControl(b)
+	}
+	if c.cfg.Mode == ModeDatagram {
+		err := c.conn.SendDatagram(b)
+		var tooLarge *quicgo.DatagramTooLargeError
+		if errors.As(err, &tooLarge) {
+			return c.writeControl(b)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if p.FrameID != c.frame {
+		if err := c.nextFrame(p.FrameID); err != nil {
+			return 0, err
+		}
+	}
+	if c.stream == nil {
+		return len(b), nil
+	}
+	if _, err := c.stream.Write(b); err != nil {
+		// late, give up the rest of the frame
+		c.stream.CancelWrite(codeExpired)
+		c.stream = nil
+		if isTimeout(err) {
+			return len(b), nil
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// nextFrame finish the stream of the last frame and open the one of id, the frame is dropped if
+// the receiver doesn't take a new stream before its deadline
+func (c *Conn) nextFrame(id uint32) error {
+	now := time.Now()
+	if c.stream != nil {
+		c.stream.Close()
+		if c.cfg.FrameDeadline > 0 {
+			c.inFlight = append(c.inFlight, sentFrame{c.stream, c.opened})
+		}
+	}
+	c.expire(now)
+	c.frame, c.stream, c.opened = id, nil, now
+
+	s, err := c.conn.OpenUniStream()
+	if err != nil {
+		var limit quicgo.StreamLimitReachedError
+		if !errors.As(err, &limit) {
+			return err
+		}
+		ctx, cancel := c.frameContext(now)
+		s, err = c.conn.OpenUniStreamSync(ctx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+	if c.cfg.FrameDeadline > 0 {
+		s.SetWriteDeadline(now.Add(c.cfg.FrameDeadline))
+	}
+	c.stream = s
+	return nil
+}
+
+// expire reset the streams of the frames past the deadline, the receiver stops waiting for them
+func (c *Conn) expire(now time.Time) {
+	n := 0
+	for _, f := range c.inFlight {
+		if now.Sub(f.opened) > c.cfg.FrameDeadline {
+			f.stream.CancelWrite(codeExpired)
+			continue
+		}
+		c.inFlight[n] = f
+		n++
+	}
+	c.inFlight = c.inFlight[:n]
+}
+
+func (c *Conn) frameContext(now time.Time) (context.Context, context.CancelFunc) {
+	if c.cfg.FrameDeadline > 0 {
+		return context.WithDeadline(c.conn.Context(), now.Add(c.cfg.FrameDeadline))
+	}
+	return context.WithCancel(c.conn.Context())
+}
+
+func (c *Conn) writeControl(b []byte) (int, error) {
+	if c.control == nil {
+		s, err := c.conn.OpenUniStreamSync(c.conn.Context())
+		if err != nil {
+			return 0, err
+		}
+		c.control = s
+	}
+	return c.control.Write(b)
+}
+
+func (c *Conn) acceptStreams() {
+	for {
+		s, err := c.conn.AcceptUniStream(c.conn.Context())
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		go c.readStream(s)
+	}
+}
+
+// readStream pass the whole records of the stream on, the rest of a reset frame is left out
+func (c *Conn) readStream(s quicgo.ReceiveStream) {
+	r := bufio.NewReader(s)
+	for {
+		var size [lengthSize]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		record := make([]byte, lengthSize+int(binary.BigEndian.Uint16(size[:])))
+		copy(record, size[:])
+		if _, err := io.ReadFull(r, record[lengthSize:]); err != nil {
+			return
+		}
+		select {
+		case c.records <- record:
+		case <-c.done:
+			s.CancelRead(0)
+			return
+		}
+	}
+}
+
+func (c *Conn) readDatagrams() {
+	for {
+		b, err := c.conn.ReceiveDatagram(c.conn.Context())
+		if err != nil {
+			return
+		}
+		select {
+		case c.records <- b:
+		default: // a late datagram is as lost
+		}
+	}
+}
+
+// fail end the reads with err, io.EOF when the peer closed
+func (c *Conn) fail(err error) {
+	c.failOnce.Do(func() {
+		var appErr *quicgo.ApplicationError
+		if errors.As(err, &appErr) && appErr.ErrorCode == codeClose {
+			err = io.EOF
+		}
+		c.err = err
+		close(c.done)
+	})
+}
+
+// Read the records in the order they arrive, a short b gets the record in several reads
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.rest) == 0 {
+		record, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		c.rest = record
+	}
+	n := copy(b, c.rest)
+	c.rest = c.rest[n:]
+	return n, nil
+}
+
+func (c *Conn) next() ([]byte, error) {
+	select {
+	case record := <-c.records:
+		return record, nil
+	default:
+	}
+	deadline := <-c.deadline
+	c.deadline <- deadline
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case record := <-c.records:
+		return record, nil
+	case <-c.done:
+		select {
+		case record := <-c.records:
+			return record, nil
+		default:
+			return nil, c.err
+		}
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *Conn) Close() error {
+	err := c.conn.CloseWithError(codeClose, "")
+	c.fail(net.ErrClosed)
+	if c.owned != nil {
+		c.owned.Close()
+	}
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline apply to the next reads
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	<-c.deadline
+	c.deadline <- t
+	return nil
+}
+
+// SetWriteDeadline is a no-op, a frame has the FrameDeadline
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
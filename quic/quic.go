@@ -0,0 +1,161 @@
+// Package quic carries the records of a transport.Conn over QUIC, which keeps the congestion
+// control and the encryption of tcp without its head of line blocking. In ModeStream each frame
+// goes on its own unidirectional stream, so a lost packet holds back its frame only, and a frame
+// older than the FrameDeadline is given up. In ModeDatagram the media packets are QUIC datagrams
+// which are lost like udp. The other packets (feedback, clock, session) go on a control stream.
+//
+// The receiver reads both modes, only the sender chooses.
+package quic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+)
+
+const (
+	ModeStream   = "stream"
+	ModeDatagram = "datagram"
+
+	alpn              = "video"
+	maxStreams        = 1 << 10 // frames in flight of a sender
+	initialPacketSize = 1350    // a datagram fits a transport record
+	idleTimeout       = 10 * time.Second
+)
+
+// Config of the sending side of a Conn
+type Config struct {
+	Mode          string
+	FrameDeadline time.Duration // a frame stream is reset after it, ModeStream, 0 for never
+}
+
+var DefaultConfig = Config{
+	Mode:          ModeStream,
+	FrameDeadline: 500 * time.Millisecond,
+}
+
+func quicConfig() *quicgo.Config {
+	return &quicgo.Config{
+		MaxIncomingUniStreams: maxStreams,
+		MaxIdleTimeout:        idleTimeout,
+		KeepAlivePeriod:       idleTimeout / 4,
+		InitialPacketSize:     initialPacketSize,
+		EnableDatagrams:       true,
+	}
+}
+
+// Dial connect to the Listener at addr
+func Dial(addr string, cfg Config) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	c, err := DialConn(pc, raddr, cfg)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	c.owned = pc
+	return c, nil
+}
+
+// DialConn connect over pc, like a udp socket bound to a port or a netsim.PacketConn.
+// pc is not closed with the Conn.
+func DialConn(pc net.PacketConn, addr net.Addr, cfg Config) (*Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), idleTimeout)
+	defer cancel()
+	// the certificate is not checked, QUIC encrypts but doesn't authenticate the server
+	conn, err := quicgo.Dial(ctx, pc, addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{alpn},
+	}, quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, cfg), nil
+}
+
+// Listener accepts the QUIC connections of the senders, it is a net.Listener
+type Listener struct {
+	l   *quicgo.Listener
+	cfg Config
+}
+
+// Listen on the udp address with a self-signed certificate
+func Listen(addr string, cfg Config) (*Listener, error) {
+	tlsConf, err := selfSigned()
+	if err != nil {
+		return nil, err
+	}
+	l, err := quicgo.ListenAddr(addr, tlsConf, quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{l: l, cfg: cfg}, nil
+}
+
+// ListenPacket listen on pc, it is not closed with the Listener
+func ListenPacket(pc net.PacketConn, cfg Config) (*Listener, error) {
+	tlsConf, err := selfSigned()
+	if err != nil {
+		return nil, err
+	}
+	l, err := quicgo.Listen(pc, tlsConf, quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{l: l, cfg: cfg}, nil
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.l.Accept(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, l.cfg), nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.l.Addr()
+}
+
+func (l *Listener) Close() error {
+	return l.l.Close()
+}
+
+// selfSigned make the TLS config of a listener with a new ECDSA certificate
+func selfSigned() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: alpn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{alpn},
+	}, nil
+}
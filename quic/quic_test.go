@@ -0,0 +1,274 @@
+package quic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/netsim"
+	"github.com/l-f-h/video/transport"
+)
+
+// pair connect a sender of cfg to a receiver over loopback, the sending side through the profile
+func pair(t testing.TB, cfg Config, p *netsim.Profile) (sender, receiver net.Conn) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ListenPacket(server, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pc net.PacketConn = client
+	if p != nil {
+		pc = netsim.NewPacketConn(client, *p)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- c
+	}()
+	c, err := DialConn(pc, server.LocalAddr(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver = <-accepted
+	if receiver == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		c.Close()
+		receiver.Close()
+		l.Close()
+		pc.Close()
+		server.Close()
+	})
+	return c, receiver
+}
+
+func sendFrames(t testing.TB, tconn *transport.Conn, n, size int) {
+	pz := transport.NewPacketizer()
+	for i := 0; i < n; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, size)
+		for _, p := range pz.Packetize(data, i == 0, time.Now()) {
+			if _, err := tconn.WritePacket(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func testMode(t *testing.T, mode string) {
+	cfg := DefaultConfig
+	cfg.Mode = mode
+	sender, receiver := pair(t, cfg, nil)
+	stconn, rtconn := transport.NewConn(sender), transport.NewConn(receiver)
+
+	const frames = 20
+	if _, err := stconn.WritePacket(&transport.Packet{Header: transport.Header{Type: transport.TypeSession}, Payload: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	sendFrames(t, stconn, frames, 3*transport.MaxPayloadSize)
+
+	received := make(map[uint32]bool)
+	session := false
+	r := transport.NewReassembler()
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(received) < frames || !session {
+		p, err := rtconn.ReadPacket()
+		if err != nil {
+			t.Fatalf("received %d frames, session %v: %v", len(received), session, err)
+		}
+		switch p.Type {
+		case transport.TypeSession:
+			session = string(p.Payload) == "hello"
+		case transport.TypeMedia:
+			// the frames arrive in any order, only count the complete ones
+			f := r.Push(p)
+			if f != nil && len(f.Data) != 3*transport.MaxPayloadSize {
+				t.Fatalf("frame %d of %d bytes", f.ID, len(f.Data))
+			}
+			if p.FragIdx == 0 {
+				received[p.FrameID] = true
+			}
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	testMode(t, ModeStream)
+}
+
+func TestDatagram(t *testing.T) {
+	testMode(t, ModeDatagram)
+}
+
+func TestClose(t *testing.T) {
+	sender, receiver := pair(t, DefaultConfig, nil)
+	sender.Close()
+	receiver.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := receiver.Read(make([]byte, 64)); err != io.EOF {
+		t.Fatalf("read after the peer closed: %v, want EOF", err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	_, receiver := pair(t, DefaultConfig, nil)
+	receiver.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := receiver.Read(make([]byte, 64))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("read error %v, want a timeout", err)
+	}
+}
+
+// modePair is a sender and a receiver of a mode, the sending side through the profile
+type modePair func(b *testing.B, p netsim.Profile) (sender, receiver net.Conn)
+
+func tcpPair(b *testing.B, p netsim.Profile) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	sender := netsim.NewConn(c, p, false)
+	b.Cleanup(func() { sender.Close() })
+	return sender, s
+}
+
+func udpPair(b *testing.B, p netsim.Profile) (net.Conn, net.Conn) {
+	s, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	c, err := net.DialUDP("udp", nil, s.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		b.Fatal(err)
+	}
+	sender := netsim.NewConn(c, p, true)
+	b.Cleanup(func() { sender.Close() })
+	return sender, s
+}
+
+func quicPair(mode string) modePair {
+	return func(b *testing.B, p netsim.Profile) (net.Conn, net.Conn) {
+		cfg := DefaultConfig
+		cfg.Mode = mode
+		return pair(b, cfg, &p)
+	}
+}
+
+// BenchmarkModes send a 2 Mbit/s stream of 30 fps with a key frame per second over the netsim
+// profiles, b.N frames, and report the frames delivered and their latency, like:
+//
+//	go test -run - -bench Modes -benchtime 150x ./quic
+func BenchmarkModes(b *testing.B) {
+	modes := []struct {
+		name string
+		pair modePair
+	}{
+		{"tcp", tcpPair},
+		{"udp", udpPair},
+		{"quic-stream", quicPair(ModeStream)},
+		{"quic-datagram", quicPair(ModeDatagram)},
+	}
+	for _, p := range []netsim.Profile{netsim.Pipe1, netsim.Pipe2} {
+		for _, m := range modes {
+			b.Run(fmt.Sprintf("%s/%s", p.Name, m.name), func(b *testing.B) {
+				sender, receiver := m.pair(b, p)
+				benchmarkStream(b, sender, receiver)
+			})
+		}
+	}
+}
+
+func benchmarkStream(b *testing.B, sender, receiver net.Conn) {
+	const (
+		fps      = 30
+		bitrate  = 2 * 1000 * 1000
+		keyRatio = 4 // size of a key frame to a delta frame
+		drain    = time.Second
+	)
+	size := bitrate / 8 / (fps - 1 + keyRatio)
+
+	var (
+		mu        sync.Mutex
+		fragments = make(map[uint32]int)
+		latencies []time.Duration
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tconn := transport.NewConn(receiver)
+		for {
+			p, err := tconn.ReadPacket()
+			if err != nil {
+				return
+			}
+			if p.Type != transport.TypeMedia {
+				continue
+			}
+			mu.Lock()
+			// a frame is delivered with its last fragment, whatever the order
+			if fragments[p.FrameID]++; fragments[p.FrameID] == int(p.FragCnt) {
+				latencies = append(latencies, time.Since(p.CaptureTime()))
+			}
+			mu.Unlock()
+		}
+	}()
+
+	tconn := transport.NewConn(sender)
+	pz := transport.NewPacketizer()
+	ticker := time.NewTicker(time.Second / fps)
+	defer ticker.Stop()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := i%fps == 0
+		n := size
+		if key {
+			n *= keyRatio
+		}
+		for _, p := range pz.Packetize(make([]byte, n), key, time.Now()) {
+			if _, err := tconn.WritePacket(p); err != nil {
+				b.Fatal(err)
+			}
+		}
+		<-ticker.C
+	}
+	time.Sleep(drain)
+	b.StopTimer()
+	receiver.Close()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(100*float64(len(latencies))/float64(b.N), "delivered%")
+	if len(latencies) > 0 {
+		b.ReportMetric(ms(latencies[len(latencies)/2]), "p50-ms")
+		b.ReportMetric(ms(latencies[len(latencies)*95/100]), "p95-ms")
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,335 @@
+// Package relay forwards the stream of one publisher to many subscribers without decoding it,
+// like an SFU. A subscriber joins on the last key frame, and a slow one loses frames up to the next
+// key frame instead of holding back the others.
+package relay
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/latency"
+	"github.com/l-f-h/video/session"
+	"github.com/l-f-h/video/transport"
+)
+
+const (
+	subscriberQueue = 1 << 6 // frames waiting for a subscriber, about 2 seconds
+	maxGOPFrames    = 1 << 8 // frames after the key frame kept for a new subscriber
+)
+
+var (
+	ErrBusy   = errors.New("relay: already published")
+	ErrClosed = errors.New("relay: closed")
+)
+
+// SubscriberStats counts the frames of a subscriber
+type SubscriberStats struct {
+	Sent    uint64
+	Dropped uint64 // dropped by the relay, the subscriber was too slow or waited for a key frame
+}
+
+// Relay has at most one publisher at a time, the subscribers stay across publishers
+type Relay struct {
+	caps session.Capabilities
+
+	mu        sync.Mutex
+	publisher *session.Session
+	params    session.Params
+	ready     chan struct{} // closed when a publisher is in
+	gop       []*transport.Frame
+	ps        h264.ParameterSets
+	subs      map[*subscriber]struct{}
+	clock     *latency.ClockEstimator // of the publisher, the subscribers get its time
+	gone      SubscriberStats         // of the subscribers that left
+	closed    bool
+}
+
+func New(caps session.Capabilities) *Relay {
+	return &Relay{
+		caps:  caps,
+		ready: make(chan struct{}),
+		subs:  make(map[*subscriber]struct{}),
+		clock: latency.NewClockEstimator(),
+	}
+}
+
+// Publish accept the session of a publisher and forward its frames until it leaves
+func (r *Relay) Publish(conn *transport.Conn) error {
+	sess, err := session.Accept(conn, r.caps, 0)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if r.publisher != nil || r.closed {
+		r.mu.Unlock()
+		return ErrBusy
+	}
+	r.publisher = sess
+	r.setParams(sess.Params)
+	close(r.ready)
+	r.mu.Unlock()
+	log.Printf("publisher %v: %v", conn.NetConn().RemoteAddr(), sess)
+
+	defer func() {
+		r.mu.Lock()
+		r.publisher = nil
+		r.ready = make(chan struct{})
+		r.gop = nil
+		r.clock = latency.NewClockEstimator()
+		r.mu.Unlock()
+	}()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	recorder := transport.NewFeedbackRecorder()
+	go sendFeedback(conn, recorder, stop)
+	go probeClock(conn, stop)
+
+	reassembler := transport.NewReassembler()
+	for {
+		p, err := conn.ReadPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		recorder.OnPacket(p.Seq, time.Now())
+		switch p.Type {
+		case transport.TypeSession:
+			if m, err := session.Unmarshal(p.Payload); err == nil {
+				r.renegotiate(conn, sess, m)
+			}
+		case transport.TypeClock:
+			if probe, err := latency.UnmarshalClockProbe(p.Payload); err == nil {
+				r.clock.AddProbe(probe, time.Now())
+			}
+		case transport.TypeMedia:
+			if frame := reassembler.Push(p); frame != nil {
+				r.forward(frame)
+			}
+		}
+	}
+}
+
+// renegotiate answer the offer of the publisher, and pass the new params to the subscribers
+func (r *Relay) renegotiate(conn *transport.Conn, sess *session.Session, offer *session.Message) {
+	answer := sess.HandleOffer(offer, r.caps)
+	if answer == nil {
+		return
+	}
+	pkt, err := answer.Packet()
+	if err != nil {
+		return
+	}
+	if _, err := conn.WritePacket(pkt); err != nil {
+		log.Printf("write session answer error: %v", err)
+		return
+	}
+	if offer.Round > 0 && answer.Type == session.TypeAnswer {
+		r.mu.Lock()
+		r.setParams(sess.CurrentParams())
+		r.mu.Unlock()
+	}
+}
+
+// setParams renegotiate the subscribers if the params change, r.mu is held
+func (r *Relay) setParams(params session.Params) {
+	if params.SameMedia(r.params) {
+		return
+	}
+	r.params = params
+	for s := range r.subs {
+		if s.sess != nil {
+			s.sess.Renegotiate(s.conn, params)
+		}
+	}
+}
+
+// forward a frame of the publisher to every subscriber
+func (r *Relay) forward(f *transport.Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ps.Update(f.Data)
+	switch {
+	case f.KeyFrame:
+		r.gop = append(r.gop[:0:0], f)
+	case len(r.gop) > 0 && len(r.gop) < maxGOPFrames:
+		r.gop = append(r.gop, f)
+	default:
+		r.gop = nil // too long since the key frame, a new subscriber waits for the next one
+	}
+	for s := range r.subs {
+		s.push(f)
+	}
+}
+
+// Subscribe offer the stream of the publisher to a subscriber and send the frames until it leaves.
+// It waits for a publisher first, and closes conn at the end.
+func (r *Relay) Subscribe(conn *transport.Conn) error {
+	s := &subscriber{
+		conn:    conn,
+		frames:  make(chan *transport.Frame, subscriberQueue),
+		waitKey: true,
+	}
+	r.mu.Lock()
+	ready, closed := r.ready, r.closed
+	r.mu.Unlock()
+	if closed {
+		conn.Close()
+		return ErrClosed
+	}
+	<-ready
+
+	r.mu.Lock()
+	params := r.params
+	r.mu.Unlock()
+	sess, err := session.Initiate(conn, params, session.DefaultTimeout)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// join on the last key frame and the frames after it, the key frame with its SPS and PPS
+	r.mu.Lock()
+	s.sess = sess
+	if !r.params.SameMedia(params) {
+		sess.Renegotiate(conn, r.params) // changed during the handshake
+	}
+	for _, f := range r.gop {
+		if f.KeyFrame {
+			f = &transport.Frame{ID: f.ID, KeyFrame: true, Timestamp: f.Timestamp, Data: r.ps.Prepend(f.Data)}
+		}
+		s.push(f)
+	}
+	r.subs[s] = struct{}{}
+	r.mu.Unlock()
+	log.Printf("subscriber %v: %v", conn.NetConn().RemoteAddr(), s.sess)
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.subs, s)
+		close(s.frames)
+		st := s.Stats()
+		r.gone.Sent += st.Sent
+		r.gone.Dropped += st.Dropped
+		r.mu.Unlock()
+	}()
+	errc := make(chan error, 2)
+	go func() {
+		errc <- s.send()
+	}()
+	go func() {
+		errc <- r.readSubscriber(s)
+	}()
+	err = <-errc
+	conn.Close()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// readSubscriber answer the clock probes of a subscriber with the time of the publisher,
+// so it measures the latency from the camera, and take its session answers
+func (r *Relay) readSubscriber(s *subscriber) error {
+	for {
+		p, err := s.conn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		switch p.Type {
+		case transport.TypeSession:
+			if m, err := session.Unmarshal(p.Payload); err == nil {
+				s.sess.HandleAnswer(m)
+			}
+		case transport.TypeClock:
+			received := time.Now()
+			probe, err := latency.UnmarshalClockProbe(p.Payload)
+			if err != nil {
+				continue
+			}
+			r.mu.Lock()
+			offset := r.clock.Offset()
+			r.mu.Unlock()
+			probe.Answer(received.Add(offset), time.Now().Add(offset))
+			if _, err := s.conn.WritePacket(probe.Packet()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Stats of every subscriber
+func (r *Relay) Stats() []SubscriberStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make([]SubscriberStats, 0, len(r.subs))
+	for s := range r.subs {
+		stats = append(stats, s.Stats())
+	}
+	return stats
+}
+
+// Totals of all the subscribers, those that left too
+func (r *Relay) Totals() SubscriberStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	total := r.gone
+	for s := range r.subs {
+		st := s.Stats()
+		total.Sent += st.Sent
+		total.Dropped += st.Dropped
+	}
+	return total
+}
+
+// Close stop the waiting subscribers, the running ones end with their conns
+func (r *Relay) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		if r.publisher == nil {
+			close(r.ready)
+		}
+	}
+}
+
+// sendFeedback report the arrival of the packets to the publisher for its congestion control
+func sendFeedback(conn *transport.Conn, recorder *transport.FeedbackRecorder, stop <-chan struct{}) {
+	ticker := time.NewTicker(transport.FeedbackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if fb := recorder.Build(); fb != nil {
+			if _, err := conn.WritePacket(transport.NewFeedbackPacket(fb)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// probeClock measure the offset to the clock of the publisher
+func probeClock(conn *transport.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(latency.ClockProbeInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := conn.WritePacket(latency.NewClockProbe(time.Now()).Packet()); err != nil && err != transport.ErrNoPeer {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package relay
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/session"
+	"github.com/l-f-h/video/transport"
+)
+
+var (
+	sps = []byte{0x67, 0x42, 0xc0, 0x1f}
+	pps = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+func tcpPair(t *testing.T) (client, server *transport.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport.NewConn(c), transport.NewConn(<-accepted)
+}
+
+// frame make an access unit of size bytes, the first key frame has the parameter sets
+func frame(i, size int, key, withPS bool) []byte {
+	var au []byte
+	if withPS {
+		au = h264.AppendAnnexB(au, sps, pps)
+	}
+	nalType := byte(0x41)
+	if key {
+		nalType = 0x65
+	}
+	body := bytes.Repeat([]byte{byte(i) | 1}, size)
+	body[0] = nalType
+	return h264.AppendAnnexB(au, body)
+}
+
+type publisher struct {
+	conn       *transport.Conn
+	packetizer *transport.Packetizer
+}
+
+func publish(t *testing.T, r *Relay) *publisher {
+	client, server := tcpPair(t)
+	go r.Publish(server)
+	params := session.Params{Codec: session.CodecH264, Width: 640, Height: 360, FPS: 30, Bitrate: 1000 * 1000}
+	if _, err := session.Initiate(client, params, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return &publisher{conn: client, packetizer: transport.NewPacketizer()}
+}
+
+func (p *publisher) send(t *testing.T, au []byte, key bool) {
+	for _, pkt := range p.packetizer.Packetize(au, key, time.Now()) {
+		if _, err := p.conn.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func subscribe(t *testing.T, r *Relay) *transport.Conn {
+	client, server := tcpPair(t)
+	go r.Subscribe(server)
+	if _, err := session.Accept(client, session.DefaultCapabilities, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func readFrame(t *testing.T, conn *transport.Conn, reassembler *transport.Reassembler) *transport.Frame {
+	conn.NetConn().SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		p, err := conn.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Type != transport.TypeMedia {
+			continue
+		}
+		if f := reassembler.Push(p); f != nil {
+			return f
+		}
+	}
+}
+
+func waitSubscribers(t *testing.T, r *Relay, n int) {
+	for i := 0; len(r.Stats()) < n; i++ {
+		if i > 100 {
+			t.Fatalf("%d subscribers, want %d", len(r.Stats()), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJoinOnKeyFrame(t *testing.T) {
+	r := New(session.DefaultCapabilities)
+	defer r.Close()
+	pub := publish(t, r)
+	defer pub.conn.Close()
+
+	pub.send(t, frame(0, 100, true, true), true)
+	pub.send(t, frame(1, 100, false, false), false)
+	pub.send(t, frame(2, 3000, true, false), true) // no parameter sets in band
+	pub.send(t, frame(3, 100, false, false), false)
+	time.Sleep(50 * time.Millisecond)
+
+	sub := subscribe(t, r)
+	defer sub.Close()
+	reassembler := transport.NewReassembler()
+	f := readFrame(t, sub, reassembler)
+	want := append(h264.AppendAnnexB(nil, sps, pps), frame(2, 3000, true, false)...)
+	if !f.KeyFrame || !bytes.Equal(f.Data, want) {
+		t.Fatalf("first frame key %v, %d bytes, want the last key frame with sps and pps", f.KeyFrame, len(f.Data))
+	}
+	if f := readFrame(t, sub, reassembler); !bytes.Equal(f.Data, frame(3, 100, false, false)) {
+		t.Fatal("second frame is not the one after the key frame")
+	}
+	pub.send(t, frame(4, 100, false, false), false)
+	if f := readFrame(t, sub, reassembler); !bytes.Equal(f.Data, frame(4, 100, false, false)) {
+		t.Fatal("live frame not forwarded")
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	r := New(session.DefaultCapabilities)
+	defer r.Close()
+	pub := publish(t, r)
+	defer pub.conn.Close()
+
+	slow := subscribe(t, r) // never reads
+	defer slow.Close()
+	fast := subscribe(t, r)
+	defer fast.Close()
+	waitSubscribers(t, r, 2)
+
+	const frames = 300
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reassembler := transport.NewReassembler()
+		for i := 0; i < frames; i++ {
+			if f := readFrame(t, fast, reassembler); !bytes.Equal(f.Data[:8], frame(i, 8, i%30 == 0, i == 0)[:8]) {
+				t.Errorf("frame %d lost or out of order", i)
+				return
+			}
+		}
+	}()
+	for i := 0; i < frames; i++ {
+		pub.send(t, frame(i, 100*1000, i%30 == 0, i == 0), i%30 == 0)
+		time.Sleep(2 * time.Millisecond)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the slow subscriber stalled the fast one")
+	}
+
+	var dropped uint64
+	for _, s := range r.Stats() {
+		dropped += s.Dropped
+	}
+	if dropped == 0 {
+		t.Fatal("no frame dropped for the slow subscriber")
+	}
+}
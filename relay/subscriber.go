@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"sync/atomic"
+
+	"github.com/l-f-h/video/session"
+	"github.com/l-f-h/video/transport"
+)
+
+type subscriber struct {
+	conn    *transport.Conn
+	sess    *session.Session
+	frames  chan *transport.Frame
+	waitKey bool // a frame was dropped, the next frames do not decode until a key frame
+	sent    uint64
+	dropped uint64
+}
+
+// push queue a frame without blocking, a full queue drops it. The relay lock is held.
+func (s *subscriber) push(f *transport.Frame) {
+	if s.waitKey && !f.KeyFrame {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	if f.KeyFrame && len(s.frames) == cap(s.frames) {
+		// the queued frames are stale, the key frame replaces them
+		for len(s.frames) > 0 {
+			select {
+			case <-s.frames:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	}
+	select {
+	case s.frames <- f:
+		s.waitKey = false
+	default:
+		s.waitKey = true
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// send the queued frames, a slow conn blocks only this subscriber
+func (s *subscriber) send() error {
+	packetizer := transport.NewPacketizer()
+	for f := range s.frames {
+		for _, p := range packetizer.Packetize(f.Data, f.KeyFrame, f.Timestamp) {
+			if _, err := s.conn.WritePacket(p); err != nil {
+				return err
+			}
+		}
+		atomic.AddUint64(&s.sent, 1)
+	}
+	return nil
+}
+
+func (s *subscriber) Stats() SubscriberStats {
+	return SubscriberStats{
+		Sent:    atomic.LoadUint64(&s.sent),
+		Dropped: atomic.LoadUint64(&s.dropped),
+	}
+}
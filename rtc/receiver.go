@@ -0,0 +1,123 @@
+package rtc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+const (
+	receiverQueue = 1 << 6
+	maxLate       = 1 << 8 // packets the sample builder waits for a missing one
+)
+
+// Frame is an access unit of the stream of a Receiver
+type Frame struct {
+	Data      []byte // Annex B
+	Key       bool
+	Timestamp uint32 // of the RTP clock
+}
+
+// Receiver is a peer receiving the video of a Sender
+type Receiver struct {
+	pc     *webrtc.PeerConnection
+	frames chan Frame
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Dial post an offer to the signaling endpoint of a Sender at url, and receive its video
+func Dial(url string) (*Receiver, error) {
+	var settings webrtc.SettingEngine
+	settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6})
+	settings.SetIncludeLoopbackCandidate(true)
+	api, err := newAPI(settings)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+	r := &Receiver{pc: pc, frames: make(chan Frame, receiverQueue), done: make(chan struct{})}
+	if err := r.offer(url); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Receiver) offer(url string) error {
+	if _, err := r.pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		return err
+	}
+	r.pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		go r.read(track)
+	})
+	offer, err := r.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	gathered := webrtc.GatheringCompletePromise(r.pc)
+	if err := r.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	<-gathered
+
+	resp, err := http.Post(url, "application/sdp", strings.NewReader(r.pc.LocalDescription().SDP))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	answer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("rtc: signaling %s: %s", resp.Status, strings.TrimSpace(string(answer)))
+	}
+	return r.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)})
+}
+
+// read build the access units of the track until the connection closes
+func (r *Receiver) read(track *webrtc.TrackRemote) {
+	defer close(r.frames)
+	builder := samplebuilder.New(maxLate, &codecs.H264Packet{}, track.Codec().ClockRate)
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		builder.Push(pkt)
+		for {
+			sample, ts := builder.PopWithTimestamp()
+			if sample == nil {
+				break
+			}
+			select {
+			case r.frames <- Frame{Data: sample.Data, Key: h264.IsKeyFrame(sample.Data), Timestamp: ts}:
+			case <-r.done:
+				return
+			}
+		}
+	}
+}
+
+// Frames is closed when the connection closes, once the track has started
+func (r *Receiver) Frames() <-chan Frame {
+	return r.frames
+}
+
+func (r *Receiver) Close() error {
+	r.once.Do(func() { close(r.done) })
+	return r.pc.Close()
+}
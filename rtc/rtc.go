@@ -0,0 +1,53 @@
+// Package rtc sends the H.264 stream to WebRTC peers like browsers. A peer posts its SDP offer to the
+// signaling endpoint and gets the answer of an ICE-lite agent with host candidates, then the frames
+// go in RTP of RFC 6184 over DTLS-SRTP. Receiver is a peer of Go for the tests and the tools.
+package rtc
+
+import (
+	"errors"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	payloadType = 102
+	h264Fmtp    = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"
+)
+
+var ErrClosed = errors.New("rtc: closed")
+
+// Config of the ICE agent of the sender
+type Config struct {
+	PortMin  uint16   // of the udp ports of the host candidates, 0 for any
+	PortMax  uint16   //
+	PublicIP []string // announced instead of the host addresses behind a 1:1 NAT
+}
+
+var h264Codec = webrtc.RTPCodecCapability{
+	MimeType:    webrtc.MimeTypeH264,
+	ClockRate:   90000,
+	SDPFmtpLine: h264Fmtp,
+	RTCPFeedback: []webrtc.RTCPFeedback{
+		{Type: "nack"},
+		{Type: "nack", Parameter: "pli"},
+		{Type: "ccm", Parameter: "fir"},
+		{Type: "goog-remb"},
+	},
+}
+
+// newAPI make the peer connections of H.264 only, with the NACK, RTCP report and TWCC interceptors
+func newAPI(settings webrtc.SettingEngine) (*webrtc.API, error) {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: h264Codec,
+		PayloadType:        payloadType,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		return nil, err
+	}
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, ir); err != nil {
+		return nil, err
+	}
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(ir), webrtc.WithSettingEngine(settings)), nil
+}
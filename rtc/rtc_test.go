@@ -0,0 +1,101 @@
+package rtc
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+)
+
+var (
+	sps = []byte{0x67, 0x42, 0xc0, 0x1f, 0xf4, 0x0a, 0x0f, 0xc8}
+	pps = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+// accessUnit of 4000 bytes, fragmented in FU-A
+func accessUnit(i int, key bool) []byte {
+	body := bytes.Repeat([]byte{byte(i) | 1}, 4000)
+	if key {
+		body[0] = 0x65
+		return h264.AppendAnnexB(nil, sps, pps, body)
+	}
+	body[0] = 0x41
+	return h264.AppendAnnexB(nil, body)
+}
+
+func TestLoopback(t *testing.T) {
+	s, err := NewSender(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	r, err := Dial(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Peers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("not connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the peer starts on the key frame, the frames before it are not sent
+	const n = 30
+	go func() {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			s.WriteFrame(accessUnit(i, i == 2), start.Add(time.Duration(i)*time.Second/30))
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	timeout := time.After(10 * time.Second)
+	var last uint32
+	for i := 2; i < n-1; i++ { // the builder holds the last frame until the next one
+		var f Frame
+		select {
+		case f = <-r.Frames():
+		case <-timeout:
+			t.Fatalf("frame %d not received", i)
+		}
+		want := h264.SplitAnnexB(accessUnit(i, i == 2))
+		got := h264.SplitAnnexB(f.Data)
+		if len(got) != len(want) || f.Key != (i == 2) {
+			t.Fatalf("frame %d: %d nal units, key %v", i, len(got), f.Key)
+		}
+		for j := range want {
+			if !bytes.Equal(got[j], want[j]) {
+				t.Fatalf("frame %d: nal unit %d differs", i, j)
+			}
+		}
+		if d := f.Timestamp - last; i > 2 && (d < 2999 || d > 3001) { // 1/30s rounded
+			t.Fatalf("frame %d: %d after the previous one", i, f.Timestamp-last)
+		}
+		last = f.Timestamp
+	}
+}
+
+func TestSignaling(t *testing.T) {
+	s, err := NewSender(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/", bytes.NewReader([]byte("not sdp"))))
+	if w.Code != 400 {
+		t.Fatalf("bad offer: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !bytes.Contains(w.Body.Bytes(), []byte("RTCPeerConnection")) {
+		t.Fatal("no viewer page")
+	}
+}
@@ -0,0 +1,316 @@
+package rtc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	peerQueue       = 1 << 6
+	maxOfferSize    = 1 << 16
+	defaultDuration = time.Second / 30
+)
+
+type frame struct {
+	data     []byte
+	key      bool
+	captured time.Time
+}
+
+// peer is a peer connection of the sender, it starts on a key frame and waits for one after a drop
+type peer struct {
+	pc        *webrtc.PeerConnection
+	track     *webrtc.TrackLocalStaticSample
+	frames    chan frame
+	waitKey   bool
+	connected bool
+	dropped   uint64
+}
+
+// Sender answers the offers of the peers and sends them the frames of WriteFrame, it is the
+// http.Handler of the signaling and of a viewer page
+type Sender struct {
+	OnKeyFrameRequest func() // called on the PLI and FIR of the peers, set before the first offer
+
+	mu     sync.Mutex
+	api    *webrtc.API
+	ps     h264.ParameterSets
+	peers  map[*peer]struct{}
+	closed bool
+}
+
+func NewSender(cfg Config) (*Sender, error) {
+	var settings webrtc.SettingEngine
+	settings.SetLite(true)
+	settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6})
+	settings.SetIncludeLoopbackCandidate(true)
+	if cfg.PortMin != 0 || cfg.PortMax != 0 {
+		if err := settings.SetEphemeralUDPPortRange(cfg.PortMin, cfg.PortMax); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.PublicIP) > 0 {
+		settings.SetNAT1To1IPs(cfg.PublicIP, webrtc.ICECandidateTypeHost)
+	}
+	api, err := newAPI(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &Sender{api: api, peers: make(map[*peer]struct{})}, nil
+}
+
+// Answer the SDP offer of a peer, the answer has all the candidates
+func (s *Sender) Answer(offer string) (string, error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return "", ErrClosed
+	}
+
+	pc, err := s.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return "", err
+	}
+	answer, p, err := s.negotiate(pc, offer)
+	if err != nil {
+		pc.Close()
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		pc.Close()
+		return "", ErrClosed
+	}
+	s.peers[p] = struct{}{}
+	go s.send(p)
+	return answer, nil
+}
+
+func (s *Sender) negotiate(pc *webrtc.PeerConnection, offer string) (string, *peer, error) {
+	track, err := webrtc.NewTrackLocalStaticSample(h264Codec, "video", "video")
+	if err != nil {
+		return "", nil, err
+	}
+	rtpSender, err := pc.AddTrack(track)
+	if err != nil {
+		return "", nil, err
+	}
+	p := &peer{pc: pc, track: track, frames: make(chan frame, peerQueue), waitKey: true}
+	go s.readRTCP(rtpSender)
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			s.mu.Lock()
+			p.connected = true
+			s.mu.Unlock()
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			s.remove(p)
+		}
+	})
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", nil, err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", nil, err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", nil, err
+	}
+	<-gathered
+	return pc.LocalDescription().SDP, p, nil
+}
+
+// readRTCP pass the key frame requests of the peer on, the interceptors handle the rest
+func (s *Sender) readRTCP(rtpSender *webrtc.RTPSender) {
+	for {
+		packets, _, err := rtpSender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, pkt := range packets {
+			switch pkt.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				if s.OnKeyFrameRequest != nil {
+					s.OnKeyFrameRequest()
+				}
+			}
+		}
+	}
+}
+
+// send the frames of the peer, the track packetizes them
+func (s *Sender) send(p *peer) {
+	var last time.Time
+	for f := range p.frames {
+		duration := defaultDuration
+		if !last.IsZero() && f.captured.After(last) {
+			duration = f.captured.Sub(last)
+		}
+		last = f.captured
+		if err := p.track.WriteSample(media.Sample{Data: f.data, Duration: duration}); err != nil {
+			s.remove(p)
+		}
+	}
+}
+
+func (s *Sender) remove(p *peer) {
+	s.mu.Lock()
+	_, ok := s.peers[p]
+	if ok {
+		delete(s.peers, p)
+		close(p.frames)
+	}
+	s.mu.Unlock()
+	if ok {
+		p.pc.Close()
+	}
+}
+
+// WriteFrame send an Annex B access unit captured at t to the connected peers without blocking
+func (s *Sender) WriteFrame(data []byte, captured time.Time) {
+	f := frame{data: append([]byte(nil), data...), captured: captured}
+	f.key = h264.IsKeyFrame(f.data)
+	if f.captured.IsZero() {
+		f.captured = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ps.Update(f.data)
+	for p := range s.peers {
+		if !p.connected {
+			continue
+		}
+		pf := f
+		if p.waitKey {
+			if !f.key {
+				p.dropped++
+				continue
+			}
+			// the decoder of the peer may have no SPS/PPS yet
+			pf.data = s.ps.Prepend(f.data)
+		}
+		select {
+		case p.frames <- pf:
+			p.waitKey = false
+		default:
+			p.waitKey = true
+			p.dropped++
+		}
+	}
+}
+
+// Peers return the number of connected peers
+func (s *Sender) Peers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for p := range s.peers {
+		if p.connected {
+			n++
+		}
+	}
+	return n
+}
+
+// ServeHTTP answer an offer posted as application/sdp with 201 and the answer, and serve the
+// viewer page otherwise
+func (s *Sender) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(viewerPage))
+	case http.MethodPost:
+		offer, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxOfferSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		answer, err := s.Answer(string(offer))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/sdp")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(answer))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Close the peer connections
+func (s *Sender) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var peers []*peer
+	for p := range s.peers {
+		peers = append(peers, p)
+		delete(s.peers, p)
+		close(p.frames)
+	}
+	s.mu.Unlock()
+	for _, p := range peers {
+		p.pc.Close()
+	}
+	return nil
+}
+
+// viewerPage offers to receive the video at its own url and plays it
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>video</title>
+</head>
+<body style="margin:0;background:#000">
+<video id="video" controls autoplay muted playsinline style="width:100%;height:100vh"></video>
+<script>
+var video = document.getElementById('video');
+var pc = new RTCPeerConnection();
+pc.addTransceiver('video', {direction: 'recvonly'});
+pc.ontrack = function (e) {
+	video.srcObject = e.streams.length ? e.streams[0] : new MediaStream([e.track]);
+};
+function gathered() {
+	return new Promise(function (resolve) {
+		if (pc.iceGatheringState === 'complete') {
+			resolve();
+			return;
+		}
+		pc.addEventListener('icegatheringstatechange', function () {
+			if (pc.iceGatheringState === 'complete') {
+				resolve();
+			}
+		});
+		setTimeout(resolve, 2000);
+	});
+}
+pc.createOffer().then(function (offer) {
+	return pc.setLocalDescription(offer);
+}).then(gathered).then(function () {
+	return fetch(location.pathname, {method: 'POST', headers: {'Content-Type': 'application/sdp'}, body: pc.localDescription.sdp});
+}).then(function (resp) {
+	return resp.text();
+}).then(function (answer) {
+	return pc.setRemoteDescription({type: 'answer', sdp: answer});
+});
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,178 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// Object is an AMF0 object, its values are float64, bool, string, Object or nil
+type Object map[string]interface{}
+
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+var ErrBadAMF = errors.New("rtmp: bad amf0 value")
+
+// appendAMF append the AMF0 encoding of the values, an int is a number
+func appendAMF(b []byte, values ...interface{}) []byte {
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			b = append(b, amfNull)
+		case int:
+			b = appendAMF(b, float64(v))
+		case float64:
+			b = append(b, amfNumber, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.BigEndian.PutUint64(b[len(b)-8:], math.Float64bits(v))
+		case bool:
+			b = append(b, amfBoolean, 0)
+			if v {
+				b[len(b)-1] = 1
+			}
+		case string:
+			if len(v) > math.MaxUint16 {
+				b = append(b, amfLongString, 0, 0, 0, 0)
+				binary.BigEndian.PutUint32(b[len(b)-4:], uint32(len(v)))
+			} else {
+				b = append(b, amfString, byte(len(v)>>8), byte(len(v)))
+			}
+			b = append(b, v...)
+		case Object:
+			b = append(b, amfObject)
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				b = append(b, byte(len(k)>>8), byte(len(k)))
+				b = append(b, k...)
+				b = appendAMF(b, v[k])
+			}
+			b = append(b, 0, 0, amfObjectEnd)
+		default:
+			b = append(b, amfUndefined)
+		}
+	}
+	return b
+}
+
+// readAMF decode all the values of b, the arrays are decoded as objects and lists
+func readAMF(b []byte) ([]interface{}, error) {
+	var values []interface{}
+	for len(b) > 0 {
+		v, rest, err := readAMFValue(b)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		b = rest
+	}
+	return values, nil
+}
+
+func readAMFValue(b []byte) (interface{}, []byte, error) {
+	if len(b) < 1 {
+		return nil, nil, ErrBadAMF
+	}
+	marker, b := b[0], b[1:]
+	switch marker {
+	case amfNumber:
+		if len(b) < 8 {
+			return nil, nil, ErrBadAMF
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+	case amfBoolean:
+		if len(b) < 1 {
+			return nil, nil, ErrBadAMF
+		}
+		return b[0] != 0, b[1:], nil
+	case amfString:
+		return readAMFString(b, 2)
+	case amfLongString:
+		return readAMFString(b, 4)
+	case amfNull, amfUndefined:
+		return nil, b, nil
+	case amfObject:
+		return readAMFObject(b)
+	case amfECMAArray:
+		if len(b) < 4 {
+			return nil, nil, ErrBadAMF
+		}
+		return readAMFObject(b[4:]) // the count is a hint, the end marker ends it
+	case amfStrictArray:
+		if len(b) < 4 {
+			return nil, nil, ErrBadAMF
+		}
+		n := int(binary.BigEndian.Uint32(b))
+		b = b[4:]
+		var list []interface{}
+		for i := 0; i < n; i++ {
+			v, rest, err := readAMFValue(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, v)
+			b = rest
+		}
+		return list, b, nil
+	case amfDate:
+		if len(b) < 10 {
+			return nil, nil, ErrBadAMF
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), b[10:], nil
+	}
+	return nil, nil, ErrBadAMF
+}
+
+func readAMFString(b []byte, lengthSize int) (interface{}, []byte, error) {
+	if len(b) < lengthSize {
+		return nil, nil, ErrBadAMF
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if lengthSize == 4 {
+		n = int(binary.BigEndian.Uint32(b))
+	}
+	b = b[lengthSize:]
+	if n > len(b) {
+		return nil, nil, ErrBadAMF
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+func readAMFObject(b []byte) (interface{}, []byte, error) {
+	obj := Object{}
+	for {
+		if len(b) < 3 {
+			return nil, nil, ErrBadAMF
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		if n == 0 && b[2] == amfObjectEnd {
+			return obj, b[3:], nil
+		}
+		b = b[2:]
+		if n > len(b) {
+			return nil, nil, ErrBadAMF
+		}
+		key := string(b[:n])
+		v, rest, err := readAMFValue(b[n:])
+		if err != nil {
+			return nil, nil, err
+		}
+		obj[key] = v
+		b = rest
+	}
+}
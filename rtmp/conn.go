@@ -0,0 +1,394 @@
+// Package rtmp publishes the H.264 stream to RTMP servers and takes the streams published by
+// RTMP encoders, the video in FLV tags without audio
+package rtmp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// message types
+const (
+	TypeSetChunkSize     = 1
+	TypeAbort            = 2
+	TypeAck              = 3
+	TypeUserControl      = 4
+	TypeWindowAckSize    = 5
+	TypeSetPeerBandwidth = 6
+	TypeAudio            = 8
+	TypeVideo            = 9
+	TypeDataAMF3         = 15
+	TypeCommandAMF3      = 17
+	TypeDataAMF0         = 18
+	TypeCommandAMF0      = 20
+)
+
+// chunk stream ids of the messages we send
+const (
+	csidControl = 2
+	csidCommand = 3
+	csidVideo   = 6
+)
+
+const (
+	DefaultPort      = "1935"
+	handshakeSize    = 1536
+	defaultChunkSize = 128
+	chunkSize        = 4096 // of our messages
+	maxMessageSize   = 1 << 24
+	windowAckSize    = 2500000
+	extendedTime     = 0xffffff
+
+	userControlPingRequest  = 6
+	userControlPingResponse = 7
+)
+
+var (
+	ErrBadHandshake = errors.New("rtmp: bad handshake")
+	ErrBadChunk     = errors.New("rtmp: bad chunk")
+)
+
+// Message is a message of the chunk stream, the timestamp is in ms
+type Message struct {
+	Type      uint8
+	StreamID  uint32
+	Timestamp uint32
+	Payload   []byte
+}
+
+// chunkStream is the header state of a chunk stream id on read
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typ       uint8
+	streamID  uint32
+	extended  bool
+	payload   []byte // of the message being read
+}
+
+// conn reads and writes the messages of an RTMP connection, and answers the protocol control
+type conn struct {
+	net.Conn
+	r         *bufio.Reader
+	wmu       sync.Mutex
+	w         *bufio.Writer
+	readSize  int
+	writeSize int
+	streams   map[uint32]*chunkStream
+	window    uint32 // the peer wants an ack every window bytes
+	received  uint32
+	acked     uint32
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{
+		Conn:      c,
+		r:         bufio.NewReaderSize(c, 1<<14),
+		w:         bufio.NewWriterSize(c, 1<<14),
+		readSize:  defaultChunkSize,
+		writeSize: defaultChunkSize,
+		streams:   make(map[uint32]*chunkStream),
+	}
+}
+
+// clientHandshake do the plain handshake of version 3, the servers accept it without digest
+func (c *conn) clientHandshake() error {
+	c.SetDeadline(time.Now().Add(DefaultTimeout))
+	defer c.SetDeadline(time.Time{})
+	c0c1 := make([]byte, 1+handshakeSize)
+	c0c1[0] = 3
+	rand.Read(c0c1[9:])
+	if _, err := c.Write(c0c1); err != nil {
+		return err
+	}
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	if _, err := io.ReadFull(c.r, s0s1s2); err != nil {
+		return err
+	}
+	if s0s1s2[0] != 3 {
+		return ErrBadHandshake
+	}
+	_, err := c.Write(s0s1s2[1 : 1+handshakeSize]) // c2 echoes s1
+	return err
+}
+
+func (c *conn) serverHandshake() error {
+	c.SetDeadline(time.Now().Add(DefaultTimeout))
+	defer c.SetDeadline(time.Time{})
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(c.r, c0c1); err != nil {
+		return err
+	}
+	if c0c1[0] != 3 {
+		return ErrBadHandshake
+	}
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = 3
+	rand.Read(s0s1s2[9 : 1+handshakeSize])
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:]) // s2 echoes c1
+	if _, err := c.Write(s0s1s2); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(c.r, make([]byte, handshakeSize))
+	return err
+}
+
+// ReadMessage return the next message, the protocol control is handled here
+func (c *conn) ReadMessage() (*Message, error) {
+	for {
+		m, err := c.readChunks()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.ack(); err != nil {
+			return nil, err
+		}
+		switch m.Type {
+		case TypeSetChunkSize:
+			if len(m.Payload) < 4 {
+				return nil, ErrBadChunk
+			}
+			size := binary.BigEndian.Uint32(m.Payload) & 0x7fffffff
+			if size < 1 || size > maxMessageSize {
+				return nil, ErrBadChunk
+			}
+			c.readSize = int(size)
+		case TypeAbort:
+			if len(m.Payload) >= 4 {
+				if cs := c.streams[binary.BigEndian.Uint32(m.Payload)]; cs != nil {
+					cs.payload = nil
+				}
+			}
+		case TypeWindowAckSize:
+			if len(m.Payload) >= 4 {
+				c.window = binary.BigEndian.Uint32(m.Payload)
+			}
+		case TypeUserControl:
+			if len(m.Payload) >= 6 && binary.BigEndian.Uint16(m.Payload) == userControlPingRequest {
+				resp := append([]byte{0, userControlPingResponse}, m.Payload[2:6]...)
+				if err := c.WriteMessage(csidControl, &Message{Type: TypeUserControl, Payload: resp}); err != nil {
+					return nil, err
+				}
+			}
+		case TypeAck, TypeSetPeerBandwidth:
+		default:
+			return m, nil
+		}
+	}
+}
+
+// ack the received bytes once a window is full
+func (c *conn) ack() error {
+	if c.window == 0 || c.received-c.acked < c.window {
+		return nil
+	}
+	c.acked = c.received
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, c.received)
+	return c.WriteMessage(csidControl, &Message{Type: TypeAck, Payload: b})
+}
+
+func (c *conn) read(b []byte) error {
+	n, err := io.ReadFull(c.r, b)
+	c.received += uint32(n)
+	return err
+}
+
+// readChunks read chunks until a message is complete
+func (c *conn) readChunks() (*Message, error) {
+	b := make([]byte, 11)
+	for {
+		if err := c.read(b[:1]); err != nil {
+			return nil, err
+		}
+		format := b[0] >> 6
+		csid := uint32(b[0] & 0x3f)
+		switch csid {
+		case 0:
+			if err := c.read(b[:1]); err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(b[0])
+		case 1:
+			if err := c.read(b[:2]); err != nil {
+				return nil, err
+			}
+			csid = 64 + uint32(b[0]) + uint32(b[1])<<8
+		}
+		cs := c.streams[csid]
+		if cs == nil {
+			if format != 0 {
+				return nil, fmt.Errorf("rtmp: chunk stream %d starts with format %d", csid, format)
+			}
+			cs = &chunkStream{}
+			c.streams[csid] = cs
+		}
+
+		headerSize := [4]int{11, 7, 3, 0}[format]
+		if err := c.read(b[:headerSize]); err != nil {
+			return nil, err
+		}
+		var field uint32
+		if format < 3 {
+			field = uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+			cs.extended = field == extendedTime
+		}
+		if format < 2 {
+			cs.length = uint32(b[3])<<16 | uint32(b[4])<<8 | uint32(b[5])
+			cs.typ = b[6]
+			if cs.length > maxMessageSize {
+				return nil, ErrBadChunk
+			}
+		}
+		if format == 0 {
+			cs.streamID = binary.LittleEndian.Uint32(b[7:])
+		}
+		if cs.extended {
+			if err := c.read(b[:4]); err != nil {
+				return nil, err
+			}
+			if format < 3 {
+				field = binary.BigEndian.Uint32(b)
+			}
+		}
+		switch {
+		case format == 0:
+			cs.timestamp, cs.delta = field, 0
+		case format < 3:
+			cs.delta = field
+			cs.timestamp += field
+		case cs.payload == nil:
+			cs.timestamp += cs.delta // a new message of the same header
+		}
+
+		if cs.payload == nil {
+			cs.payload = make([]byte, 0, cs.length)
+		}
+		n := int(cs.length) - len(cs.payload)
+		if n > c.readSize {
+			n = c.readSize
+		}
+		start := len(cs.payload)
+		cs.payload = cs.payload[:start+n]
+		if err := c.read(cs.payload[start:]); err != nil {
+			return nil, err
+		}
+		if len(cs.payload) == int(cs.length) {
+			m := &Message{Type: cs.typ, StreamID: cs.streamID, Timestamp: cs.timestamp, Payload: cs.payload}
+			cs.payload = nil
+			return m, nil
+		}
+	}
+}
+
+// WriteMessage write a message in chunks, a full header in the first one
+func (c *conn) WriteMessage(csid uint32, m *Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	ts := m.Timestamp
+	extended := ts >= extendedTime
+	if extended {
+		ts = extendedTime
+	}
+	header := []byte{
+		byte(csid),
+		byte(ts >> 16), byte(ts >> 8), byte(ts),
+		byte(len(m.Payload) >> 16), byte(len(m.Payload) >> 8), byte(len(m.Payload)),
+		m.Type,
+		0, 0, 0, 0,
+	}
+	binary.LittleEndian.PutUint32(header[8:], m.StreamID)
+	ext := make([]byte, 4)
+	binary.BigEndian.PutUint32(ext, m.Timestamp)
+
+	payload := m.Payload
+	for first := true; first || len(payload) > 0; first = false {
+		if first {
+			c.w.Write(header)
+		} else {
+			c.w.WriteByte(3<<6 | byte(csid))
+		}
+		if extended {
+			c.w.Write(ext)
+		}
+		n := len(payload)
+		if n > c.writeSize {
+			n = c.writeSize
+		}
+		c.w.Write(payload[:n])
+		payload = payload[n:]
+	}
+	return c.w.Flush()
+}
+
+// setChunkSize tell the peer the size of our chunks
+func (c *conn) setChunkSize(size int) error {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(size))
+	if err := c.WriteMessage(csidControl, &Message{Type: TypeSetChunkSize, Payload: b}); err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	c.writeSize = size
+	c.wmu.Unlock()
+	return nil
+}
+
+// writeCommand write an AMF0 command: name, transaction id, arguments
+func (c *conn) writeCommand(streamID uint32, name string, txn int, args ...interface{}) error {
+	payload := appendAMF(nil, name, txn)
+	payload = appendAMF(payload, args...)
+	return c.WriteMessage(csidCommand, &Message{Type: TypeCommandAMF0, StreamID: streamID, Payload: payload})
+}
+
+// command is a decoded command message
+type command struct {
+	name string
+	txn  float64
+	args []interface{} // after the transaction id, the first is the command object or nil
+}
+
+func parseCommand(m *Message) (*command, error) {
+	payload := m.Payload
+	if m.Type == TypeCommandAMF3 && len(payload) > 0 {
+		payload = payload[1:] // AMF0 after the format byte
+	}
+	values, err := readAMF(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) < 2 {
+		return nil, ErrBadAMF
+	}
+	name, _ := values[0].(string)
+	txn, _ := values[1].(float64)
+	return &command{name: name, txn: txn, args: values[2:]}, nil
+}
+
+// arg return the string argument i, "" if absent
+func (cmd *command) arg(i int) string {
+	if i >= len(cmd.args) {
+		return ""
+	}
+	s, _ := cmd.args[i].(string)
+	return s
+}
+
+// status return the code and level of an onStatus or _error info object
+func (cmd *command) status() (code, level string) {
+	for _, a := range cmd.args {
+		if obj, ok := a.(Object); ok {
+			code, _ = obj["code"].(string)
+			level, _ = obj["level"].(string)
+		}
+	}
+	return code, level
+}
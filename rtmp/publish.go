@@ -0,0 +1,207 @@
+package rtmp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+)
+
+const (
+	DefaultTimeout = 10 * time.Second // of the handshake and of each command
+
+	codecAVC        = 7
+	frameKey        = 1
+	frameInter      = 2
+	avcSequenceHead = 0
+	avcNALU         = 1
+	avcEndSequence  = 2
+)
+
+var ErrNotStarted = errors.New("rtmp: waiting for a key frame with SPS/PPS")
+
+// Publisher sends an H.264 stream to an RTMP server, rtmp://host:port/app/key
+type Publisher struct {
+	c        *conn
+	app      string
+	key      string
+	streamID uint32
+	ps       h264.ParameterSets
+	started  bool
+	start    time.Time
+	last     uint32 // timestamp of the last frame
+}
+
+// Publish connect to the server and start publishing the stream key of the url
+func Publish(rawurl string) (*Publisher, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if u.Scheme != "rtmp" || i <= 0 {
+		return nil, fmt.Errorf("rtmp: url %q is not rtmp://host/app/key", rawurl)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), DefaultPort)
+	}
+	nc, err := net.DialTimeout("tcp", host, DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p := &Publisher{c: newConn(nc), app: path[:i], key: path[i+1:]}
+	if u.RawQuery != "" {
+		p.key += "?" + u.RawQuery // the token of some services
+	}
+	tcURL := fmt.Sprintf("rtmp://%s/%s", u.Host, p.app)
+	if err := p.connect(tcURL); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	go p.drain()
+	return p, nil
+}
+
+// connect, create the stream and publish it, the commands of ffmpeg and OBS
+func (p *Publisher) connect(tcURL string) error {
+	if err := p.c.clientHandshake(); err != nil {
+		return err
+	}
+	if err := p.c.setChunkSize(chunkSize); err != nil {
+		return err
+	}
+	if err := p.c.writeCommand(0, "connect", 1, Object{
+		"app":      p.app,
+		"type":     "nonprivate",
+		"flashVer": "FMLE/3.0 (compatible; video)",
+		"tcUrl":    tcURL,
+	}); err != nil {
+		return err
+	}
+	if _, err := p.wait(1); err != nil {
+		return err
+	}
+	p.c.writeCommand(0, "releaseStream", 2, nil, p.key)
+	p.c.writeCommand(0, "FCPublish", 3, nil, p.key)
+	if err := p.c.writeCommand(0, "createStream", 4, nil); err != nil {
+		return err
+	}
+	result, err := p.wait(4)
+	if err != nil {
+		return err
+	}
+	if len(result.args) < 2 {
+		return fmt.Errorf("rtmp: createStream without stream id")
+	}
+	id, _ := result.args[1].(float64)
+	p.streamID = uint32(id)
+	if err := p.c.writeCommand(p.streamID, "publish", 5, nil, p.key, "live"); err != nil {
+		return err
+	}
+	_, err = p.wait(0)
+	return err
+}
+
+// wait the _result of the transaction, or the onStatus of publish for 0
+func (p *Publisher) wait(txn int) (*command, error) {
+	p.c.SetReadDeadline(time.Now().Add(DefaultTimeout))
+	defer p.c.SetReadDeadline(time.Time{})
+	for {
+		m, err := p.c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if m.Type != TypeCommandAMF0 && m.Type != TypeCommandAMF3 {
+			continue
+		}
+		cmd, err := parseCommand(m)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case cmd.name == "_error" && int(cmd.txn) == txn:
+			code, _ := cmd.status()
+			return nil, fmt.Errorf("rtmp: command %d failed: %s", txn, code)
+		case cmd.name == "_result" && int(cmd.txn) == txn && txn != 0:
+			return cmd, nil
+		case cmd.name == "onStatus" && txn == 0:
+			code, level := cmd.status()
+			if level == "error" || code != "NetStream.Publish.Start" {
+				return nil, fmt.Errorf("rtmp: publish %s: %s", p.key, code)
+			}
+			return cmd, nil
+		}
+	}
+}
+
+// drain read the messages of the server while publishing, the control is answered by ReadMessage
+func (p *Publisher) drain() {
+	for {
+		if _, err := p.c.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// WriteFrame send an Annex B access unit captured at t, the stream starts on a key frame with SPS/PPS
+func (p *Publisher) WriteFrame(au []byte, t time.Time) error {
+	changed := p.ps.Update(au)
+	if !p.started {
+		if !h264.IsKeyFrame(au) || !p.ps.Complete() {
+			return ErrNotStarted
+		}
+		p.started, p.start = true, t
+		if err := p.writeMetadata(); err != nil {
+			return err
+		}
+	}
+	ts := uint32(t.Sub(p.start) / time.Millisecond)
+	p.last = ts
+	if changed {
+		tag := append([]byte{frameKey<<4 | codecAVC, avcSequenceHead, 0, 0, 0}, p.ps.DecoderConfig()...)
+		if err := p.c.WriteMessage(csidVideo, &Message{Type: TypeVideo, StreamID: p.streamID, Timestamp: ts, Payload: tag}); err != nil {
+			return err
+		}
+	}
+
+	frameType := byte(frameInter)
+	if h264.IsKeyFrame(au) {
+		frameType = frameKey
+	}
+	tag := []byte{frameType<<4 | codecAVC, avcNALU, 0, 0, 0} // no composition offset, the encoder has no b-frames
+	for _, n := range h264.SplitAnnexB(au) {
+		switch h264.NALType(n) {
+		case h264.NALSPS, h264.NALPPS, h264.NALAUD:
+			continue // in the sequence header
+		}
+		tag = h264.AppendAVCC(tag, n)
+	}
+	return p.c.WriteMessage(csidVideo, &Message{Type: TypeVideo, StreamID: p.streamID, Timestamp: ts, Payload: tag})
+}
+
+// writeMetadata send onMetaData with the size of the SPS
+func (p *Publisher) writeMetadata() error {
+	meta := Object{"videocodecid": codecAVC}
+	if sps, err := h264.ParseSPS(p.ps.SPS); err == nil {
+		meta["width"], meta["height"] = sps.Width, sps.Height
+	}
+	payload := appendAMF(nil, "@setDataFrame", "onMetaData", meta)
+	return p.c.WriteMessage(csidCommand, &Message{Type: TypeDataAMF0, StreamID: p.streamID, Payload: payload})
+}
+
+// Close end the stream and the connection
+func (p *Publisher) Close() error {
+	if p.started {
+		tag := []byte{frameKey<<4 | codecAVC, avcEndSequence, 0, 0, 0}
+		p.c.WriteMessage(csidVideo, &Message{Type: TypeVideo, StreamID: p.streamID, Timestamp: p.last, Payload: tag})
+	}
+	p.c.writeCommand(0, "FCUnpublish", 6, nil, p.key)
+	p.c.writeCommand(0, "deleteStream", 7, nil, float64(p.streamID))
+	return p.c.Close()
+}
@@ -0,0 +1,128 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+)
+
+var (
+	sps = []byte{0x67, 0x42, 0xc0, 0x1f, 0xda, 0x01, 0x40, 0x16, 0xe8}
+	pps = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+func accessUnit(i, size int, key bool) []byte {
+	var au []byte
+	nalType := byte(0x41)
+	if key {
+		au = h264.AppendAnnexB(au, sps, pps)
+		nalType = 0x65
+	}
+	body := bytes.Repeat([]byte{byte(i) | 1}, size)
+	body[0] = nalType
+	return h264.AppendAnnexB(au, body)
+}
+
+func TestAMF(t *testing.T) {
+	values := []interface{}{"connect", 1.0, Object{"app": "live", "nested": Object{"ok": true}}, nil, "x"}
+	got, err := readAMF(appendAMF(nil, values...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Fatalf("got %#v, want %#v", got, values)
+	}
+	if _, err := readAMF([]byte{amfString, 0, 5, 'a'}); err != ErrBadAMF {
+		t.Fatalf("short string: %v", err)
+	}
+}
+
+func TestChunks(t *testing.T) {
+	a, b := net.Pipe()
+	w, r := newConn(a), newConn(b)
+	messages := []*Message{
+		{Type: TypeVideo, StreamID: 1, Timestamp: 40, Payload: bytes.Repeat([]byte{1}, 300)},
+		{Type: TypeVideo, StreamID: 1, Timestamp: 0x1000000, Payload: bytes.Repeat([]byte{2}, 5000)}, // extended
+		{Type: TypeCommandAMF0, StreamID: 0, Timestamp: 0, Payload: appendAMF(nil, "ping", 3)},
+	}
+	go func() {
+		w.setChunkSize(256)
+		for _, m := range messages {
+			w.WriteMessage(csidVideo, m)
+		}
+	}()
+	for _, want := range messages {
+		m, err := r.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != want.Type || m.StreamID != want.StreamID || m.Timestamp != want.Timestamp || !bytes.Equal(m.Payload, want.Payload) {
+			t.Fatalf("got type %d stream %d at %d with %d bytes, want %+v", m.Type, m.StreamID, m.Timestamp, len(m.Payload), want.Timestamp)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := make(chan *Frame, 64)
+	keys := make(chan string, 1)
+	s := NewServer(func(st *Stream) {
+		keys <- st.App + "/" + st.Key
+		defer close(frames)
+		for {
+			f, err := st.ReadFrame()
+			if err != nil {
+				if err != io.EOF {
+					t.Error(err)
+				}
+				return
+			}
+			frames <- f
+		}
+	})
+	go s.Serve(l)
+	defer s.Close()
+
+	p, err := Publish("rtmp://" + l.Addr().String() + "/live/cam1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := <-keys; key != "live/cam1" {
+		t.Fatalf("published %q", key)
+	}
+	start := time.Now()
+	if err := p.WriteFrame(accessUnit(0, 100, false), start); err != ErrNotStarted {
+		t.Fatalf("inter frame before the key frame: %v", err)
+	}
+	var sent [][]byte
+	for i := 0; i < 20; i++ {
+		au := accessUnit(i, 1000*(i%3)+10000, i%10 == 0)
+		if err := p.WriteFrame(au, start.Add(time.Duration(i)*40*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, au)
+	}
+	p.Close()
+
+	n := 0
+	for f := range frames {
+		if !bytes.Equal(f.Data, sent[n]) {
+			t.Fatalf("frame %d: %d bytes, want %d", n, len(f.Data), len(sent[n]))
+		}
+		if f.Key != (n%10 == 0) || f.Timestamp != time.Duration(n)*40*time.Millisecond {
+			t.Fatalf("frame %d: key %v at %v", n, f.Key, f.Timestamp)
+		}
+		n++
+	}
+	if n != len(sent) {
+		t.Fatalf("%d frames, want %d", n, len(sent))
+	}
+}
@@ -0,0 +1,232 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+)
+
+// Frame is an Annex B access unit of a published stream, a key frame has its SPS/PPS
+type Frame struct {
+	Data      []byte
+	Key       bool
+	Timestamp time.Duration // since the start of the stream, of the publisher
+}
+
+// Server takes the streams published by RTMP encoders like ffmpeg and OBS, each is given to the
+// handler, the connection closes when it returns
+type Server struct {
+	handler func(*Stream)
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+}
+
+func NewServer(handler func(*Stream)) *Server {
+	return &Server{handler: handler}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(newConn(c))
+	}
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveConn(c *conn) {
+	defer c.Close()
+	if err := c.serverHandshake(); err != nil {
+		log.Printf("rtmp %v: handshake error: %v", c.RemoteAddr(), err)
+		return
+	}
+	stream, err := accept(c)
+	if err != nil {
+		if err != io.EOF {
+			log.Printf("rtmp %v: %v", c.RemoteAddr(), err)
+		}
+		return
+	}
+	log.Printf("rtmp %v: publish %s/%s", c.RemoteAddr(), stream.App, stream.Key)
+	s.handler(stream)
+}
+
+// Stream is a stream published to the server
+type Stream struct {
+	App string
+	Key string
+
+	c          *conn
+	streamID   uint32
+	ps         h264.ParameterSets
+	lengthSize int
+}
+
+// accept answer the commands of the publisher until it publishes
+func accept(c *conn) (*Stream, error) {
+	s := &Stream{c: c, streamID: 1}
+	c.SetReadDeadline(time.Now().Add(DefaultTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	for {
+		m, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if m.Type != TypeCommandAMF0 && m.Type != TypeCommandAMF3 {
+			continue
+		}
+		cmd, err := parseCommand(m)
+		if err != nil {
+			return nil, err
+		}
+		txn := int(cmd.txn)
+		switch cmd.name {
+		case "connect":
+			if len(cmd.args) > 0 {
+				if obj, ok := cmd.args[0].(Object); ok {
+					s.App, _ = obj["app"].(string)
+				}
+			}
+			if err := s.control(TypeWindowAckSize, windowAckSize); err != nil {
+				return nil, err
+			}
+			if err := s.control(TypeSetPeerBandwidth, windowAckSize, 2); err != nil {
+				return nil, err
+			}
+			if err := c.setChunkSize(chunkSize); err != nil {
+				return nil, err
+			}
+			if err := c.writeCommand(0, "_result", txn,
+				Object{"fmsVer": "FMS/3,0,1,123", "capabilities": 31},
+				Object{"level": "status", "code": "NetConnection.Connect.Success", "description": "Connection succeeded.", "objectEncoding": 0},
+			); err != nil {
+				return nil, err
+			}
+		case "releaseStream", "FCPublish":
+			if err := c.writeCommand(0, "_result", txn, nil); err != nil {
+				return nil, err
+			}
+		case "createStream":
+			if err := c.writeCommand(0, "_result", txn, nil, int(s.streamID)); err != nil {
+				return nil, err
+			}
+		case "publish":
+			s.Key = cmd.arg(1)
+			if err := c.writeCommand(m.StreamID, "onStatus", 0, nil, Object{
+				"level":       "status",
+				"code":        "NetStream.Publish.Start",
+				"description": "Start publishing " + s.Key,
+			}); err != nil {
+				return nil, err
+			}
+			return s, nil
+		case "play":
+			c.writeCommand(m.StreamID, "onStatus", 0, nil, Object{
+				"level": "error",
+				"code":  "NetStream.Play.Failed",
+			})
+			return nil, io.EOF
+		}
+	}
+}
+
+// control write a protocol control message of 32 bit values, the last may be a byte
+func (s *Stream) control(typ uint8, value uint32, limit ...byte) error {
+	b := make([]byte, 4, 5)
+	binary.BigEndian.PutUint32(b, value)
+	b = append(b, limit...)
+	return s.c.WriteMessage(csidControl, &Message{Type: typ, Payload: b})
+}
+
+// ReadFrame return the next access unit, io.EOF when the publisher stops
+func (s *Stream) ReadFrame() (*Frame, error) {
+	for {
+		m, err := s.c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch m.Type {
+		case TypeCommandAMF0, TypeCommandAMF3:
+			if cmd, err := parseCommand(m); err == nil {
+				switch cmd.name {
+				case "FCUnpublish", "deleteStream", "closeStream":
+					return nil, io.EOF
+				}
+			}
+			continue
+		case TypeVideo:
+		default:
+			continue // audio, metadata
+		}
+		if len(m.Payload) < 5 || m.Payload[0]&0x0f != codecAVC {
+			continue
+		}
+		switch m.Payload[1] {
+		case avcSequenceHead:
+			ps, lengthSize, err := h264.ParseDecoderConfig(m.Payload[5:])
+			if err != nil {
+				return nil, err
+			}
+			s.ps, s.lengthSize = ps, lengthSize
+			continue
+		case avcEndSequence:
+			return nil, io.EOF
+		}
+		if s.lengthSize == 0 {
+			continue // no sequence header yet
+		}
+		nalus, err := h264.SplitAVCC(m.Payload[5:], s.lengthSize)
+		if err != nil {
+			return nil, err
+		}
+		f := &Frame{
+			Data:      h264.AppendAnnexB(nil, nalus...),
+			Key:       m.Payload[0]>>4 == frameKey,
+			Timestamp: time.Duration(m.Timestamp) * time.Millisecond,
+		}
+		if f.Key {
+			f.Data = s.ps.Prepend(f.Data)
+		}
+		return f, nil
+	}
+}
+
+// Close the connection of the publisher
+func (s *Stream) Close() error {
+	return s.c.Close()
+}
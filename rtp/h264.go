@@ -0,0 +1,163 @@
+package rtp
+
+import (
+	"github.com/l-f-h/video/h264"
+)
+
+const (
+	PayloadTypeH264 = 96 // dynamic, announced by the sdp
+
+	naluSTAPA = 24
+	naluFUA   = 28
+)
+
+// H264Packetizer cut access units to rtp packets, a small NAL unit goes in one packet
+// and a large one in FU-A fragments (packetization-mode=1)
+type H264Packetizer struct {
+	PayloadType uint8
+	SSRC        uint32
+	seq         uint16
+}
+
+func NewH264Packetizer(payloadType uint8, ssrc uint32) *H264Packetizer {
+	return &H264Packetizer{PayloadType: payloadType, SSRC: ssrc, seq: uint16(RandomUint32())}
+}
+
+// Seq return the seq of the next packet
+func (p *H264Packetizer) Seq() uint16 {
+	return p.seq
+}
+
+// Packetize return the packets of an Annex B access unit, the marker is set on the last one
+func (p *H264Packetizer) Packetize(au []byte, timestamp uint32) []*Packet {
+	var packets []*Packet
+	for _, n := range h264.SplitAnnexB(au) {
+		if h264.NALType(n) == h264.NALAUD {
+			continue
+		}
+		if len(n) <= MaxPayloadSize {
+			packets = append(packets, p.packet(n, timestamp))
+			continue
+		}
+		indicator := n[0]&0xe0 | naluFUA
+		for data, first := n[1:], true; len(data) > 0; first = false {
+			size := MaxPayloadSize - 2
+			if size > len(data) {
+				size = len(data)
+			}
+			header := n[0] & 0x1f
+			if first {
+				header |= 0x80
+			}
+			if size == len(data) {
+				header |= 0x40
+			}
+			payload := make([]byte, 2+size)
+			payload[0], payload[1] = indicator, header
+			copy(payload[2:], data[:size])
+			packets = append(packets, p.packet(payload, timestamp))
+			data = data[size:]
+		}
+	}
+	if len(packets) > 0 {
+		packets[len(packets)-1].Marker = true
+	}
+	return packets
+}
+
+func (p *H264Packetizer) packet(payload []byte, timestamp uint32) *Packet {
+	pkt := &Packet{
+		Header: Header{
+			PayloadType: p.PayloadType,
+			Seq:         p.seq,
+			Timestamp:   timestamp,
+			SSRC:        p.SSRC,
+		},
+		Payload: payload,
+	}
+	p.seq++
+	return pkt
+}
+
+// H264Depacketizer join the packets to access units. A gap in the seq drops the access unit
+// it falls in, the decoder would fail on it anyway.
+type H264Depacketizer struct {
+	nalus     [][]byte
+	fu        []byte
+	timestamp uint32
+	lastSeq   uint16
+	started   bool
+	broken    bool
+	Lost      uint64 // access units dropped
+}
+
+// Push a packet in the order of arrival, return the Annex B access unit completed by it and its timestamp
+func (d *H264Depacketizer) Push(p *Packet) ([]byte, uint32) {
+	var au []byte
+	var timestamp uint32
+	if d.started && p.Timestamp != d.timestamp && len(d.nalus) > 0 {
+		au, timestamp = d.flush() // the marker was lost
+	}
+	if d.started && p.Seq != d.lastSeq+1 {
+		d.broken = true
+		d.fu = nil
+	}
+	d.started = true
+	d.lastSeq = p.Seq
+	d.timestamp = p.Timestamp
+
+	if len(p.Payload) == 0 {
+		return au, timestamp
+	}
+	switch t := int(p.Payload[0] & 0x1f); {
+	case t >= 1 && t <= 23:
+		d.nalus = append(d.nalus, append([]byte(nil), p.Payload...))
+	case t == naluSTAPA:
+		b := p.Payload[1:]
+		for len(b) >= 2 {
+			size := int(b[0])<<8 | int(b[1])
+			if size == 0 || len(b) < 2+size {
+				d.broken = true
+				break
+			}
+			d.nalus = append(d.nalus, append([]byte(nil), b[2:2+size]...))
+			b = b[2+size:]
+		}
+	case t == naluFUA:
+		if len(p.Payload) < 2 {
+			d.broken = true
+			break
+		}
+		header := p.Payload[1]
+		if header&0x80 != 0 {
+			d.fu = append(d.fu[:0], p.Payload[0]&0xe0|header&0x1f)
+		} else if d.fu == nil {
+			d.broken = true // the start was lost
+			break
+		}
+		d.fu = append(d.fu, p.Payload[2:]...)
+		if header&0x40 != 0 {
+			d.nalus = append(d.nalus, d.fu)
+			d.fu = nil
+		}
+	default:
+		d.broken = true // STAP-B, MTAP, FU-B are not used with packetization-mode=1
+	}
+	if p.Marker {
+		if au != nil {
+			d.Lost++ // the access unit of the lost marker is dropped for this one
+		}
+		au, timestamp = d.flush()
+	}
+	return au, timestamp
+}
+
+func (d *H264Depacketizer) flush() ([]byte, uint32) {
+	nalus, broken := d.nalus, d.broken
+	d.nalus, d.broken, d.fu = nil, false, nil
+	if broken || len(nalus) == 0 {
+		d.Lost++
+		return nil, 0
+	}
+	return h264.AppendAnnexB(nil, nalus...), d.timestamp
+}
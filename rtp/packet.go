@@ -0,0 +1,106 @@
+// Package rtp provides RTP packets and the H.264 payload format of RFC 6184
+package rtp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	Version        = 2
+	HeaderSize     = 12
+	ClockRate      = 90000 // of video
+	MaxPayloadSize = 1200  // fits the udp MTU with the ip, udp and rtp headers
+)
+
+var (
+	ErrShortPacket = errors.New("rtp: packet too short")
+	ErrBadVersion  = errors.New("rtp: bad version")
+)
+
+// Header of a packet, the CSRCs and the extension are skipped
+type Header struct {
+	Marker      bool
+	PayloadType uint8
+	Seq         uint16
+	Timestamp   uint32
+	SSRC        uint32
+}
+
+type Packet struct {
+	Header
+	Payload []byte
+}
+
+func (p *Packet) Marshal() []byte {
+	b := make([]byte, HeaderSize+len(p.Payload))
+	b[0] = Version << 6
+	b[1] = p.PayloadType & 0x7f
+	if p.Marker {
+		b[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(b[2:], p.Seq)
+	binary.BigEndian.PutUint32(b[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(b[8:], p.SSRC)
+	copy(b[HeaderSize:], p.Payload)
+	return b
+}
+
+// Unmarshal parse a packet, the payload refers to b
+func Unmarshal(b []byte) (*Packet, error) {
+	if len(b) < HeaderSize {
+		return nil, ErrShortPacket
+	}
+	if b[0]>>6 != Version {
+		return nil, ErrBadVersion
+	}
+	p := &Packet{
+		Header: Header{
+			Marker:      b[1]&0x80 != 0,
+			PayloadType: b[1] & 0x7f,
+			Seq:         binary.BigEndian.Uint16(b[2:]),
+			Timestamp:   binary.BigEndian.Uint32(b[4:]),
+			SSRC:        binary.BigEndian.Uint32(b[8:]),
+		},
+	}
+	offset := HeaderSize + 4*int(b[0]&0x0f) // csrc
+	if b[0]&0x10 != 0 {                     // extension
+		if len(b) < offset+4 {
+			return nil, ErrShortPacket
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(b[offset+2:]))
+	}
+	end := len(b)
+	if b[0]&0x20 != 0 { // padding
+		end -= int(b[len(b)-1])
+	}
+	if offset > end {
+		return nil, ErrShortPacket
+	}
+	p.Payload = b[offset:end]
+	return p, nil
+}
+
+// Clock convert the capture times to rtp timestamps from a random base
+type Clock struct {
+	base  uint32
+	start time.Time
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{base: RandomUint32(), start: start}
+}
+
+func (c *Clock) Timestamp(t time.Time) uint32 {
+	us := int64(t.Sub(c.start) / time.Microsecond)
+	return c.base + uint32(us*(ClockRate/1000)/1000)
+}
+
+// RandomUint32 for the ssrc, the first seq and timestamp
+func RandomUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
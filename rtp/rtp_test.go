@@ -0,0 +1,84 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/l-f-h/video/h264"
+)
+
+func accessUnit(size int) []byte {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := bytes.Repeat([]byte{0x88}, size)
+	idr[0] = 0x65
+	return h264.AppendAnnexB(nil, sps, pps, idr)
+}
+
+func TestMarshal(t *testing.T) {
+	p := &Packet{Header: Header{Marker: true, PayloadType: 96, Seq: 65535, Timestamp: 1 << 31, SSRC: 7}, Payload: []byte{1, 2, 3}}
+	b := p.Marshal()
+	q, err := Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Header != p.Header || !bytes.Equal(q.Payload, p.Payload) {
+		t.Fatalf("got %+v", q)
+	}
+
+	// a csrc, an extension of one word and 2 bytes of padding
+	b = append([]byte{0xb1, 96, 0, 1, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 9, 0xbe, 0xde, 0, 1, 1, 2, 3, 4}, 5, 6, 0, 2)
+	q, err = Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(q.Payload, []byte{5, 6}) {
+		t.Fatalf("payload %x", q.Payload)
+	}
+}
+
+func TestH264RoundTrip(t *testing.T) {
+	pz := NewH264Packetizer(PayloadTypeH264, 1)
+	var d H264Depacketizer
+	for i, size := range []int{100, 5000, MaxPayloadSize, MaxPayloadSize + 1} {
+		au := accessUnit(size)
+		packets := pz.Packetize(au, uint32(i*3000))
+		for j, p := range packets {
+			if len(p.Payload) > MaxPayloadSize {
+				t.Fatalf("payload of %d bytes", len(p.Payload))
+			}
+			b, ts := d.Push(p)
+			if j < len(packets)-1 {
+				if b != nil {
+					t.Fatal("access unit before the marker")
+				}
+				continue
+			}
+			if !bytes.Equal(b, au) || ts != uint32(i*3000) {
+				t.Fatalf("access unit %d: got %d bytes at %d, want %d bytes", i, len(b), ts, len(au))
+			}
+		}
+	}
+}
+
+func TestH264Loss(t *testing.T) {
+	pz := NewH264Packetizer(PayloadTypeH264, 1)
+	var d H264Depacketizer
+	packets := pz.Packetize(accessUnit(5000), 0)
+	for i, p := range packets {
+		if i == 2 {
+			continue // lost fragment
+		}
+		if b, _ := d.Push(p); b != nil {
+			t.Fatal("broken access unit delivered")
+		}
+	}
+	au := accessUnit(10)
+	var got []byte
+	for _, p := range pz.Packetize(au, 3000) {
+		got, _ = d.Push(p)
+	}
+	if !bytes.Equal(got, au) || d.Lost != 1 {
+		t.Fatalf("after loss: got %d bytes, lost %d", len(got), d.Lost)
+	}
+}
@@ -0,0 +1,109 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/l-f-h/video/rtp"
+)
+
+// challenge is the WWW-Authenticate of a 401, Basic or Digest (rfc 2617)
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+func parseChallenge(s string) (*challenge, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), " ", 2)
+	c := &challenge{scheme: strings.ToLower(parts[0]), params: make(map[string]string)}
+	if c.scheme != "basic" && c.scheme != "digest" {
+		return nil, fmt.Errorf("rtsp: unsupported authentication %q", parts[0])
+	}
+	if len(parts) == 2 {
+		c.params = parseAuthParams(parts[1])
+	}
+	if c.scheme == "digest" && (c.params["realm"] == "" || c.params["nonce"] == "") {
+		return nil, fmt.Errorf("rtsp: bad digest challenge %q", s)
+	}
+	return c, nil
+}
+
+// parseAuthParams parse key="value", key=value lists, the values may have commas in quotes
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:1+end], s[2+end:]
+			}
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
+
+// authorization answer the challenge for a request, nc counts the requests with the nonce
+func (c *challenge) authorization(user, password, method, uri string, nc int) string {
+	if c.scheme == "basic" {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+	realm, nonce := c.params["realm"], c.params["nonce"]
+	ha1 := md5Hex(user + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	s := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, user, realm, nonce, uri)
+	if qop := c.params["qop"]; qop != "" && strings.Contains(qop, "auth") {
+		cnonce := fmt.Sprintf("%08x", rtp.RandomUint32())
+		ncs := fmt.Sprintf("%08x", nc)
+		s += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s", response="%s"`,
+			ncs, cnonce, md5Hex(ha1+":"+nonce+":"+ncs+":"+cnonce+":auth:"+ha2))
+	} else {
+		s += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+nonce+":"+ha2))
+	}
+	if opaque := c.params["opaque"]; opaque != "" {
+		s += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return s + ", algorithm=MD5"
+}
+
+// checkDigest verify the Authorization of a request against the credentials and the nonce of the server
+func checkDigest(authorization, method, user, password, realm, nonce string) bool {
+	if !strings.HasPrefix(authorization, "Digest ") {
+		return false
+	}
+	p := parseAuthParams(strings.TrimPrefix(authorization, "Digest "))
+	if p["username"] != user || p["realm"] != realm || p["nonce"] != nonce {
+		return false
+	}
+	ha1 := md5Hex(user + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + p["uri"])
+	want := md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	if p["qop"] == "auth" {
+		want = md5Hex(ha1 + ":" + nonce + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+	}
+	return p["response"] == want
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,209 @@
+// Package rtsp serves the H.264 stream of the encoder to RTSP players like VLC and ffplay,
+// with RTP over udp or interleaved in the tcp connection
+package rtsp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	Version        = "RTSP/1.0"
+	maxContentSize = 1 << 16
+)
+
+// Header of a message, the keys are canonical like net/http
+type Header map[string]string
+
+func (h Header) Get(key string) string {
+	return h[textproto.CanonicalMIMEHeaderKey(key)]
+}
+
+func (h Header) Set(key, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = value
+}
+
+// spelling of the keys that are not canonical in the rfc, some players compare them by case
+var spelling = map[string]string{
+	"Cseq":             "CSeq",
+	"Rtp-Info":         "RTP-Info",
+	"Www-Authenticate": "WWW-Authenticate",
+}
+
+func (h Header) write(w io.Writer) error {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := k
+		if s, ok := spelling[k]; ok {
+			name = s
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\r\n", name, h[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Request struct {
+	Method string
+	URL    string
+	Header Header
+	Body   []byte
+}
+
+type Response struct {
+	StatusCode int
+	Reason     string
+	Header     Header
+	Body       []byte
+}
+
+func ReadRequest(r *bufio.Reader) (*Request, error) {
+	line, header, body, err := readMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || parts[2] != Version {
+		return nil, fmt.Errorf("rtsp: bad request line %q", line)
+	}
+	return &Request{Method: parts[0], URL: parts[1], Header: header, Body: body}, nil
+}
+
+func ReadResponse(r *bufio.Reader) (*Response, error) {
+	line, header, body, err := readMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || parts[0] != Version {
+		return nil, fmt.Errorf("rtsp: bad status line %q", line)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("rtsp: bad status line %q", line)
+	}
+	resp := &Response{StatusCode: code, Header: header, Body: body}
+	if len(parts) == 3 {
+		resp.Reason = parts[2]
+	}
+	return resp, nil
+}
+
+func readMessage(r *bufio.Reader) (string, Header, []byte, error) {
+	tp := textproto.NewReader(r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	mime, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	header := make(Header, len(mime))
+	for k, v := range mime {
+		header[k] = v[0]
+	}
+	var body []byte
+	if s := header.Get("Content-Length"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxContentSize {
+			return "", nil, nil, fmt.Errorf("rtsp: bad content length %q", s)
+		}
+		body = make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	return line, header, body, nil
+}
+
+func (req *Request) Write(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %s %s\r\n", req.Method, req.URL, Version)
+	writeHeaderBody(b, req.Header, req.Body)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (resp *Response) Write(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %d %s\r\n", Version, resp.StatusCode, resp.Reason)
+	writeHeaderBody(b, resp.Header, resp.Body)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeaderBody(b *strings.Builder, h Header, body []byte) {
+	if h == nil {
+		h = Header{}
+	}
+	if len(body) > 0 {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	h.write(b)
+	b.WriteString("\r\n")
+	b.Write(body)
+}
+
+// transportSpec is the Transport header of SETUP
+type transportSpec struct {
+	tcp         bool
+	clientPorts [2]int
+	interleaved [2]int
+}
+
+func parseTransport(s string) (*transportSpec, error) {
+	// the client may offer several, take the first
+	spec := strings.Split(s, ",")[0]
+	t := &transportSpec{interleaved: [2]int{-1, -1}}
+	for i, p := range strings.Split(spec, ";") {
+		p = strings.TrimSpace(p)
+		switch {
+		case i == 0:
+			switch p {
+			case "RTP/AVP", "RTP/AVP/UDP":
+			case "RTP/AVP/TCP":
+				t.tcp = true
+			default:
+				return nil, fmt.Errorf("rtsp: unsupported transport %q", p)
+			}
+		case strings.HasPrefix(p, "client_port="):
+			if err := parsePorts(strings.TrimPrefix(p, "client_port="), &t.clientPorts); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(p, "interleaved="):
+			if err := parsePorts(strings.TrimPrefix(p, "interleaved="), &t.interleaved); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !t.tcp && t.clientPorts[0] == 0 {
+		return nil, fmt.Errorf("rtsp: no client_port in %q", spec)
+	}
+	return t, nil
+}
+
+func parsePorts(s string, ports *[2]int) error {
+	parts := strings.SplitN(s, "-", 2)
+	var err error
+	if ports[0], err = strconv.Atoi(parts[0]); err != nil {
+		return fmt.Errorf("rtsp: bad ports %q", s)
+	}
+	ports[1] = ports[0] + 1
+	if len(parts) == 2 {
+		if ports[1], err = strconv.Atoi(parts[1]); err != nil {
+			return fmt.Errorf("rtsp: bad ports %q", s)
+		}
+	}
+	return nil
+}
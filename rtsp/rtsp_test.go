@@ -0,0 +1,327 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/rtp"
+)
+
+var (
+	sps = []byte{0x67, 0x42, 0xc0, 0x1f}
+	pps = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+func accessUnit(i, size int, key bool) []byte {
+	var au []byte
+	nalType := byte(0x41)
+	if key {
+		au = h264.AppendAnnexB(au, sps, pps)
+		nalType = 0x65
+	}
+	body := bytes.Repeat([]byte{byte(i) | 1}, size)
+	body[0] = nalType
+	return h264.AppendAnnexB(au, body)
+}
+
+func startServer(t *testing.T) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	s.RTPPort = 0
+	go s.Serve(l)
+	return s, "rtsp://" + l.Addr().String() + "/live"
+}
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	cseq int
+}
+
+func dial(t *testing.T, url string) *testClient {
+	host := strings.SplitN(strings.TrimPrefix(url, "rtsp://"), "/", 2)[0]
+	c, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{t: t, conn: c, r: bufio.NewReader(c)}
+}
+
+func (c *testClient) do(method, url string, header Header) *Response {
+	c.cseq++
+	req := &Request{Method: method, URL: url, Header: Header{}}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("CSeq", fmt.Sprint(c.cseq))
+	if err := req.Write(c.conn); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		// skip the RTP interleaved before the response
+		b, err := c.r.Peek(1)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if b[0] != '$' {
+			break
+		}
+		c.readInterleaved()
+	}
+	resp, err := ReadResponse(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.Header.Get("CSeq") != fmt.Sprint(c.cseq) {
+		c.t.Fatalf("%s CSeq %q, want %d", method, resp.Header.Get("CSeq"), c.cseq)
+	}
+	return resp
+}
+
+func (c *testClient) readInterleaved() (int, []byte) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		c.t.Fatal(err)
+	}
+	b := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		c.t.Fatal(err)
+	}
+	return int(header[1]), b
+}
+
+// feed the server until the viewer got n frames
+func feed(s *Server, stop chan struct{}) {
+	for i := 0; ; i++ {
+		select {
+		case <-stop:
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+		s.WriteFrame(accessUnit(i, 3000, i%10 == 0), time.Now())
+	}
+}
+
+func checkFrames(t *testing.T, read func() []byte) {
+	var d rtp.H264Depacketizer
+	n := 0
+	for n < 15 {
+		p, err := rtp.Unmarshal(read())
+		if err != nil {
+			t.Fatal(err)
+		}
+		au, _ := d.Push(p)
+		if au == nil {
+			continue
+		}
+		nalus := h264.SplitAnnexB(au)
+		if n == 0 {
+			if !h264.IsKeyFrame(au) || !bytes.Equal(nalus[0], sps) || !bytes.Equal(nalus[1], pps) {
+				t.Fatalf("first frame %x... is not a key frame with SPS/PPS", au[:16])
+			}
+		}
+		if last := nalus[len(nalus)-1]; len(last) != 3000 {
+			t.Fatalf("frame %d has a slice of %d bytes", n, len(last))
+		}
+		n++
+	}
+}
+
+func TestInterleaved(t *testing.T) {
+	s, url := startServer(t)
+	defer s.Close()
+	c := dial(t, url)
+	defer c.conn.Close()
+
+	if resp := c.do("OPTIONS", url, nil); !strings.Contains(resp.Header.Get("Public"), "PLAY") {
+		t.Fatalf("Public %q", resp.Header.Get("Public"))
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go feed(s, stop)
+	time.Sleep(50 * time.Millisecond) // the sdp has the parameter sets
+	resp := c.do("DESCRIBE", url, Header{"Accept": "application/sdp"})
+	if body := string(resp.Body); !strings.Contains(body, "sprop-parameter-sets=Z0LAHw==,aM48gA==") {
+		t.Fatalf("sdp:\n%s", body)
+	}
+	resp = c.do("SETUP", url+"/"+trackControl, Header{"Transport": "RTP/AVP/TCP;unicast;interleaved=0-1"})
+	if resp.StatusCode != 200 || !strings.Contains(resp.Header.Get("Transport"), "interleaved=0-1") {
+		t.Fatalf("SETUP %d %q", resp.StatusCode, resp.Header.Get("Transport"))
+	}
+	id := strings.Split(resp.Header.Get("Session"), ";")[0]
+	if resp = c.do("PLAY", url, Header{"Session": id}); resp.StatusCode != 200 {
+		t.Fatalf("PLAY %d", resp.StatusCode)
+	}
+	if s.Viewers() != 1 {
+		t.Fatalf("%d viewers", s.Viewers())
+	}
+	checkFrames(t, func() []byte {
+		for {
+			ch, b := c.readInterleaved()
+			if ch == 0 {
+				return b
+			}
+		}
+	})
+	c.do("TEARDOWN", url, Header{"Session": id})
+	if s.Viewers() != 0 {
+		t.Fatalf("%d viewers after TEARDOWN", s.Viewers())
+	}
+}
+
+func TestUDP(t *testing.T) {
+	s, url := startServer(t)
+	defer s.Close()
+	c := dial(t, url)
+	defer c.conn.Close()
+	rtpConn, rtcpConn, err := listenUDPPair(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtpConn.Close()
+	defer rtcpConn.Close()
+
+	port := udpPort(rtpConn)
+	resp := c.do("SETUP", url+"/"+trackControl, Header{"Transport": fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d", port, port+1)})
+	if !strings.Contains(resp.Header.Get("Transport"), "server_port=") {
+		t.Fatalf("SETUP %d %q", resp.StatusCode, resp.Header.Get("Transport"))
+	}
+	id := strings.Split(resp.Header.Get("Session"), ";")[0]
+	if resp = c.do("PLAY", url, Header{"Session": id}); resp.StatusCode != 200 {
+		t.Fatalf("PLAY %d", resp.StatusCode)
+	}
+	if resp = c.do("PLAY", url, Header{"Session": "unknown"}); resp.StatusCode != 454 {
+		t.Fatalf("PLAY of an unknown session %d", resp.StatusCode)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go feed(s, stop)
+	rtpConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	checkFrames(t, func() []byte {
+		b := make([]byte, 1500)
+		n, err := rtpConn.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b[:n]
+	})
+
+	// closing the RTSP connection ends the session
+	c.conn.Close()
+	for i := 0; s.Viewers() != 0; i++ {
+		if i == 100 {
+			t.Fatal("session alive after the connection closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testPlay(t *testing.T, tcp bool) {
+	s, url := startServer(t)
+	s.Username, s.Password = "admin", "secret"
+	defer s.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go feed(s, stop)
+
+	if _, err := Dial(strings.Replace(url, "rtsp://", "rtsp://admin:wrong@", 1), tcp); err == nil {
+		t.Fatal("Dial with a wrong password")
+	}
+	c, err := Dial(strings.Replace(url, "rtsp://", "rtsp://admin:secret@", 1), tcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last time.Time
+	for n := 0; n < 15; n++ {
+		var f Frame
+		select {
+		case f = <-c.Frames():
+		case <-time.After(5 * time.Second):
+			t.Fatal("no frame")
+		}
+		nalus := h264.SplitAnnexB(f.Data)
+		if n == 0 && (!f.Key || !bytes.Equal(nalus[0], sps) || !bytes.Equal(nalus[1], pps)) {
+			t.Fatalf("first frame %x... is not a key frame with SPS/PPS", f.Data[:16])
+		}
+		if len(nalus[len(nalus)-1]) != 3000 {
+			t.Fatalf("frame %d has a slice of %d bytes", n, len(nalus[len(nalus)-1]))
+		}
+		if f.Timestamp.Before(last) {
+			t.Fatalf("frame %d at %v before %v", n, f.Timestamp, last)
+		}
+		last = f.Timestamp
+	}
+	if s.Viewers() != 1 {
+		t.Fatalf("%d viewers", s.Viewers())
+	}
+	c.Close()
+	for range c.Frames() {
+	}
+	if c.Err() != nil {
+		t.Fatalf("Err after Close: %v", c.Err())
+	}
+	for i := 0; s.Viewers() != 0; i++ {
+		if i == 100 {
+			t.Fatal("session alive after TEARDOWN")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientInterleaved(t *testing.T) {
+	testPlay(t, true)
+}
+
+func TestClientUDP(t *testing.T) {
+	testPlay(t, false)
+}
+
+func TestParseSDP(t *testing.T) {
+	camera := "v=0\r\no=- 1 1 IN IP4 10.0.0.2\r\ns=cam\r\nt=0 0\r\n" +
+		"m=audio 0 RTP/AVP 0\r\na=control:track2\r\n" +
+		"m=video 0 RTP/AVP 97\r\na=rtpmap:97 H264/90000\r\n" +
+		"a=fmtp:97 profile-level-id=42c01f; packetization-mode=1; sprop-parameter-sets=Z0LAHw==,aM48gA==\r\n" +
+		"a=control:track1\r\n"
+	tr, err := parseSDP([]byte(camera))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.payloadType != 97 || tr.control != "track1" || !bytes.Equal(tr.ps.SPS, sps) || !bytes.Equal(tr.ps.PPS, pps) {
+		t.Fatalf("track %+v", tr)
+	}
+	if _, err := parseSDP([]byte("v=0\r\nm=audio 0 RTP/AVP 0\r\n")); err != ErrNoH264 {
+		t.Fatalf("audio only: %v", err)
+	}
+	if u := controlURL("rtsp://cam/live/", "track1"); u != "rtsp://cam/live/track1" {
+		t.Fatal(u)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	c, err := parseChallenge(`Digest realm="cam, front", nonce="abc", qop="auth,auth-int", opaque=xyz`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.params["realm"] != "cam, front" || c.params["opaque"] != "xyz" {
+		t.Fatalf("%+v", c.params)
+	}
+	a := c.authorization("admin", "secret", "DESCRIBE", "rtsp://cam/live", 1)
+	if !checkDigest(a, "DESCRIBE", "admin", "secret", "cam, front", "abc") {
+		t.Fatalf("%s not accepted", a)
+	}
+	if checkDigest(a, "DESCRIBE", "admin", "other", "cam, front", "abc") {
+		t.Fatal("wrong password accepted")
+	}
+}
@@ -0,0 +1,92 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/rtp"
+)
+
+const trackControl = "trackID=0"
+
+// sdp describe the H.264 track, with the parameter sets once the encoder made them
+func sdp(host string, sessionID uint32, ps h264.ParameterSets) []byte {
+	fmtp := "packetization-mode=1"
+	if ps.Complete() && len(ps.SPS) >= 4 {
+		fmtp += fmt.Sprintf(";profile-level-id=%02x%02x%02x;sprop-parameter-sets=%s,%s",
+			ps.SPS[1], ps.SPS[2], ps.SPS[3],
+			base64.StdEncoding.EncodeToString(ps.SPS), base64.StdEncoding.EncodeToString(ps.PPS))
+	}
+	lines := []string{
+		"v=0",
+		fmt.Sprintf("o=- %d 1 IN IP4 %s", sessionID, host),
+		"s=video",
+		"c=IN IP4 0.0.0.0",
+		"t=0 0",
+		"a=control:*",
+		fmt.Sprintf("m=video 0 RTP/AVP %d", rtp.PayloadTypeH264),
+		fmt.Sprintf("a=rtpmap:%d H264/%d", rtp.PayloadTypeH264, rtp.ClockRate),
+		fmt.Sprintf("a=fmtp:%d %s", rtp.PayloadTypeH264, fmtp),
+		"a=control:" + trackControl,
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// track is the H.264 media of a session description
+type track struct {
+	payloadType uint8
+	control     string
+	ps          h264.ParameterSets // of sprop-parameter-sets, may be empty
+}
+
+// parseSDP find the first H.264 video media of the description of a server
+func parseSDP(b []byte) (*track, error) {
+	var t *track
+	video := false
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			if t != nil {
+				return t, nil
+			}
+			video = strings.HasPrefix(line, "m=video ")
+		case !video:
+		case strings.HasPrefix(line, "a=rtpmap:"):
+			var pt int
+			var encoding string
+			if _, err := fmt.Sscanf(line, "a=rtpmap:%d %s", &pt, &encoding); err == nil &&
+				strings.HasPrefix(strings.ToUpper(encoding), "H264/") {
+				t = &track{payloadType: uint8(pt)}
+			}
+		case strings.HasPrefix(line, "a=control:"):
+			if t != nil {
+				t.control = strings.TrimPrefix(line, "a=control:")
+			}
+		case strings.HasPrefix(line, "a=fmtp:") && t != nil:
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) < 2 {
+				continue
+			}
+			for _, p := range strings.Split(parts[1], ";") {
+				p = strings.TrimSpace(p)
+				if !strings.HasPrefix(p, "sprop-parameter-sets=") {
+					continue
+				}
+				var au []byte
+				for _, s := range strings.Split(strings.TrimPrefix(p, "sprop-parameter-sets="), ",") {
+					if nalu, err := base64.StdEncoding.DecodeString(s); err == nil && len(nalu) > 0 {
+						au = h264.AppendAnnexB(au, nalu)
+					}
+				}
+				t.ps.Update(au)
+			}
+		}
+	}
+	if t == nil {
+		return nil, ErrNoH264
+	}
+	return t, nil
+}
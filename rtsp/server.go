@@ -0,0 +1,406 @@
+package rtsp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/rtp"
+)
+
+const (
+	DefaultRTPPort = 8000
+	sessionTimeout = 60 * time.Second // of a udp viewer without request or rtcp
+	viewerQueue    = 1 << 6           // frames waiting for a viewer
+	publicMethods  = "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER"
+	realm          = "video"
+)
+
+type frame struct {
+	data     []byte
+	key      bool
+	captured time.Time
+}
+
+// Server serves one H.264 stream at any path to many viewers. RTP goes over udp from RTPPort
+// (RTCP on the next port), or interleaved in the RTSP connection.
+type Server struct {
+	RTPPort  int    // 0 picks a free pair
+	Username string // digest authentication of the viewers if set
+	Password string
+
+	mu       sync.Mutex
+	ps       h264.ParameterSets
+	sessions map[string]*session
+	rtpConn  *net.UDPConn
+	rtcpConn *net.UDPConn
+	listener net.Listener
+	closed   bool
+	id       uint32 // of the sdp
+	nonce    string
+}
+
+func NewServer() *Server {
+	return &Server{
+		RTPPort:  DefaultRTPPort,
+		sessions: make(map[string]*session),
+		id:       rtp.RandomUint32(),
+		nonce:    fmt.Sprintf("%08x%08x", rtp.RandomUint32(), rtp.RandomUint32()),
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accept the RTSP connections on l until Close
+func (s *Server) Serve(l net.Listener) error {
+	rtpConn, rtcpConn, err := listenUDPPair(s.RTPPort)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	s.mu.Lock()
+	s.listener, s.rtpConn, s.rtcpConn = l, rtpConn, rtcpConn
+	s.mu.Unlock()
+	go s.readRTCP()
+	go s.expire()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(&conn{Conn: c, r: bufio.NewReader(c)})
+	}
+}
+
+// WriteFrame send an Annex B access unit of the encoder to the viewers, data is copied
+func (s *Server) WriteFrame(data []byte, captured time.Time) {
+	f := frame{data: append([]byte(nil), data...), captured: captured}
+	f.key = h264.IsKeyFrame(f.data)
+	if f.captured.IsZero() {
+		f.captured = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ps.Update(f.data)
+	for _, sess := range s.sessions {
+		if sess.playing {
+			sess.push(f, &s.ps)
+		}
+	}
+}
+
+// Viewers return the number of playing sessions
+func (s *Server) Viewers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, sess := range s.sessions {
+		if sess.playing {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for _, sess := range s.sessions {
+		s.teardown(sess)
+	}
+	if s.listener != nil {
+		s.listener.Close()
+		s.rtpConn.Close()
+		s.rtcpConn.Close()
+	}
+	return nil
+}
+
+// conn is an RTSP connection, the interleaved RTP and the responses share it
+type conn struct {
+	net.Conn
+	r   *bufio.Reader
+	wmu sync.Mutex
+}
+
+func (c *conn) writeResponse(resp *Response) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return resp.Write(c.Conn)
+}
+
+// writeInterleaved write a packet as $ channel(1) length(2) packet
+func (c *conn) writeInterleaved(channel int, b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	header := []byte{'$', byte(channel), 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(b)))
+	if _, err := c.Conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+func (s *Server) serveConn(c *conn) {
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		for _, sess := range s.sessions {
+			if sess.conn == c {
+				s.teardown(sess)
+			}
+		}
+		s.mu.Unlock()
+	}()
+	for {
+		// the client may send its RTCP interleaved
+		if b, err := c.r.Peek(1); err != nil {
+			return
+		} else if b[0] == '$' {
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(c.r, header); err != nil {
+				return
+			}
+			if _, err := c.r.Discard(int(binary.BigEndian.Uint16(header[2:]))); err != nil {
+				return
+			}
+			continue
+		}
+		req, err := ReadRequest(c.r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("rtsp %v: %v", c.RemoteAddr(), err)
+			}
+			return
+		}
+		if err := c.writeResponse(s.handle(c, req)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handle(c *conn, req *Request) *Response {
+	resp := &Response{StatusCode: 200, Reason: "OK", Header: Header{}}
+	resp.Header.Set("CSeq", req.Header.Get("CSeq"))
+	resp.Header.Set("Server", "video")
+
+	if s.Username != "" && !checkDigest(req.Header.Get("Authorization"), req.Method, s.Username, s.Password, realm, s.nonce) {
+		resp.Header.Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", nonce="%s"`, realm, s.nonce))
+		return status(resp, 401, "Unauthorized")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sess *session
+	if id := strings.Split(req.Header.Get("Session"), ";")[0]; id != "" {
+		if sess = s.sessions[id]; sess == nil {
+			return status(resp, 454, "Session Not Found")
+		}
+		sess.lastSeen = time.Now()
+		resp.Header.Set("Session", sess.id)
+	}
+
+	switch req.Method {
+	case "OPTIONS":
+		resp.Header.Set("Public", publicMethods)
+	case "DESCRIBE":
+		host, _, _ := net.SplitHostPort(c.LocalAddr().String())
+		resp.Header.Set("Content-Type", "application/sdp")
+		resp.Header.Set("Content-Base", strings.TrimSuffix(req.URL, "/")+"/")
+		resp.Body = sdp(host, s.id, s.ps)
+	case "SETUP":
+		if sess != nil {
+			return status(resp, 459, "Aggregate Operation Not Allowed") // one track only
+		}
+		t, err := parseTransport(req.Header.Get("Transport"))
+		if err != nil {
+			return status(resp, 461, "Unsupported Transport")
+		}
+		sess = s.newSession(c, t)
+		resp.Header.Set("Session", fmt.Sprintf("%s;timeout=%d", sess.id, int(sessionTimeout/time.Second)))
+		if t.tcp {
+			resp.Header.Set("Transport", fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d;ssrc=%08X",
+				sess.channel, sess.channel+1, sess.packetizer.SSRC))
+		} else {
+			resp.Header.Set("Transport", fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d;ssrc=%08X",
+				t.clientPorts[0], t.clientPorts[1], udpPort(s.rtpConn), udpPort(s.rtcpConn), sess.packetizer.SSRC))
+		}
+	case "PLAY":
+		if sess == nil {
+			return status(resp, 454, "Session Not Found")
+		}
+		resp.Header.Set("Range", "npt=0.000-")
+		resp.Header.Set("RTP-Info", fmt.Sprintf("url=%s/%s;seq=%d;rtptime=%d",
+			strings.TrimSuffix(req.URL, "/"), trackControl, sess.packetizer.Seq(), sess.clock.Timestamp(time.Now())))
+		if !sess.playing {
+			sess.playing = true
+			go s.send(sess)
+		}
+	case "TEARDOWN":
+		if sess != nil {
+			s.teardown(sess)
+		}
+	case "GET_PARAMETER", "SET_PARAMETER":
+		// keepalive
+	default:
+		return status(resp, 501, "Not Implemented")
+	}
+	return resp
+}
+
+func status(resp *Response, code int, reason string) *Response {
+	resp.StatusCode, resp.Reason = code, reason
+	return resp
+}
+
+// newSession of a SETUP, s.mu is held
+func (s *Server) newSession(c *conn, t *transportSpec) *session {
+	sess := &session{
+		id:         fmt.Sprintf("%08X%08X", rtp.RandomUint32(), rtp.RandomUint32()),
+		conn:       c,
+		tcp:        t.tcp,
+		packetizer: rtp.NewH264Packetizer(rtp.PayloadTypeH264, rtp.RandomUint32()),
+		clock:      rtp.NewClock(time.Now()),
+		frames:     make(chan frame, viewerQueue),
+		waitKey:    true,
+		lastSeen:   time.Now(),
+		done:       make(chan struct{}),
+	}
+	if t.tcp {
+		sess.channel = t.interleaved[0]
+		if sess.channel < 0 {
+			sess.channel = 0
+		}
+	} else {
+		ip := c.RemoteAddr().(*net.TCPAddr).IP
+		sess.rtpAddr = &net.UDPAddr{IP: ip, Port: t.clientPorts[0]}
+		sess.rtcpAddr = &net.UDPAddr{IP: ip, Port: t.clientPorts[1]}
+	}
+	s.sessions[sess.id] = sess
+	return sess
+}
+
+// teardown stop a session, s.mu is held
+func (s *Server) teardown(sess *session) {
+	if _, ok := s.sessions[sess.id]; !ok {
+		return
+	}
+	delete(s.sessions, sess.id)
+	close(sess.done)
+}
+
+// send the frames of a playing session, a slow viewer loses frames in push instead of blocking
+func (s *Server) send(sess *session) {
+	for {
+		var f frame
+		select {
+		case <-sess.done:
+			return
+		case f = <-sess.frames:
+		}
+		for _, p := range sess.packetizer.Packetize(f.data, sess.clock.Timestamp(f.captured)) {
+			b := p.Marshal()
+			if sess.tcp {
+				if err := sess.conn.writeInterleaved(sess.channel, b); err != nil {
+					sess.conn.Close() // serveConn tears the sessions down
+					return
+				}
+			} else if _, err := s.rtpConn.WriteToUDP(b, sess.rtpAddr); err != nil {
+				log.Printf("rtsp session %s: %v", sess.id, err)
+			}
+		}
+	}
+}
+
+// readRTCP keep the udp sessions alive with the receiver reports of their viewers
+func (s *Server) readRTCP() {
+	b := make([]byte, 1500)
+	for {
+		_, addr, err := s.rtcpConn.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		for _, sess := range s.sessions {
+			if sess.rtcpAddr != nil && sess.rtcpAddr.IP.Equal(addr.IP) && sess.rtcpAddr.Port == addr.Port {
+				sess.lastSeen = time.Now()
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// expire tear down the udp sessions whose viewers went away silently
+func (s *Server) expire() {
+	ticker := time.NewTicker(sessionTimeout / 6)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		for _, sess := range s.sessions {
+			if !sess.tcp && time.Since(sess.lastSeen) > sessionTimeout {
+				log.Printf("rtsp session %s timeout", sess.id)
+				s.teardown(sess)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// listenUDPPair listen on an even port for RTP and the next one for RTCP
+func listenUDPPair(port int) (*net.UDPConn, *net.UDPConn, error) {
+	for i := 0; i < 100; i++ {
+		p := port
+		if port == 0 {
+			p = 20000 + 2*int(rtp.RandomUint32()%20000)
+		}
+		rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: p})
+		if err != nil {
+			if port != 0 {
+				return nil, nil, err
+			}
+			continue
+		}
+		rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: p + 1})
+		if err != nil {
+			rtpConn.Close()
+			if port != 0 {
+				return nil, nil, err
+			}
+			continue
+		}
+		return rtpConn, rtcpConn, nil
+	}
+	return nil, nil, fmt.Errorf("rtsp: no free udp port pair")
+}
+
+func udpPort(c *net.UDPConn) int {
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
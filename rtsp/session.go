@@ -0,0 +1,47 @@
+package rtsp
+
+import (
+	"net"
+	"time"
+
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/rtp"
+)
+
+// session of a viewer, from SETUP to TEARDOWN
+type session struct {
+	id       string
+	conn     *conn // of the SETUP, carries the interleaved RTP
+	tcp      bool
+	channel  int
+	rtpAddr  *net.UDPAddr
+	rtcpAddr *net.UDPAddr
+
+	packetizer *rtp.H264Packetizer
+	clock      *rtp.Clock
+	frames     chan frame
+	waitKey    bool // the viewer starts on a key frame, and waits for one after a drop
+	playing    bool
+	lastSeen   time.Time
+	dropped    uint64
+	done       chan struct{}
+}
+
+// push queue a frame without blocking, the server lock is held
+func (sess *session) push(f frame, ps *h264.ParameterSets) {
+	if sess.waitKey {
+		if !f.key {
+			sess.dropped++
+			return
+		}
+		// the decoder of the viewer may have no SPS/PPS yet
+		f.data = ps.Prepend(f.data)
+	}
+	select {
+	case sess.frames <- f:
+		sess.waitKey = false
+	default:
+		sess.waitKey = true
+		sess.dropped++
+	}
+}
@@ -0,0 +1,370 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	lengthSize     = 2
+	seqSize        = 8
+	dataHeaderSize = 1 + seqSize
+	maxRecordSize  = 1<<16 - 1
+	readBufferLen  = 1 << 17
+)
+
+var (
+	ErrBadRecord = errors.New("secure: bad record")
+	ErrTooLarge  = errors.New("secure: write too large for one record")
+)
+
+// Stats of the records dropped by a datagram conn
+type Stats struct {
+	Records  uint64
+	Forged   uint64 // failed authentication
+	Replayed uint64
+}
+
+// Conn seals every Write to one record [length(2)][type(1)][seq(8)][ciphertext],
+// and Read return the plaintext of one record
+type Conn struct {
+	net.Conn
+	datagram bool
+
+	wmu   sync.Mutex
+	wseq  uint64
+	write cipher.AEAD
+	wbuf  []byte
+
+	rmu    sync.Mutex
+	read   cipher.AEAD
+	replay replayWindow
+	rbuf   []byte
+	start  int
+	end    int
+	plain  []byte // the rest of the last record, for a short Read
+	stats  Stats
+	proved bool   // the peer sent an authentic record
+	hello  []byte // answered client hello and the answer, for a retransmitted hello
+	answer []byte
+}
+
+func newConn(conn net.Conn, datagram bool, writeKey, readKey []byte) (*Conn, error) {
+	w, err := newAEAD(writeKey)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newAEAD(readKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		Conn:     conn,
+		datagram: datagram,
+		write:    w,
+		read:     r,
+		rbuf:     make([]byte, readBufferLen),
+	}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-seqSize:], seq)
+	return n
+}
+
+// Client run the handshake on the client side of conn
+func Client(conn net.Conn, cfg Config) (*Conn, error) {
+	mode, err := cfg.mode()
+	if err != nil {
+		return nil, err
+	}
+	ephPrivate, ephPublic, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	ch := &clientHello{mode: mode, ephemeral: ephPublic, static: make([]byte, keySize)}
+	if ch.random, err = random(); err != nil {
+		return nil, err
+	}
+	var peerStatic []byte
+	if mode == modeX25519 {
+		if ch.static, err = PublicKey(cfg.PrivateKey); err != nil {
+			return nil, err
+		}
+		peerStatic = cfg.PeerKeys[0]
+	}
+	auth, err := authSecret(&cfg, mode, peerStatic)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &recordReader{conn: conn, datagram: cfg.Datagram, buf: make([]byte, readBufferLen)}
+	hello := ch.marshal()
+	deadline := time.Now().Add(cfg.timeout())
+	defer conn.SetReadDeadline(time.Time{})
+	for time.Now().Before(deadline) {
+		if err := writeRecord(conn, hello); err != nil {
+			return nil, err
+		}
+		next := time.Now().Add(retransmitInterval)
+		if next.After(deadline) {
+			next = deadline
+		}
+		if err := conn.SetReadDeadline(next); err != nil {
+			return nil, err
+		}
+		record, err := r.next()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sh, err := parseServerHello(record)
+		if err != nil {
+			continue // a stale record
+		}
+		shared, err := curve25519.X25519(ephPrivate, sh.ephemeral)
+		if err != nil {
+			return nil, ErrBadHandshake
+		}
+		k, err := deriveKeys(shared, auth, ch, sh)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal(sh.confirm, confirmMAC(k)) {
+			return nil, ErrBadHandshake // not the pinned server, or a wrong psk
+		}
+		c, err := newConn(conn, cfg.Datagram, k.clientWrite, k.serverWrite)
+		if err != nil {
+			return nil, err
+		}
+		c.rbuf, c.start, c.end = r.buf, r.start, r.end
+		return c, nil
+	}
+	return nil, ErrTimeout
+}
+
+// Server run the handshake on the server side of conn, it waits for the hello of an authorized
+// client until the timeout of cfg
+func Server(conn net.Conn, cfg Config) (*Conn, error) {
+	if _, err := cfg.mode(); err != nil {
+		return nil, err
+	}
+	r := &recordReader{conn: conn, datagram: cfg.Datagram, buf: make([]byte, readBufferLen)}
+	if err := conn.SetReadDeadline(time.Now().Add(cfg.timeout())); err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		record, err := r.next()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, ErrTimeout
+		}
+		if err != nil {
+			return nil, err
+		}
+		ch, err := parseClientHello(record)
+		if err != nil {
+			if cfg.Datagram {
+				continue
+			}
+			return nil, err
+		}
+		peerStatic := ch.static
+		if ch.mode == modePSK && !isZero(ch.static) {
+			return nil, ErrBadHandshake
+		}
+		auth, err := authSecret(&cfg, ch.mode, peerStatic)
+		if err != nil {
+			if cfg.Datagram {
+				continue // not for us, wait for the pinned client
+			}
+			return nil, err
+		}
+
+		ephPrivate, ephPublic, err := GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		sh := &serverHello{ephemeral: ephPublic}
+		if sh.random, err = random(); err != nil {
+			return nil, err
+		}
+		shared, err := curve25519.X25519(ephPrivate, ch.ephemeral)
+		if err != nil {
+			return nil, ErrBadHandshake
+		}
+		k, err := deriveKeys(shared, auth, ch, sh)
+		if err != nil {
+			return nil, err
+		}
+		sh.confirm = confirmMAC(k)
+		answer := sh.marshal()
+		if err := writeRecord(conn, answer); err != nil {
+			return nil, err
+		}
+		c, err := newConn(conn, cfg.Datagram, k.serverWrite, k.clientWrite)
+		if err != nil {
+			return nil, err
+		}
+		c.rbuf, c.start, c.end = r.buf, r.start, r.end
+		c.hello, c.answer = append([]byte(nil), record...), answer
+		return c, nil
+	}
+}
+
+// Write seal b to one record, b is a whole transport record
+func (c *Conn) Write(b []byte) (int, error) {
+	size := dataHeaderSize + len(b) + c.write.Overhead()
+	if size > maxRecordSize {
+		return 0, ErrTooLarge
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if cap(c.wbuf) < lengthSize+size {
+		c.wbuf = make([]byte, lengthSize+size)
+	}
+	rec := c.wbuf[:lengthSize+dataHeaderSize]
+	binary.BigEndian.PutUint16(rec, uint16(size))
+	rec[lengthSize] = recordData
+	binary.BigEndian.PutUint64(rec[lengthSize+1:], c.wseq)
+	rec = c.write.Seal(rec, nonce(c.write, c.wseq), b, rec[lengthSize:])
+	c.wseq++
+	if _, err := c.Conn.Write(rec); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read return the plaintext of the next authentic record. A datagram conn drops the forged
+// and replayed records, a stream conn fails on them.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.plain) == 0 {
+		r := recordReader{conn: c.Conn, datagram: c.datagram, buf: c.rbuf, start: c.start, end: c.end}
+		record, err := r.next()
+		c.start, c.end = r.start, r.end
+		if err != nil {
+			return 0, err
+		}
+		plain, err := c.open(record)
+		if err != nil {
+			if c.datagram {
+				continue
+			}
+			return 0, err
+		}
+		c.plain = plain
+	}
+	n := copy(b, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+func (c *Conn) open(record []byte) ([]byte, error) {
+	if len(record) > 0 && record[0] == recordClientHello {
+		// the server hello was lost, answer the same hello again
+		if c.hello != nil && hmac.Equal(record, c.hello) {
+			writeRecord(c.Conn, c.answer)
+		}
+		return nil, nil
+	}
+	if len(record) < dataHeaderSize+c.read.Overhead() || record[0] != recordData {
+		return nil, ErrBadRecord
+	}
+	seq := binary.BigEndian.Uint64(record[1:])
+	if !c.replay.check(seq) {
+		c.stats.Replayed++
+		return nil, ErrBadRecord
+	}
+	plain, err := c.read.Open(nil, nonce(c.read, seq), record[dataHeaderSize:], record[:dataHeaderSize])
+	if err != nil {
+		c.stats.Forged++
+		return nil, ErrBadRecord
+	}
+	c.replay.accept(seq)
+	c.stats.Records++
+	if !c.proved {
+		// the handshake alone does not prove the key of the client, pin the peer after its first record
+		c.proved = true
+		if p, ok := c.Conn.(pinner); ok {
+			p.Pin()
+		}
+	}
+	return plain, nil
+}
+
+// pinner is a datagram conn following its peer, like transport.PeerConn
+type pinner interface {
+	Pin()
+}
+
+func (c *Conn) Stats() Stats {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	return c.stats
+}
+
+func writeRecord(conn net.Conn, b []byte) error {
+	rec := make([]byte, lengthSize+len(b))
+	binary.BigEndian.PutUint16(rec, uint16(len(b)))
+	copy(rec[lengthSize:], b)
+	_, err := conn.Write(rec)
+	return err
+}
+
+// recordReader cut the records out of a stream, a datagram carries whole records
+// so the rest of a broken datagram is dropped instead of joined to the next one
+type recordReader struct {
+	conn       net.Conn
+	datagram   bool
+	buf        []byte
+	start, end int
+}
+
+func (r *recordReader) next() ([]byte, error) {
+	for {
+		if r.end-r.start >= lengthSize {
+			size := int(binary.BigEndian.Uint16(r.buf[r.start:]))
+			if r.end-r.start >= lengthSize+size {
+				record := r.buf[r.start+lengthSize : r.start+lengthSize+size]
+				r.start += lengthSize + size
+				return record, nil
+			}
+		}
+		if r.datagram {
+			r.start, r.end = 0, 0
+		} else if r.start > 0 {
+			r.end = copy(r.buf, r.buf[r.start:r.end])
+			r.start = 0
+		}
+		n, err := r.conn.Read(r.buf[r.end:])
+		r.end += n
+		if err != nil && n == 0 {
+			if err == io.EOF && r.end > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Package secure provides the optional security layer under the transport: a key exchange with a
+// pre-shared key or X25519 keys pinned on both sides, then AES-GCM on every record. Each record is
+// sealed on its own with its sequence number as nonce, so a lost datagram never breaks the others.
+package secure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	version = 1
+	keySize = 32
+
+	modePSK    = 0
+	modeX25519 = 1
+
+	recordClientHello = 1
+	recordServerHello = 2
+	recordData        = 3
+
+	clientHelloSize = 3 + keySize*3 // type, version, mode, random, ephemeral key, static key
+	serverHelloSize = 2 + keySize*3 // type, version, random, ephemeral key, confirm
+
+	retransmitInterval = 200 * time.Millisecond // the client hello is sent again until answered
+	DefaultTimeout     = 5 * time.Second
+)
+
+var (
+	ErrNoKey        = errors.New("secure: neither pre-shared key nor private key")
+	ErrUnknownPeer  = errors.New("secure: public key of the peer not pinned")
+	ErrBadHandshake = errors.New("secure: bad handshake")
+	ErrTimeout      = errors.New("secure: handshake timeout")
+)
+
+// Config of one side, PSK or PrivateKey with the pinned PeerKeys
+type Config struct {
+	PSK        []byte   // pre-shared key, both sides have the same
+	PrivateKey []byte   // own X25519 key
+	PeerKeys   [][]byte // pinned X25519 public keys of the peer, the server accepts any of them
+	Datagram   bool     // the conn loses and reorders packets, bad records are dropped instead of failing
+	Timeout    time.Duration
+}
+
+func (c *Config) mode() (byte, error) {
+	switch {
+	case len(c.PSK) > 0:
+		return modePSK, nil
+	case len(c.PrivateKey) == keySize && len(c.PeerKeys) > 0:
+		return modeX25519, nil
+	}
+	return 0, ErrNoKey
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultTimeout
+}
+
+// GenerateKey return a new X25519 key pair
+func GenerateKey() (private, public []byte, err error) {
+	private = make([]byte, keySize)
+	if _, err := rand.Read(private); err != nil {
+		return nil, nil, err
+	}
+	public, err = curve25519.X25519(private, curve25519.Basepoint)
+	return private, public, err
+}
+
+// PublicKey return the public key of a private key
+func PublicKey(private []byte) ([]byte, error) {
+	return curve25519.X25519(private, curve25519.Basepoint)
+}
+
+// EncodeKey encode a key as base64 text, the format of the key files and flags
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func DecodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("secure: bad key: %v", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("secure: key of %d bytes, want %d", len(key), keySize)
+	}
+	return key, nil
+}
+
+// LoadKey read a key written by EncodeKey
+func LoadKey(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeKey(string(b))
+}
+
+// NewConfig build the config from the flags of the commands, nil if security is off.
+// peers is a comma separated list of base64 public keys.
+func NewConfig(psk, keyFile, peers string, datagram bool) (*Config, error) {
+	cfg := &Config{PSK: []byte(psk), Datagram: datagram}
+	if keyFile == "" {
+		if psk == "" {
+			return nil, nil
+		}
+		return cfg, nil
+	}
+	var err error
+	if cfg.PrivateKey, err = LoadKey(keyFile); err != nil {
+		return nil, err
+	}
+	for _, p := range strings.Split(peers, ",") {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		key, err := DecodeKey(p)
+		if err != nil {
+			return nil, err
+		}
+		cfg.PeerKeys = append(cfg.PeerKeys, key)
+	}
+	if _, err := cfg.mode(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+type clientHello struct {
+	mode      byte
+	random    []byte
+	ephemeral []byte
+	static    []byte // public, zero for psk
+}
+
+func (h *clientHello) marshal() []byte {
+	b := make([]byte, 0, clientHelloSize)
+	b = append(b, recordClientHello, version, h.mode)
+	b = append(b, h.random...)
+	b = append(b, h.ephemeral...)
+	return append(b, h.static...)
+}
+
+func parseClientHello(b []byte) (*clientHello, error) {
+	if len(b) != clientHelloSize || b[0] != recordClientHello || b[1] != version {
+		return nil, ErrBadHandshake
+	}
+	return &clientHello{
+		mode:      b[2],
+		random:    b[3 : 3+keySize],
+		ephemeral: b[3+keySize : 3+2*keySize],
+		static:    b[3+2*keySize:],
+	}, nil
+}
+
+type serverHello struct {
+	random    []byte
+	ephemeral []byte
+	confirm   []byte
+}
+
+func (h *serverHello) marshal() []byte {
+	b := make([]byte, 0, serverHelloSize)
+	b = append(b, recordServerHello, version)
+	b = append(b, h.random...)
+	b = append(b, h.ephemeral...)
+	return append(b, h.confirm...)
+}
+
+func parseServerHello(b []byte) (*serverHello, error) {
+	if len(b) != serverHelloSize || b[0] != recordServerHello || b[1] != version {
+		return nil, ErrBadHandshake
+	}
+	return &serverHello{
+		random:    b[2 : 2+keySize],
+		ephemeral: b[2+keySize : 2+2*keySize],
+		confirm:   b[2+2*keySize:],
+	}, nil
+}
+
+// keys of the session, one per direction
+type keys struct {
+	clientWrite []byte
+	serverWrite []byte
+	confirm     []byte
+}
+
+// deriveKeys mix the ephemeral secret for forward secrecy with the authenticating secret,
+// the psk or the static-static X25519, over the transcript of the handshake
+func deriveKeys(ephemeral, auth []byte, ch *clientHello, sh *serverHello) (*keys, error) {
+	transcript := sha256.New()
+	transcript.Write(ch.marshal())
+	transcript.Write(sh.random)
+	transcript.Write(sh.ephemeral)
+
+	secret := append(append([]byte(nil), ephemeral...), auth...)
+	r := hkdf.New(sha256.New, secret, transcript.Sum(nil), []byte("video secure v1"))
+	k := &keys{
+		clientWrite: make([]byte, keySize),
+		serverWrite: make([]byte, keySize),
+		confirm:     make([]byte, keySize),
+	}
+	for _, b := range [][]byte{k.clientWrite, k.serverWrite, k.confirm} {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func confirmMAC(k *keys) []byte {
+	m := hmac.New(sha256.New, k.confirm)
+	m.Write([]byte("server finished"))
+	return m.Sum(nil)
+}
+
+// authSecret return the psk or the static-static X25519 secret, the server finds the public key
+// of the client in its pinned keys
+func authSecret(cfg *Config, mode byte, peerStatic []byte) ([]byte, error) {
+	if mode == modePSK {
+		if len(cfg.PSK) == 0 {
+			return nil, ErrNoKey
+		}
+		return cfg.PSK, nil
+	}
+	if len(cfg.PrivateKey) != keySize {
+		return nil, ErrNoKey
+	}
+	pinned := false
+	for _, k := range cfg.PeerKeys {
+		pinned = pinned || hmac.Equal(k, peerStatic)
+	}
+	if !pinned {
+		return nil, ErrUnknownPeer
+	}
+	return curve25519.X25519(cfg.PrivateKey, peerStatic)
+}
+
+func random() ([]byte, error) {
+	b := make([]byte, keySize)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+func isZero(b []byte) bool {
+	return bytes.Equal(b, make([]byte, len(b)))
+}
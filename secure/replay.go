@@ -0,0 +1,44 @@
+package secure
+
+const (
+	replayWindowSize = 1024 // records older than the newest by this are dropped
+)
+
+// replayWindow remembers the recent sequence numbers, like the anti-replay window of IPsec
+type replayWindow struct {
+	highest uint64
+	started bool
+	bitmap  [replayWindowWords]uint64
+}
+
+// a word more than the window, the oldest word of the window never shares a slot with the newest
+const replayWindowWords = replayWindowSize/64 + 1
+
+// check reports whether seq is new, it is accepted only after the record is authentic
+func (w *replayWindow) check(seq uint64) bool {
+	if !w.started || seq > w.highest {
+		return true
+	}
+	if w.highest-seq >= replayWindowSize {
+		return false
+	}
+	return w.bitmap[(seq/64)%uint64(len(w.bitmap))]&(1<<(seq%64)) == 0
+}
+
+func (w *replayWindow) accept(seq uint64) {
+	if !w.started {
+		w.started = true
+		w.highest = seq
+	} else if seq > w.highest {
+		// clear the words between the old and the new highest
+		if seq-w.highest >= replayWindowSize {
+			w.bitmap = [replayWindowWords]uint64{}
+		} else {
+			for s := (w.highest/64 + 1) * 64; s <= seq; s += 64 {
+				w.bitmap[(s/64)%uint64(len(w.bitmap))] = 0
+			}
+		}
+		w.highest = seq
+	}
+	w.bitmap[(seq/64)%uint64(len(w.bitmap))] |= 1 << (seq % 64)
+}
@@ -0,0 +1,147 @@
+// Package session provides the handshake between client and server: the sender offers the codec,
+// resolution, frame rate, bitrate and streams, the receiver accepts, counter-offers or rejects,
+// and both agree on a session id. The same exchange renegotiates a running session.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/l-f-h/video/transport"
+)
+
+const (
+	Version   = 1
+	CodecH264 = "h264"
+)
+
+type MessageType string
+
+const (
+	TypeOffer  MessageType = "offer"
+	TypeAnswer MessageType = "answer"
+	TypeReject MessageType = "reject"
+)
+
+var (
+	ErrVersion  = errors.New("session: unsupported version")
+	ErrRejected = errors.New("session: rejected")
+)
+
+// Stream is one media stream of the session
+type Stream struct {
+	ID   uint32 `json:"id"`
+	Kind string `json:"kind"` // video, audio
+}
+
+// Params of the media, offered by the sender
+type Params struct {
+	Codec   string   `json:"codec"`
+	Width   int      `json:"width"`
+	Height  int      `json:"height"`
+	FPS     int      `json:"fps"`
+	Bitrate int64    `json:"bitrate"` // bit/s
+	Streams []Stream `json:"streams"`
+}
+
+func (p Params) String() string {
+	return fmt.Sprintf("%s %dx%d@%d %dkbps %d streams", p.Codec, p.Width, p.Height, p.FPS, p.Bitrate/1000, len(p.Streams))
+}
+
+// SameMedia reports whether the params describe the same media, streams apart
+func (p Params) SameMedia(o Params) bool {
+	return p.Codec == o.Codec && p.Width == o.Width && p.Height == o.Height && p.FPS == o.FPS && p.Bitrate == o.Bitrate
+}
+
+// Message of the handshake, carried by transport packets of TypeSession.
+// Round starts at 0 for the handshake and goes up with each renegotiation.
+type Message struct {
+	Type      MessageType `json:"type"`
+	Version   int         `json:"version"`
+	SessionID uint64      `json:"session_id"`
+	Round     uint32      `json:"round"`
+	Params    Params      `json:"params"`
+	Countered bool        `json:"countered,omitempty"` // the answer changed the offered params
+	Resume    bool        `json:"resume,omitempty"`    // the offer resumes the session on a new conn
+	Reason    string      `json:"reason,omitempty"`
+}
+
+func (m *Message) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+func Unmarshal(b []byte) (*Message, error) {
+	m := &Message{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("session: %v", err)
+	}
+	if m.Version != Version {
+		return m, ErrVersion
+	}
+	return m, nil
+}
+
+// Packet wrap the message to a transport packet
+func (m *Message) Packet() (*transport.Packet, error) {
+	b, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &transport.Packet{
+		Header:  transport.Header{Type: transport.TypeSession},
+		Payload: b,
+	}, nil
+}
+
+// Capabilities of the receiver, the offers are fitted into them
+type Capabilities struct {
+	Codecs     []string
+	MaxWidth   int
+	MaxHeight  int
+	MaxFPS     int
+	MaxBitrate int64
+	MaxStreams int
+}
+
+var DefaultCapabilities = Capabilities{
+	Codecs:     []string{CodecH264},
+	MaxWidth:   1280,
+	MaxHeight:  720,
+	MaxFPS:     30,
+	MaxBitrate: 8000 * 1000,
+	MaxStreams: 4,
+}
+
+// Fit return the params within the capabilities, an error if they can not be fitted
+func (c Capabilities) Fit(p Params) (Params, error) {
+	supported := false
+	for _, codec := range c.Codecs {
+		supported = supported || codec == p.Codec
+	}
+	if !supported {
+		return p, fmt.Errorf("codec %q not supported", p.Codec)
+	}
+	if p.Width <= 0 || p.Height <= 0 || p.FPS <= 0 {
+		return p, fmt.Errorf("bad video %dx%d@%d", p.Width, p.Height, p.FPS)
+	}
+	if p.Width > c.MaxWidth || p.Height > c.MaxHeight {
+		// keep the aspect ratio, the sizes stay even for yuv420
+		scale := float64(c.MaxWidth) / float64(p.Width)
+		if s := float64(c.MaxHeight) / float64(p.Height); s < scale {
+			scale = s
+		}
+		p.Width = int(float64(p.Width)*scale) &^ 1
+		p.Height = int(float64(p.Height)*scale) &^ 1
+	}
+	if p.FPS > c.MaxFPS {
+		p.FPS = c.MaxFPS
+	}
+	if p.Bitrate > c.MaxBitrate {
+		p.Bitrate = c.MaxBitrate
+	}
+	if len(p.Streams) > c.MaxStreams {
+		p.Streams = p.Streams[:c.MaxStreams]
+	}
+	return p, nil
+}
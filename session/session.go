@@ -0,0 +1,313 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/l-f-h/video/transport"
+)
+
+const (
+	retransmitInterval = 200 * time.Millisecond // the offer is sent again until answered, for udp
+	DefaultTimeout     = 5 * time.Second
+)
+
+var ErrTimeout = errors.New("session: handshake timeout")
+
+// Session is the agreement of both sides
+type Session struct {
+	ID      uint64
+	Params  Params
+	Resumed bool // Accept: the sender resumed the session after a reconnection
+
+	mu      sync.Mutex
+	round   uint32
+	answer  *Message     // last answer of the receiver, sent again for a duplicated offer
+	pending *Message     // renegotiation offer waiting for its answer
+	done    chan Message // the answer of the pending offer
+}
+
+func (s *Session) String() string {
+	return fmt.Sprintf("session %016x %v", s.ID, s.Params)
+}
+
+// Initiate the session on the sender, the offer is sent until the receiver answers or timeout
+func Initiate(conn *transport.Conn, offer Params, timeout time.Duration) (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	answer, err := handshake(conn, &Message{Type: TypeOffer, Version: Version, SessionID: id, Params: offer}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ID: id, Params: answer.Params}, nil
+}
+
+// Resume offer the params of the session again on the new conn of a reconnection, the receiver
+// that still has the session goes on with it. A pending renegotiation is given up and the rounds
+// start again.
+func (s *Session) Resume(conn *transport.Conn, timeout time.Duration) error {
+	s.mu.Lock()
+	if s.pending != nil {
+		s.pending = nil
+		close(s.done)
+	}
+	offer := &Message{Type: TypeOffer, Version: Version, SessionID: s.ID, Params: s.Params, Resume: true}
+	s.mu.Unlock()
+
+	answer, err := handshake(conn, offer, timeout)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.Params = answer.Params
+	s.round = 0
+	s.mu.Unlock()
+	return nil
+}
+
+// handshake send the offer of round 0 until the receiver answers or timeout
+func handshake(conn *transport.Conn, offer *Message, timeout time.Duration) (*Message, error) {
+	pkt, err := offer.Packet()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	defer conn.NetConn().SetReadDeadline(time.Time{})
+	for time.Now().Before(deadline) {
+		if _, err := conn.WritePacket(pkt); err != nil {
+			return nil, err
+		}
+		answer, err := waitMessage(conn, minTime(time.Now().Add(retransmitInterval), deadline))
+		if isTimeout(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if answer.SessionID != offer.SessionID || answer.Round != 0 {
+			continue // stale
+		}
+		switch answer.Type {
+		case TypeAnswer:
+			return answer, nil
+		case TypeReject:
+			return nil, fmt.Errorf("%v: %s", ErrRejected, answer.Reason)
+		}
+	}
+	return nil, ErrTimeout
+}
+
+// Accept the session on the receiver, it waits for an offer and answers it
+// with the offer fitted into caps, timeout 0 waits forever
+func Accept(conn *transport.Conn, caps Capabilities, timeout time.Duration) (*Session, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+		defer conn.NetConn().SetReadDeadline(time.Time{})
+	}
+	for {
+		offer, err := waitMessage(conn, deadline)
+		if isTimeout(err) {
+			return nil, ErrTimeout
+		}
+		if err == ErrVersion {
+			reject := &Message{Type: TypeReject, Version: Version, SessionID: offer.SessionID, Reason: err.Error()}
+			writeMessage(conn, reject)
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		if offer.Type != TypeOffer || offer.Round != 0 {
+			continue
+		}
+		s := &Session{ID: offer.SessionID, Resumed: offer.Resume}
+		answer := s.answerOffer(offer, caps)
+		if err := writeMessage(conn, answer); err != nil {
+			return nil, err
+		}
+		if answer.Type == TypeReject {
+			return nil, fmt.Errorf("%v: %s", ErrRejected, answer.Reason)
+		}
+		return s, nil
+	}
+}
+
+// answerOffer fit the offer into caps and make the answer, the session takes the agreed params
+func (s *Session) answerOffer(offer *Message, caps Capabilities) *Message {
+	answer := &Message{Type: TypeAnswer, Version: Version, SessionID: offer.SessionID, Round: offer.Round}
+	params, err := caps.Fit(offer.Params)
+	if err != nil {
+		answer.Type = TypeReject
+		answer.Reason = err.Error()
+		return answer
+	}
+	answer.Params = params
+	answer.Countered = !params.SameMedia(offer.Params) || len(params.Streams) != len(offer.Params.Streams)
+	s.Params = params
+	s.round = offer.Round
+	s.answer = answer
+	return answer
+}
+
+// HandleOffer answer an offer received during the session on the receiver, a duplicated offer
+// gets the same answer again. It returns nil for an offer of another session or an old round.
+func (s *Session) HandleOffer(offer *Message, caps Capabilities) *Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offer.Type != TypeOffer || offer.SessionID != s.ID {
+		return nil
+	}
+	switch {
+	case offer.Resume:
+		// the sender reconnected on the same conn, like udp, its rounds start again
+		s.round = 0
+		return s.answerOffer(offer, caps)
+	case s.answer != nil && offer.Round == s.answer.Round:
+		return s.answer
+	case offer.Round <= s.round && s.answer != nil:
+		return nil
+	}
+	// a rejected offer leaves the params of the session as they are
+	return s.answerOffer(offer, caps)
+}
+
+// Restart take the offer of a sender that started again on the same conn, like udp: the offer
+// of round 0 of a new session. The session goes on as the new one; it returns nil for the
+// other offers, HandleOffer takes them.
+func (s *Session) Restart(offer *Message, caps Capabilities) *Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offer.Type != TypeOffer || offer.SessionID == s.ID || offer.Round != 0 || offer.Resume {
+		return nil
+	}
+	s.ID, s.Resumed, s.answer = offer.SessionID, false, nil
+	return s.answerOffer(offer, caps)
+}
+
+// Renegotiate offer new params during the session on the sender. The offer is sent again until
+// HandleAnswer gets its answer, which is then delivered on the returned channel.
+// The channel is closed without answer on timeout or when a newer offer replaces this one.
+func (s *Session) Renegotiate(conn *transport.Conn, params Params) (<-chan Message, error) {
+	s.mu.Lock()
+	if s.pending != nil {
+		close(s.done)
+	}
+	s.round++
+	offer := &Message{Type: TypeOffer, Version: Version, SessionID: s.ID, Round: s.round, Params: params}
+	done := make(chan Message, 1)
+	s.pending, s.done = offer, done
+	s.mu.Unlock()
+
+	pkt, err := offer.Packet()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		ticker := time.NewTicker(retransmitInterval)
+		defer ticker.Stop()
+		deadline := time.Now().Add(DefaultTimeout)
+		for {
+			if !s.isPending(offer) {
+				return
+			}
+			if time.Now().After(deadline) {
+				s.mu.Lock()
+				if s.pending == offer {
+					s.pending = nil
+					close(done)
+				}
+				s.mu.Unlock()
+				return
+			}
+			if _, err := conn.WritePacket(pkt); err != nil {
+				return
+			}
+			<-ticker.C
+		}
+	}()
+	return done, nil
+}
+
+func (s *Session) isPending(offer *Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending == offer
+}
+
+// HandleAnswer take the answer of a renegotiation on the sender, it reports whether the answer
+// was for the pending offer. The session takes the agreed params unless it was rejected.
+func (s *Session) HandleAnswer(answer *Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil || answer.SessionID != s.ID || answer.Round != s.pending.Round {
+		return false
+	}
+	if answer.Type == TypeAnswer {
+		s.Params = answer.Params
+	}
+	s.pending = nil
+	s.done <- *answer
+	close(s.done)
+	return true
+}
+
+// CurrentParams return the agreed params, they change with the renegotiation
+func (s *Session) CurrentParams() Params {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Params
+}
+
+func waitMessage(conn *transport.Conn, deadline time.Time) (*Message, error) {
+	if err := conn.NetConn().SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	for {
+		p, err := conn.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if p.Type != transport.TypeSession {
+			continue
+		}
+		return Unmarshal(p.Payload)
+	}
+}
+
+func writeMessage(conn *transport.Conn, m *Message) error {
+	pkt, err := m.Packet()
+	if err != nil {
+		return err
+	}
+	_, err = conn.WritePacket(pkt)
+	return err
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func newID() (uint64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
@@ -0,0 +1,236 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/l-f-h/video/transport"
+)
+
+var offer = Params{
+	Codec:   CodecH264,
+	Width:   1920,
+	Height:  1080,
+	FPS:     60,
+	Bitrate: 4000 * 1000,
+	Streams: []Stream{{ID: 1, Kind: "video"}},
+}
+
+func udpPair(t *testing.T) (sender, receiver *transport.Conn) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := net.DialUDP("udp", nil, l.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport.NewDatagramConn(c), transport.NewDatagramConn(transport.NewPeerConn(l))
+}
+
+func TestHandshakeCounterOffer(t *testing.T) {
+	sender, receiver := udpPair(t)
+	defer sender.Close()
+	defer receiver.Close()
+
+	accepted := make(chan *Session, 1)
+	go func() {
+		s, err := Accept(receiver, DefaultCapabilities, time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- s
+	}()
+	s, err := Initiate(sender, offer, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := <-accepted
+	if r == nil {
+		return
+	}
+	if s.ID != r.ID || s.ID == 0 {
+		t.Fatalf("session ids %x %x", s.ID, r.ID)
+	}
+	want := Params{Codec: CodecH264, Width: 1280, Height: 720, FPS: 30, Bitrate: 4000 * 1000, Streams: offer.Streams}
+	if !s.Params.SameMedia(want) || !r.Params.SameMedia(want) {
+		t.Fatalf("params %v and %v, want %v", s.Params, r.Params, want)
+	}
+}
+
+func TestHandshakeReject(t *testing.T) {
+	sender, receiver := udpPair(t)
+	defer sender.Close()
+	defer receiver.Close()
+
+	go Accept(receiver, DefaultCapabilities, time.Second)
+	vp8 := offer
+	vp8.Codec = "vp8"
+	if _, err := Initiate(sender, vp8, time.Second); err == nil {
+		t.Fatal("vp8 accepted")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	sender, receiver := udpPair(t)
+	defer sender.Close()
+	defer receiver.Close()
+	if _, err := Initiate(sender, offer, 300*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("err %v, want timeout", err)
+	}
+}
+
+func TestRenegotiate(t *testing.T) {
+	s := &Session{ID: 7, Params: offer}
+	r := &Session{ID: 7}
+	r.answerOffer(&Message{Type: TypeOffer, Version: Version, SessionID: 7, Params: offer}, DefaultCapabilities)
+
+	sender, receiver := udpPair(t)
+	defer sender.Close()
+	defer receiver.Close()
+
+	lower := offer
+	lower.Width, lower.Height, lower.FPS = 640, 360, 15
+	done, err := s.Renegotiate(sender, lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the receiver answers the first copy of the offer, and the same answer to a duplicate
+	p, err := receiver.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Unmarshal(p.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := r.HandleOffer(m, DefaultCapabilities)
+	if answer == nil || answer.Type != TypeAnswer || answer.Countered || answer.Round != 1 {
+		t.Fatalf("answer %+v", answer)
+	}
+	if again := r.HandleOffer(m, DefaultCapabilities); again != answer {
+		t.Fatal("duplicated offer answered differently")
+	}
+
+	if !s.HandleAnswer(answer) {
+		t.Fatal("answer not taken")
+	}
+	if got := <-done; got.Type != TypeAnswer {
+		t.Fatalf("done %+v", got)
+	}
+	if s.CurrentParams().Width != 640 || r.CurrentParams().FPS != 15 {
+		t.Fatalf("params %v %v", s.CurrentParams(), r.CurrentParams())
+	}
+	if s.HandleAnswer(answer) {
+		t.Fatal("answer taken twice")
+	}
+}
+
+func TestFit(t *testing.T) {
+	p, err := DefaultCapabilities.Fit(Params{Codec: CodecH264, Width: 1080, Height: 1920, FPS: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Width != 404 || p.Height != 720 {
+		t.Fatalf("fitted to %dx%d", p.Width, p.Height)
+	}
+}
+
+func TestResume(t *testing.T) {
+	s := &Session{ID: 7, Params: offer, round: 3}
+	sender, receiver := udpPair(t)
+	defer sender.Close()
+	defer receiver.Close()
+
+	// a new conn, the receiver takes the session from the offer
+	accepted := make(chan *Session, 1)
+	go func() {
+		r, err := Accept(receiver, DefaultCapabilities, time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- r
+	}()
+	if err := s.Resume(sender, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	r := <-accepted
+	if r == nil {
+		return
+	}
+	if !r.Resumed || r.ID != s.ID || !r.Params.SameMedia(s.CurrentParams()) {
+		t.Fatalf("resumed %v %v, sender %v", r.Resumed, r, s)
+	}
+
+	// the same conn, the receiver of round 1 answers the resume offer and the rounds start again
+	r.answerOffer(&Message{Type: TypeOffer, Version: Version, SessionID: 7, Round: 1, Params: offer}, DefaultCapabilities)
+	answer := r.HandleOffer(&Message{Type: TypeOffer, Version: Version, SessionID: 7, Params: offer, Resume: true}, DefaultCapabilities)
+	if answer == nil || answer.Type != TypeAnswer || answer.Round != 0 {
+		t.Fatalf("answer %+v", answer)
+	}
+	if again := r.HandleOffer(&Message{Type: TypeOffer, Version: Version, SessionID: 7, Round: 1, Params: offer}, DefaultCapabilities); again == nil {
+		t.Fatal("round 1 after the resume not answered")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	sender, receiver := udpPair(t)
+	defer sender.Close()
+	defer receiver.Close()
+	r := &Session{ID: 7, Params: offer, round: 2}
+
+	// the sender starts again on the same socket with a new session
+	initiated := make(chan *Session, 1)
+	go func() {
+		s, err := Initiate(sender, offer, time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		initiated <- s
+	}()
+	p, err := receiver.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Unmarshal(p.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.HandleOffer(m, DefaultCapabilities) != nil {
+		t.Fatal("offer of a new session renegotiated")
+	}
+	answer := r.Restart(m, DefaultCapabilities)
+	if answer == nil || answer.Type != TypeAnswer || answer.Round != 0 {
+		t.Fatalf("answer %+v", answer)
+	}
+	if r.ID != m.SessionID || r.Restart(m, DefaultCapabilities) != nil {
+		t.Fatalf("restarted %v", r)
+	}
+	if again := r.HandleOffer(m, DefaultCapabilities); again != answer {
+		t.Fatal("duplicated offer answered differently")
+	}
+	pkt, err := answer.Packet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := receiver.WritePacket(pkt); err != nil {
+		t.Fatal(err)
+	}
+	s := <-initiated
+	if s == nil {
+		return
+	}
+	if s.ID != r.ID {
+		t.Fatalf("sender %v, receiver %v", s, r)
+	}
+
+	// the rounds of the new session start from the first
+	if r.HandleOffer(&Message{Type: TypeOffer, Version: Version, SessionID: s.ID, Round: 1, Params: offer}, DefaultCapabilities) == nil {
+		t.Fatal("round 1 of the new session not answered")
+	}
+	if r.HandleOffer(&Message{Type: TypeOffer, Version: Version, SessionID: 7, Round: 3, Params: offer}, DefaultCapabilities) != nil {
+		t.Fatal("offer of the old session answered")
+	}
+}
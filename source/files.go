@@ -0,0 +1,73 @@
+package source
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Files plays image files in the order of their names at the frame rate, PNG or JPEG, like the
+// picture sequences of recv -o
+type Files struct {
+	producer
+	names []string
+	fps   int
+	loop  bool
+}
+
+// NewFiles take the files of the glob pattern, like frames/out*.png; loop plays them again
+// after the last one
+func NewFiles(pattern string, fps int, loop bool) (*Files, error) {
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("source: no file matches %s", pattern)
+	}
+	sort.Strings(names)
+	return &Files{producer: newProducer(), names: names, fps: fps, loop: loop}, nil
+}
+
+func (f *Files) Start() {
+	go func() {
+		f.finish(f.run())
+	}()
+}
+
+func (f *Files) run() error {
+	ticker := time.NewTicker(time.Second / time.Duration(f.fps))
+	defer ticker.Stop()
+	for {
+		for _, name := range f.names {
+			img, err := decodeFile(name)
+			if err != nil {
+				return err
+			}
+			if !f.send(Frame{Image: img, Timestamp: time.Now()}) || !f.wait(ticker.C) {
+				return nil
+			}
+		}
+		if !f.loop {
+			return nil
+		}
+	}
+}
+
+func decodeFile(name string) (image.Image, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("source: %s: %v", name, err)
+	}
+	return img, nil
+}
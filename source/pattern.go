@@ -0,0 +1,131 @@
+package source
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+)
+
+func newPicture(width, height int) *image.YCbCr {
+	return image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+}
+
+func clonePicture(img *image.YCbCr) *image.YCbCr {
+	c := *img
+	c.Y = append([]uint8(nil), img.Y...)
+	c.Cb = append([]uint8(nil), img.Cb...)
+	c.Cr = append([]uint8(nil), img.Cr...)
+	return &c
+}
+
+// fill the rectangle with the color
+func fill(img *image.YCbCr, r image.Rectangle, c color.YCbCr) {
+	r = r.Intersect(img.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.Y[img.YOffset(x, y)] = c.Y
+			i := img.COffset(x, y)
+			img.Cb[i], img.Cr[i] = c.Cb, c.Cr
+		}
+	}
+}
+
+func rgb(r, g, b uint8) color.YCbCr {
+	y, cb, cr := color.RGBToYCbCr(r, g, b)
+	return color.YCbCr{Y: y, Cb: cb, Cr: cr}
+}
+
+var (
+	black = rgb(0, 0, 0)
+	white = rgb(255, 255, 255)
+
+	// the 75% bars, the castellations under them, and the bottom row in widths of a bar
+	topBars = []color.YCbCr{
+		rgb(191, 191, 191), rgb(191, 191, 0), rgb(0, 191, 191), rgb(0, 191, 0),
+		rgb(191, 0, 191), rgb(191, 0, 0), rgb(0, 0, 191),
+	}
+	castellations = []color.YCbCr{
+		rgb(0, 0, 191), black, rgb(191, 0, 191), black, rgb(0, 191, 191), black, rgb(191, 191, 191),
+	}
+	bottomBars = []struct {
+		c     color.YCbCr
+		width float64
+	}{
+		{rgb(0, 33, 76), 1.25}, // -I
+		{white, 1.25},
+		{rgb(50, 0, 106), 1.25}, // +Q
+		{black, 1.25},
+		{black, 1.0 / 3}, // the pluge, black, 4% above it, black
+		{rgb(10, 10, 10), 1.0 / 3},
+		{black, 1.0 / 3},
+		{black, 1},
+	}
+)
+
+// drawBars draw the SMPTE color bars
+func drawBars(img *image.YCbCr) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	top, middle := h*2/3, h*3/4
+	bar := float64(w) / float64(len(topBars))
+	for i := range topBars {
+		x0, x1 := int(float64(i)*bar), int(float64(i+1)*bar)
+		fill(img, image.Rect(x0, 0, x1, top), topBars[i])
+		fill(img, image.Rect(x0, top, x1, middle), castellations[i])
+	}
+	x := 0.0
+	for _, b := range bottomBars {
+		fill(img, image.Rect(int(x*bar), middle, int((x+b.width)*bar+0.5), h), b.c)
+		x += b.width
+	}
+}
+
+// drawGradient draw a ramp of luma moving to the right over a hue that turns with the frames
+func drawGradient(img *image.YCbCr, n int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Y[y*img.YStride+x] = uint8((x - 2*n) * 256 / w)
+		}
+	}
+	cw, ch := (w+1)/2, (h+1)/2
+	for y := 0; y < ch; y++ {
+		for x := 0; x < cw; x++ {
+			i := y*img.CStride + x
+			img.Cb[i] = uint8(y*256/ch + n)
+			img.Cr[i] = uint8(x*256/cw - n)
+		}
+	}
+}
+
+var boxColor = rgb(230, 200, 40)
+
+// drawBox draw the box of the frame n on gray, it crosses the picture in about 2s at 30 fps and
+// bounces on the edges
+func drawBox(img *image.YCbCr, n int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	fill(img, img.Rect, color.YCbCr{Y: 64, Cb: 128, Cr: 128})
+	size := h / 5
+	x := bounce(n*max(w/60, 1), w-size)
+	y := bounce(n*max(h/45, 1), h-size)
+	fill(img, image.Rect(x, y, x+size, y+size), boxColor)
+}
+
+// bounce fold the distance into 0 to span and back
+func bounce(d, span int) int {
+	if span <= 0 {
+		return 0
+	}
+	d %= 2 * span
+	if d > span {
+		return 2*span - d
+	}
+	return d
+}
+
+// addNoise add a random value of -amplitude to amplitude to the luma
+func addNoise(img *image.YCbCr, amplitude int, r *rand.Rand) {
+	for i, y := range img.Y {
+		v := int(y) + r.Intn(2*amplitude+1) - amplitude
+		img.Y[i] = uint8(min(max(v, 0), 255))
+	}
+}
@@ -0,0 +1,82 @@
+package source
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"time"
+)
+
+// raw formats of a Pipe, the names of ffmpeg are rgba and yuv420p
+const (
+	FormatRGBA = "rgba"
+	FormatI420 = "i420"
+)
+
+// Pipe reads raw frames of a size and format, like the rawvideo of ffmpeg on stdin. The writer
+// sets the pace, a frame is stamped once read whole. Stop takes effect after the next read.
+type Pipe struct {
+	producer
+	r             io.Reader
+	format        string
+	width, height int
+}
+
+func NewPipe(r io.Reader, format string, width, height int) (*Pipe, error) {
+	switch format {
+	case FormatRGBA:
+	case FormatI420:
+		if width%2 != 0 || height%2 != 0 {
+			return nil, fmt.Errorf("source: i420 of odd size %dx%d", width, height)
+		}
+	default:
+		return nil, ErrFormat
+	}
+	return &Pipe{producer: newProducer(), r: r, format: format, width: width, height: height}, nil
+}
+
+// FrameSize return the bytes of a frame
+func (p *Pipe) FrameSize() int {
+	if p.format == FormatRGBA {
+		return 4 * p.width * p.height
+	}
+	return p.width*p.height + 2*(p.width/2)*(p.height/2)
+}
+
+func (p *Pipe) Start() {
+	go func() {
+		p.finish(p.run())
+	}()
+}
+
+func (p *Pipe) run() error {
+	for {
+		buf := make([]byte, p.FrameSize())
+		if _, err := io.ReadFull(p.r, buf); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !p.send(Frame{Image: p.image(buf), Timestamp: time.Now()}) {
+			return nil
+		}
+	}
+}
+
+// image wrap the bytes of a frame without copy
+func (p *Pipe) image(buf []byte) image.Image {
+	r := image.Rect(0, 0, p.width, p.height)
+	if p.format == FormatRGBA {
+		return &image.RGBA{Pix: buf, Stride: 4 * p.width, Rect: r}
+	}
+	ySize, cSize := p.width*p.height, (p.width/2)*(p.height/2)
+	return &image.YCbCr{
+		Y:              buf[:ySize],
+		Cb:             buf[ySize : ySize+cSize],
+		Cr:             buf[ySize+cSize:],
+		YStride:        p.width,
+		CStride:        p.width / 2,
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Rect:           r,
+	}
+}
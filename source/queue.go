@@ -0,0 +1,92 @@
+package source
+
+import (
+	"sync/atomic"
+)
+
+// Policy of a full queue, when the consumer is slower than the source
+type Policy string
+
+const (
+	// Block waits for the consumer, the source slows down; a camera buffers the frames in its
+	// driver and the latency grows
+	Block Policy = "block"
+	// DropOldest drops the oldest frame of the queue for the new one
+	DropOldest Policy = "drop-oldest"
+	// DropNewest drops the new frame, the queue keeps the older ones
+	DropNewest Policy = "drop-newest"
+	// LatestOnly keeps only the newest frame, the least latency
+	LatestOnly Policy = "latest-only"
+)
+
+// ParsePolicy check the name of a policy
+func ParsePolicy(name string) (Policy, error) {
+	switch p := Policy(name); p {
+	case Block, DropOldest, DropNewest, LatestOnly:
+		return p, nil
+	}
+	return "", ErrPolicy
+}
+
+// Queue passes the frames of a source to its consumer, one pusher, a full queue follows the
+// policy and counts the frames it drops
+type Queue struct {
+	frames  chan Frame
+	policy  Policy
+	dropped uint64 // atomic
+}
+
+// NewQueue make a queue of size frames, of one for LatestOnly
+func NewQueue(size int, policy Policy) *Queue {
+	if policy == LatestOnly {
+		size = 1
+	}
+	return &Queue{frames: make(chan Frame, size), policy: policy}
+}
+
+func (q *Queue) Frames() <-chan Frame {
+	return q.frames
+}
+
+// Dropped return the frames dropped so far
+func (q *Queue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// Push queue the frame, false if done was closed while it waited
+func (q *Queue) Push(f Frame, done <-chan struct{}) bool {
+	switch q.policy {
+	case DropNewest:
+		select {
+		case q.frames <- f:
+		default:
+			atomic.AddUint64(&q.dropped, 1)
+		}
+		return true
+	case DropOldest, LatestOnly:
+		for {
+			select {
+			case q.frames <- f:
+				return true
+			default:
+			}
+			// the consumer may take it first, the next try has room then
+			select {
+			case <-q.frames:
+				atomic.AddUint64(&q.dropped, 1)
+			default:
+			}
+		}
+	}
+	select {
+	case q.frames <- f:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Close the queue after the last frame
+func (q *Queue) Close() {
+	close(q.frames)
+}
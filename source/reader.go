@@ -0,0 +1,58 @@
+package source
+
+import (
+	"image"
+	"io"
+	"time"
+)
+
+// FrameReader decodes the pictures of a stream in order, io.EOF after the last one, like the
+// Y4MReader of codec. A Reader closes it at the end if it is an io.Closer too.
+type FrameReader interface {
+	ReadFrame() (*image.YCbCr, error)
+}
+
+// Reader plays the pictures of a FrameReader
+type Reader struct {
+	producer
+	r        FrameReader
+	interval time.Duration
+}
+
+// NewReader play a picture every interval, or each one once read for 0, when the writer of a
+// pipe sets the pace
+func NewReader(r FrameReader, interval time.Duration) *Reader {
+	return &Reader{producer: newProducer(), r: r, interval: interval}
+}
+
+func (r *Reader) Start() {
+	go func() {
+		r.finish(r.run())
+	}()
+}
+
+func (r *Reader) run() error {
+	if c, ok := r.r.(io.Closer); ok {
+		defer c.Close()
+	}
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		img, err := r.r.ReadFrame()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !r.send(Frame{Image: img, Timestamp: time.Now()}) {
+			return nil
+		}
+		if tick != nil && !r.wait(tick) {
+			return nil
+		}
+	}
+}
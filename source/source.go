@@ -0,0 +1,107 @@
+// Package source has the producers of the pictures to encode behind the Source interface, and
+// the sources that need no camera: a synthetic picture, image files and raw frames from a pipe.
+// The camera of OpenCV is the WebCam of the package cam.
+//
+// The frames wait for the consumer in the Queue of the source, its Policy tells what a full
+// queue does: wait, the default here, or drop frames like a live camera should.
+package source
+
+import (
+	"errors"
+	"image"
+	"sync"
+	"time"
+)
+
+const queueSize = 1 << 5 // frames, like the queue of the camera
+
+var (
+	ErrFormat  = errors.New("source: unknown raw format")
+	ErrPattern = errors.New("source: unknown synthetic pattern")
+	ErrPolicy  = errors.New("source: unknown queue policy")
+)
+
+// Frame is a picture with its capture time
+type Frame struct {
+	image.Image
+	Timestamp time.Time
+}
+
+// Source produces frames from Start until Stop or its end, then Frames is closed and Err tells
+// why: nil for Stop or the end of the frames. SetPolicy goes before Start, Dropped counts the
+// frames of its policy.
+type Source interface {
+	Start()
+	Frames() <-chan Frame
+	Stop()
+	Err() error
+	SetPolicy(p Policy)
+	Dropped() uint64
+}
+
+// producer is the queue and the stop of a source, the loop of the source owns it
+type producer struct {
+	queue    *Queue
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+func newProducer() producer {
+	return producer{
+		queue: NewQueue(queueSize, Block),
+		done:  make(chan struct{}),
+	}
+}
+
+func (p *producer) Frames() <-chan Frame {
+	return p.queue.Frames()
+}
+
+func (p *producer) SetPolicy(policy Policy) {
+	p.queue = NewQueue(queueSize, policy)
+}
+
+func (p *producer) Dropped() uint64 {
+	return p.queue.Dropped()
+}
+
+func (p *producer) Stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
+
+func (p *producer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// send queue the frame, false once stopped
+func (p *producer) send(f Frame) bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	return p.queue.Push(f, p.done)
+}
+
+// wait the tick of the frame rate, false once stopped
+func (p *producer) wait(tick <-chan time.Time) bool {
+	select {
+	case <-tick:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// finish close the queue after the last frame
+func (p *producer) finish(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+	p.queue.Close()
+}
@@ -0,0 +1,338 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// collect read n frames, or all of them for n < 0
+func collect(t *testing.T, s Source, n int) []Frame {
+	var frames []Frame
+	timeout := time.After(5 * time.Second)
+	for n < 0 || len(frames) < n {
+		select {
+		case f, ok := <-s.Frames():
+			if !ok {
+				return frames
+			}
+			frames = append(frames, f)
+		case <-timeout:
+			t.Fatalf("%d frames after 5s", len(frames))
+		}
+	}
+	return frames
+}
+
+// drain wait the end of the queue after Stop
+func drain(t *testing.T, s Source) {
+	collect(t, s, -1)
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSynthetic(t *testing.T) {
+	s, err := NewSynthetic(SyntheticConfig{Width: 64, Height: 48, FPS: 100, Pattern: PatternGradient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	frames := collect(t, s, 5)
+	s.Stop()
+	drain(t, s)
+	for i, f := range frames {
+		if f.Bounds() != image.Rect(0, 0, 64, 48) {
+			t.Fatalf("frame %d of %v", i, f.Bounds())
+		}
+		if i > 0 && !f.Timestamp.After(frames[i-1].Timestamp) {
+			t.Fatalf("frame %d at %v, before %v", i, f.Timestamp, frames[i-1].Timestamp)
+		}
+	}
+	// the picture moves
+	if frames[0].At(10, 30) == frames[1].At(10, 30) {
+		t.Fatal("the same picture twice")
+	}
+
+	for _, spec := range []string{"", "bars", "box,noise=20", "gradient,nostamp"} {
+		if _, err := ParseSynthetic(spec, 64, 48, 30); err != nil {
+			t.Fatalf("%q: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"stars", "box,noise=x", "box,blur=2"} {
+		if _, err := ParseSynthetic(spec, 64, 48, 30); err == nil {
+			t.Fatalf("%q: no error", spec)
+		}
+	}
+}
+
+// lossy pass the picture through jpeg, like the encoder blurs and shifts the colors
+func lossy(t *testing.T, img image.Image, quality int) *image.YCbCr {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := jpeg.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.(*image.YCbCr)
+}
+
+// half scale the picture down by 2, like a sender lowering the resolution
+func half(img *image.YCbCr) *image.YCbCr {
+	out := newPicture(img.Rect.Dx()/2, img.Rect.Dy()/2)
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			out.Y[out.YOffset(x, y)] = img.Y[img.YOffset(2*x, 2*y)]
+		}
+	}
+	return out
+}
+
+func TestStamp(t *testing.T) {
+	at := time.Date(2026, 10, 19, 12, 0, 0, int(123*time.Millisecond), time.UTC)
+	for _, pattern := range []string{PatternBars, PatternGradient, PatternBox} {
+		s, err := NewSynthetic(SyntheticConfig{Width: 640, Height: 360, FPS: 30, Pattern: pattern, Noise: 30})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < 100; n += 37 {
+			img := s.draw(n, at)
+			for name, got := range map[string]*image.YCbCr{"drawn": img, "jpeg": lossy(t, img, 30), "half": half(img)} {
+				stamp, ok := Detect(got)
+				if !ok || stamp.Frame != uint32(n) || !stamp.Time.Equal(at) {
+					t.Fatalf("%s frame %d %s: stamp %v %v", pattern, n, name, stamp, ok)
+				}
+			}
+		}
+	}
+
+	s, _ := NewSynthetic(SyntheticConfig{Width: 640, Height: 360, FPS: 30, Pattern: PatternBox, NoStamp: true})
+	if stamp, ok := Detect(s.draw(7, at)); ok {
+		t.Fatalf("stamp %v without one", stamp)
+	}
+}
+
+func TestStampCheck(t *testing.T) {
+	var c StampCheck
+	for _, n := range []uint32{10, 11, 13, 13, 12, 14, 1000, 1001, 3, 4} {
+		c.Add(Stamp{Frame: n}, true)
+	}
+	c.Add(Stamp{}, false)
+	want := StampCheck{Frames: 11, Unreadable: 1, Missing: 1 + 985, Repeated: 1, Reordered: 1, started: true, last: 4}
+	if c != want {
+		t.Fatalf("%+v, want %+v", c, want)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 3; i++ {
+		img := image.NewGray(image.Rect(0, 0, 8, 8))
+		img.SetGray(0, 0, color.Gray{Y: uint8(i)})
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("out%05d.png", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	s, err := NewFiles(filepath.Join(dir, "out*.png"), 100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	frames := collect(t, s, -1)
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("%d frames of 3 files", len(frames))
+	}
+	for i, f := range frames {
+		if y := f.At(0, 0).(color.Gray).Y; int(y) != i {
+			t.Fatalf("frame %d is the file %d", i, y)
+		}
+	}
+
+	// a loop goes on until stopped
+	s, err = NewFiles(filepath.Join(dir, "out*.png"), 100, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	collect(t, s, 5)
+	s.Stop()
+	drain(t, s)
+
+	if _, err := NewFiles(filepath.Join(dir, "none*.png"), 100, false); err == nil {
+		t.Fatal("no file matched and no error")
+	}
+}
+
+func TestPipe(t *testing.T) {
+	// two rgba frames of 4x2, the second is red
+	var raw bytes.Buffer
+	raw.Write(make([]byte, 4*4*2))
+	raw.Write(bytes.Repeat([]byte{255, 0, 0, 255}, 4*2))
+	s, err := NewPipe(&raw, FormatRGBA, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	frames := collect(t, s, -1)
+	if err := s.Err(); err != nil || len(frames) != 2 {
+		t.Fatalf("%d frames, %v", len(frames), err)
+	}
+	if r, _, _, _ := frames[1].At(3, 1).RGBA(); r != 0xffff {
+		t.Fatalf("red of the second frame %x", r)
+	}
+
+	// an i420 frame and a half
+	yuv := bytes.Repeat([]byte{200}, 4*2+2*2*1)
+	s, err = NewPipe(bytes.NewReader(append(yuv, yuv[:5]...)), FormatI420, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	frames = collect(t, s, -1)
+	if len(frames) != 1 || s.Err() != io.ErrUnexpectedEOF {
+		t.Fatalf("%d frames, %v", len(frames), s.Err())
+	}
+	if y := frames[0].At(1, 1).(color.YCbCr).Y; y != 200 {
+		t.Fatalf("luma %d", y)
+	}
+
+	if _, err := NewPipe(&raw, "nv12", 4, 2); err != ErrFormat {
+		t.Fatalf("format nv12: %v", err)
+	}
+}
+
+// pictures is a FrameReader of n pictures then err
+type pictures struct {
+	n   int
+	err error
+}
+
+func (p *pictures) ReadFrame() (*image.YCbCr, error) {
+	if p.n == 0 {
+		return nil, p.err
+	}
+	p.n--
+	return newPicture(4, 2), nil
+}
+
+func TestReader(t *testing.T) {
+	s := NewReader(&pictures{n: 3, err: io.EOF}, time.Millisecond)
+	s.Start()
+	if frames := collect(t, s, -1); len(frames) != 3 || s.Err() != nil {
+		t.Fatalf("%d frames, %v", len(frames), s.Err())
+	}
+
+	s = NewReader(&pictures{n: 2, err: io.ErrUnexpectedEOF}, 0)
+	s.Start()
+	if frames := collect(t, s, -1); len(frames) != 2 || s.Err() != io.ErrUnexpectedEOF {
+		t.Fatalf("%d frames, %v", len(frames), s.Err())
+	}
+}
+
+func TestQueue(t *testing.T) {
+	// 5 frames in a queue of 3 without consumer
+	for _, c := range []struct {
+		policy Policy
+		want   []int
+	}{
+		{DropOldest, []int{2, 3, 4}},
+		{DropNewest, []int{0, 1, 2}},
+		{LatestOnly, []int{4}},
+	} {
+		q := NewQueue(3, c.policy)
+		for n := 0; n < 5; n++ {
+			if !q.Push(Frame{Timestamp: time.Unix(int64(n), 0)}, nil) {
+				t.Fatalf("%s: push %d", c.policy, n)
+			}
+		}
+		q.Close()
+		var got []int
+		for f := range q.Frames() {
+			got = append(got, int(f.Timestamp.Unix()))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) || q.Dropped() != uint64(5-len(c.want)) {
+			t.Fatalf("%s: frames %v dropped %d, want %v", c.policy, got, q.Dropped(), c.want)
+		}
+	}
+
+	// a full blocking queue waits until done
+	q := NewQueue(1, Block)
+	q.Push(Frame{}, nil)
+	done := make(chan struct{})
+	time.AfterFunc(10*time.Millisecond, func() { close(done) })
+	if q.Push(Frame{}, done) || q.Dropped() != 0 {
+		t.Fatal("pushed to a full queue")
+	}
+
+	if _, err := ParsePolicy("drop-all"); err != ErrPolicy {
+		t.Fatalf("policy drop-all: %v", err)
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	const interval = 2 * time.Millisecond // of the source, the consumer takes 10 times longer
+	for _, policy := range []Policy{Block, DropOldest, DropNewest, LatestOnly} {
+		s, err := NewSynthetic(SyntheticConfig{Width: 88, Height: 50, FPS: int(time.Second / interval), Pattern: PatternBox})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetPolicy(policy)
+		s.Start()
+		var check StampCheck
+		var age time.Duration // of the last frame taken
+		for i := 0; i < 15; i++ {
+			f := collect(t, s, 1)[0]
+			age = time.Since(f.Timestamp)
+			stamp, ok := Detect(f.Image.(*image.YCbCr))
+			check.Add(stamp, ok)
+			time.Sleep(10 * interval)
+		}
+		s.Stop()
+		for _, f := range collect(t, s, -1) {
+			stamp, ok := Detect(f.Image.(*image.YCbCr))
+			check.Add(stamp, ok)
+		}
+		dropped := s.Dropped()
+
+		switch policy {
+		case Block:
+			// every frame arrives, late
+			if dropped != 0 || check.Missing != 0 || age < 10*interval*5 {
+				t.Fatalf("%s: dropped %d, %v, the last one %v old", policy, dropped, &check, age)
+			}
+		case LatestOnly:
+			// the frames are fresh
+			if age > 10*interval {
+				t.Fatalf("%s: the last frame %v old", policy, age)
+			}
+			fallthrough
+		default:
+			// the gaps of the stamps are the drops, a dropped tail of drop-newest is no gap
+			if dropped == 0 || check.Missing > dropped || (policy != DropNewest && check.Missing != dropped) {
+				t.Fatalf("%s: dropped %d, %v", policy, dropped, &check)
+			}
+		}
+		if check.Repeated != 0 || check.Reordered != 0 || check.Unreadable != 0 {
+			t.Fatalf("%s: %v", policy, &check)
+		}
+	}
+}
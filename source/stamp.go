@@ -0,0 +1,179 @@
+package source
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"time"
+)
+
+// The stamp is a strip of black and white cells over the top of the picture, two rows of
+// codeCols, big enough to go through the encoder: the frame number, the capture time in ms and a
+// CRC-8, 88 bits with the most significant first. Under it the same in digits for the eyes.
+// The cells are a fraction of the width, a scaled picture keeps them.
+const (
+	codeCols  = 44
+	codeRows  = 2
+	codeBytes = codeCols * codeRows / 8
+
+	maxReorder = 64 // frames, further back the sender started again
+)
+
+// Stamp is the frame number and the capture time burned in a picture
+type Stamp struct {
+	Frame uint32
+	Time  time.Time // ms
+}
+
+func (s Stamp) marshal() []byte {
+	b := make([]byte, codeBytes)
+	binary.BigEndian.PutUint32(b, s.Frame)
+	ms := uint64(s.Time.UnixMilli())
+	binary.BigEndian.PutUint16(b[4:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[6:], uint32(ms))
+	b[10] = crc8(b[:10])
+	return b
+}
+
+// cell return the rectangle of the bit i of the stamp in a picture of width w
+func cell(i, w int) image.Rectangle {
+	col, row := i%codeCols, i/codeCols
+	return image.Rect(col*w/codeCols, row*w/codeCols, (col+1)*w/codeCols, (row+1)*w/codeCols)
+}
+
+// drawStamp burn the stamp in the picture
+func drawStamp(img *image.YCbCr, s Stamp) {
+	w := img.Rect.Dx()
+	b := s.marshal()
+	for i := 0; i < 8*codeBytes; i++ {
+		c := black
+		if b[i/8]&(0x80>>(i%8)) != 0 {
+			c = white
+		}
+		fill(img, cell(i, w).Add(img.Rect.Min), c)
+	}
+	px := max(w/codeCols/3, 1)
+	at := img.Rect.Min.Add(image.Pt(px, codeRows*w/codeCols+px))
+	drawText(img, at, px, fmt.Sprintf("%08d %s", s.Frame, s.Time.Format("15:04:05.000")))
+}
+
+// Detect read the stamp of a decoded picture of a Synthetic, false if it has none or it is
+// damaged
+func Detect(img *image.YCbCr) (Stamp, bool) {
+	w := img.Rect.Dx()
+	if w < codeCols || img.Rect.Dy() < codeRows*w/codeCols {
+		return Stamp{}, false
+	}
+	b := make([]byte, codeBytes)
+	for i := 0; i < 8*codeBytes; i++ {
+		// the middle of the cell, the encoder blurs the edges
+		r := cell(i, w).Add(img.Rect.Min)
+		r = r.Inset(min(r.Dx(), r.Dy()) / 4)
+		sum, n := 0, 0
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				sum += int(img.Y[img.YOffset(x, y)])
+				n++
+			}
+		}
+		if n > 0 && sum/n >= 128 {
+			b[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	if crc8(b[:10]) != b[10] {
+		return Stamp{}, false
+	}
+	ms := uint64(binary.BigEndian.Uint16(b[4:]))<<32 | uint64(binary.BigEndian.Uint32(b[6:]))
+	return Stamp{Frame: binary.BigEndian.Uint32(b), Time: time.UnixMilli(int64(ms))}, true
+}
+
+// crc8 with the polynomial x^8+x^2+x+1 from 0xff, a flat picture is no stamp
+func crc8(b []byte) uint8 {
+	crc := uint8(0xff)
+	for _, v := range b {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// StampCheck follows the stamps of the received pictures in their order: the frames missing
+// between two numbers, the ones received again or after a later one
+type StampCheck struct {
+	Frames     uint64
+	Unreadable uint64 // without a stamp
+	Missing    uint64
+	Repeated   uint64
+	Reordered  uint64
+
+	started bool
+	last    uint32
+}
+
+// Add the stamp of the next picture, ok is the one of Detect
+func (c *StampCheck) Add(s Stamp, ok bool) {
+	c.Frames++
+	if !ok {
+		c.Unreadable++
+		return
+	}
+	d := int32(s.Frame - c.last)
+	switch {
+	case !c.started || d < -maxReorder:
+		c.started = true
+	case d == 0:
+		c.Repeated++
+		return
+	case d < 0:
+		c.Reordered++
+		return
+	default:
+		c.Missing += uint64(d - 1)
+	}
+	c.last = s.Frame
+}
+
+func (c *StampCheck) String() string {
+	return fmt.Sprintf("stamps %d missing %d repeated %d reordered %d unreadable %d",
+		c.Frames, c.Missing, c.Repeated, c.Reordered, c.Unreadable)
+}
+
+// glyphs of 3x5 pixels, a row per byte with the left pixel in the bit 2
+var glyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	':': {0, 2, 0, 2, 0},
+	'.': {0, 0, 0, 0, 2},
+	' ': {},
+}
+
+// drawText write the text in white on black from the top left at, with pixels of px
+func drawText(img *image.YCbCr, at image.Point, px int, text string) {
+	fill(img, image.Rect(at.X, at.Y, at.X+(4*len(text)+1)*px, at.Y+7*px), black)
+	for i, r := range text {
+		g := glyphs[r]
+		for row := 0; row < 5; row++ {
+			for col := 0; col < 3; col++ {
+				if g[row]&(4>>col) == 0 {
+					continue
+				}
+				x, y := at.X+(4*i+1+col)*px, at.Y+(1+row)*px
+				fill(img, image.Rect(x, y, x+px, y+px), white)
+			}
+		}
+	}
+}
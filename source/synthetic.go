@@ -0,0 +1,110 @@
+package source
+
+import (
+	"image"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PatternBars     = "bars"     // SMPTE color bars
+	PatternGradient = "gradient" // moving gradients of luma and hue
+	PatternBox      = "box"      // a box bouncing on a gray background
+)
+
+// SyntheticConfig of a Synthetic
+type SyntheticConfig struct {
+	Width, Height int
+	FPS           int
+	Pattern       string
+	Noise         int  // amplitude of the random noise on the luma, 0 for none
+	NoStamp       bool // no burned-in counter and time
+}
+
+// Synthetic draws a test pattern at the frame rate, like a camera. Every frame has its number
+// and capture time burned in, Detect reads them back from the decoded picture.
+type Synthetic struct {
+	producer
+	cfg        SyntheticConfig
+	background *image.YCbCr // of the still patterns
+	rand       *rand.Rand
+}
+
+func NewSynthetic(cfg SyntheticConfig) (*Synthetic, error) {
+	s := &Synthetic{producer: newProducer(), cfg: cfg, rand: rand.New(rand.NewSource(1))}
+	switch cfg.Pattern {
+	case PatternBars:
+		s.background = newPicture(cfg.Width, cfg.Height)
+		drawBars(s.background)
+	case PatternGradient, PatternBox:
+	default:
+		return nil, ErrPattern
+	}
+	return s, nil
+}
+
+// ParseSynthetic make the Synthetic of a spec like box,noise=16: the pattern bars, gradient or
+// box first, gradient if empty, then noise=<amplitude> and nostamp
+func ParseSynthetic(spec string, width, height, fps int) (*Synthetic, error) {
+	cfg := SyntheticConfig{Width: width, Height: height, FPS: fps, Pattern: PatternGradient}
+	for i, opt := range strings.Split(spec, ",") {
+		name, value, _ := strings.Cut(opt, "=")
+		switch {
+		case name == "nostamp":
+			cfg.NoStamp = true
+		case i == 0 && value == "":
+			if name != "" {
+				cfg.Pattern = name
+			}
+		case name == "noise":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Noise = n
+		default:
+			return nil, ErrPattern
+		}
+	}
+	return NewSynthetic(cfg)
+}
+
+func (s *Synthetic) Start() {
+	go s.run()
+}
+
+func (s *Synthetic) run() {
+	ticker := time.NewTicker(time.Second / time.Duration(s.cfg.FPS))
+	defer ticker.Stop()
+	for n := 0; ; n++ {
+		now := time.Now()
+		if !s.send(Frame{Image: s.draw(n, now), Timestamp: now}) || !s.wait(ticker.C) {
+			break
+		}
+	}
+	s.finish(nil)
+}
+
+// draw the frame n captured at t
+func (s *Synthetic) draw(n int, t time.Time) *image.YCbCr {
+	var img *image.YCbCr
+	switch s.cfg.Pattern {
+	case PatternGradient:
+		img = newPicture(s.cfg.Width, s.cfg.Height)
+		drawGradient(img, n)
+	case PatternBox:
+		img = newPicture(s.cfg.Width, s.cfg.Height)
+		drawBox(img, n)
+	default:
+		img = clonePicture(s.background)
+	}
+	if s.cfg.Noise > 0 {
+		addNoise(img, s.cfg.Noise, s.rand)
+	}
+	if !s.cfg.NoStamp {
+		drawStamp(img, Stamp{Frame: uint32(n), Time: t})
+	}
+	return img
+}
@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	lengthSize    = 2
+	readBufferLen = 1 << 16
+)
+
+var ErrBadRecord = errors.New("transport: bad record length")
+
+// Conn sends packets as records of [length(2)][packet] over a net.Conn.
+// A stream is cut by the length. A datagram conn carries whole records, the rest of a
+// datagram is dropped after a bad or cut record, so junk or a lost datagram never breaks
+// the framing of the next ones.
+type Conn struct {
+	conn     net.Conn
+	datagram bool
+	epoch    time.Time
+
+	wmu  sync.Mutex
+	seq  uint16
+	wbuf []byte
+
+	rbuf  []byte
+	start int
+	end   int
+}
+
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:  conn,
+		epoch: time.Now(),
+		wbuf:  make([]byte, lengthSize+MaxPacketSize),
+		rbuf:  make([]byte, readBufferLen),
+	}
+}
+
+// NewDatagramConn return a Conn over a conn that keeps the boundaries of the datagrams, like udp
+func NewDatagramConn(conn net.Conn) *Conn {
+	c := NewConn(conn)
+	c.datagram = true
+	return c
+}
+
+// Reconnect return a Conn over the new conn of a reconnection that goes on with the sequence
+// numbers and the clock of c, the feedback of the peer still matches the packets sent before
+func (c *Conn) Reconnect(conn net.Conn) *Conn {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	n := NewConn(conn)
+	n.datagram, n.epoch, n.seq = c.datagram, c.epoch, c.seq
+	return n
+}
+
+// WritePacket stamp the transport sequence number and the send time, then write the packet.
+// It is safe to call from several goroutines, return the sequence number of the packet.
+func (c *Conn) WritePacket(p *Packet) (uint16, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	p.Seq = c.seq
+	p.SendTime = uint32(time.Since(c.epoch) / time.Microsecond)
+	c.seq++
+
+	size := p.Size()
+	if size > 1<<16-1 {
+		return p.Seq, ErrBadRecord
+	}
+	if cap(c.wbuf) < lengthSize+size {
+		c.wbuf = make([]byte, lengthSize+size)
+	}
+	b := c.wbuf[:lengthSize+size]
+	binary.BigEndian.PutUint16(b, uint16(size))
+	p.MarshalTo(b[lengthSize:])
+	_, err := c.conn.Write(b)
+	return p.Seq, err
+}
+
+// ReadPacket read the next packet, the payload is valid until the next call. A datagram conn
+// skips the bad records, a stream one fails on them.
+func (c *Conn) ReadPacket() (*Packet, error) {
+	for {
+		if c.end-c.start >= lengthSize {
+			size := int(binary.BigEndian.Uint16(c.rbuf[c.start:]))
+			if size < HeaderSize {
+				if c.datagram {
+					c.start = c.end
+					continue
+				}
+				return nil, ErrBadRecord
+			}
+			if c.end-c.start >= lengthSize+size {
+				record := c.rbuf[c.start+lengthSize : c.start+lengthSize+size]
+				c.start += lengthSize + size
+				p, err := Unmarshal(record)
+				if err == ErrBadVersion || (err != nil && c.datagram) {
+					continue
+				}
+				return p, err
+			}
+		}
+		if c.datagram {
+			// the rest of the datagram is cut, a record never spans two
+			c.start, c.end = 0, 0
+		} else if c.start > 0 {
+			// compact the buffer, the rest of a record is still in flight
+			c.end = copy(c.rbuf, c.rbuf[c.start:c.end])
+			c.start = 0
+		}
+		n, err := c.conn.Read(c.rbuf[c.end:])
+		c.end += n
+		if err != nil {
+			if err == io.EOF && c.end > 0 && n == 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if n == 0 {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Since return the duration from the epoch of the conn, it is the clock of SendTime
+func (c *Conn) Since(t time.Time) time.Duration {
+	return t.Sub(c.epoch)
+}
+
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,208 @@
+package transport
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+const (
+	FeedbackInterval   = 50 * time.Millisecond
+	maxFeedbackPacket  = (MaxPayloadSize - feedbackHeaderSize) * 8 / (8*4 + 1) // bitmap bit + delta of each packet
+	feedbackHeaderSize = 12
+)
+
+// PacketArrival is the fate of one packet in the feedback
+type PacketArrival struct {
+	Received bool
+	Delta    int32 // us after the RefTime of the feedback
+}
+
+// Feedback reports the arrival time of each packet since the last feedback, like transport-cc of webrtc
+//
+//	0-1: base seq
+//	2-3: packet count
+//	4-11: reference time, us of the receiver clock
+//	bitmap of received packets, then the int32 delta of each received packet
+type Feedback struct {
+	BaseSeq uint16
+	RefTime int64
+	Packets []PacketArrival
+}
+
+func (fb *Feedback) Marshal() []byte {
+	bitmapLen := (len(fb.Packets) + 7) / 8
+	received := 0
+	for _, p := range fb.Packets {
+		if p.Received {
+			received++
+		}
+	}
+	b := make([]byte, feedbackHeaderSize+bitmapLen+received*4)
+	binary.BigEndian.PutUint16(b[0:], fb.BaseSeq)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(fb.Packets)))
+	binary.BigEndian.PutUint64(b[4:], uint64(fb.RefTime))
+	bitmap := b[feedbackHeaderSize : feedbackHeaderSize+bitmapLen]
+	deltas := b[feedbackHeaderSize+bitmapLen:]
+	for i, p := range fb.Packets {
+		if !p.Received {
+			continue
+		}
+		bitmap[i/8] |= 1 << uint(7-i%8)
+		binary.BigEndian.PutUint32(deltas, uint32(p.Delta))
+		deltas = deltas[4:]
+	}
+	return b
+}
+
+func UnmarshalFeedback(b []byte) (*Feedback, error) {
+	if len(b) < feedbackHeaderSize {
+		return nil, ErrShortPacket
+	}
+	fb := &Feedback{
+		BaseSeq: binary.BigEndian.Uint16(b[0:]),
+		RefTime: int64(binary.BigEndian.Uint64(b[4:])),
+		Packets: make([]PacketArrival, binary.BigEndian.Uint16(b[2:])),
+	}
+	bitmapLen := (len(fb.Packets) + 7) / 8
+	if len(b) < feedbackHeaderSize+bitmapLen {
+		return nil, ErrShortPacket
+	}
+	bitmap := b[feedbackHeaderSize : feedbackHeaderSize+bitmapLen]
+	deltas := b[feedbackHeaderSize+bitmapLen:]
+	for i := range fb.Packets {
+		if bitmap[i/8]&(1<<uint(7-i%8)) == 0 {
+			continue
+		}
+		if len(deltas) < 4 {
+			return nil, ErrShortPacket
+		}
+		fb.Packets[i] = PacketArrival{Received: true, Delta: int32(binary.BigEndian.Uint32(deltas))}
+		deltas = deltas[4:]
+	}
+	return fb, nil
+}
+
+// NewFeedbackPacket wrap the feedback to a packet
+func NewFeedbackPacket(fb *Feedback) *Packet {
+	return &Packet{
+		Header:  Header{Type: TypeFeedback},
+		Payload: fb.Marshal(),
+	}
+}
+
+// SeqUnwrapper extend the 16 bit sequence numbers to int64
+type SeqUnwrapper struct {
+	last    int64
+	started bool
+}
+
+func (u *SeqUnwrapper) Unwrap(seq uint16) int64 {
+	if !u.started {
+		u.started = true
+		u.last = int64(seq)
+		return u.last
+	}
+	u.last = u.Peek(seq)
+	return u.last
+}
+
+// Peek unwrap seq against the last one without updating the state
+func (u *SeqUnwrapper) Peek(seq uint16) int64 {
+	return u.last + int64(int16(seq-uint16(u.last)))
+}
+
+// FeedbackRecorder records the arrival of the packets on the receiver and builds the feedback
+type FeedbackRecorder struct {
+	mu        sync.Mutex
+	epoch     time.Time
+	unwrapper SeqUnwrapper
+	arrivals  map[int64]time.Time
+	next      int64 // the first seq not reported yet
+	highest   int64
+	started   bool
+	stats     RecorderStats
+}
+
+// RecorderStats counts the packets reported by the feedback
+type RecorderStats struct {
+	Received uint64
+	Lost     uint64 // not arrived when reported, a late packet is counted as lost
+}
+
+func NewFeedbackRecorder() *FeedbackRecorder {
+	return &FeedbackRecorder{
+		epoch:    time.Now(),
+		arrivals: make(map[int64]time.Time),
+	}
+}
+
+func (r *FeedbackRecorder) OnPacket(seq uint16, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.unwrapper.Unwrap(seq)
+	if !r.started {
+		r.started = true
+		r.next = s
+		r.highest = s
+	}
+	if s < r.next {
+		return // reported as lost already
+	}
+	if s > r.highest {
+		r.highest = s
+	}
+	r.arrivals[s] = at
+}
+
+// Restart follow the seqs of a new sender from its next packet, the packets of the old one not
+// reported yet are dropped and the stats go on
+func (r *FeedbackRecorder) Restart() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unwrapper = SeqUnwrapper{}
+	r.arrivals = make(map[int64]time.Time)
+	r.started = false
+}
+
+// Build the feedback of the packets since the last one, return nil if nothing arrived
+func (r *FeedbackRecorder) Build() *Feedback {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started || r.highest < r.next {
+		return nil
+	}
+	end := r.highest + 1
+	if end-r.next > maxFeedbackPacket {
+		end = r.next + maxFeedbackPacket
+	}
+
+	var ref time.Time
+	for s := r.next; s < end; s++ {
+		if at, ok := r.arrivals[s]; ok && (ref.IsZero() || at.Before(ref)) {
+			ref = at
+		}
+	}
+	fb := &Feedback{
+		BaseSeq: uint16(r.next),
+		RefTime: int64(ref.Sub(r.epoch) / time.Microsecond),
+		Packets: make([]PacketArrival, end-r.next),
+	}
+	for s := r.next; s < end; s++ {
+		if at, ok := r.arrivals[s]; ok {
+			fb.Packets[s-r.next] = PacketArrival{Received: true, Delta: int32(at.Sub(ref) / time.Microsecond)}
+			delete(r.arrivals, s)
+			r.stats.Received++
+		} else {
+			r.stats.Lost++
+		}
+	}
+	r.next = end
+	return fb
+}
+
+func (r *FeedbackRecorder) Stats() RecorderStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
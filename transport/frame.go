@@ -0,0 +1,184 @@
+package transport
+
+import (
+	"time"
+)
+
+const (
+	maxPendingFrames = 1 << 5 // frames waiting for their fragments
+)
+
+// Packetizer cut the encoded frames to packets
+type Packetizer struct {
+	frameID uint32
+}
+
+func NewPacketizer() *Packetizer {
+	return &Packetizer{}
+}
+
+// Packetize return the media packets of one frame captured at captured, their payloads refer to data
+func (pz *Packetizer) Packetize(data []byte, keyFrame bool, captured time.Time) []*Packet {
+	pz.frameID++
+	cnt := (len(data) + MaxPayloadSize - 1) / MaxPayloadSize
+	if cnt == 0 {
+		cnt = 1
+	}
+	var flags uint8
+	if keyFrame {
+		flags |= FlagKeyFrame
+	}
+	var timestamp int64
+	if !captured.IsZero() {
+		timestamp = captured.UnixNano() / int64(time.Microsecond)
+	}
+	packets := make([]*Packet, 0, cnt)
+	for i := 0; i < cnt; i++ {
+		end := (i + 1) * MaxPayloadSize
+		if end > len(data) {
+			end = len(data)
+		}
+		packets = append(packets, &Packet{
+			Header: Header{
+				Type:      TypeMedia,
+				Flags:     flags,
+				FrameID:   pz.frameID,
+				FragIdx:   uint16(i),
+				FragCnt:   uint16(cnt),
+				Timestamp: timestamp,
+			},
+			Payload: data[i*MaxPayloadSize : end],
+		})
+	}
+	return packets
+}
+
+// Frame is a complete frame from the reassembler
+type Frame struct {
+	ID        uint32
+	KeyFrame  bool
+	Timestamp time.Time // capture time on the sender clock
+	Data      []byte
+}
+
+type partialFrame struct {
+	id       uint32
+	keyFrame bool
+	captured time.Time
+	frags    [][]byte
+	received int
+}
+
+// ReassemblerStats is the counter of the receiver
+type ReassemblerStats struct {
+	Frames     uint64 // complete frames
+	LostFrames uint64 // frames never completed, partially or entirely lost
+	Packets    uint64
+	Duplicates uint64
+}
+
+// Reassembler join the fragments to frames and deliver them in order.
+// A frame missing fragments is dropped once a later frame completes.
+type Reassembler struct {
+	pending   map[uint32]*partialFrame
+	delivered uint32 // id of the last delivered frame
+	started   bool
+	stats     ReassemblerStats
+}
+
+func NewReassembler() *Reassembler {
+	return &Reassembler{
+		pending: make(map[uint32]*partialFrame),
+	}
+}
+
+// Push a media packet, return the frame completed by it or nil
+func (r *Reassembler) Push(p *Packet) *Frame {
+	r.stats.Packets++
+	if p.FragCnt == 0 || p.FragIdx >= p.FragCnt {
+		return nil
+	}
+	if r.started && !after(p.FrameID, r.delivered) {
+		r.stats.Duplicates++ // late or duplicated
+		return nil
+	}
+
+	f, ok := r.pending[p.FrameID]
+	if !ok {
+		f = &partialFrame{id: p.FrameID, captured: p.CaptureTime(), frags: make([][]byte, p.FragCnt)}
+		r.pending[p.FrameID] = f
+	}
+	if int(p.FragIdx) >= len(f.frags) {
+		return nil
+	}
+	if f.frags[p.FragIdx] != nil {
+		r.stats.Duplicates++
+		return nil
+	}
+	frag := make([]byte, len(p.Payload))
+	copy(frag, p.Payload)
+	f.frags[p.FragIdx] = frag
+	f.received++
+	f.keyFrame = f.keyFrame || p.IsKeyFrame()
+
+	if f.received < len(f.frags) {
+		r.evict()
+		return nil
+	}
+	return r.deliver(f.id)
+}
+
+// deliver the complete frame id, the older frames still missing fragments are given up.
+// Every complete frame is delivered at once, so the pending frames before id are all partial.
+func (r *Reassembler) deliver(id uint32) *Frame {
+	for pid := range r.pending {
+		if !after(pid, id) && pid != id {
+			delete(r.pending, pid)
+		}
+	}
+	f := r.pending[id]
+	delete(r.pending, id)
+	if r.started {
+		r.stats.LostFrames += uint64(id - r.delivered - 1)
+	}
+	r.delivered = id
+	r.started = true
+	r.stats.Frames++
+	return join(f)
+}
+
+// evict the oldest partial frame if too many are waiting, it is counted as lost on the next delivery
+func (r *Reassembler) evict() {
+	if len(r.pending) <= maxPendingFrames {
+		return
+	}
+	var oldest uint32
+	first := true
+	for pid := range r.pending {
+		if first || after(oldest, pid) {
+			oldest, first = pid, false
+		}
+	}
+	delete(r.pending, oldest)
+}
+
+func (r *Reassembler) Stats() ReassemblerStats {
+	return r.stats
+}
+
+func join(f *partialFrame) *Frame {
+	size := 0
+	for _, frag := range f.frags {
+		size += len(frag)
+	}
+	data := make([]byte, 0, size)
+	for _, frag := range f.frags {
+		data = append(data, frag...)
+	}
+	return &Frame{ID: f.id, KeyFrame: f.keyFrame, Timestamp: f.captured, Data: data}
+}
+
+// after report whether a is after b with wrapping
+func after(a, b uint32) bool {
+	return a != b && a-b < 1<<31
+}
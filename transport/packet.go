@@ -0,0 +1,123 @@
+// Package transport provides the packet format shared by all the protocols (tcp/udp/rudp) between client and server
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type PacketType uint8
+
+const (
+	TypeMedia PacketType = iota
+	TypeFeedback
+	TypeClock   // probe for the clock offset between the hosts
+	TypeSession // handshake and renegotiation of the session
+)
+
+const (
+	FlagKeyFrame uint8 = 1 << iota // the frame has an IDR slice
+)
+
+const (
+	Version        = 1
+	HeaderSize     = 24
+	MaxPacketSize  = 1200 // a packet fits the udp MTU of any path
+	MaxPayloadSize = MaxPacketSize - HeaderSize
+)
+
+var (
+	ErrShortPacket = errors.New("transport: packet too short")
+	ErrBadVersion  = errors.New("transport: unknown version")
+)
+
+// Header of every packet
+//
+//	0: version(4) | type(4)
+//	1: flags
+//	2-3: transport-wide sequence number, every sent packet gets one
+//	4-7: frame id
+//	8-9: fragment index in the frame
+//	10-11: fragment count of the frame
+//	12-15: send time, us of the sender clock, wraps
+//	16-23: capture time of the frame, unix us of the sender clock, 0 if unknown
+type Header struct {
+	Type      PacketType
+	Flags     uint8
+	Seq       uint16
+	FrameID   uint32
+	FragIdx   uint16
+	FragCnt   uint16
+	SendTime  uint32
+	Timestamp int64
+}
+
+type Packet struct {
+	Header
+	Payload []byte
+}
+
+func (p *Packet) IsKeyFrame() bool {
+	return p.Flags&FlagKeyFrame != 0
+}
+
+func (p *Packet) Size() int {
+	return HeaderSize + len(p.Payload)
+}
+
+func (p *Packet) Marshal() []byte {
+	b := make([]byte, p.Size())
+	p.MarshalTo(b)
+	return b
+}
+
+// MarshalTo write the packet to b, b must have p.Size() bytes
+func (p *Packet) MarshalTo(b []byte) {
+	b[0] = Version<<4 | byte(p.Type)&0x0f
+	b[1] = p.Flags
+	binary.BigEndian.PutUint16(b[2:], p.Seq)
+	binary.BigEndian.PutUint32(b[4:], p.FrameID)
+	binary.BigEndian.PutUint16(b[8:], p.FragIdx)
+	binary.BigEndian.PutUint16(b[10:], p.FragCnt)
+	binary.BigEndian.PutUint32(b[12:], p.SendTime)
+	binary.BigEndian.PutUint64(b[16:], uint64(p.Timestamp))
+	copy(b[HeaderSize:], p.Payload)
+}
+
+// Unmarshal parse a packet, the payload refers to b
+func Unmarshal(b []byte) (*Packet, error) {
+	if len(b) < HeaderSize {
+		return nil, ErrShortPacket
+	}
+	if b[0]>>4 != Version {
+		return nil, ErrBadVersion
+	}
+	p := &Packet{
+		Header: Header{
+			Type:      PacketType(b[0] & 0x0f),
+			Flags:     b[1],
+			Seq:       binary.BigEndian.Uint16(b[2:]),
+			FrameID:   binary.BigEndian.Uint32(b[4:]),
+			FragIdx:   binary.BigEndian.Uint16(b[8:]),
+			FragCnt:   binary.BigEndian.Uint16(b[10:]),
+			SendTime:  binary.BigEndian.Uint32(b[12:]),
+			Timestamp: int64(binary.BigEndian.Uint64(b[16:])),
+		},
+		Payload: b[HeaderSize:],
+	}
+	return p, nil
+}
+
+// CaptureTime return the capture time of the frame, zero if unknown
+func (h Header) CaptureTime() time.Time {
+	if h.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, h.Timestamp*int64(time.Microsecond))
+}
+
+func (h Header) String() string {
+	return fmt.Sprintf("type %d seq %d frame %d %d/%d flags %#x", h.Type, h.Seq, h.FrameID, h.FragIdx, h.FragCnt, h.Flags)
+}
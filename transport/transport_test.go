@@ -0,0 +1,219 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketMarshal(t *testing.T) {
+	p := &Packet{
+		Header:  Header{Type: TypeMedia, Flags: FlagKeyFrame, Seq: 65535, FrameID: 7, FragIdx: 1, FragCnt: 3, SendTime: 123456, Timestamp: 1600000000123456},
+		Payload: []byte{0, 0, 0, 1, 0x65},
+	}
+	q, err := Unmarshal(p.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Header != p.Header || !bytes.Equal(q.Payload, p.Payload) {
+		t.Fatalf("got %v, want %v", q.Header, p.Header)
+	}
+}
+
+func TestReassembler(t *testing.T) {
+	pz := NewPacketizer()
+	r := NewReassembler()
+
+	key := bytes.Repeat([]byte{1}, MaxPayloadSize*2+10)
+	captured := time.Unix(1600000000, 123456000)
+	packets := pz.Packetize(key, true, captured)
+	if len(packets) != 3 {
+		t.Fatalf("%d packets, want 3", len(packets))
+	}
+	// out of order
+	for _, i := range []int{2, 0, 1} {
+		if f := r.Push(packets[i]); f != nil {
+			if i != 1 || !bytes.Equal(f.Data, key) || !f.KeyFrame || !f.Timestamp.Equal(captured) {
+				t.Fatalf("bad frame %d at packet %d", f.ID, i)
+			}
+		}
+	}
+
+	// frame 2 loses a fragment, frame 3 completes
+	lost := pz.Packetize(bytes.Repeat([]byte{2}, MaxPayloadSize+1), false, time.Time{})
+	r.Push(lost[0])
+	if f := r.Push(pz.Packetize([]byte{3}, false, time.Time{})[0]); f == nil || f.ID != 3 {
+		t.Fatalf("frame 3 not delivered")
+	}
+	// the late fragment is dropped
+	if f := r.Push(lost[1]); f != nil {
+		t.Fatalf("late frame %d delivered", f.ID)
+	}
+	stats := r.Stats()
+	if stats.Frames != 2 || stats.LostFrames != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestConnOverStreamAndDatagram(t *testing.T) {
+	for _, network := range []string{"tcp", "udp"} {
+		var a, b net.Conn
+		if network == "tcp" {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			if a, err = net.Dial("tcp", l.Addr().String()); err != nil {
+				t.Fatal(err)
+			}
+			if b, err = l.Accept(); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b = NewPeerConn(pc)
+			if a, err = net.Dial("udp", pc.LocalAddr().String()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sender, receiver := NewConn(a), NewConn(b)
+		if network == "udp" {
+			sender, receiver = NewDatagramConn(a), NewDatagramConn(b)
+		}
+		data := bytes.Repeat([]byte{9}, 3000)
+		for _, p := range NewPacketizer().Packetize(data, false, time.Now()) {
+			if _, err := sender.WritePacket(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r := NewReassembler()
+		var frame *Frame
+		for frame == nil {
+			p, err := receiver.ReadPacket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame = r.Push(p)
+		}
+		if !bytes.Equal(frame.Data, data) {
+			t.Fatalf("%s: frame corrupted", network)
+		}
+
+		// feedback goes back on the same conn
+		if _, err := receiver.WritePacket(NewFeedbackPacket(&Feedback{BaseSeq: 1})); err != nil {
+			t.Fatal(err)
+		}
+		p, err := sender.ReadPacket()
+		if err != nil || p.Type != TypeFeedback {
+			t.Fatalf("%s: read feedback %v %v", network, p, err)
+		}
+		a.Close()
+		b.Close()
+	}
+}
+
+func TestDatagramJunk(t *testing.T) {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	a, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	sender, receiver := NewDatagramConn(a), NewDatagramConn(NewPeerConn(pc))
+
+	junk := [][]byte{
+		[]byte("ABC"),          // a length of 16K, the start of nothing
+		{0},                    // shorter than a length
+		{0, 3, 1, 2, 3},        // shorter than a header
+		{0, 40, 1, 2, 3, 4, 5}, // cut
+	}
+	for _, j := range junk {
+		if _, err := sender.WritePacket(NewFeedbackPacket(&Feedback{})); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.Write(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sender.WritePacket(NewFeedbackPacket(&Feedback{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.NetConn().SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= len(junk); i++ {
+		p, err := receiver.ReadPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if p.Seq != uint16(i) || p.Type != TypeFeedback {
+			t.Fatalf("packet %d: %+v", i, p)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	a1, b1 := net.Pipe()
+	a2, b2 := net.Pipe()
+	defer a2.Close()
+	defer b2.Close()
+	c := NewConn(a1)
+	go NewConn(b1).ReadPacket()
+	first, err := c.WritePacket(NewFeedbackPacket(&Feedback{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1.Close()
+
+	go func() {
+		if _, err := c.Reconnect(a2).WritePacket(NewFeedbackPacket(&Feedback{})); err != nil {
+			t.Error(err)
+		}
+	}()
+	p, err := NewConn(b2).ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Seq != first+1 {
+		t.Fatalf("seq %d after the reconnection, %d before", p.Seq, first)
+	}
+}
+
+func TestFeedbackRecorder(t *testing.T) {
+	r := NewFeedbackRecorder()
+	now := time.Now()
+	for _, seq := range []uint16{65534, 65535, 1, 2} { // 0 lost
+		r.OnPacket(seq, now.Add(time.Duration(seq%8)*time.Millisecond))
+	}
+	fb, err := UnmarshalFeedback(r.Build().Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.BaseSeq != 65534 || len(fb.Packets) != 5 {
+		t.Fatalf("feedback base %d count %d", fb.BaseSeq, len(fb.Packets))
+	}
+	for i, p := range fb.Packets {
+		if p.Received != (i != 2) {
+			t.Fatalf("packet %d received %v", i, p.Received)
+		}
+	}
+	if fb.Packets[3].Delta != 0 || fb.Packets[1].Delta != 6000 {
+		t.Fatalf("deltas %+v", fb.Packets)
+	}
+	if r.Build() != nil {
+		t.Fatal("empty feedback built")
+	}
+	if s := r.Stats(); s.Received != 4 || s.Lost != 1 {
+		t.Fatalf("stats %+v", s)
+	}
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+var ErrNoPeer = errors.New("transport: no peer yet")
+
+// PeerConn turns a listening udp socket to a net.Conn of the last peer that sent to it,
+// so the receiver can answer feedback on the socket it reads media from
+type PeerConn struct {
+	*net.UDPConn
+	mu     sync.Mutex
+	peer   *net.UDPAddr
+	pinned bool
+}
+
+func NewPeerConn(conn *net.UDPConn) *PeerConn {
+	return &PeerConn{UDPConn: conn}
+}
+
+func (c *PeerConn) Read(b []byte) (int, error) {
+	for {
+		n, addr, err := c.UDPConn.ReadFromUDP(b)
+		if addr != nil {
+			c.mu.Lock()
+			if c.pinned && (!addr.IP.Equal(c.peer.IP) || addr.Port != c.peer.Port) {
+				c.mu.Unlock()
+				continue
+			}
+			c.peer = addr
+			c.mu.Unlock()
+		}
+		return n, err
+	}
+}
+
+func (c *PeerConn) Write(b []byte) (int, error) {
+	peer := c.Peer()
+	if peer == nil {
+		return 0, ErrNoPeer
+	}
+	return c.UDPConn.WriteToUDP(b, peer)
+}
+
+func (c *PeerConn) Peer() *net.UDPAddr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peer
+}
+
+func (c *PeerConn) RemoteAddr() net.Addr {
+	if peer := c.Peer(); peer != nil {
+		return peer
+	}
+	return nil
+}
+
+// Pin stop following new peers, the packets of the others are dropped
+func (c *PeerConn) Pin() {
+	c.mu.Lock()
+	c.pinned = true
+	c.mu.Unlock()
+}
@@ -0,0 +1,63 @@
+package ws
+
+// viewerPage plays the fMP4 of the websocket at its own url with Media Source Extensions,
+// staying at the live edge
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>video</title>
+</head>
+<body style="margin:0;background:#000">
+<video id="video" controls autoplay muted playsinline style="width:100%;height:100vh"></video>
+<script>
+var video = document.getElementById('video');
+var source = new MediaSource();
+var buffer = null, queue = [];
+
+function append() {
+	if (buffer && !buffer.updating && queue.length) {
+		buffer.appendBuffer(queue.shift());
+	}
+}
+
+function open(mime) {
+	if (buffer) {
+		buffer.changeType(mime);
+		return;
+	}
+	buffer = source.addSourceBuffer(mime);
+	buffer.addEventListener('updateend', function () {
+		var b = video.buffered;
+		if (b.length) {
+			var end = b.end(b.length - 1);
+			if (video.currentTime < b.start(0) || end - video.currentTime > 1) {
+				video.currentTime = end - 0.1;
+			}
+			if (video.currentTime - b.start(0) > 30) {
+				buffer.remove(b.start(0), video.currentTime - 10);
+				return;
+			}
+		}
+		append();
+	});
+}
+
+source.addEventListener('sourceopen', function () {
+	var scheme = location.protocol === 'https:' ? 'wss://' : 'ws://';
+	var socket = new WebSocket(scheme + location.host + location.pathname + '?format=fmp4');
+	socket.binaryType = 'arraybuffer';
+	socket.onmessage = function (e) {
+		if (typeof e.data === 'string') {
+			open(e.data);
+			return;
+		}
+		queue.push(e.data);
+		append();
+	};
+});
+video.src = URL.createObjectURL(source);
+</script>
+</body>
+</html>
+`
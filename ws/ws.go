@@ -0,0 +1,260 @@
+// Package ws streams the H.264 of the encoder to browsers over WebSocket, through the proxies
+// that block raw UDP. A viewer gets either the access units or fMP4 fragments for Media Source
+// Extensions, which the embedded viewer page plays.
+//
+// A binary message of FormatH264 is an access unit:
+//
+//	0-3: length of the access unit
+//	4: flags, 1 for a key frame
+//	5-12: capture time, unix us
+//	Annex B access unit, a key frame has its SPS and PPS
+//
+// FormatFMP4 sends a text message with the MIME type of the stream, then the init segment, then
+// a moof and mdat per frame; a change of the parameter sets sends a new type and init segment.
+package ws
+
+import (
+	"encoding/binary"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/l-f-h/video/h264"
+	"github.com/l-f-h/video/mp4"
+)
+
+const (
+	FormatH264 = "h264"
+	FormatFMP4 = "fmp4"
+
+	HeaderSize   = 13
+	FlagKeyFrame = 1
+
+	viewerQueue  = 1 << 6
+	writeTimeout = 5 * time.Second
+)
+
+type message struct {
+	typ  int // websocket.TextMessage or BinaryMessage
+	data []byte
+}
+
+// viewer is a websocket connection, it starts on a key frame and waits for one after a drop
+type viewer struct {
+	conn     *websocket.Conn
+	format   string
+	messages chan message
+	waitKey  bool
+	init     int // of the init segment sent, FormatFMP4
+	dropped  uint64
+	done     chan struct{}
+}
+
+// Server sends the frames of WriteFrame to the viewers, it is the http.Handler of both the
+// websocket and the viewer page
+type Server struct {
+	mu        sync.Mutex
+	upgrader  websocket.Upgrader
+	ps        h264.ParameterSets
+	init      int // id of the parameter sets
+	mime      string
+	initSeg   []byte
+	start     time.Time
+	last      time.Time
+	fragments uint32
+	viewers   map[*viewer]struct{}
+	closed    bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true }, // a public stream
+		},
+		viewers: make(map[*viewer]struct{}),
+	}
+}
+
+// WriteFrame send an Annex B access unit captured at t to the viewers without blocking
+func (s *Server) WriteFrame(au []byte, t time.Time) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ps.Update(au) && s.ps.Complete() {
+		s.init++
+		s.mime = `video/mp4; codecs="` + s.ps.Codec() + `"`
+		s.initSeg, _ = mp4.InitSegment(s.ps) // an SPS it can't parse is left to the h264 viewers
+	}
+	if len(s.viewers) == 0 || !s.ps.Complete() {
+		s.last = t
+		return
+	}
+	key := h264.IsKeyFrame(au)
+
+	var raw, fragment []byte
+	for v := range s.viewers {
+		if v.waitKey && !key {
+			v.dropped++
+			continue
+		}
+		var msgs []message
+		switch v.format {
+		case FormatFMP4:
+			if s.initSeg == nil {
+				continue
+			}
+			if fragment == nil {
+				fragment = s.fragment(au, key, t)
+			}
+			if v.init != s.init {
+				msgs = append(msgs, message{websocket.TextMessage, []byte(s.mime)}, message{websocket.BinaryMessage, s.initSeg})
+			}
+			msgs = append(msgs, message{websocket.BinaryMessage, fragment})
+		default:
+			if raw == nil {
+				data := au
+				if key {
+					data = s.ps.Prepend(au) // for the viewers joining on it
+				}
+				raw = marshalFrame(data, key, t)
+			}
+			msgs = append(msgs, message{websocket.BinaryMessage, raw})
+		}
+		if len(v.messages)+len(msgs) > cap(v.messages) {
+			v.waitKey = true
+			v.dropped++
+			continue
+		}
+		for _, m := range msgs {
+			v.messages <- m
+		}
+		v.waitKey = false
+		if v.format == FormatFMP4 {
+			v.init = s.init
+		}
+	}
+	s.last = t
+}
+
+// fragment of the frame, its duration is the one of the previous frame
+func (s *Server) fragment(au []byte, key bool, t time.Time) []byte {
+	if s.start.IsZero() {
+		s.start = t
+	}
+	duration := uint32(mp4.Timescale / 30)
+	if !s.last.IsZero() && t.After(s.last) {
+		duration = uint32(int64(t.Sub(s.last)) * mp4.Timescale / int64(time.Second))
+	}
+	s.fragments++
+	base := uint64(int64(t.Sub(s.start)) * mp4.Timescale / int64(time.Second))
+	return mp4.Fragment(s.fragments, base, []mp4.Sample{{Data: au, Duration: duration, Key: key}})
+}
+
+func marshalFrame(au []byte, key bool, t time.Time) []byte {
+	b := make([]byte, HeaderSize+len(au))
+	binary.BigEndian.PutUint32(b, uint32(len(au)))
+	if key {
+		b[4] = FlagKeyFrame
+	}
+	binary.BigEndian.PutUint64(b[5:], uint64(t.UnixNano()/int64(time.Microsecond)))
+	copy(b[HeaderSize:], au)
+	return b
+}
+
+// Viewers return the number of connected viewers
+func (s *Server) Viewers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.viewers)
+}
+
+// ServeHTTP upgrade a websocket request, ?format=fmp4 for MSE, and serve the viewer page otherwise
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(viewerPage))
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatH264
+	}
+	if format != FormatH264 && format != FormatFMP4 {
+		http.Error(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader answered
+	}
+	v := &viewer{
+		conn:     conn,
+		format:   format,
+		messages: make(chan message, viewerQueue),
+		waitKey:  true,
+		done:     make(chan struct{}),
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.viewers[v] = struct{}{}
+	s.mu.Unlock()
+
+	go s.read(v)
+	s.write(v)
+}
+
+// read the control frames of the viewer until it leaves
+func (s *Server) read(v *viewer) {
+	defer close(v.done)
+	for {
+		if _, _, err := v.conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) write(v *viewer) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.viewers, v)
+		s.mu.Unlock()
+		v.conn.Close()
+	}()
+	for {
+		select {
+		case m, ok := <-v.messages:
+			if !ok {
+				v.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(writeTimeout))
+				return
+			}
+			v.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := v.conn.WriteMessage(m.typ, m.data); err != nil {
+				return
+			}
+		case <-v.done:
+			return
+		}
+	}
+}
+
+// Close disconnect the viewers
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for v := range s.viewers {
+		close(v.messages)
+		delete(s.viewers, v)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/l-f-h/video/h264"
+)
+
+var (
+	sps = []byte{0x67, 0x42, 0xc0, 0x0d, 0xf4, 0x0a, 0x0f, 0xc8} // baseline 320x240
+	pps = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+func accessUnit(i int, key bool) []byte {
+	if key {
+		return h264.AppendAnnexB(nil, sps, pps, []byte{0x65, 0x88, byte(i)})
+	}
+	return h264.AppendAnnexB(nil, []byte{0x41, 0x9a, byte(i)})
+}
+
+// dial connect a viewer and wait for the server to have it
+func dial(t *testing.T, s *Server, url, format string) *websocket.Conn {
+	n := s.Viewers()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/?format="+format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s.Viewers() == n {
+		time.Sleep(time.Millisecond)
+	}
+	return conn
+}
+
+func read(t *testing.T, conn *websocket.Conn) (int, []byte) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	typ, b, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return typ, b
+}
+
+func TestH264(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	defer s.Close()
+
+	start := time.Now()
+	s.WriteFrame(accessUnit(0, true), start) // before the viewer
+	conn := dial(t, s, srv.URL, FormatH264)
+	defer conn.Close()
+	for i := 1; i < 5; i++ {
+		s.WriteFrame(accessUnit(i, i == 2), start.Add(time.Duration(i)*time.Second/30))
+	}
+
+	// the viewer starts on the key frame
+	for i := 2; i < 5; i++ {
+		typ, b := read(t, conn)
+		au := accessUnit(i, i == 2)
+		if typ != websocket.BinaryMessage || len(b) != HeaderSize+len(au) || int(binary.BigEndian.Uint32(b)) != len(au) {
+			t.Fatalf("frame %d: message of %d bytes", i, len(b))
+		}
+		if key := b[4]&FlagKeyFrame != 0; key != (i == 2) || !bytes.Equal(b[HeaderSize:], au) {
+			t.Fatalf("frame %d: key %v %x", i, key, b[HeaderSize:])
+		}
+		captured := start.Add(time.Duration(i) * time.Second / 30)
+		if us := int64(binary.BigEndian.Uint64(b[5:])); us != captured.UnixNano()/int64(time.Microsecond) {
+			t.Fatalf("frame %d: capture time %d", i, us)
+		}
+	}
+}
+
+func TestFMP4(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	defer s.Close()
+
+	conn := dial(t, s, srv.URL, FormatFMP4)
+	defer conn.Close()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		s.WriteFrame(accessUnit(i, i == 0), start.Add(time.Duration(i)*time.Second/30))
+	}
+	if typ, b := read(t, conn); typ != websocket.TextMessage || string(b) != `video/mp4; codecs="avc1.42c00d"` {
+		t.Fatalf("mime type %q", b)
+	}
+	if _, b := read(t, conn); string(b[4:8]) != "ftyp" {
+		t.Fatalf("init segment %q", b[4:8])
+	}
+	for i := 0; i < 3; i++ {
+		if _, b := read(t, conn); string(b[4:8]) != "moof" {
+			t.Fatalf("fragment %d: %q", i, b[4:8])
+		}
+	}
+}
+
+func TestSlowViewer(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	defer s.Close()
+
+	conn := dial(t, s, srv.URL, FormatH264)
+	defer conn.Close()
+	var v *viewer
+	s.mu.Lock()
+	for v = range s.viewers {
+	}
+	s.mu.Unlock()
+
+	// the viewer does not read, the frames past the queue and the socket buffers are dropped
+	// and it waits for a key frame
+	big := h264.AppendAnnexB(nil, bytes.Repeat([]byte{0x41}, 1<<16))
+	s.WriteFrame(accessUnit(0, true), time.Now())
+	for i := 0; i < 10*viewerQueue; i++ {
+		s.WriteFrame(big, time.Now())
+	}
+	s.mu.Lock()
+	dropped, waitKey := v.dropped, v.waitKey
+	s.mu.Unlock()
+	if dropped == 0 || !waitKey {
+		t.Fatalf("dropped %d, waiting for a key frame %v", dropped, waitKey)
+	}
+}
+
+func TestViewerPage(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewServer().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), "MediaSource") {
+		t.Fatal("no viewer page")
+	}
+}